@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// defaultAccountName is used when GMAIL_ACCOUNTS is not set. It keeps the
+// original single-account file names (token.json, personal-email-style-guide.md).
+const defaultAccountName = "default"
+
+var accountNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// AccountRegistry holds one GmailServer per configured account
+type AccountRegistry struct {
+	servers map[string]*GmailServer
+	order   []string
+	primary string
+}
+
+// configuredAccountNames reads GMAIL_ACCOUNTS (comma-separated) and GMAIL_PRIMARY_ACCOUNT
+func configuredAccountNames() (names []string, primary string, err error) {
+	raw := strings.TrimSpace(os.Getenv("GMAIL_ACCOUNTS"))
+	if raw == "" {
+		return []string{defaultAccountName}, defaultAccountName, nil
+	}
+
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if !accountNamePattern.MatchString(name) {
+			return nil, "", fmt.Errorf("invalid account name %q in GMAIL_ACCOUNTS (use lowercase letters, digits, '-' and '_')", name)
+		}
+		if seen[name] {
+			return nil, "", fmt.Errorf("account %q listed twice in GMAIL_ACCOUNTS", name)
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return nil, "", fmt.Errorf("GMAIL_ACCOUNTS is set but contains no account names")
+	}
+
+	primary = strings.ToLower(strings.TrimSpace(os.Getenv("GMAIL_PRIMARY_ACCOUNT")))
+	if primary == "" {
+		primary = names[0]
+	}
+	if !seen[primary] {
+		return nil, "", fmt.Errorf("GMAIL_PRIMARY_ACCOUNT %q is not listed in GMAIL_ACCOUNTS", primary)
+	}
+	return names, primary, nil
+}
+
+// NewAccountRegistry authenticates every configured account
+func NewAccountRegistry() (*AccountRegistry, error) {
+	names, primary, err := configuredAccountNames()
+	if err != nil {
+		return nil, err
+	}
+
+	registry := &AccountRegistry{
+		servers: make(map[string]*GmailServer),
+		primary: primary,
+	}
+	for _, name := range names {
+		server, err := NewGmailServer(name)
+		if err != nil {
+			return nil, fmt.Errorf("account %s: %v", name, err)
+		}
+		registry.Add(server)
+	}
+	return registry, nil
+}
+
+// Add registers a server under its account name
+func (r *AccountRegistry) Add(server *GmailServer) {
+	if _, exists := r.servers[server.account]; !exists {
+		r.order = append(r.order, server.account)
+	}
+	r.servers[server.account] = server
+	if r.primary == "" {
+		r.primary = server.account
+	}
+}
+
+// Primary returns the server for the primary account
+func (r *AccountRegistry) Primary() *GmailServer {
+	return r.servers[r.primary]
+}
+
+// All returns every account's server in configuration order
+func (r *AccountRegistry) All() []*GmailServer {
+	servers := make([]*GmailServer, 0, len(r.order))
+	for _, name := range r.order {
+		servers = append(servers, r.servers[name])
+	}
+	return servers
+}
+
+// Resolve looks up an account by name or email address; empty selects the primary account
+func (r *AccountRegistry) Resolve(account string) (*GmailServer, error) {
+	account = strings.TrimSpace(account)
+	if account == "" {
+		return r.Primary(), nil
+	}
+
+	if server, ok := r.servers[strings.ToLower(account)]; ok {
+		return server, nil
+	}
+	for _, server := range r.servers {
+		if server.email != "" && strings.EqualFold(server.email, account) {
+			return server, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown account %q. Available accounts: %s", account, strings.Join(r.order, ", "))
+}
+
+// FromRequest resolves the optional "account" argument of a tool call
+func (r *AccountRegistry) FromRequest(req mcp.CallToolRequest) (*GmailServer, error) {
+	account, _ := req.GetArguments()["account"].(string)
+	return r.Resolve(account)
+}
+
+// withAccountParam adds the optional account argument shared by every Gmail tool
+func withAccountParam() mcp.ToolOption {
+	return mcp.WithString("account",
+		mcp.Description("Account to use, by name or email address (optional, defaults to the primary account). Use list_accounts to see what is configured."),
+	)
+}
+
+// LoadEmailAddresses fetches each account's address so tools and approvals can show it
+func (r *AccountRegistry) LoadEmailAddresses(ctx context.Context) error {
+	for _, server := range r.All() {
+		profile, err := server.mailbox.GetProfile(ctx)
+		if err != nil {
+			return fmt.Errorf("account %s: %v", server.account, err)
+		}
+		server.email = profile.EmailAddress
+		log.Printf("✅ Account %s authenticated as %s", server.account, server.email)
+	}
+	return nil
+}
+
+// tokenFileName returns the OAuth token file name for an account
+func tokenFileName(account string) string {
+	if account == defaultAccountName {
+		return "token.json"
+	}
+	return fmt.Sprintf("token-%s.json", account)
+}
+
+// styleGuideFileName returns the personal style guide file name for an account
+func styleGuideFileName(account string) string {
+	if account == defaultAccountName {
+		return "personal-email-style-guide.md"
+	}
+	return fmt.Sprintf("personal-email-style-guide-%s.md", account)
+}
+
+// styleGuideURI returns the MCP resource URI of an account's style guide
+func styleGuideURI(account string) string {
+	if account == defaultAccountName {
+		return "file://personal-email-style-guide"
+	}
+	return "file://personal-email-style-guide/" + account
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// CleanedBody is a message body split into the part the sender actually wrote
+// and the boilerplate mail clients wrap around it
+type CleanedBody struct {
+	Body          string `json:"body"`
+	QuotedHistory string `json:"quotedHistory,omitempty"`
+	Signature     string `json:"signature,omitempty"`
+	Disclaimer    string `json:"disclaimer,omitempty"`
+}
+
+var (
+	// "On Tue, Jan 2, 2024 at 10:00 AM Jane <jane@x.com> wrote:" (Gmail, Apple Mail, Thunderbird) and common translations
+	attributionPattern = regexp.MustCompile(`(?i)^(on|am|le|el|op|il)\s.{4,300}\s(wrote|schrieb|a écrit|escribió|schreef|ha scritto)(\s.{1,150})?\s?:$`)
+	// Short form used by some clients directly above a ">" block: "Jane Doe <jane@x.com> wrote:"
+	shortAttributionPattern = regexp.MustCompile(`(?i)^.{1,150}\swrote:$`)
+	// Attribution lines Gmail wraps over two lines start like this
+	attributionStartPattern = regexp.MustCompile(`(?i)^(on|am|le|el|op|il)\s`)
+	// Outlook plain-text reply separator
+	originalMessagePattern = regexp.MustCompile(`(?i)^-{2,}\s*original message\s*-{2,}$`)
+	// Forwarded content is what the sender is sharing, so scanning stops there
+	forwardedPattern = regexp.MustCompile(`(?i)^(-{2,}\s*forwarded message\s*-{2,}|begin forwarded message:)$`)
+	// Outlook separates the reply from a header block with a rule of underscores
+	underscoreRulePattern = regexp.MustCompile(`^_{10,}$`)
+	horizontalRulePattern = regexp.MustCompile(`^((\* ?){3,}|-{3,}|_{3,})$`)
+	outlookFromPattern    = regexp.MustCompile(`(?i)^from:\s*\S`)
+	outlookHeaderPattern  = regexp.MustCompile(`(?i)^(sent|date|to|cc|subject):\s*\S`)
+
+	// "-- " (RFC 3676), possibly escaped by the HTML to markdown conversion
+	signatureDelimiterPattern = regexp.MustCompile(`^(\\?-){2}\s*$`)
+	mobileSignaturePattern    = regexp.MustCompile(`(?i)^(sent from my .{2,40}|sent from (mail|outlook|yahoo mail) for .{2,30}|sent from yahoo mail.*|get outlook for .{2,30}|sent via .{2,40})$`)
+	valedictionPattern        = regexp.MustCompile(`(?i)^(best|best regards|best wishes|kind regards|warm regards|warmly|regards|many thanks|thanks|thank you|thanks again|cheers|sincerely|yours sincerely|yours truly|all the best|br)[,.!]?$`)
+
+	disclaimerPattern       = regexp.MustCompile(`(?i)(confidential|privileged|intended (solely |only )?for the (sole )?use|intended recipient|addressee|received this (e-?mail|message|communication) in error|notify the sender|(do not|prohibited from) (read|copy|copying|disclos|distribut|us)|unauthori[sz]ed (use|disclosure|review|access)|prohibited|liability|viruses)`)
+	disclaimerStartPattern  = regexp.MustCompile(`(?i)^(disclaimer|confidentiality notice|confidential(ity)?:|important notice|notice:|legal notice|privileged (and|&) confidential)`)
+	environmentNotePattern  = regexp.MustCompile(`(?i)(consider the environment|think before you print|before printing this e-?mail)`)
+	markdownLinkPattern     = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	markdownEmphasisRemover = strings.NewReplacer("**", "", "__", "", "\\", "")
+)
+
+// Limits on what a trailing block may look like to be treated as a signature
+const (
+	maxSignatureLines    = 10
+	maxSignatureLineLen  = 100
+	minDisclaimerLength  = 60
+	minDisclaimerMatches = 2
+)
+
+// cleanEmailBody separates quoted history, a trailing disclaimer and the
+// signature from the text the sender wrote
+func cleanEmailBody(text string) CleanedBody {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+
+	var result CleanedBody
+	var quoted []string
+	lines, quoted = splitQuotedHistory(lines)
+	result.QuotedHistory = joinLines(quoted)
+	if len(quoted) > 0 {
+		// Drop the horizontal rule Outlook's HTML puts above the quoted message
+		end := len(lines)
+		for end > 0 && (isBlank(lines[end-1]) || horizontalRulePattern.MatchString(strings.TrimSpace(lines[end-1]))) {
+			end--
+		}
+		lines = lines[:end]
+	}
+
+	var disclaimer []string
+	lines, disclaimer = splitDisclaimer(lines)
+	result.Disclaimer = joinLines(disclaimer)
+
+	var signature []string
+	lines, signature = splitSignature(lines)
+	result.Signature = joinLines(signature)
+
+	result.Body = joinLines(lines)
+	return result
+}
+
+// normalizeLine strips the markdown that HTML conversion adds so patterns can
+// match Outlook's bold "**From:**" headers and escaped dashes
+func normalizeLine(line string) string {
+	line = markdownLinkPattern.ReplaceAllString(line, "$1")
+	return strings.TrimSpace(markdownEmphasisRemover.Replace(line))
+}
+
+func isQuotedLine(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), ">")
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// splitQuotedHistory returns the lines the sender wrote and the quoted copy
+// of earlier messages. Inline replies, where the sender answers between quoted
+// lines, are left alone since the quotes give the answers their meaning.
+func splitQuotedHistory(lines []string) (kept, quoted []string) {
+	// Bottom-posted reply: the message opens with the quoted text
+	first := firstNonBlank(lines, 0)
+	if first >= 0 && (isQuotedLine(lines[first]) || isAttribution(lines, first)) {
+		end := first
+		if !isQuotedLine(lines[end]) {
+			end = attributionEnd(lines, first)
+		}
+		for end < len(lines) && (isQuotedLine(lines[end]) || isBlank(lines[end])) {
+			end++
+		}
+		if end < len(lines) && !containsQuotedLine(lines[end:]) {
+			return lines[end:], lines[first:end]
+		}
+	}
+
+	for i := range lines {
+		line := normalizeLine(lines[i])
+		if forwardedPattern.MatchString(line) {
+			break
+		}
+		if isAttribution(lines, i) || originalMessagePattern.MatchString(line) || isOutlookHeader(lines, i) {
+			return lines[:i], lines[i:]
+		}
+		if underscoreRulePattern.MatchString(strings.ReplaceAll(strings.TrimSpace(lines[i]), "\\", "")) {
+			if next := firstNonBlank(lines, i+1); next >= 0 && isOutlookHeader(lines, next) {
+				return lines[:i], lines[i:]
+			}
+		}
+		// A ">" block that runs to the end of the message is quoted history
+		if isQuotedLine(lines[i]) && onlyQuotedFrom(lines, i) {
+			return lines[:i], lines[i:]
+		}
+	}
+	return lines, nil
+}
+
+// isAttribution reports whether line i (joined with the next one when a client
+// wrapped it) introduces quoted text
+func isAttribution(lines []string, i int) bool {
+	line := normalizeLine(lines[i])
+	if attributionPattern.MatchString(line) {
+		return true
+	}
+	if i+1 < len(lines) && attributionStartPattern.MatchString(line) {
+		if attributionPattern.MatchString(line + " " + normalizeLine(lines[i+1])) {
+			return true
+		}
+	}
+	if shortAttributionPattern.MatchString(line) {
+		next := firstNonBlank(lines, i+1)
+		return next >= 0 && isQuotedLine(lines[next])
+	}
+	return false
+}
+
+// attributionEnd returns the index just past an attribution line starting at i
+func attributionEnd(lines []string, i int) int {
+	if !attributionPattern.MatchString(normalizeLine(lines[i])) && !shortAttributionPattern.MatchString(normalizeLine(lines[i])) {
+		return i + 2
+	}
+	return i + 1
+}
+
+// isOutlookHeader reports whether line i starts an Outlook "From: / Sent: / To:"
+// block, which Outlook puts above the message being replied to
+func isOutlookHeader(lines []string, i int) bool {
+	if !outlookFromPattern.MatchString(normalizeLine(lines[i])) {
+		return false
+	}
+	headers := 0
+	for j, seen := i+1, 0; j < len(lines) && seen < 5; j++ {
+		if isBlank(lines[j]) {
+			continue
+		}
+		seen++
+		if outlookHeaderPattern.MatchString(normalizeLine(lines[j])) {
+			headers++
+		}
+	}
+	return headers >= 2
+}
+
+func onlyQuotedFrom(lines []string, i int) bool {
+	for _, line := range lines[i:] {
+		if !isBlank(line) && !isQuotedLine(line) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsQuotedLine(lines []string) bool {
+	for _, line := range lines {
+		if isQuotedLine(line) {
+			return true
+		}
+	}
+	return false
+}
+
+func firstNonBlank(lines []string, from int) int {
+	for i := from; i < len(lines); i++ {
+		if !isBlank(lines[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// splitDisclaimer moves trailing legal or environmental boilerplate paragraphs out of the body
+func splitDisclaimer(lines []string) (kept, disclaimer []string) {
+	end := len(lines)
+	for end > 0 && isBlank(lines[end-1]) {
+		end--
+	}
+
+	cut := end
+	for cut > 0 {
+		start := cut - 1
+		for start > 0 && !isBlank(lines[start-1]) {
+			start--
+		}
+		if !isDisclaimer(normalizeLine(strings.Join(lines[start:cut], " "))) {
+			break
+		}
+		cut = start
+		for cut > 0 && isBlank(lines[cut-1]) {
+			cut--
+		}
+	}
+
+	// Never treat the whole message as boilerplate
+	if cut == end || firstNonBlank(lines[:cut], 0) < 0 {
+		return lines, nil
+	}
+	return lines[:cut], lines[cut:end]
+}
+
+func isDisclaimer(paragraph string) bool {
+	if environmentNotePattern.MatchString(paragraph) && len(paragraph) < 200 {
+		return true
+	}
+	if len(paragraph) < minDisclaimerLength {
+		return false
+	}
+	if disclaimerStartPattern.MatchString(paragraph) {
+		return true
+	}
+	distinct := make(map[string]bool)
+	for _, match := range disclaimerPattern.FindAllString(strings.ToLower(paragraph), -1) {
+		distinct[match] = true
+	}
+	return len(distinct) >= minDisclaimerMatches
+}
+
+// splitSignature moves the sender's signature block out of the body. It looks
+// for the "-- " delimiter, then a mobile "Sent from my ..." line, then contact
+// details below a closing such as "Best," (the closing and the name stay in
+// the body).
+func splitSignature(lines []string) (kept, signature []string) {
+	end := len(lines)
+	for end > 0 && isBlank(lines[end-1]) {
+		end--
+	}
+	if end == 0 {
+		return lines, nil
+	}
+
+	for i := end - 1; i > 0; i-- {
+		if signatureDelimiterPattern.MatchString(strings.TrimSpace(lines[i])) && firstNonBlank(lines[:i], 0) >= 0 {
+			return lines[:i], lines[i+1 : end]
+		}
+	}
+
+	if mobileSignaturePattern.MatchString(normalizeLine(lines[end-1])) && firstNonBlank(lines[:end-1], 0) >= 0 {
+		return lines[:end-1], lines[end-1 : end]
+	}
+
+	// Closing followed by a name and a few short lines of contact details
+	for i := end - 2; i >= 0 && end-i <= maxSignatureLines+2; i-- {
+		if !valedictionPattern.MatchString(normalizeLine(lines[i])) {
+			continue
+		}
+		name := firstNonBlank(lines, i+1)
+		if name < 0 || name+1 >= end {
+			return lines, nil
+		}
+		for _, line := range lines[name+1 : end] {
+			if len(strings.TrimSpace(line)) > maxSignatureLineLen {
+				return lines, nil
+			}
+		}
+		return lines[:name+1], lines[name+1 : end]
+	}
+	return lines, nil
+}
+
+// joinLines joins lines back into text without surrounding blank lines
+func joinLines(lines []string) string {
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
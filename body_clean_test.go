@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestCleanEmailBodyGoldens runs every case in testdata/bodies (see its README)
+func TestCleanEmailBodyGoldens(t *testing.T) {
+	goldens, err := filepath.Glob(filepath.Join("testdata", "bodies", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(goldens) == 0 {
+		t.Fatal("no cases in testdata/bodies")
+	}
+	inputs, _ := filepath.Glob(filepath.Join("testdata", "bodies", "*.txt"))
+	if len(inputs) != len(goldens) {
+		t.Errorf("%d .txt inputs but %d .json goldens; every case needs both", len(inputs), len(goldens))
+	}
+
+	for _, golden := range goldens {
+		name := strings.TrimSuffix(filepath.Base(golden), ".json")
+		t.Run(name, func(t *testing.T) {
+			input, err := os.ReadFile(strings.TrimSuffix(golden, ".json") + ".txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want struct {
+				Description string `json:"description"`
+				CleanedBody
+			}
+			if err := json.Unmarshal(data, &want); err != nil {
+				t.Fatalf("bad golden: %v", err)
+			}
+
+			got := cleanEmailBody(string(input))
+			for _, field := range []struct{ name, got, want string }{
+				{"body", got.Body, want.Body},
+				{"quotedHistory", got.QuotedHistory, want.QuotedHistory},
+				{"signature", got.Signature, want.Signature},
+				{"disclaimer", got.Disclaimer, want.Disclaimer},
+			} {
+				if field.got != field.want {
+					t.Errorf("%s (%s):\ngot:  %q\nwant: %q", field.name, want.Description, field.got, field.want)
+				}
+			}
+		})
+	}
+}
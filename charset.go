@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"mime"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/ianaindex"
+	"google.golang.org/api/gmail/v1"
+)
+
+// htmlMetaCharsetPattern finds <meta charset="..."> and <meta http-equiv="Content-Type" content="...; charset=...">
+var htmlMetaCharsetPattern = regexp.MustCompile(`(?i)<meta[^>]+charset\s*=\s*["']?\s*([A-Za-z0-9._:-]+)`)
+
+// Thresholds for guessing the charset of a part that does not declare one
+const (
+	minKanaRatio    = 0.1 // Japanese prose is full of kana; Chinese has none
+	minHanziRatio   = 0.8
+	maxIsolatedHigh = 0.5 // Latin text has lone accented bytes, CJK encodings use byte pairs
+)
+
+// sniffedJapanese and sniffedChinese are the multi-byte charsets tried, in
+// order, when a part has no usable charset label
+var (
+	sniffedJapanese = []string{"shift_jis", "euc-jp"}
+	sniffedChinese  = []string{"gbk"}
+)
+
+// decodePartText decodes a text part's body and transcodes it to UTF-8
+func decodePartText(part *gmail.MessagePart) (string, error) {
+	// Try base64url decoding first (Gmail's preferred encoding)
+	data, err := base64.URLEncoding.DecodeString(part.Body.Data)
+	if err != nil {
+		// Try standard base64 if URL encoding fails
+		data, err = base64.StdEncoding.DecodeString(part.Body.Data)
+		if err != nil {
+			return "", err
+		}
+	}
+	return toUTF8(data, partCharset(part), part.MimeType), nil
+}
+
+// partCharset returns the charset parameter of a part's Content-Type header
+func partCharset(part *gmail.MessagePart) string {
+	// ParseMediaType still returns the parameters it could read when others are malformed
+	_, params, _ := mime.ParseMediaType(headerValue(part.Headers, "Content-Type"))
+	return strings.Trim(params["charset"], `"' `)
+}
+
+// toUTF8 converts data from its declared charset, falling back to the HTML
+// meta tag and then to guessing from the bytes themselves
+func toUTF8(data []byte, declared, mimeType string) string {
+	if text, ok := decodeWithLabel(data, declared); ok {
+		return text
+	}
+	if mimeType == "text/html" {
+		head := data[:min(len(data), 2048)]
+		if match := htmlMetaCharsetPattern.FindSubmatch(head); match != nil {
+			if text, ok := decodeWithLabel(data, string(match[1])); ok {
+				return text
+			}
+		}
+	}
+	return sniffCharset(data)
+}
+
+// decodeWithLabel decodes data with the named charset, reporting false when
+// the label is unknown or evidently wrong
+func decodeWithLabel(data []byte, label string) (string, bool) {
+	if label == "" {
+		return "", false
+	}
+	enc := lookupCharset(label)
+	if enc == nil {
+		return "", false
+	}
+	if isUTF8Label(label) {
+		// Mislabelled UTF-8 is common; let the sniffer handle anything invalid
+		return string(data), utf8.Valid(data)
+	}
+	// Senders often label UTF-8 as Latin-1; real Latin-1 text is almost
+	// never also valid multi-byte UTF-8
+	if isSingleByteLatin(label) && utf8.Valid(data) && hasHighBytes(data) {
+		return string(data), true
+	}
+
+	decoded, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return "", false
+	}
+	return string(decoded), true
+}
+
+// lookupCharset resolves a MIME charset label, accepting the WHATWG names
+// browsers use (so "iso-8859-1" decodes as its Windows-1252 superset and
+// "gb2312" as GBK) and falling back to the IANA registry
+func lookupCharset(label string) encoding.Encoding {
+	label = strings.ToLower(strings.TrimSpace(label))
+	if enc, err := htmlindex.Get(label); err == nil {
+		return enc
+	}
+	if enc, err := ianaindex.IANA.Encoding(label); err == nil && enc != nil {
+		return enc
+	}
+	return nil
+}
+
+func isUTF8Label(label string) bool {
+	switch strings.ToLower(strings.TrimSpace(label)) {
+	case "utf-8", "utf8", "unicode-1-1-utf-8":
+		return true
+	}
+	return false
+}
+
+func isSingleByteLatin(label string) bool {
+	label = strings.ToLower(strings.TrimSpace(label))
+	return label == "us-ascii" || label == "ascii" || strings.HasPrefix(label, "iso-8859-") ||
+		strings.HasPrefix(label, "iso8859-") || strings.HasPrefix(label, "latin") || strings.HasPrefix(label, "windows-125") ||
+		strings.HasPrefix(label, "cp125")
+}
+
+func hasHighBytes(data []byte) bool {
+	for _, b := range data {
+		if b >= 0x80 {
+			return true
+		}
+	}
+	return false
+}
+
+// sniffCharset guesses the encoding of unlabelled text: ISO-2022-JP if it
+// contains its escape sequences, UTF-8 if it is valid, then Japanese or
+// Chinese multi-byte encodings when the bytes decode cleanly into plausible
+// text, and Windows-1252 otherwise (it accepts any byte sequence)
+func sniffCharset(data []byte) string {
+	// ISO-2022-JP is 7-bit, so check for its escapes before trusting valid UTF-8
+	if bytes.Contains(data, []byte("\x1b$B")) || bytes.Contains(data, []byte("\x1b$@")) {
+		if text, ok := decodeWithLabel(data, "iso-2022-jp"); ok {
+			return text
+		}
+	}
+
+	if utf8.Valid(data) {
+		return string(data)
+	}
+
+	if isolatedHighByteRatio(data) <= maxIsolatedHigh {
+		best, bestRatio := "", 0.0
+		for _, label := range sniffedJapanese {
+			if text, ok := cleanDecode(data, label); ok {
+				if kana, _, total := scriptCounts(text); total > 0 && float64(kana)/float64(total) > bestRatio {
+					best, bestRatio = text, float64(kana)/float64(total)
+				}
+			}
+		}
+		if bestRatio >= minKanaRatio {
+			return best
+		}
+
+		for _, label := range sniffedChinese {
+			if text, ok := cleanDecode(data, label); ok {
+				if kana, han, total := scriptCounts(text); kana == 0 && total > 0 && float64(han)/float64(total) >= minHanziRatio {
+					return text
+				}
+			}
+		}
+	}
+
+	text, _ := decodeWithLabel(data, "windows-1252")
+	return text
+}
+
+// cleanDecode decodes data with label, rejecting output with replacement or control characters
+func cleanDecode(data []byte, label string) (string, bool) {
+	enc := lookupCharset(label)
+	if enc == nil {
+		return "", false
+	}
+	decoded, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return "", false
+	}
+	text := string(decoded)
+	for _, r := range text {
+		if r == utf8.RuneError || (unicode.IsControl(r) && r != '\n' && r != '\r' && r != '\t') {
+			return "", false
+		}
+	}
+	return text, true
+}
+
+// scriptCounts counts kana and Han characters (with CJK punctuation) among the non-ASCII runes of text
+func scriptCounts(text string) (kana, han, total int) {
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			continue
+		}
+		total++
+		switch {
+		case unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r) && r < 0xFF61:
+			// Half-width katakana (U+FF61 and up) is what Chinese bytes turn into under Shift_JIS
+			kana++
+		case unicode.Is(unicode.Han, r), unicode.IsPunct(r), r == 0x3000:
+			han++
+		}
+	}
+	return kana, han, total
+}
+
+// isolatedHighByteRatio returns the share of non-ASCII bytes that sit between
+// ASCII bytes, as accented letters do in Latin-1 text
+func isolatedHighByteRatio(data []byte) float64 {
+	high, isolated := 0, 0
+	for i, b := range data {
+		if b < 0x80 {
+			continue
+		}
+		high++
+		prevASCII := i == 0 || data[i-1] < 0x80
+		nextASCII := i == len(data)-1 || data[i+1] < 0x80
+		if prevASCII && nextASCII {
+			isolated++
+		}
+	}
+	if high == 0 {
+		return 0
+	}
+	return float64(isolated) / float64(high)
+}
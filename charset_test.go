@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"google.golang.org/api/gmail/v1"
+)
+
+// TestDecodeCharsetGoldens decodes every message in testdata/charsets (see
+// its README) and compares the body with the expected UTF-8 text
+func TestDecodeCharsetGoldens(t *testing.T) {
+	messages, err := filepath.Glob(filepath.Join("testdata", "charsets", "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) == 0 {
+		t.Fatal("no cases in testdata/charsets")
+	}
+
+	for _, path := range messages {
+		name := strings.TrimSuffix(filepath.Base(path), ".eml")
+		t.Run(name, func(t *testing.T) {
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := os.ReadFile(strings.TrimSuffix(path, ".eml") + ".txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			payload, err := parseRawMessage(raw, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := extractEmailBody(&gmail.Message{Payload: payload})
+			if normalizeNewlines(got) != normalizeNewlines(string(want)) {
+				t.Errorf("body:\ngot:  %q\nwant: %q", got, want)
+			}
+		})
+	}
+}
+
+func normalizeNewlines(text string) string {
+	return strings.TrimRight(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+}
+
+// encodeWith encodes UTF-8 text into a legacy charset for the tests below
+func encodeWith(t *testing.T, enc encoding.Encoding, text string) []byte {
+	t.Helper()
+	encoded, err := enc.NewEncoder().Bytes([]byte(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
+
+func TestSniffCharset(t *testing.T) {
+	jaText := "お世話になっております。来週の打ち合わせはいかがでしょうか。"
+	zhText := "您好，我们下周三下午三点开会，请准时参加。谢谢。"
+	latinText := "Café au lait, crème brûlée and naïve façades."
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"valid UTF-8", []byte(jaText), jaText},
+		{"ISO-2022-JP escapes", encodeWith(t, japanese.ISO2022JP, jaText), jaText},
+		{"Shift_JIS with kana", encodeWith(t, japanese.ShiftJIS, jaText), jaText},
+		{"EUC-JP with kana", encodeWith(t, japanese.EUCJP, jaText), jaText},
+		// Chinese has no kana, so it is not taken for Japanese even if it decodes as Shift_JIS
+		{"GBK without kana", encodeWith(t, simplifiedchinese.GBK, zhText), zhText},
+		// Accented letters between ASCII are isolated high bytes: Latin, not CJK
+		{"Windows-1252 accents", encodeWith(t, charmap.Windows1252, latinText), latinText},
+		{"Windows-1252 smart quotes", encodeWith(t, charmap.Windows1252, "“Quoted” – fine…"), "“Quoted” – fine…"},
+		{"plain ASCII", []byte("just ascii"), "just ascii"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sniffCharset(tt.data); got != tt.want {
+				t.Errorf("sniffCharset = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSniffCharsetThresholds(t *testing.T) {
+	// Two accented letters in a row are a high-byte pair, so a short Latin
+	// word can look like CJK; the isolated share decides which way it goes
+	if ratio := isolatedHighByteRatio([]byte("caf\xe9 na\xefve")); ratio != 1 {
+		t.Errorf("isolated ratio of Latin-1 accents = %v, want 1", ratio)
+	}
+	if ratio := isolatedHighByteRatio(encodeWith(t, japanese.ShiftJIS, "日本語")); ratio != 0 {
+		t.Errorf("isolated ratio of Shift_JIS = %v, want 0", ratio)
+	}
+	if ratio := isolatedHighByteRatio([]byte("plain")); ratio != 0 {
+		t.Errorf("isolated ratio of ASCII = %v, want 0", ratio)
+	}
+
+	// Above maxIsolatedHigh the CJK decoders are not even tried
+	mostlyIsolated := []byte("a\xe9b\xe9c\xe9d\x93x\xfa")
+	if ratio := isolatedHighByteRatio(mostlyIsolated); ratio <= maxIsolatedHigh {
+		t.Fatalf("test input ratio %v should exceed %v", ratio, maxIsolatedHigh)
+	}
+	if got, want := sniffCharset(mostlyIsolated), decodeLatin(t, mostlyIsolated); got != want {
+		t.Errorf("mostly isolated high bytes = %q, want Windows-1252 %q", got, want)
+	}
+
+	// Kanji with too few kana is not accepted as Japanese
+	kanjiOnly := encodeWith(t, japanese.ShiftJIS, "東京都千代田区丸の内一丁目")
+	kana, _, total := scriptCounts(decodeWith(t, japanese.ShiftJIS, kanjiOnly))
+	if float64(kana)/float64(total) >= minKanaRatio {
+		t.Fatalf("test input kana ratio %d/%d should be below %v", kana, total, minKanaRatio)
+	}
+	if got := sniffCharset(kanjiOnly); got == "東京都千代田区丸の内一丁目" {
+		t.Errorf("kanji-only text was taken for Shift_JIS despite the kana threshold")
+	}
+
+	// Half-width katakana does not count as kana: it is what GBK bytes become under Shift_JIS
+	if kana, _, _ := scriptCounts("ｱｲｳ"); kana != 0 {
+		t.Errorf("half-width katakana counted as kana: %d", kana)
+	}
+}
+
+func decodeWith(t *testing.T, enc encoding.Encoding, data []byte) string {
+	t.Helper()
+	decoded, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(decoded)
+}
+
+func decodeLatin(t *testing.T, data []byte) string {
+	return decodeWith(t, charmap.Windows1252, data)
+}
+
+func TestDecodeWithLabelMislabelledLatin1(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		label string
+		want  string
+	}{
+		{"UTF-8 labelled ISO-8859-1", []byte("Grüße aus München"), "iso-8859-1", "Grüße aus München"},
+		{"UTF-8 labelled Windows-1252", []byte("Price: 5 €"), "windows-1252", "Price: 5 €"},
+		{"UTF-8 labelled latin1", []byte("naïve"), "latin1", "naïve"},
+		{"real ISO-8859-1", []byte("Gr\xfc\xdfe aus M\xfcnchen"), "iso-8859-1", "Grüße aus München"},
+		{"ISO-8859-1 read as Windows-1252", []byte("\x93quoted\x94"), "ISO-8859-1", "“quoted”"},
+		{"ASCII labelled ISO-8859-1", []byte("hello"), "iso-8859-1", "hello"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := decodeWithLabel(tt.data, tt.label)
+			if !ok || got != tt.want {
+				t.Errorf("decodeWithLabel(%q) = %q, %v; want %q", tt.label, got, ok, tt.want)
+			}
+		})
+	}
+
+	// Invalid UTF-8 labelled UTF-8 falls through to the sniffer
+	if _, ok := decodeWithLabel([]byte("caf\xe9"), "utf-8"); ok {
+		t.Error("invalid UTF-8 accepted under a UTF-8 label")
+	}
+	if got := toUTF8([]byte("caf\xe9"), "utf-8", "text/plain"); got != "café" {
+		t.Errorf("toUTF8 of mislabelled Latin-1 = %q, want %q", got, "café")
+	}
+}
+
+func TestDecodePartText(t *testing.T) {
+	part := &gmail.MessagePart{
+		MimeType: "text/plain",
+		Headers:  []*gmail.MessagePartHeader{{Name: "Content-Type", Value: `text/plain; charset="Shift_JIS"`}},
+		Body:     &gmail.MessagePartBody{Data: base64.URLEncoding.EncodeToString(encodeWith(t, japanese.ShiftJIS, "こんにちは"))},
+	}
+	got, err := decodePartText(part)
+	if err != nil || got != "こんにちは" {
+		t.Fatalf("decodePartText = %q, %v", got, err)
+	}
+
+	// Standard base64 is accepted too
+	part.Body.Data = base64.StdEncoding.EncodeToString(encodeWith(t, japanese.ShiftJIS, "こんにちは?>"))
+	if got, err := decodePartText(part); err != nil || got != "こんにちは?>" {
+		t.Fatalf("decodePartText of standard base64 = %q, %v", got, err)
+	}
+}
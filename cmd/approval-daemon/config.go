@@ -13,6 +13,22 @@ type Config struct {
 	NtfyTopic     string `json:"ntfy_topic"`
 	SigningSecret string `json:"signing_secret"`
 	SetupComplete bool   `json:"setup_complete"`
+
+	// Self-hosted ntfy, all optional: without NtfyServer notifications go
+	// through ntfy.sh. A token takes precedence over a username and password.
+	NtfyServer   string `json:"ntfy_server,omitempty"`   // base URL, e.g. https://ntfy.example.com
+	NtfyToken    string `json:"ntfy_token,omitempty"`    // access token, sent as a bearer token
+	NtfyUsername string `json:"ntfy_username,omitempty"` // basic auth
+	NtfyPassword string `json:"ntfy_password,omitempty"`
+	NtfyCAFile   string `json:"ntfy_ca_file,omitempty"` // PEM bundle to trust besides the system roots
+
+	// Private previews (see preview.go): notifications carry no email content
+	// and the phone reads it, decrypted, in a web view where it also decides.
+	// The key is created with the config; without PreviewURL the web view is
+	// opened at its listen address, from a browser on this machine.
+	PreviewURL    string `json:"preview_url,omitempty"`    // https address the phone reaches the web view at
+	PreviewListen string `json:"preview_listen,omitempty"` // address the web view listens on, default 127.0.0.1:8765
+	PreviewKey    string `json:"preview_key,omitempty"`    // base64url AES-256 key, shared with the phone when pairing
 }
 
 func loadConfig() (*Config, error) {
@@ -71,9 +87,15 @@ func createNewConfig() (*Config, error) {
 		return nil, fmt.Errorf("failed to generate secret: %w", err)
 	}
 
+	previewKey, err := generatePreviewKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate preview key: %w", err)
+	}
+
 	return &Config{
 		NtfyTopic:     "gmail-mcp-" + topic,
 		SigningSecret: secret,
+		PreviewKey:    previewKey,
 		SetupComplete: false,
 	}, nil
 }
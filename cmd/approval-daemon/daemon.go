@@ -2,23 +2,39 @@ package main
 
 import (
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
 	"fmt"
 	"log"
-	"strings"
 	"sync"
 	"time"
 )
 
+// maxPendingEmails bounds how many approval requests can wait at once, so a
+// runaway client cannot flood the phone with notifications
+const maxPendingEmails = 20
+
+// approvalTimeout is how long a request waits for the user before it is refused
+const approvalTimeout = 5 * time.Minute
+
 type PendingEmail struct {
+	ID           string // request ID, part of the approve/reject action bodies
 	DraftID      string
+	ContentHash  string
+	Account      string
+	From         string
 	To           string
+	Cc           string
+	Bcc          string
 	Subject      string
 	Body         string
-	ApproveToken string
-	RejectToken  string
+	Attachments  []Attachment
+	ApproveNonce string // one-time nonces of the signed Approve and Reject actions
+	RejectNonce  string
 	QueuedAt     time.Time
-	ResultChan   chan ApprovalResult
+	ExpiresAt    time.Time           // signed into the actions; later presses are refused
+	Sealed       sealedPreview       // encrypted preview for the web view, the only place the actions are shown
+	ResultChan   chan ApprovalResult // buffered; receives exactly one decision
 }
 
 type ApprovalResult struct {
@@ -28,139 +44,262 @@ type ApprovalResult struct {
 
 type ApprovalDaemon struct {
 	config  *Config
-	pending *PendingEmail
-	mu      sync.Mutex
+	ntfy    *NtfyClient
+	timeout time.Duration // how long a request waits, approvalTimeout outside tests
+
+	// mu guards pending only. It is never held while waiting for the user or
+	// talking to ntfy, so requests queue and resolve independently.
+	mu         sync.Mutex
+	pending    map[string]*PendingEmail // request ID -> email awaiting a decision
+	usedNonces map[string]int64         // nonce -> expiry of every action already accepted
 }
 
-func newApprovalDaemon(config *Config) *ApprovalDaemon {
+func newApprovalDaemon(config *Config, ntfy *NtfyClient) *ApprovalDaemon {
 	return &ApprovalDaemon{
-		config: config,
+		config:     config,
+		ntfy:       ntfy,
+		timeout:    approvalTimeout,
+		pending:    make(map[string]*PendingEmail),
+		usedNonces: make(map[string]int64),
 	}
 }
 
-func (d *ApprovalDaemon) queueEmail(req IPCRequest) IPCResponse {
-	d.mu.Lock()
-	defer d.mu.Unlock()
-
-	if d.pending != nil {
+// queueEmail notifies the user and blocks until they decide, the request times
+// out, or the client hangs up. Any number of requests can wait at once.
+func (d *ApprovalDaemon) queueEmail(req IPCRequest, hangup <-chan struct{}) IPCResponse {
+	if req.ContentHash == "" {
 		return IPCResponse{
 			Success: false,
-			Error:   "another email is pending approval - only one at a time",
+			Error:   "missing content_hash: the approval must be bound to the draft's content",
 		}
 	}
 
-	// Generate one-time tokens
-	approveToken, _ := generateToken()
-	rejectToken, _ := generateToken()
+	// Generate the request ID and one-time action nonces
+	id, err := generateToken()
+	if err != nil {
+		return IPCResponse{Success: false, Error: fmt.Sprintf("failed to generate request ID: %v", err)}
+	}
+	approveNonce, err := generateToken()
+	if err != nil {
+		return IPCResponse{Success: false, Error: fmt.Sprintf("failed to generate nonce: %v", err)}
+	}
+	rejectNonce, err := generateToken()
+	if err != nil {
+		return IPCResponse{Success: false, Error: fmt.Sprintf("failed to generate nonce: %v", err)}
+	}
+	queuedAt := time.Now()
 
-	d.pending = &PendingEmail{
+	pending := &PendingEmail{
+		ID:           id,
 		DraftID:      req.DraftID,
+		ContentHash:  req.ContentHash,
+		Account:      req.Account,
+		From:         req.From,
 		To:           req.To,
+		Cc:           req.Cc,
+		Bcc:          req.Bcc,
 		Subject:      req.Subject,
 		Body:         req.Body,
-		ApproveToken: approveToken,
-		RejectToken:  rejectToken,
-		QueuedAt:     time.Now(),
+		Attachments:  req.Attachments,
+		ApproveNonce: approveNonce,
+		RejectNonce:  rejectNonce,
+		QueuedAt:     queuedAt,
+		ExpiresAt:    queuedAt.Add(d.timeout),
 		ResultChan:   make(chan ApprovalResult, 1),
 	}
+	// Decisions are only taken in the paired web view, so without a key there
+	// would be no way to approve, and nothing is published in the clear instead
+	if !d.config.previewsEnabled() {
+		return IPCResponse{
+			Success: false,
+			Error:   "no preview key to encrypt the approval with: restart gmail-approval-daemon to create one",
+		}
+	}
+	if pending.Sealed, err = d.sealedPreviewFor(pending); err != nil {
+		return IPCResponse{Success: false, Error: fmt.Sprintf("failed to encrypt preview: %v", err)}
+	}
+
+	d.mu.Lock()
+	if len(d.pending) >= maxPendingEmails {
+		d.mu.Unlock()
+		return IPCResponse{
+			Success: false,
+			Error:   fmt.Sprintf("%d emails are already pending approval - decide on those first", maxPendingEmails),
+		}
+	}
+	d.pending[id] = pending
+	d.mu.Unlock()
 
 	// Send notification
-	if err := d.sendApprovalNotification(); err != nil {
-		d.pending = nil
+	if err := d.sendApprovalNotification(pending); err != nil {
+		d.remove(id)
 		return IPCResponse{
 			Success: false,
 			Error:   fmt.Sprintf("failed to send notification: %v", err),
 		}
 	}
 
-	log.Printf("📧 Email queued for approval: to=%s subject=%s", req.To, req.Subject)
+	log.Printf("📧 Email queued for approval: request=%s account=%s from=%s to=%s subject=%s hash=%s", id, req.Account, req.From, req.To, req.Subject, req.ContentHash)
+
+	timeout := time.NewTimer(d.timeout)
+	defer timeout.Stop()
 
-	// Wait for approval (blocking)
+	// Wait for approval (blocking, without the lock)
+	var result ApprovalResult
 	select {
-	case result := <-d.pending.ResultChan:
-		d.pending = nil
-		if result.Error != nil {
-			return IPCResponse{Success: false, Error: result.Error.Error()}
+	case result = <-pending.ResultChan:
+	case <-timeout.C:
+		if d.remove(id) {
+			log.Printf("⌛ Approval timed out: request=%s draft=%s", id, req.DraftID)
+			return IPCResponse{Success: false, Error: "approval timed out"}
 		}
-		if result.Approved {
-			return IPCResponse{Success: true, Status: "approved"}
+		// The user decided at the last moment; their decision is already on its way
+		result = <-pending.ResultChan
+	case <-hangup:
+		if d.remove(id) {
+			log.Printf("🚫 Approval request withdrawn by client: request=%s draft=%s", id, req.DraftID)
+			return IPCResponse{Success: false, Error: "request cancelled"}
 		}
-		return IPCResponse{Success: false, Error: "rejected by user"}
-	case <-time.After(5 * time.Minute):
-		d.pending = nil
-		return IPCResponse{Success: false, Error: "approval timed out"}
+		result = <-pending.ResultChan
 	}
+
+	if result.Error != nil {
+		return IPCResponse{Success: false, Error: result.Error.Error()}
+	}
+	if result.Approved {
+		return IPCResponse{Success: true, Status: "approved", ContentHash: req.ContentHash}
+	}
+	return IPCResponse{Success: false, Error: "rejected by user"}
 }
 
-func (d *ApprovalDaemon) sendApprovalNotification() error {
-	truncatedBody := d.pending.Body
-	if len(truncatedBody) > 200 {
-		truncatedBody = truncatedBody[:200] + "..."
-	}
-
-	message := fmt.Sprintf("To: %s\nSubject: %s\n\n%s",
-		d.pending.To, d.pending.Subject, truncatedBody)
-
-	actions := []NtfyAction{
-		{
-			Action: "http",
-			Label:  "✓ Approve",
-			URL:    fmt.Sprintf("%s/%s", ntfyBaseURL, d.config.NtfyTopic),
-			Method: "POST",
-			Body:   "APPROVE:" + d.pending.ApproveToken,
-		},
-		{
-			Action: "http",
-			Label:  "✗ Reject",
-			URL:    fmt.Sprintf("%s/%s", ntfyBaseURL, d.config.NtfyTopic),
-			Method: "POST",
-			Body:   "REJECT:" + d.pending.RejectToken,
-		},
-	}
-
-	return sendNtfyMessageWithActions(d.config.NtfyTopic, "📧 Approve email?", message, actions)
+// remove drops a request that is still pending, reporting whether it was;
+// false means a decision already claimed it
+func (d *ApprovalDaemon) remove(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.pending[id]; !ok {
+		return false
+	}
+	delete(d.pending, id)
+	return true
 }
 
-func (d *ApprovalDaemon) startPolling() {
-	since := time.Now()
-	ticker := time.NewTicker(1 * time.Second)
-	defer ticker.Stop()
+// pendingCount returns how many requests are waiting for the user
+func (d *ApprovalDaemon) pendingCount() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.pending)
+}
 
-	for range ticker.C {
-		d.mu.Lock()
-		pending := d.pending
+// resolve delivers the user's decision if action is a genuine, unexpired,
+// unused action for a pending request. Each request is resolved at most once.
+// On refusal it says why, for the log.
+func (d *ApprovalDaemon) resolve(action ApprovalAction, now time.Time) (accepted bool, refusal string) {
+	d.mu.Lock()
+	if _, used := d.usedNonces[action.Nonce]; used {
+		d.mu.Unlock()
+		return false, "replayed"
+	}
+	pending, ok := d.pending[action.RequestID]
+	if !ok {
+		d.mu.Unlock()
+		return false, "no such pending request"
+	}
+	nonce := pending.RejectNonce
+	if action.Approve {
+		nonce = pending.ApproveNonce
+	}
+	// The signature covers the content hash held here, so an action signed for
+	// other content, another request or a later expiry does not verify
+	if !verifyApprovalAction(d.config.SigningSecret, pending.ContentHash, action) ||
+		subtle.ConstantTimeCompare([]byte(action.Nonce), []byte(nonce)) != 1 ||
+		action.Expiry != pending.ExpiresAt.Unix() {
+		d.mu.Unlock()
+		return false, "bad signature"
+	}
+	if action.expired(now) {
 		d.mu.Unlock()
+		return false, "expired"
+	}
 
-		if pending == nil {
-			continue
-		}
+	d.rememberNonceLocked(action, now)
+	delete(d.pending, action.RequestID)
+	d.mu.Unlock()
 
-		messages, err := pollNtfyMessages(d.config.NtfyTopic, since)
-		if err != nil {
-			log.Printf("Poll error: %v", err)
-			continue
-		}
+	// Removing the request under the lock makes this the only send on a
+	// buffered channel, so it never blocks
+	pending.ResultChan <- ApprovalResult{Approved: action.Approve}
+	return true, ""
+}
 
-		for _, msg := range messages {
-			d.handlePollMessage(msg, pending)
+// rememberNonceLocked records an accepted action's nonce until it expires,
+// after which the expiry check refuses it anyway
+func (d *ApprovalDaemon) rememberNonceLocked(action ApprovalAction, now time.Time) {
+	for nonce, expiry := range d.usedNonces {
+		if now.Unix() >= expiry {
+			delete(d.usedNonces, nonce)
 		}
 	}
+	d.usedNonces[action.Nonce] = action.Expiry
 }
 
-func (d *ApprovalDaemon) handlePollMessage(msg NtfyPollMessage, pending *PendingEmail) {
-	if strings.HasPrefix(msg.Message, "APPROVE:") {
-		token := strings.TrimPrefix(msg.Message, "APPROVE:")
-		if token == pending.ApproveToken {
-			log.Println("✅ Email approved by user")
-			pending.ResultChan <- ApprovalResult{Approved: true}
-		}
-	} else if strings.HasPrefix(msg.Message, "REJECT:") {
-		token := strings.TrimPrefix(msg.Message, "REJECT:")
-		if token == pending.RejectToken {
-			log.Println("❌ Email rejected by user")
-			pending.ResultChan <- ApprovalResult{Approved: false}
-		}
+// signedAction builds the Approve or Reject action for a pending request
+func (d *ApprovalDaemon) signedAction(pending *PendingEmail, approve bool) ApprovalAction {
+	action := ApprovalAction{
+		Approve:   approve,
+		RequestID: pending.ID,
+		Expiry:    pending.ExpiresAt.Unix(),
+		Nonce:     pending.RejectNonce,
+	}
+	if approve {
+		action.Nonce = pending.ApproveNonce
+	}
+	action.Signature = signApprovalAction(d.config.SigningSecret, pending.ContentHash, action)
+	return action
+}
+
+// sendApprovalNotification tells the phone that something is pending. The
+// topic only ever learns that much: the email and the Approve/Reject actions
+// are in the encrypted web view, which the Review button opens.
+func (d *ApprovalDaemon) sendApprovalNotification(pending *PendingEmail) error {
+	actions := []NtfyAction{{
+		Action: "view",
+		Label:  "🔍 Review",
+		URL:    d.config.reviewURL(pending.ID),
+	}}
+	return d.ntfy.sendMessageWithActions(d.config.NtfyTopic, "📧 Approval pending", "An email is waiting for your approval. Tap Review to read it and decide.", actions)
+}
+
+// sender describes the sending account, e.g. "work (me@example.com)"
+func (p *PendingEmail) sender() string {
+	switch {
+	case p.From == "" && p.Account == "":
+		return "(unknown account)"
+	case p.From == "":
+		return p.Account
+	case p.Account == "" || p.Account == "default":
+		return p.From
+	default:
+		return fmt.Sprintf("%s (%s)", p.Account, p.From)
+	}
+}
+
+// applyAction resolves a pending request with a signed action from source,
+// the web view. Anything forged, expired or replayed is logged and ignored.
+func (d *ApprovalDaemon) applyAction(action ApprovalAction, source string) (accepted bool, refusal string) {
+	accepted, refusal = d.resolve(action, time.Now())
+	if !accepted {
+		log.Printf("⚠️  Ignoring approval action: request=%s from=%s reason=%s", action.RequestID, source, refusal)
+		return false, refusal
+	}
+
+	if action.Approve {
+		log.Printf("✅ Email approved by user: request=%s via=%s", action.RequestID, source)
+	} else {
+		log.Printf("❌ Email rejected by user: request=%s via=%s", action.RequestID, source)
 	}
+	return true, ""
 }
 
 func generateToken() (string, error) {
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testSigningSecret = "test-signing-secret"
+
+// newTestDaemon returns a daemon that is not connected to ntfy, for tests
+// that resolve requests directly
+func newTestDaemon(t *testing.T) *ApprovalDaemon {
+	t.Helper()
+	return newApprovalDaemon(&Config{NtfyTopic: "test-topic", SigningSecret: testSigningSecret}, nil)
+}
+
+// addPending registers a pending request as queueEmail would, without
+// notifying anyone or waiting
+func addPending(d *ApprovalDaemon, id, contentHash string, expiresAt time.Time) *PendingEmail {
+	pending := &PendingEmail{
+		ID:           id,
+		ContentHash:  contentHash,
+		ApproveNonce: id + "-approve",
+		RejectNonce:  id + "-reject",
+		ExpiresAt:    expiresAt,
+		ResultChan:   make(chan ApprovalResult, 1),
+	}
+	d.mu.Lock()
+	d.pending[id] = pending
+	d.mu.Unlock()
+	return pending
+}
+
+func TestResolveAcceptsGenuineAction(t *testing.T) {
+	d := newTestDaemon(t)
+	now := time.Unix(1700000000, 0)
+	pending := addPending(d, "req1", "sha256:aa", now.Add(approvalTimeout))
+
+	if accepted, refusal := d.resolve(d.signedAction(pending, true), now); !accepted {
+		t.Fatalf("genuine approve refused: %s", refusal)
+	}
+	select {
+	case result := <-pending.ResultChan:
+		if !result.Approved {
+			t.Fatal("approve delivered as a rejection")
+		}
+	default:
+		t.Fatal("no decision delivered")
+	}
+	if d.pendingCount() != 0 {
+		t.Fatal("resolved request still pending")
+	}
+}
+
+func TestResolveRefusesForgedActions(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name    string
+		action  func(d *ApprovalDaemon, pending *PendingEmail) ApprovalAction
+		refusal string
+	}{
+		{
+			name: "wrong secret",
+			action: func(d *ApprovalDaemon, pending *PendingEmail) ApprovalAction {
+				action := d.signedAction(pending, true)
+				action.Signature = signApprovalAction("guessed-secret", pending.ContentHash, action)
+				return action
+			},
+			refusal: "bad signature",
+		},
+		{
+			name: "content hash from another request",
+			action: func(d *ApprovalDaemon, pending *PendingEmail) ApprovalAction {
+				action := d.signedAction(pending, true)
+				action.Signature = signApprovalAction(testSigningSecret, "sha256:other", action)
+				return action
+			},
+			refusal: "bad signature",
+		},
+		{
+			name: "tampered expiry",
+			action: func(d *ApprovalDaemon, pending *PendingEmail) ApprovalAction {
+				action := d.signedAction(pending, true)
+				action.Expiry += 3600
+				return action
+			},
+			refusal: "bad signature",
+		},
+		{
+			name: "other decision's nonce",
+			action: func(d *ApprovalDaemon, pending *PendingEmail) ApprovalAction {
+				// Correctly signed, but approving with the Reject button's nonce
+				action := d.signedAction(pending, true)
+				action.Nonce = pending.RejectNonce
+				action.Signature = signApprovalAction(testSigningSecret, pending.ContentHash, action)
+				return action
+			},
+			refusal: "bad signature",
+		},
+		{
+			name: "unknown request",
+			action: func(d *ApprovalDaemon, pending *PendingEmail) ApprovalAction {
+				action := d.signedAction(pending, true)
+				action.RequestID = "req-unknown"
+				return action
+			},
+			refusal: "no such pending request",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDaemon(t)
+			pending := addPending(d, "req1", "sha256:aa", now.Add(approvalTimeout))
+
+			accepted, refusal := d.resolve(tt.action(d, pending), now)
+			if accepted || refusal != tt.refusal {
+				t.Fatalf("resolve = %v, %q; want refusal %q", accepted, refusal, tt.refusal)
+			}
+			if d.pendingCount() != 1 || len(pending.ResultChan) != 0 {
+				t.Fatal("refused action touched the pending request")
+			}
+			// The genuine action still works afterwards
+			if accepted, refusal := d.resolve(d.signedAction(pending, false), now); !accepted {
+				t.Fatalf("genuine reject refused after forgery: %s", refusal)
+			}
+		})
+	}
+}
+
+func TestResolveRefusesExpiredAction(t *testing.T) {
+	d := newTestDaemon(t)
+	expiresAt := time.Unix(1700000000, 0)
+	pending := addPending(d, "req1", "sha256:aa", expiresAt)
+
+	if accepted, refusal := d.resolve(d.signedAction(pending, true), expiresAt); accepted || refusal != "expired" {
+		t.Fatalf("resolve at expiry = %v, %q; want refusal %q", accepted, refusal, "expired")
+	}
+	if accepted, refusal := d.resolve(d.signedAction(pending, true), expiresAt.Add(time.Hour)); accepted || refusal != "expired" {
+		t.Fatalf("resolve after expiry = %v, %q; want refusal %q", accepted, refusal, "expired")
+	}
+	if len(pending.ResultChan) != 0 {
+		t.Fatal("expired action delivered a decision")
+	}
+}
+
+func TestResolveRefusesReplayedNonce(t *testing.T) {
+	d := newTestDaemon(t)
+	now := time.Unix(1700000000, 0)
+	pending := addPending(d, "req1", "sha256:aa", now.Add(approvalTimeout))
+	approve := d.signedAction(pending, true)
+
+	if accepted, refusal := d.resolve(approve, now); !accepted {
+		t.Fatalf("first use refused: %s", refusal)
+	}
+	if accepted, refusal := d.resolve(approve, now.Add(time.Second)); accepted || refusal != "replayed" {
+		t.Fatalf("second use = %v, %q; want refusal %q", accepted, refusal, "replayed")
+	}
+
+	// Even if the same request ID were pending again, the spent nonce stays refused
+	addPending(d, "req1", "sha256:aa", now.Add(approvalTimeout))
+	if accepted, refusal := d.resolve(approve, now.Add(2*time.Second)); accepted || refusal != "replayed" {
+		t.Fatalf("replay onto a new request = %v, %q; want refusal %q", accepted, refusal, "replayed")
+	}
+}
+
+// stubNtfy stands in for the ntfy server: it accepts every publish and
+// records it. onPublish, if set, runs while the publishing request waits.
+type stubNtfy struct {
+	mu        sync.Mutex
+	published []NtfyMessage
+	onPublish func(NtfyMessage)
+}
+
+func (s *stubNtfy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var msg NtfyMessage
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.published = append(s.published, msg)
+	onPublish := s.onPublish
+	s.mu.Unlock()
+	if onPublish != nil {
+		onPublish(msg)
+	}
+}
+
+func (s *stubNtfy) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.published)
+}
+
+// waitPublished waits until n notifications have been published and returns
+// them. queueEmail registers a request before it publishes, so a request
+// being pending does not mean its notification has gone out yet.
+func (s *stubNtfy) waitPublished(t *testing.T, n int) []NtfyMessage {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.count() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d notifications published, want %d", s.count(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]NtfyMessage(nil), s.published...)
+}
+
+// newNotifyingTestDaemon returns a daemon with private previews set up that
+// publishes to a stub ntfy server; nothing serves its web view
+func newNotifyingTestDaemon(t *testing.T) (*ApprovalDaemon, *stubNtfy) {
+	t.Helper()
+	t.Setenv("GMAIL_APPROVAL_NTFY_URL", "")
+	stub := &stubNtfy{}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+
+	key, err := generatePreviewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{
+		NtfyTopic:     "test-topic",
+		NtfyServer:    server.URL,
+		SigningSecret: testSigningSecret,
+		PreviewURL:    "https://phone.example",
+		PreviewKey:    key,
+	}
+	ntfy, err := newNtfyClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newApprovalDaemon(config, ntfy), stub
+}
+
+func TestNotificationCarriesNoCredentials(t *testing.T) {
+	d, stub := newNotifyingTestDaemon(t)
+	d.config.NtfyToken = "tk_daemon_secret"
+	ntfy, err := newNtfyClient(d.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.ntfy = ntfy
+
+	hangup := make(chan struct{})
+	done := make(chan IPCResponse, 1)
+	go func() { done <- d.queueEmail(testRequest(2), hangup) }()
+	defer func() { close(hangup); <-done }()
+
+	// Every subscriber, and the server's cache, sees the whole notification
+	published, err := json.Marshal(stub.waitPublished(t, 1)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(published), "tk_daemon_secret") || strings.Contains(string(published), "Bearer") {
+		t.Fatalf("notification carries the daemon's credentials: %s", published)
+	}
+}
+
+// waitPending waits until n requests are pending and returns them by subject
+func waitPending(t *testing.T, d *ApprovalDaemon, n int) map[string]*PendingEmail {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for d.pendingCount() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d requests pending, want %d", d.pendingCount(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	bySubject := make(map[string]*PendingEmail, n)
+	for _, pending := range d.pending {
+		bySubject[pending.Subject] = pending
+	}
+	return bySubject
+}
+
+func testRequest(i int) IPCRequest {
+	return IPCRequest{
+		Action:      "queue",
+		DraftID:     fmt.Sprintf("draft-%d", i),
+		ContentHash: fmt.Sprintf("sha256:%064x", i),
+		To:          "bob@example.com",
+		Subject:     fmt.Sprintf("email %d", i),
+		Body:        "hello",
+	}
+}
+
+func TestQueueEmailResolvesConcurrentRequestsOutOfOrder(t *testing.T) {
+	d, stub := newNotifyingTestDaemon(t)
+	const n = 10
+
+	responses := make([]IPCResponse, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses[i] = d.queueEmail(testRequest(i), nil)
+		}()
+	}
+	pending := waitPending(t, d, n)
+
+	// Decide newest first, approving the even ones, from several goroutines
+	var deciders sync.WaitGroup
+	for i := n - 1; i >= 0; i-- {
+		deciders.Add(1)
+		go func() {
+			defer deciders.Done()
+			p := pending[fmt.Sprintf("email %d", i)]
+			if accepted, refusal := d.resolve(d.signedAction(p, i%2 == 0), time.Now()); !accepted {
+				t.Errorf("decision on email %d refused: %s", i, refusal)
+			}
+		}()
+	}
+	deciders.Wait()
+	wg.Wait()
+
+	if stub.count() != n {
+		t.Fatalf("%d notifications published, want %d", stub.count(), n)
+	}
+
+	for i, resp := range responses {
+		if i%2 == 0 {
+			if !resp.Success || resp.Status != "approved" || resp.ContentHash != testRequest(i).ContentHash {
+				t.Errorf("email %d: got %+v, want approved with its own content hash", i, resp)
+			}
+		} else if resp.Success || resp.Error != "rejected by user" {
+			t.Errorf("email %d: got %+v, want rejected", i, resp)
+		}
+	}
+	if d.pendingCount() != 0 {
+		t.Fatalf("%d requests left pending", d.pendingCount())
+	}
+}
+
+func TestQueueEmailRefusesBeyondCap(t *testing.T) {
+	d, stub := newNotifyingTestDaemon(t)
+
+	var wg sync.WaitGroup
+	for i := range maxPendingEmails {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.queueEmail(testRequest(i), nil)
+		}()
+	}
+	pending := waitPending(t, d, maxPendingEmails)
+
+	resp := d.queueEmail(testRequest(maxPendingEmails), nil)
+	if resp.Success || !strings.Contains(resp.Error, "already pending") {
+		t.Fatalf("request over the cap: got %+v, want refusal", resp)
+	}
+	// Deciding one frees a slot
+	if accepted, refusal := d.resolve(d.signedAction(pending["email 0"], false), time.Now()); !accepted {
+		t.Fatalf("reject refused: %s", refusal)
+	}
+	done := make(chan IPCResponse, 1)
+	go func() { done <- d.queueEmail(testRequest(maxPendingEmails), nil) }()
+	pending = waitPending(t, d, maxPendingEmails)
+
+	for _, p := range pending {
+		d.resolve(d.signedAction(p, false), time.Now())
+	}
+	wg.Wait()
+	if resp := <-done; resp.Error != "rejected by user" {
+		t.Fatalf("request queued after a slot freed: got %+v", resp)
+	}
+	// One notification per accepted request; the refused one sent none
+	if stub.count() != maxPendingEmails+1 {
+		t.Fatalf("%d notifications published, want %d", stub.count(), maxPendingEmails+1)
+	}
+}
+
+func TestQueueEmailWithdrawnOnHangup(t *testing.T) {
+	d, _ := newNotifyingTestDaemon(t)
+
+	hangup := make(chan struct{})
+	done := make(chan IPCResponse, 1)
+	go func() { done <- d.queueEmail(testRequest(1), hangup) }()
+	pending := waitPending(t, d, 1)["email 1"]
+
+	close(hangup)
+	if resp := <-done; resp.Success || resp.Error != "request cancelled" {
+		t.Fatalf("after hangup: got %+v, want cancelled", resp)
+	}
+	if d.pendingCount() != 0 {
+		t.Fatal("withdrawn request still pending")
+	}
+	if accepted, refusal := d.resolve(d.signedAction(pending, true), time.Now()); accepted || refusal != "no such pending request" {
+		t.Fatalf("approve after hangup = %v, %q; want refusal", accepted, refusal)
+	}
+}
+
+func TestQueueEmailTimeoutRacesDecision(t *testing.T) {
+	d, stub := newNotifyingTestDaemon(t)
+	d.timeout = 2 * time.Millisecond
+
+	type decision struct {
+		accepted bool
+		refusal  string
+	}
+	decided := make(chan decision, 1)
+	// The notification is published before the wait starts, so the decision
+	// is scheduled from there to land around the moment the timer fires
+	stub.onPublish = func(NtfyMessage) {
+		var p *PendingEmail
+		d.mu.Lock()
+		for _, pending := range d.pending {
+			p = pending // the only one
+		}
+		d.mu.Unlock()
+		go func() {
+			time.Sleep(time.Duration(rand.IntN(4000)) * time.Microsecond)
+			// The race under test is with the wait timer, not the signed
+			// expiry, which has only one-second resolution
+			accepted, refusal := d.resolve(d.signedAction(p, true), time.Unix(0, 0))
+			decided <- decision{accepted, refusal}
+		}()
+	}
+
+	var approved, timedOut int
+	for i := range 200 {
+		resp := d.queueEmail(testRequest(i), nil)
+		got := <-decided
+		switch {
+		case got.accepted && resp.Success && resp.Status == "approved":
+			approved++
+		case !got.accepted && got.refusal == "no such pending request" && resp.Error == "approval timed out":
+			timedOut++
+		default:
+			t.Fatalf("round %d: decision %+v but response %+v", i, got, resp)
+		}
+		if d.pendingCount() != 0 {
+			t.Fatalf("round %d: request left pending", i)
+		}
+	}
+	t.Logf("%d approved, %d timed out", approved, timedOut)
+}
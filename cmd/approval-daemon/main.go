@@ -1,15 +1,22 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"os"
+	"time"
+
+	qrcode "github.com/skip2/go-qrcode"
 )
 
 func main() {
 	reset := flag.Bool("reset", false, "Reset configuration and re-run setup")
 	status := flag.Bool("status", false, "Show daemon status")
+	pair := flag.Bool("pair", false, "Show the QR code that pairs a phone with the private previews")
 	flag.Parse()
 
 	if *status {
@@ -17,6 +24,13 @@ func main() {
 		return
 	}
 
+	if *pair {
+		if err := showPairing(); err != nil {
+			log.Fatalf("Pairing error: %v", err)
+		}
+		return
+	}
+
 	if *reset {
 		resetConfig()
 	}
@@ -26,8 +40,121 @@ func main() {
 	}
 }
 
+// showStatus reports whether the daemon is set up and running, and checks
+// that the ntfy server is reachable with the configured credentials
 func showStatus() {
-	fmt.Println("Status: not implemented yet")
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Printf("Config:  ✗ %v\n", err)
+		os.Exit(1)
+	}
+	if config == nil || !config.SetupComplete {
+		fmt.Println("Config:  ✗ setup not completed - run gmail-approval-daemon to set up")
+		os.Exit(1)
+	}
+	fmt.Printf("Config:  ✓ %s\n", getConfigPath())
+
+	healthy := true
+	if err := pingDaemon(); err != nil {
+		fmt.Printf("Daemon:  ✗ %v\n", err)
+		healthy = false
+	} else {
+		fmt.Printf("Daemon:  ✓ running (%s)\n", getSocketPath())
+	}
+
+	ntfy, err := newNtfyClient(config)
+	if err != nil {
+		fmt.Printf("ntfy:    ✗ %v\n", err)
+		os.Exit(1)
+	}
+	auth := "no credentials"
+	switch {
+	case config.NtfyToken != "":
+		auth = "access token"
+	case config.NtfyUsername != "":
+		auth = "user " + config.NtfyUsername
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ntfyPublishTimeout)
+	err = ntfy.check(ctx, config.NtfyTopic)
+	cancel()
+	if err != nil {
+		fmt.Printf("ntfy:    ✗ %v\n", err)
+		healthy = false
+	} else {
+		fmt.Printf("ntfy:    ✓ %s reachable, topic readable (%s)\n", ntfy.topicURL(config.NtfyTopic), auth)
+	}
+
+	switch {
+	case !config.previewsEnabled():
+		fmt.Println("Preview: ✗ no preview key, approval requests are refused: restart the daemon to create one")
+		healthy = false
+	case config.PreviewURL == "":
+		fmt.Printf("Preview: ✓ encrypted, reviewed at %s on this machine only (set preview_url to review on your phone)\n", config.previewBaseURL())
+	default:
+		fmt.Printf("Preview: ✓ encrypted, reviewed at %s\n", config.PreviewURL)
+	}
+
+	if !healthy {
+		os.Exit(1)
+	}
+}
+
+// showPairing prints the pairing QR code for the private previews, creating
+// the preview key if the config has none yet
+func showPairing() error {
+	config, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if config == nil {
+		return fmt.Errorf("not set up yet: run gmail-approval-daemon first")
+	}
+	if _, err := normalizePreviewURL(config.previewBaseURL()); err != nil {
+		return err
+	}
+	if config.PreviewKey == "" {
+		if config.PreviewKey, err = generatePreviewKey(); err != nil {
+			return err
+		}
+		if err := saveConfig(config); err != nil {
+			return fmt.Errorf("failed to save preview key: %w", err)
+		}
+		fmt.Println("Generated a new preview key; restart the daemon to use it.")
+	}
+
+	qr, err := qrcode.New(config.pairingURL(), qrcode.Medium)
+	if err != nil {
+		return fmt.Errorf("failed to generate QR code: %w", err)
+	}
+	fmt.Print(qr.ToSmallString(false))
+	fmt.Println("Scan with your phone's camera to pair it. The link holds the preview key: don't share it.")
+	if config.PreviewURL == "" {
+		// The web view is only reachable here until preview_url is set
+		fmt.Printf("Without preview_url only this machine reaches the web view: pair a browser here by opening %s\n", config.pairingURL())
+	}
+	return nil
+}
+
+// pingDaemon asks a running daemon for its status over the socket
+func pingDaemon() error {
+	conn, err := net.DialTimeout("unix", getSocketPath(), 2*time.Second)
+	if err != nil {
+		return fmt.Errorf("not running (no socket at %s)", getSocketPath())
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if err := json.NewEncoder(conn).Encode(IPCRequest{Action: "status"}); err != nil {
+		return fmt.Errorf("failed to query daemon: %w", err)
+	}
+	var resp IPCResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return fmt.Errorf("failed to read daemon status: %w", err)
+	}
+	if !resp.Success {
+		return fmt.Errorf("daemon reported: %s", resp.Error)
+	}
+	return nil
 }
 
 func resetConfig() {
@@ -76,8 +203,39 @@ func run() error {
 		}
 	}
 
+	// Approval actions are signed with the secret; configs from before it was
+	// used may lack one
+	if config.SigningSecret == "" {
+		if config.SigningSecret, err = generateRandomString(32); err != nil {
+			return fmt.Errorf("failed to generate signing secret: %w", err)
+		}
+		if err := saveConfig(config); err != nil {
+			return fmt.Errorf("failed to save signing secret: %w", err)
+		}
+	}
+
+	// Previews are sealed with the preview key, without which nothing could be
+	// approved; configs from before previews were always on may lack one
+	if config.PreviewKey == "" {
+		if config.PreviewKey, err = generatePreviewKey(); err != nil {
+			return fmt.Errorf("failed to generate preview key: %w", err)
+		}
+		if err := saveConfig(config); err != nil {
+			return fmt.Errorf("failed to save preview key: %w", err)
+		}
+		log.Println("Created a preview key: run gmail-approval-daemon -pair to pair your phone")
+	}
+	if _, err := normalizePreviewURL(config.previewBaseURL()); err != nil {
+		return err
+	}
+
+	ntfy, err := newNtfyClient(config)
+	if err != nil {
+		return fmt.Errorf("failed to configure ntfy: %w", err)
+	}
+
 	// Create and start daemon
-	daemon := newApprovalDaemon(config)
+	daemon := newApprovalDaemon(config, ntfy)
 
 	// Start socket server
 	socketServer, err := newSocketServer(daemon)
@@ -86,13 +244,24 @@ func run() error {
 	}
 	defer socketServer.close()
 
-	// Start polling in background
-	go daemon.startPolling()
+	// Serve the encrypted previews, where approvals are decided
+	previewServer, err := newPreviewServer(daemon)
+	if err != nil {
+		return err
+	}
+	defer previewServer.close()
+	go previewServer.run()
 
 	log.Println("═══════════════════════════════════════════════════════════════")
 	log.Println("📱 APPROVAL DAEMON RUNNING")
 	log.Println("═══════════════════════════════════════════════════════════════")
+	log.Printf("   ntfy server: %s", ntfy.baseURL)
 	log.Printf("   ntfy topic: %s", config.NtfyTopic)
+	if config.PreviewURL == "" {
+		log.Printf("   Private previews: %s (this machine only; set preview_url to review on your phone)", config.previewBaseURL())
+	} else {
+		log.Printf("   Private previews: %s", config.PreviewURL)
+	}
 	log.Printf("   Socket: %s", getSocketPath())
 	log.Println("═══════════════════════════════════════════════════════════════")
 
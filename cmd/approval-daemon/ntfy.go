@@ -2,22 +2,33 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"strings"
 	"time"
 )
 
-const ntfyBaseURL = "https://ntfy.sh"
+const defaultNtfyBaseURL = "https://ntfy.sh"
 
+// ntfyPublishTimeout bounds a single publish or status check
+const ntfyPublishTimeout = 30 * time.Second
+
+// NtfyAction is a notification button. Only view actions, which open a URL,
+// are used: anything an action carries is published to every subscriber, so
+// nothing that authenticates or decides may go in one.
 type NtfyAction struct {
 	Action string `json:"action"`
 	Label  string `json:"label"`
 	URL    string `json:"url,omitempty"`
-	Method string `json:"method,omitempty"`
-	Body   string `json:"body,omitempty"`
 }
 
 type NtfyMessage struct {
@@ -29,24 +40,119 @@ type NtfyMessage struct {
 	Actions  []NtfyAction `json:"actions,omitempty"`
 }
 
-type NtfyPollMessage struct {
-	ID      string `json:"id"`
-	Time    int64  `json:"time"`
-	Event   string `json:"event"`
-	Topic   string `json:"topic"`
-	Message string `json:"message"`
+// NtfyClient talks to one ntfy server: ntfy.sh, or a self-hosted instance
+// with its own credentials and CA
+type NtfyClient struct {
+	baseURL       string
+	authorization string       // Authorization header value, empty without credentials; only ever sent to the server
+	client        *http.Client // publishing and status checks
+}
+
+// newNtfyClient configures the ntfy server from config. GMAIL_APPROVAL_NTFY_URL
+// overrides the base URL, e.g. to run the whole approval flow against a local ntfy.
+func newNtfyClient(config *Config) (*NtfyClient, error) {
+	baseURL := defaultNtfyBaseURL
+	if config.NtfyServer != "" {
+		baseURL = config.NtfyServer
+	}
+	if override := os.Getenv("GMAIL_APPROVAL_NTFY_URL"); override != "" {
+		baseURL = override
+	}
+	baseURL, err := normalizeNtfyBaseURL(baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.NtfyCAFile != "" {
+		roots, err := loadCABundle(config.NtfyCAFile)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+	}
+
+	c := &NtfyClient{
+		baseURL: baseURL,
+		client:  &http.Client{Transport: transport, Timeout: ntfyPublishTimeout},
+	}
+	switch {
+	case config.NtfyToken != "":
+		c.authorization = "Bearer " + config.NtfyToken
+	case config.NtfyUsername != "":
+		credentials := config.NtfyUsername + ":" + config.NtfyPassword
+		c.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+	}
+	return c, nil
+}
+
+// normalizeNtfyBaseURL checks a server URL and strips any trailing slash.
+// Plain http is only allowed to this machine, since requests carry approval
+// tokens and credentials.
+func normalizeNtfyBaseURL(raw string) (string, error) {
+	parsed, err := url.Parse(strings.TrimRight(strings.TrimSpace(raw), "/"))
+	if err != nil || parsed.Host == "" || (parsed.Scheme != "https" && parsed.Scheme != "http") {
+		return "", fmt.Errorf("invalid ntfy server URL %q: use e.g. https://ntfy.example.com", raw)
+	}
+	if parsed.Scheme == "http" && !isLoopbackHost(parsed.Hostname()) {
+		return "", fmt.Errorf("ntfy server %q must use https: credentials would otherwise cross the network in the clear", raw)
+	}
+	if parsed.RawQuery != "" || parsed.Fragment != "" {
+		return "", fmt.Errorf("invalid ntfy server URL %q: remove the query string", raw)
+	}
+	return parsed.String(), nil
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// loadCABundle returns the system roots plus the PEM certificates in path
+func loadCABundle(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ntfy CA bundle: %w", err)
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("ntfy CA bundle %s contains no PEM certificates", path)
+	}
+	return roots, nil
+}
+
+// topicURL is where a topic is read from, and what the phone opens to subscribe
+func (c *NtfyClient) topicURL(topic string) string {
+	return c.baseURL + "/" + url.PathEscape(topic)
 }
 
-func sendNtfyNotification(topic, title, message string) error {
+func (c *NtfyClient) newRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.authorization != "" {
+		req.Header.Set("Authorization", c.authorization)
+	}
+	return req, nil
+}
+
+func (c *NtfyClient) sendNotification(topic, title, message string) error {
 	msg := NtfyMessage{
 		Topic:   topic,
 		Title:   title,
 		Message: message,
 	}
-	return sendNtfyMessage(msg)
+	return c.sendMessage(msg)
 }
 
-func sendNtfyMessageWithActions(topic, title, message string, actions []NtfyAction) error {
+func (c *NtfyClient) sendMessageWithActions(topic, title, message string, actions []NtfyAction) error {
 	msg := NtfyMessage{
 		Topic:    topic,
 		Title:    title,
@@ -55,60 +161,53 @@ func sendNtfyMessageWithActions(topic, title, message string, actions []NtfyActi
 		Tags:     []string{"email", "outgoing_envelope"},
 		Actions:  actions,
 	}
-	return sendNtfyMessage(msg)
+	return c.sendMessage(msg)
 }
 
-func sendNtfyMessage(msg NtfyMessage) error {
+func (c *NtfyClient) sendMessage(msg NtfyMessage) error {
 	body, err := json.Marshal(msg)
 	if err != nil {
 		return fmt.Errorf("failed to marshal message: %w", err)
 	}
 
-	resp, err := http.Post(ntfyBaseURL, "application/json", bytes.NewReader(body))
+	req, err := c.newRequest(context.Background(), http.MethodPost, c.baseURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to send notification: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
 		return fmt.Errorf("ntfy returned status %d: %s", resp.StatusCode, string(body))
 	}
 	return nil
 }
 
-func pollNtfyMessages(topic string, since time.Time) ([]NtfyPollMessage, error) {
-	pollURL := fmt.Sprintf("%s/%s/json?poll=1&since=%d", ntfyBaseURL, url.PathEscape(topic), since.Unix())
-
-	resp, err := http.Get(pollURL)
+// check verifies that the server is reachable and that the configured
+// credentials may read topic, without publishing anything
+func (c *NtfyClient) check(ctx context.Context, topic string) error {
+	req, err := c.newRequest(ctx, http.MethodGet, c.topicURL(topic)+"/json?poll=1&since=1m", nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to poll: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("ntfy poll returned status %d: %s", resp.StatusCode, string(body))
+		return fmt.Errorf("failed to create request: %w", err)
 	}
-
-	body, err := io.ReadAll(resp.Body)
+	resp, err := c.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read poll response: %w", err)
+		return fmt.Errorf("cannot reach %s: %w", c.baseURL, err)
 	}
+	defer resp.Body.Close()
 
-	// ntfy returns newline-delimited JSON
-	var messages []NtfyPollMessage
-	for _, line := range bytes.Split(body, []byte("\n")) {
-		if len(line) == 0 {
-			continue
-		}
-		var msg NtfyPollMessage
-		if err := json.Unmarshal(line, &msg); err != nil {
-			continue // Skip malformed messages
-		}
-		if msg.Event == "message" {
-			messages = append(messages, msg)
-		}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%s rejected the credentials for topic %s (status %d): check ntfy_token or ntfy_username/ntfy_password", c.baseURL, topic, resp.StatusCode)
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("ntfy returned status %d: %s", resp.StatusCode, string(body))
 	}
-	return messages, nil
 }
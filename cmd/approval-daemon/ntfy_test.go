@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeNtfyBaseURL(t *testing.T) {
+	tests := []struct {
+		raw, want string
+		wantErr   bool
+	}{
+		{raw: "https://ntfy.example.com/", want: "https://ntfy.example.com"},
+		{raw: " https://ntfy.example.com/base ", want: "https://ntfy.example.com/base"},
+		{raw: "http://localhost:8080", want: "http://localhost:8080"},
+		{raw: "http://127.0.0.1:8080", want: "http://127.0.0.1:8080"},
+		{raw: "http://ntfy.example.com", wantErr: true},
+		{raw: "ftp://ntfy.example.com", wantErr: true},
+		{raw: "ntfy.example.com", wantErr: true},
+		{raw: "https://ntfy.example.com/?token=x", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := normalizeNtfyBaseURL(tt.raw)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("normalizeNtfyBaseURL(%q) = %q, want an error", tt.raw, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("normalizeNtfyBaseURL(%q) = %q, %v; want %q", tt.raw, got, err, tt.want)
+		}
+	}
+}
+
+func TestNtfyClientCredentialsAndCA(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Authorization") {
+		case "Bearer tk_good", "Basic dXNlcjpwYXNz": // user:pass
+		default:
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+	t.Setenv("GMAIL_APPROVAL_NTFY_URL", "")
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr string
+	}{
+		{name: "bearer token", config: Config{NtfyToken: "tk_good", NtfyCAFile: caFile}},
+		{name: "basic auth", config: Config{NtfyUsername: "user", NtfyPassword: "pass", NtfyCAFile: caFile}},
+		{name: "rejected token", config: Config{NtfyToken: "tk_bad", NtfyCAFile: caFile}, wantErr: "rejected the credentials"},
+		{name: "untrusted certificate", config: Config{NtfyToken: "tk_good"}, wantErr: "cannot reach"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.NtfyServer = server.URL
+			ntfy, err := newNtfyClient(&tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = ntfy.check(context.Background(), "approvals")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("check() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := newNtfyClient(&Config{NtfyServer: server.URL, NtfyCAFile: filepath.Join(t.TempDir(), "missing.pem")}); err == nil {
+		t.Fatal("missing CA bundle accepted")
+	}
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Private previews: approval notifications on ntfy only say that an approval
+// is pending. The email itself, and the signed Approve/Reject actions, are
+// sealed with AES-256-GCM under preview_key and served as ciphertext by a
+// small web view. The phone's browser gets the key
+// once, when it scans the pairing QR code during setup (in the URL fragment,
+// which is never sent to a server), and decrypts the preview itself. Neither
+// ntfy nor anything proxying the web view sees the content.
+//
+// The web view is the only place decisions are taken. The topic carries no
+// content and no actions, so reading it, or posting to it, approves nothing.
+//
+// Previews are always on. The key is created with the config, or at startup
+// for configs from before that. Without preview_url the Review link opens the
+// web view at its listen address, which works from a browser on this machine;
+// preview_url is the https address that lets the phone review as well.
+
+const defaultPreviewListen = "127.0.0.1:8765"
+
+// previewKeyBytes is the AES-256 key size
+const previewKeyBytes = 32
+
+// approvalPreview is what the web view shows once decrypted
+type approvalPreview struct {
+	RequestID   string       `json:"requestId"`
+	Sender      string       `json:"sender"`
+	To          string       `json:"to"`
+	Cc          string       `json:"cc,omitempty"`
+	Bcc         string       `json:"bcc,omitempty"`
+	Subject     string       `json:"subject"`
+	Body        string       `json:"body"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+	ContentHash string       `json:"contentHash"`
+	ExpiresAt   int64        `json:"expiresAt"`
+	Approve     string       `json:"approve"` // signed action bodies, posted back to decide
+	Reject      string       `json:"reject"`
+}
+
+// sealedPreview is an encrypted approvalPreview, bound to its request ID
+type sealedPreview struct {
+	Nonce      string `json:"nonce"`      // base64url GCM nonce
+	Ciphertext string `json:"ciphertext"` // base64url ciphertext and tag
+}
+
+// generatePreviewKey returns a new base64url-encoded AES-256 key
+func generatePreviewKey() (string, error) {
+	key := make([]byte, previewKeyBytes)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(key), nil
+}
+
+// previewsEnabled reports whether there is a key to seal previews with,
+// without which no request can be approved
+func (c *Config) previewsEnabled() bool {
+	return c.PreviewKey != ""
+}
+
+// previewListen is the address the web view listens on
+func (c *Config) previewListen() string {
+	if c.PreviewListen == "" {
+		return defaultPreviewListen
+	}
+	return c.PreviewListen
+}
+
+// previewBaseURL is where the web view is opened: preview_url, or the listen
+// address itself without one
+func (c *Config) previewBaseURL() string {
+	if c.PreviewURL == "" {
+		return "http://" + c.previewListen()
+	}
+	return c.PreviewURL
+}
+
+// normalizePreviewURL checks the web view's public base URL. Browsers only
+// allow WebCrypto on https pages (or localhost), so plain http elsewhere could
+// never decrypt anything.
+func normalizePreviewURL(raw string) (string, error) {
+	normalized, err := normalizeNtfyBaseURL(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid preview URL %q: use the https address your phone reaches the daemon's web view at", raw)
+	}
+	return normalized, nil
+}
+
+// sealPreview encrypts preview under the base64url key, using the request ID
+// as additional data so a ciphertext cannot be served for another request
+func sealPreview(key string, preview approvalPreview) (sealedPreview, error) {
+	aead, err := previewAEAD(key)
+	if err != nil {
+		return sealedPreview{}, err
+	}
+	plaintext, err := json.Marshal(preview)
+	if err != nil {
+		return sealedPreview{}, fmt.Errorf("failed to marshal preview: %w", err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return sealedPreview{}, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	ciphertext := aead.Seal(nil, nonce, plaintext, []byte(preview.RequestID))
+	return sealedPreview{
+		Nonce:      base64.RawURLEncoding.EncodeToString(nonce),
+		Ciphertext: base64.RawURLEncoding.EncodeToString(ciphertext),
+	}, nil
+}
+
+func previewAEAD(key string) (cipher.AEAD, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(key)
+	if err != nil || len(raw) != previewKeyBytes {
+		return nil, fmt.Errorf("preview_key must be %d base64url-encoded bytes", previewKeyBytes)
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// pairingURL opens the web view on the phone and hands it the key
+func (c *Config) pairingURL() string {
+	return c.previewBaseURL() + "/pair#" + c.PreviewKey
+}
+
+// reviewURL is the page the notification's Review button opens
+func (c *Config) reviewURL(requestID string) string {
+	return c.previewBaseURL() + "/review/" + requestID
+}
+
+// PreviewServer is the web view that serves sealed previews and takes the
+// decisions made on them
+type PreviewServer struct {
+	daemon   *ApprovalDaemon
+	listener net.Listener
+	server   *http.Server
+}
+
+func newPreviewServer(daemon *ApprovalDaemon) (*PreviewServer, error) {
+	addr := daemon.config.previewListen()
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for previews on %s: %w", addr, err)
+	}
+
+	s := &PreviewServer{daemon: daemon, listener: listener}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /pair", s.handlePage(pairHTML))
+	mux.HandleFunc("GET /review/{id}", s.handlePage(reviewHTML))
+	mux.HandleFunc("GET /api/requests/{id}", s.handleSealed)
+	mux.HandleFunc("POST /api/requests/{id}/decision", s.handleDecision)
+	s.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+	return s, nil
+}
+
+func (s *PreviewServer) run() {
+	log.Printf("Preview web view listening on %s", s.listener.Addr())
+	if err := s.server.Serve(s.listener); err != nil && err != http.ErrServerClosed {
+		log.Printf("Preview server error: %v", err)
+	}
+}
+
+func (s *PreviewServer) close() {
+	s.server.Shutdown(context.Background())
+}
+
+// setPreviewHeaders keeps pages and previews out of caches and referrers
+func setPreviewHeaders(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; connect-src 'self'")
+}
+
+// handlePage serves a static page; everything secret stays in the browser
+func (s *PreviewServer) handlePage(page string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		setPreviewHeaders(w)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, page)
+	}
+}
+
+func (s *PreviewServer) handleSealed(w http.ResponseWriter, r *http.Request) {
+	setPreviewHeaders(w)
+	sealed, ok := s.daemon.sealedPreview(r.PathValue("id"))
+	if !ok {
+		writePreviewError(w, http.StatusNotFound, "this email is no longer waiting for approval")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sealed)
+}
+
+// handleDecision applies an action the web view decrypted from the preview
+func (s *PreviewServer) handleDecision(w http.ResponseWriter, r *http.Request) {
+	setPreviewHeaders(w)
+	var req struct {
+		Action string `json:"action"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&req); err != nil {
+		writePreviewError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	action, ok := parseApprovalAction(req.Action)
+	if !ok || action.RequestID != r.PathValue("id") {
+		writePreviewError(w, http.StatusBadRequest, "invalid action")
+		return
+	}
+	if accepted, refusal := s.daemon.applyAction(action, "web view"); !accepted {
+		writePreviewError(w, http.StatusConflict, "not applied: "+refusal)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+func writePreviewError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": message})
+}
+
+// previewScript is shared by the pages: key storage and base64url helpers
+const previewScript = `
+const KEY_NAME = 'gmail-mcp-preview-key';
+function fromB64url(s) {
+    s = s.replace(/-/g, '+').replace(/_/g, '/');
+    while (s.length % 4) s += '=';
+    return Uint8Array.from(atob(s), c => c.charCodeAt(0));
+}
+`
+
+const pairHTML = `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>Pair approval previews</title>
+    <style>body { font-family: -apple-system, system-ui, sans-serif; max-width: 600px; margin: 40px auto; padding: 20px; }</style>
+</head>
+<body>
+    <h1 id="title">Pairing…</h1>
+    <p id="detail"></p>
+    <script>` + previewScript + `
+        const key = location.hash.slice(1);
+        // Drop the key from the address bar and history right away
+        history.replaceState(null, '', location.pathname);
+        if (key && fromB64url(key).length === 32) {
+            localStorage.setItem(KEY_NAME, key);
+            document.getElementById('title').textContent = '✓ This device is paired';
+            document.getElementById('detail').textContent = 'Approval requests will open here with their full content. You can close this page.';
+        } else {
+            document.getElementById('title').textContent = '✗ No pairing key';
+            document.getElementById('detail').textContent = 'Scan the pairing QR code on the daemon setup page again.';
+        }
+    </script>
+</body>
+</html>`
+
+const reviewHTML = `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>Review email</title>
+    <style>
+        body { font-family: -apple-system, system-ui, sans-serif; max-width: 700px; margin: 20px auto; padding: 16px; }
+        .field { margin: 6px 0; }
+        .label { color: #666; display: inline-block; min-width: 70px; }
+        .body { white-space: pre-wrap; background: #fafafa; border: 1px solid #ddd; border-radius: 4px; padding: 12px; margin: 16px 0; }
+        .attachment, .hash { font-family: monospace; font-size: 12px; word-break: break-all; }
+        .btn { border: none; color: white; padding: 14px 28px; border-radius: 4px; font-size: 16px; margin: 6px 6px 6px 0; }
+        .approve { background: #4CAF50; }
+        .reject { background: #f44336; }
+        .error { color: #c62828; }
+    </style>
+</head>
+<body>
+    <h2 id="title">Decrypting…</h2>
+    <div id="preview" hidden>
+        <div class="field"><span class="label">From</span> <span id="sender"></span></div>
+        <div class="field"><span class="label">To</span> <span id="to"></span></div>
+        <div class="field" id="cc-row" hidden><span class="label">Cc</span> <span id="cc"></span></div>
+        <div class="field" id="bcc-row" hidden><span class="label">Bcc</span> <span id="bcc"></span></div>
+        <div class="field"><span class="label">Subject</span> <strong id="subject"></strong></div>
+        <div class="body" id="body"></div>
+        <div id="attachments"></div>
+        <p class="hash">🔒 <span id="hash"></span></p>
+        <p id="expires"></p>
+        <button class="btn approve" id="approve">✓ Approve</button>
+        <button class="btn reject" id="reject">✗ Reject</button>
+    </div>
+    <p id="status"></p>
+    <script>` + previewScript + `
+        const id = location.pathname.split('/').pop();
+        const show = (elementId, text) => { document.getElementById(elementId).textContent = text; };
+
+        async function load() {
+            const key = localStorage.getItem(KEY_NAME);
+            if (!key) {
+                show('title', 'This device is not paired');
+                show('status', 'Scan the pairing QR code on the daemon setup page, then open the notification again.');
+                return;
+            }
+            const resp = await fetch('/api/requests/' + encodeURIComponent(id));
+            const sealed = await resp.json();
+            if (!resp.ok) {
+                show('title', 'Nothing to review');
+                show('status', sealed.error);
+                return;
+            }
+            const cryptoKey = await crypto.subtle.importKey('raw', fromB64url(key), 'AES-GCM', false, ['decrypt']);
+            let plaintext;
+            try {
+                plaintext = await crypto.subtle.decrypt(
+                    { name: 'AES-GCM', iv: fromB64url(sealed.nonce), additionalData: new TextEncoder().encode(id) },
+                    cryptoKey, fromB64url(sealed.ciphertext));
+            } catch (err) {
+                show('title', 'Cannot decrypt this request');
+                document.getElementById('status').className = 'error';
+                show('status', 'The preview did not decrypt with this device\'s key. Pair again, and do not approve anything you cannot read.');
+                return;
+            }
+            render(JSON.parse(new TextDecoder().decode(plaintext)));
+        }
+
+        function render(preview) {
+            show('title', 'Approve this email?');
+            show('sender', preview.sender);
+            show('to', preview.to);
+            if (preview.cc) { show('cc', preview.cc); document.getElementById('cc-row').hidden = false; }
+            if (preview.bcc) { show('bcc', preview.bcc); document.getElementById('bcc-row').hidden = false; }
+            show('subject', preview.subject);
+            show('body', preview.body);
+            show('hash', preview.contentHash);
+            show('expires', 'Expires at ' + new Date(preview.expiresAt * 1000).toLocaleTimeString());
+            const list = document.getElementById('attachments');
+            for (const attachment of preview.attachments || []) {
+                const row = document.createElement('div');
+                row.className = 'attachment';
+                row.textContent = '📎 ' + (attachment.filename || '(unnamed ' + attachment.mimeType + ' part)') + ' (' + attachment.size + ' bytes) sha256:' + attachment.sha256;
+                list.appendChild(row);
+            }
+            document.getElementById('approve').onclick = () => decide(preview.approve, 'Approved');
+            document.getElementById('reject').onclick = () => decide(preview.reject, 'Rejected');
+            document.getElementById('preview').hidden = false;
+        }
+
+        async function decide(action, label) {
+            document.querySelectorAll('.btn').forEach(b => b.disabled = true);
+            const resp = await fetch('/api/requests/' + encodeURIComponent(id) + '/decision', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ action: action }),
+            });
+            const data = await resp.json();
+            if (data.success) {
+                show('title', '✓ ' + label);
+                document.getElementById('preview').hidden = true;
+            } else {
+                document.getElementById('status').className = 'error';
+                show('status', data.error);
+            }
+        }
+
+        load().catch(err => { show('title', 'Error'); show('status', err.message); });
+    </script>
+</body>
+</html>`
+
+// sealedPreviewFor builds and encrypts the preview of a pending request
+func (d *ApprovalDaemon) sealedPreviewFor(pending *PendingEmail) (sealedPreview, error) {
+	return sealPreview(d.config.PreviewKey, approvalPreview{
+		RequestID:   pending.ID,
+		Sender:      pending.sender(),
+		To:          pending.To,
+		Cc:          pending.Cc,
+		Bcc:         pending.Bcc,
+		Subject:     pending.Subject,
+		Body:        pending.Body,
+		Attachments: pending.Attachments,
+		ContentHash: pending.ContentHash,
+		ExpiresAt:   pending.ExpiresAt.Unix(),
+		Approve:     d.signedAction(pending, true).String(),
+		Reject:      d.signedAction(pending, false).String(),
+	})
+}
+
+// sealedPreview returns the encrypted preview of a request that is still pending
+func (d *ApprovalDaemon) sealedPreview(id string) (sealedPreview, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	pending, ok := d.pending[strings.TrimSpace(id)]
+	if !ok {
+		return sealedPreview{}, false
+	}
+	return pending.Sealed, true
+}
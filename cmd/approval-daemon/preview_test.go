@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// decryptPreview does what the paired phone's browser does with a sealed preview
+func decryptPreview(t *testing.T, key, requestID string, sealed sealedPreview) approvalPreview {
+	t.Helper()
+	aead, err := previewAEAD(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce, _ := base64.RawURLEncoding.DecodeString(sealed.Nonce)
+	ciphertext, _ := base64.RawURLEncoding.DecodeString(sealed.Ciphertext)
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(requestID))
+	if err != nil {
+		t.Fatalf("preview does not decrypt: %v", err)
+	}
+	var preview approvalPreview
+	if err := json.Unmarshal(plaintext, &preview); err != nil {
+		t.Fatal(err)
+	}
+	return preview
+}
+
+// startPreviewServer serves d's web view on a free local port and points
+// its preview_url there
+func startPreviewServer(t *testing.T, d *ApprovalDaemon) {
+	t.Helper()
+	d.config.PreviewListen = "127.0.0.1:0"
+	previews, err := newPreviewServer(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go previews.run()
+	t.Cleanup(previews.close)
+	d.config.PreviewURL = "http://" + previews.listener.Addr().String()
+}
+
+// reviewedRequestID returns the request ID in a notification's Review link
+func reviewedRequestID(t *testing.T, notification NtfyMessage) string {
+	t.Helper()
+	if len(notification.Actions) != 1 || notification.Actions[0].Action != "view" {
+		t.Fatalf("notification actions = %+v, want just the Review link", notification.Actions)
+	}
+	reviewURL, err := url.Parse(notification.Actions[0].URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimPrefix(reviewURL.Path, "/review/")
+}
+
+// fetchSealed gets a request's sealed preview as the review page does
+func fetchSealed(t *testing.T, d *ApprovalDaemon, requestID string) sealedPreview {
+	t.Helper()
+	resp, err := http.Get(d.config.PreviewURL + "/api/requests/" + requestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var sealed sealedPreview
+	if err := json.NewDecoder(resp.Body).Decode(&sealed); err != nil {
+		t.Fatal(err)
+	}
+	return sealed
+}
+
+// postDecision posts an action to the web view and returns the status code
+func postDecision(t *testing.T, d *ApprovalDaemon, requestID, action string) int {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"action": action})
+	resp, err := http.Post(d.config.PreviewURL+"/api/requests/"+requestID+"/decision", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestApprovalThroughNotificationAndWebView(t *testing.T) {
+	d, stub := newNotifyingTestDaemon(t)
+	startPreviewServer(t, d)
+
+	done := make(chan IPCResponse, 1)
+	go func() { done <- d.queueEmail(testRequest(3), nil) }()
+
+	// The phone taps Review in the notification
+	requestID := reviewedRequestID(t, stub.waitPublished(t, 1)[0])
+	preview := decryptPreview(t, d.config.PreviewKey, requestID, fetchSealed(t, d, requestID))
+	if preview.Subject != "email 3" || preview.ContentHash != testRequest(3).ContentHash {
+		t.Fatalf("decrypted preview %+v, want email 3", preview)
+	}
+
+	if status := postDecision(t, d, requestID, preview.Approve); status != http.StatusOK {
+		t.Fatalf("approve returned status %d", status)
+	}
+	if result := <-done; !result.Success || result.Status != "approved" {
+		t.Fatalf("queueEmail = %+v, want approved", result)
+	}
+	if status := postDecision(t, d, requestID, preview.Approve); status != http.StatusConflict {
+		t.Fatalf("replayed approve returned status %d, want %d", status, http.StatusConflict)
+	}
+}
+
+func TestTopicReaderCannotApproveFirst(t *testing.T) {
+	d, stub := newNotifyingTestDaemon(t)
+	startPreviewServer(t, d)
+
+	done := make(chan IPCResponse, 1)
+	go func() { done <- d.queueEmail(testRequest(5), nil) }()
+
+	// Whoever reads the topic sees the notification as soon as the phone does,
+	// but it holds nothing of the email and nothing that decides it
+	notification := stub.waitPublished(t, 1)[0]
+	published, err := json.Marshal(notification)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leak := range []string{"email 5", "bob@example.com", testRequest(5).ContentHash, "APPROVE:", "REJECT:"} {
+		if strings.Contains(string(published), leak) {
+			t.Fatalf("notification contains %q: %s", leak, published)
+		}
+	}
+	requestID := reviewedRequestID(t, notification)
+
+	// The reader tries to approve before the user: on the topic, which the
+	// daemon does not read, and in the web view the Review link leads to,
+	// where the sealed preview is of no use without the key
+	forged := ApprovalAction{Approve: true, RequestID: requestID, Expiry: time.Now().Add(approvalTimeout).Unix(), Nonce: "guessed"}
+	forged.Signature = signApprovalAction("guessed-secret", "", forged)
+	if err := d.ntfy.sendNotification(d.config.NtfyTopic, "", forged.String()); err != nil {
+		t.Fatal(err)
+	}
+	fetchSealed(t, d, requestID)
+	if status := postDecision(t, d, requestID, forged.String()); status != http.StatusConflict {
+		t.Fatalf("forged approve returned status %d, want %d", status, http.StatusConflict)
+	}
+	if d.pendingCount() != 1 {
+		t.Fatal("the reader's approve was applied")
+	}
+
+	// The paired phone still decides
+	preview := decryptPreview(t, d.config.PreviewKey, requestID, fetchSealed(t, d, requestID))
+	if status := postDecision(t, d, requestID, preview.Reject); status != http.StatusOK {
+		t.Fatalf("reject returned status %d", status)
+	}
+	if result := <-done; result.Success || result.Error != "rejected by user" {
+		t.Fatalf("queueEmail = %+v, want rejected", result)
+	}
+}
+
+func TestQueueEmailRefusedWithoutPreviewKey(t *testing.T) {
+	d, stub := newNotifyingTestDaemon(t)
+	d.config.PreviewKey = ""
+
+	// There is no other way to decide, and the email is never published in the clear
+	resp := d.queueEmail(testRequest(6), nil)
+	if resp.Success || !strings.Contains(resp.Error, "no preview key") {
+		t.Fatalf("queueEmail = %+v, want refused", resp)
+	}
+	if stub.count() != 0 || d.pendingCount() != 0 {
+		t.Fatalf("%d notifications published, %d pending; want none", stub.count(), d.pendingCount())
+	}
+}
+
+func TestPreviewsOnByDefault(t *testing.T) {
+	config, err := createNewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := previewAEAD(config.PreviewKey); err != nil {
+		t.Fatalf("new config has no usable preview key: %v", err)
+	}
+
+	// Without preview_url the web view is reviewed where it listens
+	if got, want := config.reviewURL("req1"), "http://"+defaultPreviewListen+"/review/req1"; got != want {
+		t.Errorf("reviewURL = %q, want %q", got, want)
+	}
+	config.PreviewListen = "127.0.0.1:9000"
+	if got, want := config.pairingURL(), "http://127.0.0.1:9000/pair#"+config.PreviewKey; got != want {
+		t.Errorf("pairingURL = %q, want %q", got, want)
+	}
+	config.PreviewURL = "https://laptop.tailnet.ts.net"
+	if got, want := config.reviewURL("req1"), "https://laptop.tailnet.ts.net/review/req1"; got != want {
+		t.Errorf("reviewURL = %q, want %q", got, want)
+	}
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"log"
@@ -10,6 +12,7 @@ import (
 	"net/http"
 	"os/exec"
 	"runtime"
+	"strings"
 
 	qrcode "github.com/skip2/go-qrcode"
 )
@@ -19,9 +22,14 @@ type SetupServer struct {
 	listener net.Listener
 	server   *http.Server
 	done     chan bool
+	token    string // per-session token the page sends with every change
 }
 
 func newSetupServer(config *Config) (*SetupServer, error) {
+	token, err := generateToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate setup token: %w", err)
+	}
 	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
 		return nil, fmt.Errorf("failed to create listener: %w", err)
@@ -31,16 +39,48 @@ func newSetupServer(config *Config) (*SetupServer, error) {
 		config:   config,
 		listener: listener,
 		done:     make(chan bool),
+		token:    token,
 	}, nil
 }
 
-func (s *SetupServer) run() error {
+// handler routes the setup page. Any web page the user visits can send
+// requests to 127.0.0.1, so every change must carry the token embedded in
+// the page and come from the page's own origin, and every request must be
+// addressed to the listener itself rather than a rebound DNS name.
+func (s *SetupServer) handler() http.Handler {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", s.handleSetup)
-	mux.HandleFunc("/test", s.handleTest)
-	mux.HandleFunc("/complete", s.handleComplete)
+	mux.HandleFunc("GET /{$}", s.handleSetup)
+	mux.HandleFunc("POST /server", s.requireSession(s.handleServer))
+	mux.HandleFunc("POST /previews", s.requireSession(s.handlePreviews))
+	mux.HandleFunc("POST /test", s.requireSession(s.handleTest))
+	mux.HandleFunc("POST /complete", s.requireSession(s.handleComplete))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != s.listener.Addr().String() {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
 
-	s.server = &http.Server{Handler: mux}
+// requireSession refuses requests without the page's token or from another origin
+func (s *SetupServer) requireSession(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" && origin != "http://"+s.listener.Addr().String() {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Setup-Token")), []byte(s.token)) != 1 {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *SetupServer) run() error {
+	s.server = &http.Server{Handler: s.handler()}
 
 	url := fmt.Sprintf("http://%s", s.listener.Addr().String())
 	log.Println("═══════════════════════════════════════════════════════════════")
@@ -58,9 +98,15 @@ func (s *SetupServer) run() error {
 }
 
 func (s *SetupServer) handleSetup(w http.ResponseWriter, r *http.Request) {
-	// Generate QR code for ntfy topic subscription
+	ntfy, err := newNtfyClient(s.config)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	// Generate QR code for ntfy topic subscription on the configured server
 	// Use HTTPS URL so iOS Camera recognizes it and opens Safari -> ntfy app
-	ntfyURL := fmt.Sprintf("https://ntfy.sh/%s", s.config.NtfyTopic)
+	ntfyURL := ntfy.topicURL(s.config.NtfyTopic)
 	qr, err := qrcode.Encode(ntfyURL, qrcode.Medium, 256)
 	if err != nil {
 		http.Error(w, "Failed to generate QR code", 500)
@@ -68,23 +114,155 @@ func (s *SetupServer) handleSetup(w http.ResponseWriter, r *http.Request) {
 	}
 	qrBase64 := base64.StdEncoding.EncodeToString(qr)
 
+	// The pairing QR code carries the preview key to the phone
+	pairingQR := ""
+	if s.config.previewsEnabled() {
+		png, err := qrcode.Encode(s.config.pairingURL(), qrcode.Medium, 256)
+		if err != nil {
+			http.Error(w, "Failed to generate QR code", 500)
+			return
+		}
+		pairingQR = base64.StdEncoding.EncodeToString(png)
+	}
+
+	configuredServer := s.config.NtfyServer
+	if configuredServer == "" {
+		configuredServer = defaultNtfyBaseURL
+	}
+
 	tmpl := template.Must(template.New("setup").Parse(setupHTML))
-	tmpl.Execute(w, map[string]string{
-		"Topic":  s.config.NtfyTopic,
-		"QRCode": qrBase64,
+	tmpl.Execute(w, map[string]interface{}{
+		"Token":    s.token,
+		"Topic":    s.config.NtfyTopic,
+		"QRCode":   qrBase64,
+		"Server":   configuredServer,
+		"TopicURL": ntfyURL,
+		"Username": s.config.NtfyUsername,
+		"CAFile":   s.config.NtfyCAFile,
+		"HasToken": s.config.NtfyToken != "",
+		"HasAuth":  s.config.NtfyToken != "" || s.config.NtfyUsername != "",
+
+		"PreviewURL":    s.config.PreviewURL,
+		"PreviewListen": s.config.PreviewListen,
+		"PairingQRCode": pairingQR,
+		"PairingURL":    s.config.pairingURL(),
+		"PreviewLocal":  s.config.PreviewURL == "",
 	})
 }
 
+// handleServer saves the ntfy server settings. Blank credential fields keep
+// the stored ones, so the page never has to echo secrets back.
+func (s *SetupServer) handleServer(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeSetupResult(w, err)
+		return
+	}
+
+	updated := *s.config
+	updated.NtfyServer = strings.TrimSpace(r.FormValue("server"))
+	updated.NtfyCAFile = strings.TrimSpace(r.FormValue("ca_file"))
+	switch r.FormValue("auth") {
+	case "token":
+		if token := strings.TrimSpace(r.FormValue("token")); token != "" {
+			updated.NtfyToken = token
+		}
+		updated.NtfyUsername, updated.NtfyPassword = "", ""
+	case "basic":
+		updated.NtfyUsername = strings.TrimSpace(r.FormValue("username"))
+		if password := r.FormValue("password"); password != "" {
+			updated.NtfyPassword = password
+		}
+		updated.NtfyToken = ""
+	default:
+		updated.NtfyToken, updated.NtfyUsername, updated.NtfyPassword = "", "", ""
+	}
+	if updated.NtfyServer != "" {
+		server, err := normalizeNtfyBaseURL(updated.NtfyServer)
+		if err != nil {
+			writeSetupResult(w, err)
+			return
+		}
+		updated.NtfyServer = server
+	}
+	if updated.NtfyServer == defaultNtfyBaseURL {
+		updated.NtfyServer = ""
+	}
+
+	if _, err := newNtfyClient(&updated); err != nil {
+		writeSetupResult(w, err)
+		return
+	}
+	if err := saveConfig(&updated); err != nil {
+		writeSetupResult(w, err)
+		return
+	}
+	*s.config = updated
+	writeSetupResult(w, nil)
+}
+
+// handlePreviews saves the private preview settings, creating the key if the
+// config has none; a blank URL keeps the web view on this machine
+func (s *SetupServer) handlePreviews(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeSetupResult(w, err)
+		return
+	}
+
+	updated := *s.config
+	updated.PreviewURL = ""
+	updated.PreviewListen = strings.TrimSpace(r.FormValue("preview_listen"))
+	if raw := strings.TrimSpace(r.FormValue("preview_url")); raw != "" {
+		previewURL, err := normalizePreviewURL(raw)
+		if err != nil {
+			writeSetupResult(w, err)
+			return
+		}
+		updated.PreviewURL = previewURL
+	}
+	if updated.PreviewListen != "" {
+		if _, _, err := net.SplitHostPort(updated.PreviewListen); err != nil {
+			writeSetupResult(w, fmt.Errorf("invalid listen address %q: use host:port, e.g. %s", updated.PreviewListen, defaultPreviewListen))
+			return
+		}
+	}
+	if _, err := normalizePreviewURL(updated.previewBaseURL()); err != nil {
+		writeSetupResult(w, fmt.Errorf("the web view is not reachable at %s: enter the https address your phone opens it at", updated.previewBaseURL()))
+		return
+	}
+	if updated.PreviewKey == "" {
+		key, err := generatePreviewKey()
+		if err != nil {
+			writeSetupResult(w, err)
+			return
+		}
+		updated.PreviewKey = key
+	}
+
+	if err := saveConfig(&updated); err != nil {
+		writeSetupResult(w, err)
+		return
+	}
+	*s.config = updated
+	writeSetupResult(w, nil)
+}
+
 func (s *SetupServer) handleTest(w http.ResponseWriter, r *http.Request) {
-	// Send test notification
-	err := sendNtfyNotification(s.config.NtfyTopic, "Test Notification", "If you see this, setup is working!")
+	ntfy, err := newNtfyClient(s.config)
+	if err == nil {
+		// Send test notification
+		err = ntfy.sendNotification(s.config.NtfyTopic, "Test Notification", "If you see this, setup is working!")
+	}
+	writeSetupResult(w, err)
+}
+
+// writeSetupResult answers the setup page's fetch calls
+func writeSetupResult(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.Write([]byte(fmt.Sprintf(`{"success": false, "error": "%s"}`, err.Error())))
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(`{"success": true}`))
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
 }
 
 func (s *SetupServer) handleComplete(w http.ResponseWriter, r *http.Request) {
@@ -129,12 +307,36 @@ const setupHTML = `<!DOCTYPE html>
         .status.success { background: #e8f5e9; color: #2e7d32; }
         .status.error { background: #ffebee; color: #c62828; }
         .step { margin: 20px 0; padding: 15px; background: #fafafa; border-radius: 4px; }
+        .topic-inline { font-family: monospace; }
+        form label { display: block; margin: 10px 0; }
+        form input, form select { width: 100%; padding: 8px; font-size: 14px; }
         .step-num { display: inline-block; width: 30px; height: 30px; background: #4CAF50; color: white; border-radius: 50%; text-align: center; line-height: 30px; margin-right: 10px; }
     </style>
 </head>
 <body>
     <h1>📱 Gmail Approval Daemon Setup</h1>
 
+    <div class="step">
+        <strong>ntfy server</strong> <small>(optional)</small>
+        <p>Notifications go through <span class="topic-inline">{{.Server}}</span>. To keep approval requests off the public server, enter your self-hosted ntfy instance and its credentials.</p>
+        <form id="server-form" onsubmit="saveForm(event, '/server', 'server-status')">
+            <label>Server URL<br><input name="server" value="{{.Server}}" placeholder="https://ntfy.example.com"></label>
+            <label>Authentication<br>
+                <select name="auth" onchange="showAuth(this.value)">
+                    <option value="none" {{if not .HasAuth}}selected{{end}}>None</option>
+                    <option value="token" {{if .HasToken}}selected{{end}}>Access token</option>
+                    <option value="basic" {{if and .HasAuth (not .HasToken)}}selected{{end}}>Username and password</option>
+                </select>
+            </label>
+            <label class="auth auth-token">Access token<br><input name="token" type="password" placeholder="{{if .HasToken}}(unchanged){{else}}tk_...{{end}}" autocomplete="off"></label>
+            <label class="auth auth-basic">Username<br><input name="username" value="{{.Username}}" autocomplete="off"></label>
+            <label class="auth auth-basic">Password<br><input name="password" type="password" placeholder="{{if and .HasAuth (not .HasToken)}}(unchanged){{end}}" autocomplete="off"></label>
+            <label>CA bundle <small>(PEM file, for a private CA)</small><br><input name="ca_file" value="{{.CAFile}}" placeholder="/etc/ssl/my-ca.pem"></label>
+            <button class="btn btn-test" type="submit">Save Server</button>
+        </form>
+        <div id="server-status"></div>
+    </div>
+
     <div class="step">
         <span class="step-num">1</span>
         <strong>Install the ntfy app</strong>
@@ -144,7 +346,7 @@ const setupHTML = `<!DOCTYPE html>
     <div class="step">
         <span class="step-num">2</span>
         <strong>Subscribe to your private topic</strong>
-        <p>Scan this QR code with your phone's camera. It will open ntfy.sh where you can subscribe.</p>
+        <p>Scan this QR code with your phone's camera. It will open {{.TopicURL}} where you can subscribe.</p>
         <div class="qr-container">
             <img src="data:image/png;base64,{{.QRCode}}" alt="QR Code">
         </div>
@@ -152,6 +354,27 @@ const setupHTML = `<!DOCTYPE html>
         <div class="topic">{{.Topic}}</div>
     </div>
 
+    <div class="step">
+        <strong>Private previews</strong>
+        <p>ntfy only sees "approval pending". The email is encrypted with a key that only this daemon and the browsers you pair hold, and you read it and decide in a small web view the daemon serves. With the URL left blank the web view is only reachable from this computer; to review on your phone, enter the https address it reaches the web view at (for example through Tailscale or a reverse proxy).</p>
+        <form onsubmit="saveForm(event, '/previews', 'preview-status')">
+            <label>Web view URL, as your phone opens it <small>(blank: this computer only)</small><br><input name="preview_url" value="{{.PreviewURL}}" placeholder="https://laptop.tailnet.ts.net:8765"></label>
+            <label>Listen address<br><input name="preview_listen" value="{{.PreviewListen}}" placeholder="127.0.0.1:8765"></label>
+            <button class="btn btn-test" type="submit">Save Previews</button>
+        </form>
+        <div id="preview-status"></div>
+        {{if .PairingQRCode}}
+        {{if .PreviewLocal}}
+        <p>Once setup is complete and the daemon is running, pair this computer's browser by opening <a href="{{.PairingURL}}" target="_blank" rel="noreferrer">the pairing link</a> (or the one <code>gmail-approval-daemon -pair</code> prints). It stores the key in the browser only; the key never goes to the server.</p>
+        {{else}}
+        <p>Scan this QR code with your phone to pair it. It opens the web view and stores the key in your phone's browser only; the key never goes to the server.</p>
+        <div class="qr-container">
+            <img src="data:image/png;base64,{{.PairingQRCode}}" alt="Pairing QR Code">
+        </div>
+        {{end}}
+        {{end}}
+    </div>
+
     <div class="step">
         <span class="step-num">3</span>
         <strong>Test the connection</strong>
@@ -167,15 +390,48 @@ const setupHTML = `<!DOCTYPE html>
     </div>
 
     <script>
+        const SETUP_TOKEN = '{{.Token}}';
         let testSuccessful = false;
 
+        function showAuth(kind) {
+            document.querySelectorAll('.auth').forEach(el => {
+                el.style.display = el.classList.contains('auth-' + kind) ? 'block' : 'none';
+            });
+        }
+        showAuth(document.querySelector('select[name=auth]').value);
+
+        async function saveForm(event, url, statusId) {
+            event.preventDefault();
+            const status = document.getElementById(statusId);
+            status.className = 'status';
+            status.textContent = 'Saving...';
+            try {
+                const resp = await fetch(url, {
+                    method: 'POST',
+                    headers: { 'X-Setup-Token': SETUP_TOKEN },
+                    body: new URLSearchParams(new FormData(event.target)),
+                });
+                const data = await resp.json();
+                if (data.success) {
+                    // Reload so the QR codes point at the new settings
+                    location.reload();
+                } else {
+                    status.className = 'status error';
+                    status.textContent = '✗ ' + data.error;
+                }
+            } catch (err) {
+                status.className = 'status error';
+                status.textContent = '✗ Error: ' + err.message;
+            }
+        }
+
         async function testNotification() {
             const status = document.getElementById('status');
             status.className = 'status';
             status.textContent = 'Sending test notification...';
 
             try {
-                const resp = await fetch('/test', { method: 'POST' });
+                const resp = await fetch('/test', { method: 'POST', headers: { 'X-Setup-Token': SETUP_TOKEN } });
                 const data = await resp.json();
                 if (data.success) {
                     status.className = 'status success';
@@ -196,7 +452,7 @@ const setupHTML = `<!DOCTYPE html>
             if (!testSuccessful) return;
 
             try {
-                const resp = await fetch('/complete', { method: 'POST' });
+                const resp = await fetch('/complete', { method: 'POST', headers: { 'X-Setup-Token': SETUP_TOKEN } });
                 const data = await resp.json();
                 if (data.success) {
                     document.body.innerHTML = '<h1>✓ Setup Complete!</h1><p>You can close this window. The daemon is now running.</p>';
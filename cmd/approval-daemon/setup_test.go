@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// newTestSetupServer serves a setup page for a fresh config saved under a
+// temporary home directory
+func newTestSetupServer(t *testing.T) (*SetupServer, string) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("GMAIL_APPROVAL_NTFY_URL", "")
+	config, err := createNewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := newSetupServer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: s.handler()}
+	go server.Serve(s.listener)
+	t.Cleanup(func() { server.Close() })
+	return s, "http://" + s.listener.Addr().String()
+}
+
+func TestSetupRefusesCrossSiteChanges(t *testing.T) {
+	s, base := newTestSetupServer(t)
+
+	send := func(method, path, host string, header map[string]string) (int, string) {
+		t.Helper()
+		form := url.Values{"server": {"https://ntfy.example.com"}, "auth": {"none"}}
+		req, err := http.NewRequest(method, base+path, strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for name, value := range header {
+			req.Header.Set(name, value)
+		}
+		if host != "" {
+			req.Host = host
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	// The page embeds the session's token, but only for its own address
+	if status, page := send(http.MethodGet, "/", "", nil); status != http.StatusOK || !strings.Contains(page, s.token) {
+		t.Fatalf("setup page: status %d, token embedded %v", status, strings.Contains(page, s.token))
+	}
+	if status, page := send(http.MethodGet, "/", "rebound.example.com", nil); status != http.StatusForbidden || strings.Contains(page, s.token) {
+		t.Fatalf("setup page under another host name: status %d, want %d", status, http.StatusForbidden)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		host   string
+		header map[string]string
+		want   int
+	}{
+		{name: "no token", method: http.MethodPost, header: map[string]string{"Origin": base}, want: http.StatusForbidden},
+		{name: "wrong token", method: http.MethodPost, header: map[string]string{"Origin": base, "X-Setup-Token": "guessed"}, want: http.StatusForbidden},
+		{name: "other origin", method: http.MethodPost, header: map[string]string{"Origin": "https://evil.example", "X-Setup-Token": s.token}, want: http.StatusForbidden},
+		{name: "rebound host", method: http.MethodPost, host: "rebound.example.com", header: map[string]string{"X-Setup-Token": s.token}, want: http.StatusForbidden},
+		{name: "GET", method: http.MethodGet, header: map[string]string{"X-Setup-Token": s.token}, want: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		if status, _ := send(tt.method, "/server", tt.host, tt.header); status != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, status, tt.want)
+		}
+	}
+	if s.config.NtfyServer != "" {
+		t.Fatalf("a refused request changed the server to %q", s.config.NtfyServer)
+	}
+
+	status, body := send(http.MethodPost, "/server", "", map[string]string{"Origin": base, "X-Setup-Token": s.token})
+	if status != http.StatusOK || !strings.Contains(body, `"success":true`) || s.config.NtfyServer != "https://ntfy.example.com" {
+		t.Fatalf("change from the page: status %d, body %s, server %q", status, body, s.config.NtfyServer)
+	}
+}
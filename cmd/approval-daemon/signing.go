@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// actionSigningContext starts the signed data, so a signature made for
+// anything else can never pass as an approval
+const actionSigningContext = "gmail-mcp-approval-action-v1"
+
+// ApprovalAction is an Approve or Reject button press posted back by the
+// web view, as "APPROVE:<request ID>:<expiry>:<nonce>:<signature>" (or REJECT:).
+// The signature is an HMAC-SHA256, keyed with Config.SigningSecret, over the
+// request ID, the draft's content hash, the decision, the expiry and the nonce.
+type ApprovalAction struct {
+	Approve   bool
+	RequestID string
+	Expiry    int64 // Unix seconds after which the action is refused
+	Nonce     string
+	Signature string
+}
+
+// signApprovalAction returns the signature for an action on a request whose
+// draft hashes to contentHash
+func signApprovalAction(secret, contentHash string, action ApprovalAction) string {
+	decision := "reject"
+	if action.Approve {
+		decision = "approve"
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	// Newline-separated: none of the fields can contain one
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%d\n%s", actionSigningContext, action.RequestID, contentHash, decision, action.Expiry, action.Nonce)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyApprovalAction checks the action's signature in constant time
+func verifyApprovalAction(secret, contentHash string, action ApprovalAction) bool {
+	expected := signApprovalAction(secret, contentHash, action)
+	return hmac.Equal([]byte(expected), []byte(action.Signature))
+}
+
+// String formats the action as the body of an ntfy message
+func (a ApprovalAction) String() string {
+	prefix := "REJECT"
+	if a.Approve {
+		prefix = "APPROVE"
+	}
+	return fmt.Sprintf("%s:%s:%d:%s:%s", prefix, a.RequestID, a.Expiry, a.Nonce, a.Signature)
+}
+
+// parseApprovalAction reads an action posted by the web view; ok is false for
+// anything that is not shaped like one
+func parseApprovalAction(message string) (action ApprovalAction, ok bool) {
+	decision, rest, found := strings.Cut(message, ":")
+	if !found {
+		return ApprovalAction{}, false
+	}
+	switch decision {
+	case "APPROVE":
+		action.Approve = true
+	case "REJECT":
+	default:
+		return ApprovalAction{}, false
+	}
+
+	fields := strings.Split(rest, ":")
+	if len(fields) != 4 || fields[0] == "" || fields[2] == "" || fields[3] == "" {
+		return ApprovalAction{}, false
+	}
+	expiry, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return ApprovalAction{}, false
+	}
+	action.RequestID, action.Expiry, action.Nonce, action.Signature = fields[0], expiry, fields[2], fields[3]
+	return action, true
+}
+
+// expired reports whether the action may no longer be used at now
+func (a ApprovalAction) expired(now time.Time) bool {
+	return now.Unix() >= a.Expiry
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestApprovalActionRoundTrip(t *testing.T) {
+	action := ApprovalAction{Approve: true, RequestID: "req1", Expiry: 1700000000, Nonce: "n1"}
+	action.Signature = signApprovalAction("secret", "sha256:aa", action)
+
+	parsed, ok := parseApprovalAction(action.String())
+	if !ok {
+		t.Fatalf("parseApprovalAction(%q) failed", action.String())
+	}
+	if parsed != action {
+		t.Fatalf("round trip = %+v, want %+v", parsed, action)
+	}
+	if !verifyApprovalAction("secret", "sha256:aa", parsed) {
+		t.Fatal("genuine action did not verify")
+	}
+}
+
+func TestParseApprovalActionRejectsMalformed(t *testing.T) {
+	for _, message := range []string{
+		"",
+		"hello",
+		"APPROVE",
+		"APPROVE:req1",
+		"APPROVE:req1:notanumber:n1:sig",
+		"APPROVE::1700000000:n1:sig",
+		"APPROVE:req1:1700000000::sig",
+		"APPROVE:req1:1700000000:n1:",
+		"APPROVE:req1:1700000000:n1:sig:extra",
+		"MAYBE:req1:1700000000:n1:sig",
+	} {
+		if action, ok := parseApprovalAction(message); ok {
+			t.Errorf("parseApprovalAction(%q) = %+v, want refusal", message, action)
+		}
+	}
+}
+
+func TestVerifyApprovalActionRejectsAlteredFields(t *testing.T) {
+	genuine := ApprovalAction{Approve: true, RequestID: "req1", Expiry: 1700000000, Nonce: "n1"}
+	genuine.Signature = signApprovalAction("secret", "sha256:aa", genuine)
+
+	tests := []struct {
+		name        string
+		secret      string
+		contentHash string
+		alter       func(*ApprovalAction)
+	}{
+		{"wrong secret", "other", "sha256:aa", func(*ApprovalAction) {}},
+		{"other content", "secret", "sha256:bb", func(*ApprovalAction) {}},
+		{"other request", "secret", "sha256:aa", func(a *ApprovalAction) { a.RequestID = "req2" }},
+		{"flipped decision", "secret", "sha256:aa", func(a *ApprovalAction) { a.Approve = false }},
+		{"later expiry", "secret", "sha256:aa", func(a *ApprovalAction) { a.Expiry++ }},
+		{"other nonce", "secret", "sha256:aa", func(a *ApprovalAction) { a.Nonce = "n2" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action := genuine
+			tt.alter(&action)
+			if verifyApprovalAction(tt.secret, tt.contentHash, action) {
+				t.Fatal("altered action verified")
+			}
+		})
+	}
+}
+
+func TestApprovalActionExpired(t *testing.T) {
+	action := ApprovalAction{Expiry: 1700000000}
+	if action.expired(time.Unix(1699999999, 0)) {
+		t.Error("expired one second early")
+	}
+	if !action.expired(time.Unix(1700000000, 0)) {
+		t.Error("not expired at the expiry")
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
@@ -16,16 +17,45 @@ type SocketServer struct {
 
 type IPCRequest struct {
 	Action  string `json:"action"`
+	Account string `json:"account,omitempty"`
+	From    string `json:"from,omitempty"`
 	To      string `json:"to,omitempty"`
+	Cc      string `json:"cc,omitempty"`
+	Bcc     string `json:"bcc,omitempty"`
 	Subject string `json:"subject,omitempty"`
 	Body    string `json:"body,omitempty"`
 	DraftID string `json:"draft_id,omitempty"`
+
+	// ContentHash is the canonical hash of the draft's raw MIME. The approval
+	// is bound to it, and the MCP server only sends a draft that still matches.
+	ContentHash string `json:"content_hash,omitempty"`
+
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+// Attachment describes a part of the email awaiting approval besides its body
+// text: a file, or a part without a filename
+type Attachment struct {
+	Filename string `json:"filename"` // empty for unnamed parts
+	MimeType string `json:"mimeType"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
+}
+
+// label names the part for display, e.g. "report.pdf" or "(unnamed text/plain part)"
+func (a Attachment) label() string {
+	if a.Filename == "" {
+		return fmt.Sprintf("(unnamed %s part)", a.MimeType)
+	}
+	return a.Filename
 }
 
 type IPCResponse struct {
 	Success bool   `json:"success"`
 	Error   string `json:"error,omitempty"`
 	Status  string `json:"status,omitempty"`
+
+	ContentHash string `json:"content_hash,omitempty"` // the hash that was approved
 }
 
 func getSocketPath() string {
@@ -88,7 +118,14 @@ func (s *SocketServer) handleConnection(conn net.Conn) {
 
 	switch req.Action {
 	case "queue_email":
-		resp := s.daemon.queueEmail(req)
+		// The client sends nothing after the request, so a finished read means
+		// it hung up (e.g. the MCP tool call was cancelled)
+		hangup := make(chan struct{})
+		go func() {
+			io.Copy(io.Discard, conn)
+			close(hangup)
+		}()
+		resp := s.daemon.queueEmail(req, hangup)
 		encoder.Encode(resp)
 	case "status":
 		encoder.Encode(IPCResponse{Success: true, Status: "running"})
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer/html"
+)
+
+// maxHeaderLineLen is the line length RFC 5322 recommends; longer header values are folded
+const maxHeaderLineLen = 78
+
+// maxBase64LineLen is the longest base64 line RFC 2045 allows
+const maxBase64LineLen = 76
+
+// maxEncodedWordLen keeps an RFC 2047 word plus a header name like "Subject: " within maxHeaderLineLen
+const maxEncodedWordLen = 66
+
+// markdownRenderer turns markdown bodies into the HTML alternative. Raw HTML
+// in the markdown is dropped rather than passed through.
+var markdownRenderer = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+	goldmark.WithRendererOptions(html.WithHardWraps()),
+)
+
+// OutgoingMessage is an email to be written out as RFC 822 for a Gmail draft.
+// Address fields take comma-separated lists such as `Jane <jane@example.com>, bob@example.com`.
+type OutgoingMessage struct {
+	From    string
+	To      string
+	Cc      string
+	Bcc     string
+	ReplyTo string
+	Subject string
+
+	Body     string // plain text, or markdown when Markdown is set
+	Markdown bool   // render Body to HTML and send both versions
+	HTMLBody string // HTML version to send alongside Body (overrides Markdown rendering)
+
+	InReplyTo  string // Message-ID of the message being replied to
+	References string
+
+	Attachments []OutgoingAttachment // sent as multipart/mixed after the body
+}
+
+// addressFields lists the address headers of m in the order they are written
+func (m *OutgoingMessage) addressFields() []struct{ name, value string } {
+	return []struct{ name, value string }{
+		{"From", m.From},
+		{"To", m.To},
+		{"Cc", m.Cc},
+		{"Bcc", m.Bcc},
+		{"Reply-To", m.ReplyTo},
+	}
+}
+
+// Validate rejects header values that could add headers of their own and
+// addresses net/mail cannot parse
+func (m *OutgoingMessage) Validate() error {
+	for _, field := range append(m.addressFields(), []struct{ name, value string }{
+		{"Subject", m.Subject},
+		{"In-Reply-To", m.InReplyTo},
+		{"References", m.References},
+	}...) {
+		if i := strings.IndexFunc(field.value, isHeaderControlChar); i >= 0 {
+			return fmt.Errorf("%s contains a line break or control character (%q); header values must be a single line", field.name, field.value[i])
+		}
+	}
+
+	if strings.TrimSpace(m.To) == "" {
+		return fmt.Errorf("at least one To recipient is required")
+	}
+	for _, field := range m.addressFields() {
+		if strings.TrimSpace(field.value) == "" {
+			continue
+		}
+		if _, err := mail.ParseAddressList(field.value); err != nil {
+			return fmt.Errorf("invalid %s address list %q: %v", field.name, field.value, err)
+		}
+	}
+	return nil
+}
+
+// isHeaderControlChar matches CR, LF and the other control characters that have no place in a header value
+func isHeaderControlChar(r rune) bool {
+	return (r < ' ' && r != '\t') || r == 0x7f
+}
+
+// Build validates the message and renders it as RFC 822 with UTF-8 headers and bodies
+func (m *OutgoingMessage) Build() ([]byte, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	for _, field := range m.addressFields() {
+		if strings.TrimSpace(field.value) == "" {
+			continue
+		}
+		addresses, _ := mail.ParseAddressList(field.value)
+		writeHeader(&buf, field.name, formatAddressList(addresses))
+	}
+	writeHeader(&buf, "Subject", encodeHeaderText(m.Subject))
+	if m.InReplyTo != "" {
+		writeHeader(&buf, "In-Reply-To", m.InReplyTo)
+	}
+	if m.References != "" {
+		writeHeader(&buf, "References", m.References)
+	}
+	writeHeader(&buf, "MIME-Version", "1.0")
+
+	if len(m.Attachments) == 0 {
+		if err := m.writeBody(&buf); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	boundary, err := newBoundary()
+	if err != nil {
+		return nil, err
+	}
+	writeHeader(&buf, "Content-Type", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": boundary}))
+	buf.WriteString("\r\n")
+	buf.WriteString("--" + boundary + "\r\n")
+	if err := m.writeBody(&buf); err != nil {
+		return nil, err
+	}
+	for _, attachment := range m.Attachments {
+		buf.WriteString("\r\n--" + boundary + "\r\n")
+		writeAttachmentPart(&buf, attachment)
+	}
+	buf.WriteString("\r\n--" + boundary + "--\r\n")
+	return buf.Bytes(), nil
+}
+
+// writeBody writes the message text as a single text/plain part, or as
+// multipart/alternative when there is an HTML version
+func (m *OutgoingMessage) writeBody(buf *bytes.Buffer) error {
+	htmlBody := m.HTMLBody
+	if htmlBody == "" && m.Markdown {
+		rendered, err := renderMarkdown(m.Body)
+		if err != nil {
+			return err
+		}
+		htmlBody = rendered
+	}
+
+	if htmlBody == "" {
+		writeTextPart(buf, "text/plain", m.Body)
+		return nil
+	}
+
+	boundary, err := newBoundary()
+	if err != nil {
+		return err
+	}
+	writeHeader(buf, "Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": boundary}))
+	buf.WriteString("\r\n")
+	for _, part := range []struct{ mediaType, content string }{
+		{"text/plain", m.Body},
+		{"text/html", htmlBody},
+	} {
+		buf.WriteString("--" + boundary + "\r\n")
+		writeTextPart(buf, part.mediaType, part.content)
+		buf.WriteString("\r\n")
+	}
+	buf.WriteString("--" + boundary + "--\r\n")
+	return nil
+}
+
+// writeAttachmentPart writes a file as a base64 part with its filename in both
+// Content-Disposition and the older Content-Type name parameter
+func writeAttachmentPart(buf *bytes.Buffer, attachment OutgoingAttachment) {
+	writeHeader(buf, "Content-Type", mime.FormatMediaType(attachment.MimeType, map[string]string{"name": attachment.Filename}))
+	writeHeader(buf, "Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}))
+	writeHeader(buf, "Content-Transfer-Encoding", "base64")
+	buf.WriteString("\r\n")
+
+	encoded := base64.StdEncoding.EncodeToString(attachment.Data)
+	for len(encoded) > maxBase64LineLen {
+		buf.WriteString(encoded[:maxBase64LineLen] + "\r\n")
+		encoded = encoded[maxBase64LineLen:]
+	}
+	if encoded != "" {
+		buf.WriteString(encoded + "\r\n")
+	}
+}
+
+// writeTextPart writes Content-Type and encoding headers followed by a quoted-printable UTF-8 body
+func writeTextPart(buf *bytes.Buffer, mediaType, content string) {
+	writeHeader(buf, "Content-Type", mime.FormatMediaType(mediaType, map[string]string{"charset": "UTF-8"}))
+	writeHeader(buf, "Content-Transfer-Encoding", "quoted-printable")
+	buf.WriteString("\r\n")
+
+	// Normalise line endings so the encoder emits CRLF line breaks
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	writer := quotedprintable.NewWriter(buf)
+	writer.Write([]byte(strings.ReplaceAll(content, "\n", "\r\n")))
+	writer.Close()
+}
+
+// renderMarkdown converts a markdown body to an HTML document
+func renderMarkdown(source string) (string, error) {
+	var out bytes.Buffer
+	if err := markdownRenderer.Convert([]byte(source), &out); err != nil {
+		return "", fmt.Errorf("failed to render markdown: %v", err)
+	}
+	return "<!DOCTYPE html>\n<html><head><meta charset=\"UTF-8\"></head><body>\n" + out.String() + "</body></html>\n", nil
+}
+
+// formatAddressList renders addresses for a header, RFC 2047-encoding display names
+func formatAddressList(addresses []*mail.Address) string {
+	formatted := make([]string, len(addresses))
+	for i, address := range addresses {
+		if address.Name == "" {
+			formatted[i] = address.Address
+		} else {
+			formatted[i] = address.String()
+		}
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// encodeHeaderText encodes unstructured header text (e.g. a subject) as RFC 2047
+// words when it is not plain ASCII. Each word is kept short enough to fit on a
+// folded header line.
+func encodeHeaderText(text string) string {
+	ascii := true
+	for i := 0; i < len(text); i++ {
+		if text[i] >= utf8.RuneSelf || text[i] < ' ' {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return text
+	}
+
+	const prefix, suffix = "=?UTF-8?q?", "?="
+	var words []string
+	var word strings.Builder
+	for _, r := range text {
+		encoded := qEncodeRune(r)
+		if word.Len() > 0 && len(prefix)+word.Len()+len(encoded)+len(suffix) > maxEncodedWordLen {
+			words = append(words, prefix+word.String()+suffix)
+			word.Reset()
+		}
+		word.WriteString(encoded)
+	}
+	words = append(words, prefix+word.String()+suffix)
+	return strings.Join(words, " ")
+}
+
+// qEncodeRune encodes one character for an RFC 2047 "Q" encoded word
+func qEncodeRune(r rune) string {
+	switch {
+	case r == ' ':
+		return "_"
+	case r < utf8.RuneSelf && (r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("!*+-/", r)):
+		return string(r)
+	}
+	var encoded strings.Builder
+	for _, b := range []byte(string(r)) {
+		fmt.Fprintf(&encoded, "=%02X", b)
+	}
+	return encoded.String()
+}
+
+// writeHeader writes "Name: value", folding at spaces so lines stay within maxHeaderLineLen where possible
+func writeHeader(buf *bytes.Buffer, name, value string) {
+	line := name + ":"
+	for _, word := range strings.Fields(value) {
+		if len(line)+1+len(word) > maxHeaderLineLen && strings.TrimSpace(line) != name+":" {
+			buf.WriteString(line + "\r\n")
+			line = ""
+		}
+		line += " " + word
+	}
+	buf.WriteString(line + "\r\n")
+}
+
+func newBoundary() (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("failed to generate MIME boundary: %v", err)
+	}
+	return "gmail-mcp-" + hex.EncodeToString(random), nil
+}
+
+// withCompositionParams adds the optional recipient and formatting parameters shared by the email-writing tools
+func withCompositionParams() mcp.ToolOption {
+	options := []mcp.ToolOption{
+		mcp.WithString("cc",
+			mcp.Description("Comma-separated Cc recipients, e.g. 'Jane Doe <jane@example.com>, bob@example.com' (optional)"),
+		),
+		mcp.WithString("bcc",
+			mcp.Description("Comma-separated Bcc recipients; they receive the email without being shown to others (optional)"),
+		),
+		mcp.WithString("reply_to",
+			mcp.Description("Address replies should go to, if not the sending account (optional)"),
+		),
+		mcp.WithBoolean("markdown",
+			mcp.Description("Treat body as markdown and also send it as formatted HTML (default: false, plain text only)"),
+		),
+		mcp.WithString("html_body",
+			mcp.Description("HTML version of the email to send alongside the plain-text body (optional, overrides markdown rendering)"),
+		),
+	}
+	return func(tool *mcp.Tool) {
+		for _, option := range options {
+			option(tool)
+		}
+	}
+}
+
+// outgoingFromRequest reads to, subject, body and the composition parameters from a tool call
+func outgoingFromRequest(req mcp.CallToolRequest) (OutgoingMessage, error) {
+	var outgoing OutgoingMessage
+	var err error
+	args := req.GetArguments()
+	replyAll, _ := args["reply_all"].(bool)
+	// reply_all derives the recipients from the thread, so to is only extra addresses
+	if outgoing.To, err = req.RequireString("to"); err != nil && !replyAll {
+		return outgoing, fmt.Errorf("to parameter is required and must be a string")
+	}
+	if outgoing.Subject, err = req.RequireString("subject"); err != nil {
+		return outgoing, fmt.Errorf("subject parameter is required and must be a string")
+	}
+	if outgoing.Body, err = req.RequireString("body"); err != nil {
+		return outgoing, fmt.Errorf("body parameter is required and must be a string")
+	}
+
+	outgoing.Cc, _ = args["cc"].(string)
+	outgoing.Bcc, _ = args["bcc"].(string)
+	outgoing.ReplyTo, _ = args["reply_to"].(string)
+	outgoing.Markdown, _ = args["markdown"].(bool)
+	outgoing.HTMLBody, _ = args["html_body"].(string)
+	return outgoing, nil
+}
+
+// Recipients is the final recipient set of a built message, parsed back from its headers
+type Recipients struct {
+	To      []string `json:"to"`
+	Cc      []string `json:"cc,omitempty"`
+	Bcc     []string `json:"bcc,omitempty"`
+	Subject string   `json:"subject"`
+}
+
+// parseRecipients reads the recipients and subject from raw RFC 822, so an
+// approval shows exactly what Gmail will act on rather than what was requested
+func parseRecipients(raw []byte) (Recipients, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return Recipients{}, fmt.Errorf("failed to parse built message: %v", err)
+	}
+
+	var recipients Recipients
+	for _, field := range []struct {
+		name string
+		list *[]string
+	}{
+		{"To", &recipients.To},
+		{"Cc", &recipients.Cc},
+		{"Bcc", &recipients.Bcc},
+	} {
+		if len(msg.Header[field.name]) > 1 {
+			return Recipients{}, fmt.Errorf("built message has more than one %s header", field.name)
+		}
+		if msg.Header.Get(field.name) == "" {
+			continue
+		}
+		addresses, err := msg.Header.AddressList(field.name)
+		if err != nil {
+			return Recipients{}, fmt.Errorf("failed to parse %s header of built message: %v", field.name, err)
+		}
+		for _, address := range addresses {
+			*field.list = append(*field.list, displayAddress(address))
+		}
+	}
+
+	var decoder mime.WordDecoder
+	recipients.Subject, err = decoder.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		return Recipients{}, fmt.Errorf("failed to decode subject of built message: %v", err)
+	}
+	return recipients, nil
+}
+
+// displayAddress formats an address for people to read, e.g. "José <jose@example.com>"
+func displayAddress(address *mail.Address) string {
+	switch {
+	case address.Name == "":
+		return address.Address
+	case strings.ContainsAny(address.Name, `,;:<>@"()[]\`):
+		// Quote names like "Smith, J" so a joined list still reads unambiguously
+		return fmt.Sprintf("%q <%s>", address.Name, address.Address)
+	default:
+		return fmt.Sprintf("%s <%s>", address.Name, address.Address)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestValidateRejectsHeaderInjection(t *testing.T) {
+	for _, field := range []struct {
+		name string
+		set  func(m *OutgoingMessage, value string)
+	}{
+		{"To", func(m *OutgoingMessage, v string) { m.To = v }},
+		{"Cc", func(m *OutgoingMessage, v string) { m.Cc = v }},
+		{"Subject", func(m *OutgoingMessage, v string) { m.Subject = v }},
+		{"In-Reply-To", func(m *OutgoingMessage, v string) { m.InReplyTo = v }},
+		{"References", func(m *OutgoingMessage, v string) { m.References = v }},
+	} {
+		for _, injected := range []string{
+			"x@example.com\r\nBcc: evil@example.com",
+			"x@example.com\nBcc: evil@example.com",
+			"x@example.com\rBcc: evil@example.com",
+			"x@example.com\x00Bcc: evil@example.com",
+		} {
+			m := OutgoingMessage{To: "bob@example.com", Subject: "Hello", Body: "Hi"}
+			field.set(&m, injected)
+			if err := m.Validate(); err == nil || !strings.Contains(err.Error(), field.name) {
+				t.Errorf("%s = %q: Validate() = %v, want an error naming %s", field.name, injected, err, field.name)
+			}
+			if raw, err := m.Build(); err == nil {
+				t.Errorf("%s = %q: Build() succeeded:\n%s", field.name, injected, raw)
+			}
+		}
+	}
+}
+
+func TestBuildRoundTripsRecipients(t *testing.T) {
+	m := OutgoingMessage{
+		To:      `"Smith, Jane" <jane@example.com>, bob@example.com`,
+		Cc:      "José <jose@example.com>",
+		Bcc:     "audit@example.com",
+		Subject: "Grüße: Q3 numbers",
+		Body:    "See attached.",
+	}
+	raw, err := m.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipients, err := parseRecipients(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(recipients.To, ", "); got != `"Smith, Jane" <jane@example.com>, bob@example.com` {
+		t.Errorf("To = %s", got)
+	}
+	if got := strings.Join(recipients.Cc, ", "); got != "José <jose@example.com>" {
+		t.Errorf("Cc = %s", got)
+	}
+	if got := strings.Join(recipients.Bcc, ", "); got != "audit@example.com" {
+		t.Errorf("Bcc = %s", got)
+	}
+	if recipients.Subject != m.Subject {
+		t.Errorf("Subject = %q, want %q", recipients.Subject, m.Subject)
+	}
+}
+
+func TestParseRecipientsRefusesDuplicateHeaders(t *testing.T) {
+	for _, header := range []string{"To", "Cc", "Bcc"} {
+		raw := "To: bob@example.com\r\n" +
+			header + ": first@example.com\r\n" +
+			header + ": second@example.com\r\n" +
+			"Subject: Hi\r\n\r\nBody\r\n"
+		if header == "To" {
+			raw = strings.Replace(raw, "To: bob@example.com\r\n", "", 1)
+		}
+		if _, err := parseRecipients([]byte(raw)); err == nil || !strings.Contains(err.Error(), header) {
+			t.Errorf("two %s headers: parseRecipients() = %v, want an error naming %s", header, err, header)
+		}
+	}
+}
+
+func TestCreateDraftInjectionNeverReachesGmail(t *testing.T) {
+	server, mailbox := newFakeServer(t)
+
+	for _, args := range []map[string]interface{}{
+		{"to": "x\r\nBcc: evil@x", "subject": "Hi", "body": "Hello"},
+		{"to": "bob@example.com", "cc": "x\nBcc: evil@x", "subject": "Hi", "body": "Hello"},
+		{"to": "bob@example.com", "subject": "Hi\r\nBcc: evil@x", "body": "Hello"},
+	} {
+		// The same path create_draft takes after resolving the account
+		outgoing, err := outgoingFromRequest(toolRequest("create_draft", args))
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := server.CreateDraft(context.Background(), outgoing, "", false, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.IsError || !strings.Contains(resultText(t, result), "line break") {
+			t.Errorf("%v: result %q, want a line break refusal", args, resultText(t, result))
+		}
+	}
+
+	drafts, err := mailbox.ListDrafts(context.Background(), 10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drafts.Drafts) != 0 || len(mailbox.SentMessages()) != 0 {
+		t.Fatalf("injected message reached Gmail: %d drafts, %d sent", len(drafts.Drafts), len(mailbox.SentMessages()))
+	}
+}
+
+func TestSendEmailInjectionNeverReachesGmail(t *testing.T) {
+	daemon := startFakeApprovalDaemon(t, approveAll)
+	server, mailbox := newFakeServer(t)
+
+	for _, args := range []map[string]interface{}{
+		{"to": "x\r\nBcc: evil@x", "subject": "Hi", "body": "Hello"},
+		{"to": "bob@example.com", "cc": "x\nBcc: evil@x", "subject": "Hi", "body": "Hello"},
+		{"to": "bob@example.com", "subject": "Hi\r\nBcc: evil@x", "body": "Hello"},
+	} {
+		// The same path send_email_ato takes after resolving the account
+		outgoing, err := outgoingFromRequest(toolRequest("send_email_ato", args))
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := server.SendWithApproval(context.Background(), outgoing, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.IsError || !strings.Contains(resultText(t, result), "line break") {
+			t.Errorf("%v: result %q, want a line break refusal", args, resultText(t, result))
+		}
+	}
+
+	drafts, err := mailbox.ListDrafts(context.Background(), 10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drafts.Drafts) != 0 || len(mailbox.SentMessages()) != 0 {
+		t.Fatalf("injected message reached Gmail: %d drafts, %d sent", len(drafts.Drafts), len(mailbox.SentMessages()))
+	}
+	if len(daemon.received()) != 0 {
+		t.Fatal("injected message was sent for approval")
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// threadBodyBudget caps the body text returned per thread (8000 chars = ~2000 tokens)
+const threadBodyBudget = 8000
+
+// Signatures and disclaimers are returned separately, cut down to these lengths
+const (
+	maxSignatureChars  = 500
+	maxDisclaimerChars = 200
+)
+
+// ConversationOptions selects which messages of a thread fetch_email_bodies returns
+type ConversationOptions struct {
+	LatestOnly bool     // only the most recent message
+	FromIndex  int      // 1-based, inclusive; 0 means the first message
+	ToIndex    int      // 1-based, inclusive; 0 means the last message
+	MessageIDs []string // only these messages, in thread order
+
+	IncludeQuoted bool // return quoted history in full, sharing the message's budget
+}
+
+// withoutDrafts returns the messages of a thread that have been sent or received
+func withoutDrafts(messages []*gmail.Message) []*gmail.Message {
+	var conversation []*gmail.Message
+	for _, msg := range messages {
+		if !slices.Contains(msg.LabelIds, "DRAFT") {
+			conversation = append(conversation, msg)
+		}
+	}
+	return conversation
+}
+
+// selectMessages returns the messages of conversation (a thread without its
+// drafts) picked by opts, along with their 1-based positions
+func selectMessages(conversation []*gmail.Message, opts ConversationOptions) ([]*gmail.Message, []int) {
+	// A thread holding nothing but drafts has no latest message
+	if len(conversation) == 0 {
+		return nil, nil
+	}
+
+	first, last := 1, len(conversation)
+	if opts.LatestOnly {
+		first = last
+	}
+	if opts.FromIndex > 0 {
+		first = max(first, opts.FromIndex)
+	}
+	if opts.ToIndex > 0 {
+		last = min(last, opts.ToIndex)
+	}
+
+	var selected []*gmail.Message
+	var positions []int
+	for i := first; i <= last; i++ {
+		msg := conversation[i-1]
+		if len(opts.MessageIDs) > 0 && !slices.Contains(opts.MessageIDs, msg.Id) {
+			continue
+		}
+		selected = append(selected, msg)
+		positions = append(positions, i)
+	}
+	return selected, positions
+}
+
+// splitBudget shares budget between bodies of the given lengths. Later (more
+// recent) messages get a larger share, and whatever a short message does not
+// use is passed on to the others.
+func splitBudget(lengths []int, budget int) []int {
+	n := len(lengths)
+	shares := make([]int, n)
+	open := make([]bool, n)
+	for i := range open {
+		open[i] = true
+	}
+	// The newest message weighs about twice the oldest
+	weight := func(i int) int { return n + i }
+
+	for remaining := budget; ; {
+		totalWeight := 0
+		for i := range n {
+			if open[i] {
+				totalWeight += weight(i)
+			}
+		}
+		if totalWeight == 0 {
+			return shares
+		}
+
+		settled := false
+		for i := range n {
+			if open[i] && lengths[i]*totalWeight <= remaining*weight(i) {
+				shares[i] = lengths[i]
+				remaining -= lengths[i]
+				open[i] = false
+				settled = true
+			}
+		}
+		if !settled {
+			for i := range n {
+				if open[i] {
+					shares[i] = remaining * weight(i) / totalWeight
+				}
+			}
+			return shares
+		}
+	}
+}
+
+// renderConversation builds the per-message entries of a thread for fetch_email_bodies
+func renderConversation(messages []*gmail.Message, positions []int, opts ConversationOptions) []map[string]interface{} {
+	cleaned := make([]CleanedBody, len(messages))
+	lengths := make([]int, len(messages))
+	for i, msg := range messages {
+		cleaned[i] = cleanEmailBody(extractEmailBody(msg))
+		lengths[i] = len(cleaned[i].Body)
+		if opts.IncludeQuoted {
+			lengths[i] += len(cleaned[i].QuotedHistory)
+		}
+	}
+	shares := splitBudget(lengths, threadBodyBudget)
+
+	entries := make([]map[string]interface{}, len(messages))
+	for i, msg := range messages {
+		var headers []*gmail.MessagePartHeader
+		if msg.Payload != nil {
+			headers = msg.Payload.Headers
+		}
+
+		entry := map[string]interface{}{
+			"index":     positions[i],
+			"messageId": msg.Id,
+			"from":      headerValue(headers, "From"),
+			"date":      headerValue(headers, "Date"),
+		}
+		if to := headerValue(headers, "To"); to != "" {
+			entry["to"] = to
+		}
+		if cc := headerValue(headers, "Cc"); cc != "" {
+			entry["cc"] = cc
+		}
+
+		body, truncated := fitToBudget(cleaned[i].Body, shares[i])
+		entry["body"] = body
+		if quoted := cleaned[i].QuotedHistory; quoted != "" {
+			if opts.IncludeQuoted {
+				var quotedTruncated bool
+				entry["quotedHistory"], quotedTruncated = fitToBudget(quoted, max(shares[i]-len(cleaned[i].Body), 0))
+				truncated = truncated || quotedTruncated
+			} else {
+				// Earlier messages are returned on their own; say how much was left out
+				entry["quotedHistoryChars"] = len(quoted)
+			}
+		}
+		if truncated {
+			entry["truncated"] = true
+		}
+		if cleaned[i].Signature != "" {
+			entry["signature"] = truncateAtWord(cleaned[i].Signature, maxSignatureChars)
+		}
+		if cleaned[i].Disclaimer != "" {
+			entry["disclaimer"] = truncateAtWord(cleaned[i].Disclaimer, maxDisclaimerChars)
+		}
+		entries[i] = entry
+	}
+	return entries
+}
+
+// fitToBudget truncates text to about limit characters, noting how much was cut
+func fitToBudget(text string, limit int) (string, bool) {
+	if len(text) <= limit {
+		return text, false
+	}
+	cut := truncateAtWord(text, limit)
+	shown := len(strings.TrimSuffix(cut, "…"))
+	return cut + fmt.Sprintf("\n\n[Content truncated - %d of %d characters shown]", shown, len(text)), true
+}
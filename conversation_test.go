@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestSelectMessages(t *testing.T) {
+	thread := []*gmail.Message{
+		{Id: "m1"},
+		{Id: "d1", LabelIds: []string{"DRAFT"}},
+		{Id: "m2"},
+		{Id: "m3"},
+	}
+	tests := []struct {
+		name      string
+		messages  []*gmail.Message
+		opts      ConversationOptions
+		wantIDs   []string
+		wantIndex []int
+	}{
+		{"whole thread skips drafts", thread, ConversationOptions{}, []string{"m1", "m2", "m3"}, []int{1, 2, 3}},
+		{"latest only", thread, ConversationOptions{LatestOnly: true}, []string{"m3"}, []int{3}},
+		{"index range", thread, ConversationOptions{FromIndex: 2, ToIndex: 3}, []string{"m2", "m3"}, []int{2, 3}},
+		{"range past the end", thread, ConversationOptions{FromIndex: 5}, nil, nil},
+		{"message IDs", thread, ConversationOptions{MessageIDs: []string{"m3", "m1", "d1"}}, []string{"m1", "m3"}, []int{1, 3}},
+		{"draft-only thread", []*gmail.Message{{Id: "d1", LabelIds: []string{"DRAFT"}}}, ConversationOptions{LatestOnly: true}, nil, nil},
+		{"empty thread", nil, ConversationOptions{FromIndex: 1, ToIndex: 1}, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, positions := selectMessages(withoutDrafts(tt.messages), tt.opts)
+			var ids []string
+			for _, msg := range selected {
+				ids = append(ids, msg.Id)
+			}
+			if !slices.Equal(ids, tt.wantIDs) || !slices.Equal(positions, tt.wantIndex) {
+				t.Fatalf("selected %v at %v, want %v at %v", ids, positions, tt.wantIDs, tt.wantIndex)
+			}
+		})
+	}
+}
+
+// standaloneDraft is a thread holding nothing but an unsent draft
+var standaloneDraft = FixtureMessage{ID: "d1", ThreadID: "t9", To: "bob@example.com", Subject: "Idea", Body: "Not sent yet", Labels: []string{"DRAFT"}}
+
+func TestFetchEmailBodiesDraftOnlyThread(t *testing.T) {
+	server, _ := newFakeServer(t, plansThread, standaloneDraft,
+		FixtureMessage{ID: "d2", ThreadID: "t1", To: "alice@example.com", Subject: "Re: Plans", Body: "Draft reply", Labels: []string{"DRAFT"}},
+	)
+
+	result, err := server.FetchEmailBodies(context.Background(), []string{"t9", "t1"}, ConversationOptions{LatestOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var threads []struct {
+		ThreadID     string `json:"threadId"`
+		MessageCount int    `json:"messageCount"`
+		Messages     []struct {
+			Index     int    `json:"index"`
+			MessageID string `json:"messageId"`
+		} `json:"messages"`
+	}
+	decodeResult(t, result, &threads)
+	if len(threads) != 2 || threads[0].MessageCount != 0 || len(threads[0].Messages) != 0 {
+		t.Fatalf("draft-only thread = %+v", threads)
+	}
+	// The count matches the indexes: the draft reply in t1 is left out of both
+	if threads[1].MessageCount != 1 || len(threads[1].Messages) != 1 || threads[1].Messages[0].Index != 1 || threads[1].Messages[0].MessageID != "m1" {
+		t.Fatalf("thread with a draft reply = %+v", threads[1])
+	}
+}
+
+func TestReplyToDraftOnlyThread(t *testing.T) {
+	server, _ := newFakeServer(t, standaloneDraft)
+	outgoing := OutgoingMessage{To: "bob@example.com", Subject: "Idea", Body: "Hi"}
+	err := server.prepareReply(context.Background(), &outgoing, "t9", false)
+	if err == nil || !strings.Contains(err.Error(), "no messages to reply to") {
+		t.Fatalf("reply to a draft-only thread: %v", err)
+	}
+}
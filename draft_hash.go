@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// draftContentHash returns the hash an approval is bound to: the SHA-256 of a
+// draft's raw RFC 822 source, as "sha256:<hex>". Line endings are normalized to
+// CRLF and trailing blank lines dropped first, so the same message hashes the
+// same however Gmail happens to serialize it.
+func draftContentHash(raw []byte) string {
+	canonical := bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n"))
+	canonical = bytes.ReplaceAll(canonical, []byte("\r"), []byte("\n"))
+	canonical = bytes.TrimRight(canonical, "\n")
+	canonical = bytes.ReplaceAll(canonical, []byte("\n"), []byte("\r\n"))
+
+	sum := sha256.Sum256(canonical)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// fetchDraftRaw returns a draft's RFC 822 source exactly as Gmail stores it,
+// and the thread the draft belongs to
+func (g *GmailServer) fetchDraftRaw(ctx context.Context, draftID string) ([]byte, string, error) {
+	draft, err := g.mailbox.GetDraftRaw(ctx, draftID)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get draft: %v", err)
+	}
+	if draft.Message == nil || draft.Message.Raw == "" {
+		return nil, "", fmt.Errorf("draft %s has no message", draftID)
+	}
+	raw, err := decodeRawMessage(draft.Message.Raw)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode draft %s: %v", draftID, err)
+	}
+	return raw, draft.Message.ThreadId, nil
+}
+
+// SendApprovedDraft sends a draft only if it still hashes to contentHash, the
+// hash the user approved. The draft is re-fetched first, so an edit made while
+// the approval was pending (by another client, or a create_draft replace)
+// stops the send instead of going out unseen. The bytes that were checked are
+// sent as a new message rather than sending the draft by ID, which would send
+// whatever the draft holds by then; the draft is deleted afterwards.
+func (g *GmailServer) SendApprovedDraft(ctx context.Context, draftID, contentHash string) error {
+	raw, threadID, err := g.fetchDraftRaw(ctx, draftID)
+	if err != nil {
+		return err
+	}
+	if current := draftContentHash(raw); current != contentHash {
+		log.Printf("🚫 Draft changed after approval, not sending: account=%s id=%s approved=%s current=%s", g.account, draftID, contentHash, current)
+		return fmt.Errorf("draft %s changed after it was approved (approved %s, now %s); nothing was sent", draftID, contentHash, current)
+	}
+
+	message := &gmail.Message{Raw: base64.URLEncoding.EncodeToString(raw), ThreadId: threadID}
+	if _, err := g.mailbox.SendMessage(ctx, message); err != nil {
+		return fmt.Errorf("failed to send draft: %v", err)
+	}
+	// The approved message is out; a draft left behind is only clutter
+	if err := g.mailbox.DeleteDraft(ctx, draftID); err != nil {
+		log.Printf("⚠️  Sent draft %s but could not delete it: %v", draftID, err)
+	}
+	return nil
+}
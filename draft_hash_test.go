@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestDraftContentHashIgnoresLineEndings(t *testing.T) {
+	crlf := draftContentHash([]byte("To: bob@example.com\r\nSubject: Hi\r\n\r\nHello\r\n"))
+	lf := draftContentHash([]byte("To: bob@example.com\nSubject: Hi\n\nHello\n\n"))
+	if crlf != lf {
+		t.Fatalf("same message hashed differently: %s vs %s", crlf, lf)
+	}
+	if !strings.HasPrefix(crlf, "sha256:") || len(crlf) != len("sha256:")+64 {
+		t.Fatalf("hash %q is not sha256:<hex>", crlf)
+	}
+	if other := draftContentHash([]byte("To: eve@example.com\nSubject: Hi\n\nHello\n")); other == crlf {
+		t.Fatal("different recipients hashed the same")
+	}
+}
+
+// saveDraft stores outgoing as a draft and returns its ID and content hash
+func saveDraft(t *testing.T, server *GmailServer, mailbox *FakeMailbox, outgoing OutgoingMessage) (string, string) {
+	t.Helper()
+	raw, err := outgoing.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	draft, err := mailbox.CreateDraft(context.Background(), &gmail.Draft{Message: &gmail.Message{Raw: base64.URLEncoding.EncodeToString(raw)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, _, err := server.fetchDraftRaw(context.Background(), draft.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return draft.Id, draftContentHash(stored)
+}
+
+func TestSendApprovedDraftSendsOnlyApprovedContent(t *testing.T) {
+	server, mailbox := newFakeServer(t)
+	ctx := context.Background()
+
+	draftID, approved := saveDraft(t, server, mailbox, OutgoingMessage{To: "bob@example.com", Subject: "Hi", Body: "Hello"})
+	if err := server.SendApprovedDraft(ctx, draftID, approved); err != nil {
+		t.Fatal(err)
+	}
+	sent := mailbox.SentMessages()
+	if len(sent) != 1 || headerValue(sent[0].Payload.Headers, "To") != "bob@example.com" {
+		t.Fatalf("sent %d messages, want the approved one", len(sent))
+	}
+
+	// Edited after approval: the approval no longer covers it
+	draftID, approved = saveDraft(t, server, mailbox, OutgoingMessage{To: "bob@example.com", Subject: "Hi", Body: "Hello"})
+	raw, _ := (&OutgoingMessage{To: "bob@example.com, eve@example.com", Subject: "Hi", Body: "Hello"}).Build()
+	if _, err := mailbox.UpdateDraft(ctx, draftID, &gmail.Draft{Message: &gmail.Message{Raw: base64.URLEncoding.EncodeToString(raw)}}); err != nil {
+		t.Fatal(err)
+	}
+	err := server.SendApprovedDraft(ctx, draftID, approved)
+	if err == nil || !strings.Contains(err.Error(), "changed after it was approved") {
+		t.Fatalf("SendApprovedDraft after an edit = %v, want a refusal", err)
+	}
+	if len(mailbox.SentMessages()) != 1 {
+		t.Fatal("edited draft was sent")
+	}
+}
+
+func TestSendWithApprovalThroughDaemon(t *testing.T) {
+	daemon := startFakeApprovalDaemon(t, approveAll)
+	server, mailbox := newFakeServer(t)
+
+	outgoing := OutgoingMessage{To: "Bob <bob@example.com>", Bcc: "audit@example.com", Subject: "Quarterly", Body: "Numbers attached."}
+	result, err := server.SendWithApproval(context.Background(), outgoing, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent struct {
+		Status      string   `json:"status"`
+		To          []string `json:"to"`
+		Bcc         []string `json:"bcc"`
+		ContentHash string   `json:"contentHash"`
+	}
+	decodeResult(t, result, &sent)
+	if sent.Status != "sent" || len(mailbox.SentMessages()) != 1 {
+		t.Fatalf("result %+v, %d sent; want one sent", sent, len(mailbox.SentMessages()))
+	}
+
+	// The daemon was shown the draft as stored, bound to its hash
+	requests := daemon.received()
+	if len(requests) != 1 {
+		t.Fatalf("%d approval requests, want 1", len(requests))
+	}
+	req := requests[0]
+	if req["to"] != "Bob <bob@example.com>" || req["bcc"] != "audit@example.com" || req["subject"] != "Quarterly" || req["content_hash"] != sent.ContentHash {
+		t.Fatalf("approval request = %v", req)
+	}
+}
+
+func TestApprovalShowsBothBodyAlternatives(t *testing.T) {
+	daemon := startFakeApprovalDaemon(t, approveAll)
+	server, _ := newFakeServer(t)
+
+	// A text version that says something the HTML doesn't must not go out unseen
+	outgoing := OutgoingMessage{
+		To:       "bob@example.com",
+		Subject:  "Invoice",
+		Body:     "Please wire the money to account 999.",
+		HTMLBody: "<p>Your invoice is attached.</p>",
+	}
+	if _, err := server.SendWithApproval(context.Background(), outgoing, ""); err != nil {
+		t.Fatal(err)
+	}
+	requests := daemon.received()
+	if len(requests) != 1 {
+		t.Fatalf("%d approval requests, want 1", len(requests))
+	}
+	body, _ := requests[0]["body"].(string)
+	for _, want := range []string{"Please wire the money to account 999.", "Your invoice is attached."} {
+		if !strings.Contains(body, want) {
+			t.Errorf("approval body %q does not show %q", body, want)
+		}
+	}
+}
+
+// editsAfterCheck is a mailbox where another client rewrites a draft right
+// after it has been fetched for sending
+type editsAfterCheck struct {
+	*FakeMailbox
+	edit []byte
+}
+
+func (m editsAfterCheck) GetDraftRaw(ctx context.Context, draftID string) (*gmail.Draft, error) {
+	draft, err := m.FakeMailbox.GetDraftRaw(ctx, draftID)
+	if err == nil {
+		_, err = m.FakeMailbox.UpdateDraft(ctx, draftID, &gmail.Draft{Message: &gmail.Message{Raw: base64.URLEncoding.EncodeToString(m.edit)}})
+	}
+	return draft, err
+}
+
+func TestSendApprovedDraftSendsTheCheckedBytes(t *testing.T) {
+	server, mailbox := newFakeServer(t, plansThread)
+	ctx := context.Background()
+
+	// A reply, so the send has to stay in the draft's thread
+	raw, _ := (&OutgoingMessage{To: "alice@example.com", Subject: "Re: Plans", Body: "Friday works", InReplyTo: "<m1@example.com>"}).Build()
+	draft, err := mailbox.CreateDraft(ctx, &gmail.Draft{Message: &gmail.Message{Raw: base64.URLEncoding.EncodeToString(raw), ThreadId: "t1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, _, err := server.fetchDraftRaw(ctx, draft.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	draftID, approved := draft.Id, draftContentHash(stored)
+
+	edit, _ := (&OutgoingMessage{To: "alice@example.com, eve@example.com", Subject: "Re: Plans", Body: "Friday works"}).Build()
+	server.mailbox = editsAfterCheck{mailbox, edit}
+	if err := server.SendApprovedDraft(ctx, draftID, approved); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := mailbox.SentMessages()
+	if len(sent) != 1 {
+		t.Fatalf("%d messages sent, want 1", len(sent))
+	}
+	if to := headerValue(sent[0].Payload.Headers, "To"); to != "alice@example.com" {
+		t.Fatalf("sent to %q: the edit made after the check went out", to)
+	}
+	if sent[0].ThreadId != "t1" {
+		t.Fatalf("sent in thread %q, want the draft's thread t1", sent[0].ThreadId)
+	}
+	if _, err := mailbox.GetDraft(ctx, draftID); err == nil {
+		t.Fatal("draft left behind after sending")
+	}
+}
+
+func TestSendWithApprovalRefusesDraftEditedWhilePending(t *testing.T) {
+	var mailbox *FakeMailbox
+	startFakeApprovalDaemon(t, func(req map[string]interface{}) map[string]interface{} {
+		// Someone rewrites the draft while the phone shows the original
+		draftID, _ := req["draft_id"].(string)
+		raw, _ := (&OutgoingMessage{To: "eve@example.com", Subject: "Hi", Body: "Hello"}).Build()
+		mailbox.UpdateDraft(context.Background(), draftID, &gmail.Draft{Message: &gmail.Message{Raw: base64.URLEncoding.EncodeToString(raw)}})
+		return approveAll(req)
+	})
+	var server *GmailServer
+	server, mailbox = newFakeServer(t)
+
+	result, err := server.SendWithApproval(context.Background(), OutgoingMessage{To: "bob@example.com", Subject: "Hi", Body: "Hello"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.IsError || !strings.Contains(resultText(t, result), "changed after it was approved") {
+		t.Fatalf("result %q, want a refusal", resultText(t, result))
+	}
+	if len(mailbox.SentMessages()) != 0 {
+		t.Fatal("sent content that was never approved")
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"google.golang.org/api/gmail/v1"
+)
+
+// create_draft modes for a thread that already has a draft. Without a mode
+// the call is refused, so a draft the user wrote is never overwritten implicitly.
+const (
+	draftModeNew     = "new"     // add another draft next to the existing ones
+	draftModeReplace = "replace" // overwrite the thread's draft
+	draftModeAppend  = "append"  // add the new text (and keep the attachments) at the end of the thread's draft
+)
+
+// draftsCursorQuery stands in for a search query in list_drafts cursors, so
+// search_threads cursors cannot be replayed against the drafts list
+const draftsCursorQuery = "(drafts)"
+
+// draftHeaders are returned by get_draft, in this order
+var draftHeaders = []string{"From", "To", "Cc", "Bcc", "Reply-To", "Subject", "Date", "In-Reply-To", "References"}
+
+// ListDrafts returns one page of drafts, newest first, with their recipients, subject and a snippet
+func (g *GmailServer) ListDrafts(ctx context.Context, maxResults int64, cursor string) (*mcp.CallToolResult, error) {
+	if maxResults <= 0 {
+		maxResults = 10
+	}
+
+	page := 1
+	pageToken := ""
+	if cursor != "" {
+		decoded, err := decodeSearchCursor(cursor, draftsCursorQuery, g.account)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		page = decoded.Page
+		pageToken = decoded.PageToken
+	}
+
+	list, err := g.mailbox.ListDrafts(ctx, maxResults, pageToken)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to list drafts: %v", err)), nil
+	}
+
+	// Drafts are listed by ID only; fetch them in parallel like search results
+	drafts := make([]map[string]interface{}, len(list.Drafts))
+	var wg sync.WaitGroup
+	workers := make(chan struct{}, threadFetchWorkers)
+	for i, draft := range list.Drafts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			workers <- struct{}{}
+			defer func() { <-workers }()
+			drafts[i] = g.summarizeDraft(ctx, draft.Id)
+		}()
+	}
+	wg.Wait()
+
+	response := map[string]interface{}{
+		"drafts":             drafts,
+		"page":               page,
+		"resultSizeEstimate": list.ResultSizeEstimate,
+		"hasMore":            list.NextPageToken != "",
+	}
+	if list.NextPageToken != "" {
+		response["nextCursor"] = encodeSearchCursor(searchCursor{
+			Query:     draftsCursorQuery,
+			Account:   g.account,
+			PageToken: list.NextPageToken,
+			Page:      page + 1,
+		})
+	}
+
+	resultJSON, _ := json.MarshalIndent(response, "", "  ")
+	return mcp.NewToolResultText(string(resultJSON)), nil
+}
+
+// summarizeDraft describes a draft for list_drafts, or reports why it could not be loaded
+func (g *GmailServer) summarizeDraft(ctx context.Context, draftID string) map[string]interface{} {
+	draft, err := g.mailbox.GetDraft(ctx, draftID)
+	if err != nil || draft.Message == nil || draft.Message.Payload == nil {
+		if err == nil {
+			err = fmt.Errorf("draft has no message")
+		}
+		return map[string]interface{}{"draftId": draftID, "error": err.Error()}
+	}
+
+	headers := draft.Message.Payload.Headers
+	summary := map[string]interface{}{
+		"draftId":   draft.Id,
+		"messageId": draft.Message.Id,
+		"to":        headerValue(headers, "To"),
+		"subject":   headerValue(headers, "Subject"),
+	}
+	if draft.Message.ThreadId != "" {
+		summary["threadId"] = draft.Message.ThreadId
+	}
+	if cc := headerValue(headers, "Cc"); cc != "" {
+		summary["cc"] = cc
+	}
+	if body := strings.TrimSpace(extractEmailBody(draft.Message)); body != "" {
+		summary["snippet"] = truncateAtWord(body, 200)
+	}
+	if attachments := extractAttachmentInfo(draft.Message); len(attachments) > 0 {
+		summary["attachmentCount"] = len(attachments)
+	}
+	return summary
+}
+
+// GetDraft returns a draft's headers, full body and attachments
+func (g *GmailServer) GetDraft(ctx context.Context, draftID string) (*mcp.CallToolResult, error) {
+	draft, err := g.mailbox.GetDraft(ctx, draftID)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to get draft: %v", err)), nil
+	}
+	if draft.Message == nil || draft.Message.Payload == nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Draft %s has no message", draftID)), nil
+	}
+
+	headers := make(map[string]string)
+	for _, name := range draftHeaders {
+		if value := headerValue(draft.Message.Payload.Headers, name); value != "" {
+			headers[name] = value
+		}
+	}
+
+	result := map[string]interface{}{
+		"draftId":   draft.Id,
+		"messageId": draft.Message.Id,
+		"threadId":  draft.Message.ThreadId,
+		"headers":   headers,
+		"body":      extractEmailBody(draft.Message),
+	}
+	if attachments := extractAttachmentInfo(draft.Message); len(attachments) > 0 {
+		result["attachments"] = attachments
+	}
+	resultJSON, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(resultJSON)), nil
+}
+
+// DeleteDraft permanently deletes a draft
+func (g *GmailServer) DeleteDraft(ctx context.Context, draftID string) (*mcp.CallToolResult, error) {
+	if err := g.mailbox.DeleteDraft(ctx, draftID); err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to delete draft: %v", err)), nil
+	}
+	resultJSON, _ := json.MarshalIndent(map[string]interface{}{
+		"draftId": draftID,
+		"action":  "deleted",
+		"message": "Draft deleted",
+	}, "", "  ")
+	return mcp.NewToolResultText(string(resultJSON)), nil
+}
+
+// SendExistingDraft sends a draft as it is saved in Gmail, once the user
+// approves it on their phone. The approval is built from the draft's own
+// RFC 822 source, so it shows exactly what will be sent.
+func (g *GmailServer) SendExistingDraft(ctx context.Context, draftID string) (*mcp.CallToolResult, error) {
+	log.Printf("📝 Sending existing draft: account=%s id=%s", g.account, draftID)
+	return g.approveAndSendDraft(ctx, draftID)
+}
+
+// UpdateDraft replaces the content of a draft, keeping its thread and the
+// threading headers of the message it replies to. The draft's attachments are
+// kept, ahead of any outgoing adds, unless removeAttachments is set.
+func (g *GmailServer) UpdateDraft(ctx context.Context, draftID string, outgoing OutgoingMessage, removeAttachments bool) (*mcp.CallToolResult, error) {
+	existing, err := g.mailbox.GetDraft(ctx, draftID)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to get draft: %v", err)), nil
+	}
+	if existing.Message == nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Draft %s has no message", draftID)), nil
+	}
+	if existing.Message.Payload != nil {
+		outgoing.InReplyTo = headerValue(existing.Message.Payload.Headers, "In-Reply-To")
+		outgoing.References = headerValue(existing.Message.Payload.Headers, "References")
+	}
+	if !removeAttachments && existing.Message.Payload != nil {
+		var adding int64
+		for _, attachment := range outgoing.Attachments {
+			adding += int64(len(attachment.Data))
+		}
+		kept, err := g.originalAttachments(ctx, existing.Message, adding)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("cannot keep the draft's attachments: %v (pass remove_attachments to drop them)", err)), nil
+		}
+		outgoing.Attachments = append(kept, outgoing.Attachments...)
+	}
+
+	raw, err := outgoing.Build()
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to build email: %v", err)), nil
+	}
+	result, err := draftResult(outgoing, raw)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to build email: %v", err)), nil
+	}
+
+	updated, err := g.mailbox.UpdateDraft(ctx, draftID, &gmail.Draft{
+		Id: draftID,
+		Message: &gmail.Message{
+			ThreadId: existing.Message.ThreadId,
+			Raw:      base64.URLEncoding.EncodeToString(raw),
+		},
+	})
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to update draft: %v", err)), nil
+	}
+
+	result["draftId"] = updated.Id
+	result["action"] = "updated"
+	result["message"] = "Draft updated successfully"
+	resultJSON, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(resultJSON)), nil
+}
+
+// draftResult describes a built draft for create_draft and update_draft
+func draftResult(outgoing OutgoingMessage, raw []byte) (map[string]interface{}, error) {
+	result := map[string]interface{}{
+		"to":      outgoing.To,
+		"subject": outgoing.Subject,
+	}
+	if outgoing.Cc != "" {
+		result["cc"] = outgoing.Cc
+	}
+	if outgoing.Bcc != "" {
+		result["bcc"] = outgoing.Bcc
+	}
+	if len(outgoing.Attachments) > 0 {
+		attachments, err := parseAttachmentSummaries(raw)
+		if err != nil {
+			return nil, err
+		}
+		result["attachments"] = attachments
+	}
+	return result, nil
+}
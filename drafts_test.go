@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// draftsUnavailable is a mailbox whose drafts cannot be listed
+type draftsUnavailable struct {
+	*FakeMailbox
+}
+
+func (m draftsUnavailable) ListDrafts(ctx context.Context, maxResults int64, pageToken string) (*gmail.ListDraftsResponse, error) {
+	return nil, errors.New("backend error")
+}
+
+func TestCreateDraftFailsWhenThreadDraftsCannotBeChecked(t *testing.T) {
+	fake, err := NewFakeMailbox("me@example.com", plansThread)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewGmailServerWithMailbox("default", draftsUnavailable{fake})
+
+	for _, mode := range []string{"", draftModeReplace, draftModeAppend} {
+		outgoing := OutgoingMessage{To: "alice@example.com", Subject: "Plans", Body: "Yes!"}
+		result, err := server.CreateDraft(context.Background(), outgoing, "t1", false, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.IsError || !strings.Contains(resultText(t, result), "existing drafts") {
+			t.Errorf("mode %q: result %q, want a failure to check existing drafts", mode, resultText(t, result))
+		}
+	}
+
+	// "new" never looks at existing drafts, so it still works
+	outgoing := OutgoingMessage{To: "alice@example.com", Subject: "Plans", Body: "Yes!"}
+	result, err := server.CreateDraft(context.Background(), outgoing, "t1", false, draftModeNew)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.IsError {
+		t.Fatalf("mode new: %s", resultText(t, result))
+	}
+	drafts, _ := fake.ListDrafts(context.Background(), 10, "")
+	if len(drafts.Drafts) != 1 {
+		t.Fatalf("%d drafts created, want only the one from mode new", len(drafts.Drafts))
+	}
+}
+
+// draftBody returns the plain text body of a draft in the fake
+func draftBody(t *testing.T, mailbox *FakeMailbox, draftID string) string {
+	t.Helper()
+	draft, err := mailbox.GetDraft(context.Background(), draftID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(strings.ReplaceAll(extractEmailBody(draft.Message), "\r\n", "\n"))
+}
+
+func TestCreateDraftModes(t *testing.T) {
+	server, mailbox := newFakeServer(t, plansThread)
+	ctx := context.Background()
+
+	type draftResponse struct {
+		DraftID string `json:"draftId"`
+		Action  string `json:"action"`
+		Subject string `json:"subject"`
+	}
+	create := func(body, mode string) (draftResponse, string) {
+		t.Helper()
+		result, err := server.CreateDraft(ctx, OutgoingMessage{To: "alice@example.com", Subject: "Plans", Body: body}, "t1", false, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.IsError {
+			return draftResponse{}, resultText(t, result)
+		}
+		var response draftResponse
+		decodeResult(t, result, &response)
+		return response, ""
+	}
+
+	first, refusal := create("Yes!", "")
+	if refusal != "" || first.Action != "created" {
+		t.Fatalf("first draft: %+v %s", first, refusal)
+	}
+	draft, _ := mailbox.GetDraft(ctx, first.DraftID)
+	if draft.Message.ThreadId != "t1" || headerValue(draft.Message.Payload.Headers, "In-Reply-To") != "<m1@example.com>" || headerValue(draft.Message.Payload.Headers, "Subject") != "Re: Plans" {
+		t.Fatalf("draft is not a reply in the thread: %+v", draft.Message.Payload.Headers)
+	}
+
+	if _, refusal := create("Again", ""); !strings.Contains(refusal, "already has a draft ("+first.DraftID+")") {
+		t.Fatalf("second draft without a mode: %q, want a refusal naming the draft", refusal)
+	}
+
+	replaced, refusal := create("Yes, at 7.", draftModeReplace)
+	if refusal != "" || replaced.Action != "replaced" || replaced.DraftID != first.DraftID {
+		t.Fatalf("replace: %+v %s", replaced, refusal)
+	}
+	if body := draftBody(t, mailbox, first.DraftID); body != "Yes, at 7." {
+		t.Fatalf("replaced body = %q", body)
+	}
+
+	appended, refusal := create("I'll bring wine.", draftModeAppend)
+	if refusal != "" || appended.Action != "appended" || appended.DraftID != first.DraftID {
+		t.Fatalf("append: %+v %s", appended, refusal)
+	}
+	if body := draftBody(t, mailbox, first.DraftID); body != "Yes, at 7.\n\nI'll bring wine." {
+		t.Fatalf("appended body = %q", body)
+	}
+
+	second, refusal := create("Another thought", draftModeNew)
+	if refusal != "" || second.Action != "created" || second.DraftID == first.DraftID {
+		t.Fatalf("new: %+v %s", second, refusal)
+	}
+	if _, refusal := create("Which one?", draftModeReplace); !strings.Contains(refusal, "has 2 drafts") {
+		t.Fatalf("replace with two drafts: %q, want a refusal", refusal)
+	}
+
+	result, _ := server.CreateDraft(ctx, OutgoingMessage{To: "alice@example.com", Subject: "Plans", Body: "x"}, "", false, draftModeAppend)
+	if !result.IsError || !strings.Contains(resultText(t, result), "needs thread_id") {
+		t.Fatalf("append without a thread: %s", resultText(t, result))
+	}
+	result, _ = server.CreateDraft(ctx, OutgoingMessage{To: "alice@example.com", Subject: "Plans", Body: "x"}, "t1", false, "merge")
+	if !result.IsError || !strings.Contains(resultText(t, result), "invalid mode") {
+		t.Fatalf("unknown mode: %s", resultText(t, result))
+	}
+
+	drafts, _ := mailbox.ListDrafts(ctx, 10, "")
+	if len(drafts.Drafts) != 2 || len(mailbox.SentMessages()) != 0 {
+		t.Fatalf("%d drafts, %d sent; want 2 drafts and nothing sent", len(drafts.Drafts), len(mailbox.SentMessages()))
+	}
+}
+
+func TestSendExistingDraft(t *testing.T) {
+	var approve atomic.Bool
+	daemon := startFakeApprovalDaemon(t, func(req map[string]interface{}) map[string]interface{} {
+		if approve.Load() {
+			return approveAll(req)
+		}
+		return map[string]interface{}{"success": false, "status": "rejected", "error": "Email rejected by user"}
+	})
+	server, mailbox := newFakeServer(t, plansThread)
+	ctx := context.Background()
+
+	newDraft := func(raw string) string {
+		t.Helper()
+		draft, err := mailbox.CreateDraft(ctx, &gmail.Draft{Message: &gmail.Message{ThreadId: "t1", Raw: base64.URLEncoding.EncodeToString([]byte(raw))}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return draft.Id
+	}
+	reply := newDraft("To: alice@example.com\r\nSubject: Re: Plans\r\n\r\nFriday works.")
+
+	if result, _ := server.SendExistingDraft(ctx, "missing"); !result.IsError {
+		t.Fatalf("unknown draft: %s", resultText(t, result))
+	}
+	if result, _ := server.SendExistingDraft(ctx, newDraft("Subject: Nobody\r\n\r\nHello")); !result.IsError || !strings.Contains(resultText(t, result), "no recipients") {
+		t.Fatalf("draft without recipients: %s", resultText(t, result))
+	}
+	if len(daemon.received()) != 0 {
+		t.Fatal("invalid drafts were sent for approval")
+	}
+
+	if result, _ := server.SendExistingDraft(ctx, reply); !result.IsError || !strings.Contains(resultText(t, result), "rejected") {
+		t.Fatalf("rejected draft: %s", resultText(t, result))
+	}
+	if _, err := mailbox.GetDraft(ctx, reply); err != nil || len(mailbox.SentMessages()) != 0 {
+		t.Fatalf("rejected draft was sent (%v)", err)
+	}
+
+	approve.Store(true)
+	result, err := server.SendExistingDraft(ctx, reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent struct {
+		Status  string   `json:"status"`
+		To      []string `json:"to"`
+		Subject string   `json:"subject"`
+	}
+	decodeResult(t, result, &sent)
+	if sent.Status != "sent" || len(sent.To) != 1 || sent.To[0] != "alice@example.com" || sent.Subject != "Re: Plans" {
+		t.Fatalf("approved draft: %+v", sent)
+	}
+	if len(mailbox.SentMessages()) != 1 {
+		t.Fatalf("%d messages sent, want 1", len(mailbox.SentMessages()))
+	}
+	// The approval shows what the draft itself says
+	requests := daemon.received()
+	if last := requests[len(requests)-1]; last["to"] != "alice@example.com" || last["subject"] != "Re: Plans" || last["body"] != "Friday works." || last["draft_id"] != reply {
+		t.Fatalf("approval request %+v", last)
+	}
+}
+
+func TestUpdateDraftKeepsAttachments(t *testing.T) {
+	server, mailbox := newFakeServer(t, plansThread)
+	ctx := context.Background()
+	attachmentNames := func(draftID string) []string {
+		t.Helper()
+		draft, err := mailbox.GetDraft(ctx, draftID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, attachment := range extractAttachmentInfo(draft.Message) {
+			names = append(names, attachment["filename"].(string))
+		}
+		return names
+	}
+
+	outgoing := OutgoingMessage{
+		To: "alice@example.com", Subject: "Plans", Body: "Agenda attached.",
+		Attachments: []OutgoingAttachment{{Filename: "agenda.txt", MimeType: "text/plain", Data: []byte("1. Lunch")}},
+	}
+	if result, err := server.CreateDraft(ctx, outgoing, "", false, ""); err != nil || result.IsError {
+		t.Fatalf("create draft: %v %s", err, resultText(t, result))
+	}
+	drafts, _ := mailbox.ListDrafts(ctx, 10, "")
+	draftID := drafts.Drafts[0].Id
+
+	// A new body keeps the file, and new files are added after it
+	outgoing.Body = "Updated agenda attached."
+	outgoing.Attachments = []OutgoingAttachment{{Filename: "map.txt", MimeType: "text/plain", Data: []byte("here")}}
+	if result, err := server.UpdateDraft(ctx, draftID, outgoing, false); err != nil || result.IsError {
+		t.Fatalf("update draft: %v %s", err, resultText(t, result))
+	}
+	if body := draftBody(t, mailbox, draftID); body != "Updated agenda attached." {
+		t.Fatalf("body after update = %q", body)
+	}
+	if names := strings.Join(attachmentNames(draftID), ","); names != "agenda.txt,map.txt" {
+		t.Fatalf("attachments after update = %s, want agenda.txt,map.txt", names)
+	}
+
+	// Only an explicit request drops them
+	outgoing.Attachments = nil
+	if result, err := server.UpdateDraft(ctx, draftID, outgoing, true); err != nil || result.IsError {
+		t.Fatalf("update draft: %v %s", err, resultText(t, result))
+	}
+	if names := attachmentNames(draftID); len(names) != 0 {
+		t.Fatalf("attachments after remove_attachments = %v, want none", names)
+	}
+}
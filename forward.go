@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// forwardedHeaders are quoted above the original body, in the order Gmail uses
+var forwardedHeaders = []string{"From", "Date", "Subject", "To", "Cc"}
+
+// PrepareForward fills in outgoing as a forward of messageID: a "Fwd:"
+// subject, the note followed by the original headers and body, and the
+// original attachments. It returns the thread the forward belongs to.
+func (g *GmailServer) PrepareForward(ctx context.Context, outgoing *OutgoingMessage, messageID, note string) (string, error) {
+	original, err := g.mailbox.GetMessage(ctx, messageID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get message %s: %v", messageID, err)
+	}
+	if original.Payload == nil {
+		return "", fmt.Errorf("message %s has no content to forward", messageID)
+	}
+	headers := original.Payload.Headers
+
+	outgoing.Subject = forwardSubject(headerValue(headers, "Subject"))
+	outgoing.Body = forwardBody(note, headers, extractEmailBody(original))
+
+	// Keep the forward in the original conversation, as Gmail does
+	outgoing.InReplyTo, outgoing.References = threadingHeaders(headers)
+
+	outgoing.Attachments, err = g.originalAttachments(ctx, original, 0)
+	if err != nil {
+		return "", err
+	}
+	return original.ThreadId, nil
+}
+
+// forwardSubject prefixes subject with "Fwd:" unless it is already a forward
+func forwardSubject(subject string) string {
+	lower := strings.ToLower(subject)
+	if strings.HasPrefix(lower, "fwd:") || strings.HasPrefix(lower, "fw:") {
+		return subject
+	}
+	return "Fwd: " + subject
+}
+
+// forwardBody lays out a forward the way Gmail does: the note, a separator,
+// the original headers and then the original text
+func forwardBody(note string, headers []*gmail.MessagePartHeader, originalBody string) string {
+	var body strings.Builder
+	if note = strings.TrimSpace(note); note != "" {
+		body.WriteString(note + "\n\n")
+	}
+	body.WriteString("---------- Forwarded message ---------\n")
+	for _, name := range forwardedHeaders {
+		value := headerValue(headers, name)
+		if value == "" {
+			continue
+		}
+		if name == "Date" {
+			if date, err := mail.ParseDate(value); err == nil {
+				value = date.Format("Mon, Jan 2, 2006 at 3:04 PM")
+			}
+		}
+		fmt.Fprintf(&body, "%s: %s\n", name, value)
+	}
+	body.WriteString("\n" + strings.TrimSpace(originalBody) + "\n")
+	return body.String()
+}
+
+// originalAttachments downloads every part of msg that has a filename, so a
+// forward or a rewritten draft carries the same files. They must fit the attachment size limits
+// alongside reserved bytes already attached; if they don't, nothing is
+// downloaded and the error names the files at fault.
+func (g *GmailServer) originalAttachments(ctx context.Context, msg *gmail.Message, reserved int64) ([]OutgoingAttachment, error) {
+	var parts []*gmail.MessagePart
+	walkParts(msg.Payload, func(part *gmail.MessagePart) {
+		if part.Filename != "" && part.Body != nil {
+			parts = append(parts, part)
+		}
+	})
+
+	maxFile, maxTotal := g.attachments.sizeLimits()
+	var tooLarge, all []string
+	total := reserved
+	for _, part := range parts {
+		described := fmt.Sprintf("%q (%s)", part.Filename, formatByteSize(part.Body.Size))
+		all = append(all, described)
+		if part.Body.Size > maxFile {
+			tooLarge = append(tooLarge, described)
+		}
+		total += part.Body.Size
+	}
+	if len(tooLarge) > 0 {
+		return nil, fmt.Errorf("attachments larger than the %s limit per file: %s", formatByteSize(maxFile), strings.Join(tooLarge, ", "))
+	}
+	if total > maxTotal {
+		return nil, fmt.Errorf("attachments total %s, over the %s limit per email: %s", formatByteSize(total), formatByteSize(maxTotal), strings.Join(all, ", "))
+	}
+
+	attachments := make([]OutgoingAttachment, 0, len(parts))
+	for _, part := range parts {
+		encoded := part.Body.Data // small attachments can come inline
+		if part.Body.AttachmentId != "" {
+			body, err := g.mailbox.GetAttachment(ctx, msg.Id, part.Body.AttachmentId)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get attachment %q: %v", part.Filename, err)
+			}
+			encoded = body.Data
+		}
+		data, err := decodeRawMessage(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode attachment %q: %v", part.Filename, err)
+		}
+		// Gmail's reported size is what we checked; don't trust it beyond that
+		if int64(len(data)) > maxFile {
+			return nil, fmt.Errorf("attachment %q is larger than the %s limit", part.Filename, formatByteSize(maxFile))
+		}
+
+		mimeType := part.MimeType
+		if mimeType == "" {
+			mimeType = detectMimeType(part.Filename, data)
+		}
+		attachments = append(attachments, OutgoingAttachment{
+			Filename: part.Filename,
+			MimeType: mimeType,
+			Data:     data,
+		})
+	}
+	return attachments, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// countingAttachments counts attachment downloads
+type countingAttachments struct {
+	*FakeMailbox
+	downloads *atomic.Int32
+}
+
+func (m countingAttachments) GetAttachment(ctx context.Context, messageID, attachmentID string) (*gmail.MessagePartBody, error) {
+	m.downloads.Add(1)
+	return m.FakeMailbox.GetAttachment(ctx, messageID, attachmentID)
+}
+
+func TestPrepareForwardLimitsAttachmentSize(t *testing.T) {
+	file := func(name string, size int) FixtureAttachment {
+		return FixtureAttachment{Filename: name, MimeType: "application/octet-stream", Data: bytes.Repeat([]byte("x"), size)}
+	}
+	tests := []struct {
+		name        string
+		attachments []FixtureAttachment
+		wantErr     []string // substrings of the error; nil means the forward is prepared
+	}{
+		{"within limits", []FixtureAttachment{file("a.bin", 40), file("b.bin", 40)}, nil},
+		{"one file too large", []FixtureAttachment{file("small.bin", 10), file("huge.bin", 120)}, []string{"100 bytes limit per file", `"huge.bin" (120 bytes)`}},
+		{"total too large", []FixtureAttachment{file("a.bin", 80), file("b.bin", 80)}, []string{"total 160 bytes, over the 150 bytes limit", `"a.bin" (80 bytes)`, `"b.bin" (80 bytes)`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := plansThread
+			original.Attachments = tt.attachments
+			fake, err := NewFakeMailbox("me@example.com", original)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var downloads atomic.Int32
+			server := NewGmailServerWithMailbox("default", countingAttachments{fake, &downloads})
+			server.attachments = &AttachmentPolicy{MaxFileBytes: 100, MaxTotalBytes: 150}
+
+			outgoing := OutgoingMessage{To: "bob@example.com"}
+			_, err = server.PrepareForward(context.Background(), &outgoing, "m1", "FYI")
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(outgoing.Attachments) != len(tt.attachments) {
+					t.Fatalf("%d attachments forwarded, want %d", len(outgoing.Attachments), len(tt.attachments))
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatal("oversized attachments were forwarded")
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not mention %q", err, want)
+				}
+			}
+			if strings.Contains(err.Error(), "small.bin") {
+				t.Errorf("error %q blames a file within the limit", err)
+			}
+			if n := downloads.Load(); n != 0 {
+				t.Errorf("%d attachments downloaded before refusing", n)
+			}
+		})
+	}
+}
+
+// unpaddedAttachments serves attachment data without base64 padding, as
+// Gmail may
+type unpaddedAttachments struct {
+	*FakeMailbox
+}
+
+func (m unpaddedAttachments) GetAttachment(ctx context.Context, messageID, attachmentID string) (*gmail.MessagePartBody, error) {
+	body, err := m.FakeMailbox.GetAttachment(ctx, messageID, attachmentID)
+	if err != nil {
+		return nil, err
+	}
+	body.Data = strings.TrimRight(body.Data, "=")
+	return body, nil
+}
+
+func TestPrepareForwardDecodesUnpaddedAttachments(t *testing.T) {
+	original := plansThread
+	data := []byte("forty bytes of attachment, needing pad!!")
+	original.Attachments = []FixtureAttachment{{Filename: "notes.txt", MimeType: "text/plain", Data: data}}
+	fake, err := NewFakeMailbox("me@example.com", original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewGmailServerWithMailbox("default", unpaddedAttachments{fake})
+
+	outgoing := OutgoingMessage{To: "bob@example.com"}
+	if _, err := server.PrepareForward(context.Background(), &outgoing, "m1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(outgoing.Attachments) != 1 || !bytes.Equal(outgoing.Attachments[0].Data, data) {
+		t.Fatalf("forwarded attachments %+v, want notes.txt intact", outgoing.Attachments)
+	}
+}
+
+func TestPrepareForwardDefaultLimits(t *testing.T) {
+	original := plansThread
+	original.Attachments = []FixtureAttachment{{Filename: "scan.pdf", MimeType: "application/pdf", Data: make([]byte, defaultAttachmentMaxBytes+1)}}
+	fake, err := NewFakeMailbox("me@example.com", original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewGmailServerWithMailbox("default", fake)
+
+	// No attachment directory configured: the default limits still apply
+	outgoing := OutgoingMessage{To: "bob@example.com"}
+	if _, err := server.PrepareForward(context.Background(), &outgoing, "m1", ""); err == nil || !strings.Contains(err.Error(), `"scan.pdf"`) {
+		t.Fatalf("error %v, want scan.pdf refused", err)
+	}
+}
+
+func TestAppendDraftCountsNewAttachmentsTowardsLimit(t *testing.T) {
+	ctx := context.Background()
+	fake, err := NewFakeMailbox("me@example.com", plansThread)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewGmailServerWithMailbox("default", fake)
+	server.attachments = &AttachmentPolicy{MaxFileBytes: 100, MaxTotalBytes: 150}
+
+	withFile := func(name string) OutgoingMessage {
+		return OutgoingMessage{
+			To: "alice@example.com", Subject: "Plans", Body: "See attached",
+			Attachments: []OutgoingAttachment{{Filename: name, MimeType: "application/octet-stream", Data: bytes.Repeat([]byte("x"), 80)}},
+		}
+	}
+	if result, err := server.CreateDraft(ctx, withFile("first.bin"), "t1", false, ""); err != nil || result.IsError {
+		t.Fatalf("first draft: %v %s", err, resultText(t, result))
+	}
+	result, err := server.CreateDraft(ctx, withFile("second.bin"), "t1", false, draftModeAppend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.IsError || !strings.Contains(resultText(t, result), `"first.bin" (80 bytes)`) {
+		t.Fatalf("append over the total limit: %s", resultText(t, result))
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// defaultHTTPHost keeps --http mode reachable from this machine only
+const defaultHTTPHost = "127.0.0.1"
+
+// HTTPAccess decides who may reach the MCP endpoints in --http mode. Browser
+// requests are only let in from listed origins, and without a token the Host
+// header must be a loopback name so a web page can't get in through DNS
+// rebinding. Listening beyond loopback requires a bearer token.
+type HTTPAccess struct {
+	Host           string          // interface to listen on
+	Token          string          // bearer token clients must send; empty for none
+	AllowedOrigins map[string]bool // browser origins (scheme://host:port) allowed to call the endpoints
+}
+
+// httpAccessFromEnv reads GMAIL_HTTP_HOST, GMAIL_HTTP_TOKEN and GMAIL_HTTP_ALLOWED_ORIGINS
+func httpAccessFromEnv() (*HTTPAccess, error) {
+	access := &HTTPAccess{
+		Host:           defaultHTTPHost,
+		Token:          strings.TrimSpace(os.Getenv("GMAIL_HTTP_TOKEN")),
+		AllowedOrigins: make(map[string]bool),
+	}
+	if host := strings.TrimSpace(os.Getenv("GMAIL_HTTP_HOST")); host != "" {
+		access.Host = strings.Trim(host, "[]")
+	}
+	for _, origin := range strings.Split(os.Getenv("GMAIL_HTTP_ALLOWED_ORIGINS"), ",") {
+		origin = normalizeOrigin(origin)
+		if origin == "" {
+			continue
+		}
+		if origin == "*" {
+			return nil, fmt.Errorf("GMAIL_HTTP_ALLOWED_ORIGINS must list origins, not *")
+		}
+		access.AllowedOrigins[origin] = true
+	}
+	if !isLoopbackHost(access.Host) && access.Token == "" {
+		return nil, fmt.Errorf("GMAIL_HTTP_HOST %q is reachable from other machines; set GMAIL_HTTP_TOKEN so clients must authenticate", access.Host)
+	}
+	return access, nil
+}
+
+// Addr returns the address to listen on
+func (a *HTTPAccess) Addr(port string) string {
+	return net.JoinHostPort(a.Host, port)
+}
+
+// BaseURL returns the server's URL as seen from this machine
+func (a *HTTPAccess) BaseURL(port string) string {
+	host := a.Host
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// Wrap guards an MCP transport handler
+func (a *HTTPAccess) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.allowsHost(r.Host) {
+			http.Error(w, "Host not allowed", http.StatusForbidden)
+			return
+		}
+
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if !a.AllowedOrigins[normalizeOrigin(origin)] {
+				http.Error(w, "Origin not allowed", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+			w.Header().Set("Access-Control-Allow-Methods", "POST, GET, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Accept, Authorization, Mcp-Session-Id")
+			w.Header().Set("Access-Control-Expose-Headers", "Mcp-Session-Id")
+		}
+
+		// Preflights carry no credentials
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		if a.Token != "" && !a.hasToken(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gmail-mcp"`)
+			http.Error(w, "Missing or invalid bearer token", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowsHost checks the Host header. A page that rebinds its own name to
+// 127.0.0.1 still sends that name, so without a token only loopback names
+// pass; with one, the page couldn't authenticate anyway.
+func (a *HTTPAccess) allowsHost(hostHeader string) bool {
+	if a.Token != "" {
+		return true
+	}
+	host := hostHeader
+	if h, _, err := net.SplitHostPort(hostHeader); err == nil {
+		host = h
+	}
+	return isLoopbackHost(strings.Trim(host, "[]"))
+}
+
+// hasToken checks the request's Authorization header in constant time
+func (a *HTTPAccess) hasToken(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(a.Token)) == 1
+}
+
+// isLoopbackHost reports whether host is localhost or a loopback IP
+func isLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// normalizeOrigin lowercases an origin and drops a trailing slash
+func normalizeOrigin(origin string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(origin), "/"))
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPAccessFromEnv(t *testing.T) {
+	tests := []struct {
+		name, host, token, origins string
+		wantHost                   string
+		wantErr                    bool
+	}{
+		{name: "default is loopback", wantHost: "127.0.0.1"},
+		{name: "localhost", host: "localhost", wantHost: "localhost"},
+		{name: "IPv6 loopback", host: "[::1]", wantHost: "::1"},
+		{name: "all interfaces need a token", host: "0.0.0.0", wantErr: true},
+		{name: "LAN address needs a token", host: "192.168.1.20", wantErr: true},
+		{name: "all interfaces with a token", host: "0.0.0.0", token: "s3cret", wantHost: "0.0.0.0"},
+		{name: "wildcard origin", origins: "*", wantErr: true},
+		{name: "origin list", origins: "http://localhost:6274/, https://app.example.com", wantHost: "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GMAIL_HTTP_HOST", tt.host)
+			t.Setenv("GMAIL_HTTP_TOKEN", tt.token)
+			t.Setenv("GMAIL_HTTP_ALLOWED_ORIGINS", tt.origins)
+			access, err := httpAccessFromEnv()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %+v, want an error", access)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if access.Host != tt.wantHost {
+				t.Fatalf("host %q, want %q", access.Host, tt.wantHost)
+			}
+			if tt.origins != "" && (!access.AllowedOrigins["http://localhost:6274"] || !access.AllowedOrigins["https://app.example.com"]) {
+				t.Fatalf("allowed origins %v", access.AllowedOrigins)
+			}
+		})
+	}
+}
+
+func TestHTTPAccessWrap(t *testing.T) {
+	local := &HTTPAccess{Host: "127.0.0.1", AllowedOrigins: map[string]bool{"http://localhost:6274": true}}
+	remote := &HTTPAccess{Host: "0.0.0.0", Token: "s3cret", AllowedOrigins: map[string]bool{}}
+
+	tests := []struct {
+		name       string
+		access     *HTTPAccess
+		method     string
+		host       string
+		headers    map[string]string
+		wantStatus int
+		wantCORS   string
+	}{
+		{name: "local client", access: local, host: "localhost:8080", wantStatus: http.StatusTeapot},
+		{name: "local client by IP", access: local, host: "127.0.0.1:8080", wantStatus: http.StatusTeapot},
+		{name: "IPv6 loopback", access: local, host: "[::1]:8080", wantStatus: http.StatusTeapot},
+		{name: "rebound name", access: local, host: "attacker.example:8080", wantStatus: http.StatusForbidden},
+		{name: "unlisted origin", access: local, host: "localhost:8080", headers: map[string]string{"Origin": "https://attacker.example"}, wantStatus: http.StatusForbidden},
+		{name: "listed origin", access: local, host: "localhost:8080", headers: map[string]string{"Origin": "http://localhost:6274"}, wantStatus: http.StatusTeapot, wantCORS: "http://localhost:6274"},
+		{name: "preflight", access: local, method: http.MethodOptions, host: "localhost:8080", headers: map[string]string{"Origin": "http://localhost:6274"}, wantStatus: http.StatusOK, wantCORS: "http://localhost:6274"},
+		{name: "no token", access: remote, host: "mail-box.lan:8080", wantStatus: http.StatusUnauthorized},
+		{name: "wrong token", access: remote, host: "mail-box.lan:8080", headers: map[string]string{"Authorization": "Bearer guess"}, wantStatus: http.StatusUnauthorized},
+		{name: "token", access: remote, host: "mail-box.lan:8080", headers: map[string]string{"Authorization": "Bearer s3cret"}, wantStatus: http.StatusTeapot},
+		{name: "preflight without token", access: remote, method: http.MethodOptions, host: "mail-box.lan:8080", wantStatus: http.StatusOK},
+		{name: "token from an unlisted origin", access: remote, host: "mail-box.lan:8080", headers: map[string]string{"Authorization": "Bearer s3cret", "Origin": "https://attacker.example"}, wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := tt.access.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusTeapot)
+			}))
+			method := tt.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			req := httptest.NewRequest(method, "/mcp", nil)
+			req.Host = tt.host
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantCORS {
+				t.Fatalf("Access-Control-Allow-Origin %q, want %q", got, tt.wantCORS)
+			}
+		})
+	}
+}
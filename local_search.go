@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"google.golang.org/api/gmail/v1"
+)
+
+// maxIndexedAttachmentBytes skips attachments too large to be worth downloading for the index
+const maxIndexedAttachmentBytes = 10 << 20
+
+// LocalSearch keeps a full-text index of the mirrored threads: message bodies
+// are indexed as soon as the mirror sees them, attachment text is extracted in
+// the background and cached on disk so it is only downloaded once. Cached text
+// of messages that leave the mirror is deleted after the sync that drops them.
+type LocalSearch struct {
+	mirror   *MailboxMirror
+	index    *SearchIndex
+	cacheDir string
+
+	mu         sync.Mutex
+	pending    map[string]*gmail.Thread // threads whose attachments still need indexing
+	cacheFiles map[string][]string      // thread ID -> cache files its attachments may use
+	prune      bool                     // a thread lost attachments since the last pruneCache
+	wake       chan struct{}
+}
+
+// NewLocalSearch creates an index over mirror; call mirror.Subscribe and Run to fill it
+func NewLocalSearch(mirror *MailboxMirror) *LocalSearch {
+	return &LocalSearch{
+		mirror:     mirror,
+		index:      NewSearchIndex(),
+		cacheDir:   filepath.Join(mirror.dir, "attachment-text"),
+		pending:    make(map[string]*gmail.Thread),
+		cacheFiles: make(map[string][]string),
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// ThreadUpdated re-indexes a thread's messages and queues its attachments
+func (s *LocalSearch) ThreadUpdated(thread *gmail.Thread) {
+	current := make(map[string]bool)
+	for _, msg := range thread.Messages {
+		current[msg.Id] = true
+	}
+	s.index.RemoveWhere(thread.Id, func(doc *IndexedDoc) bool {
+		return !current[doc.MessageID]
+	})
+
+	for _, msg := range thread.Messages {
+		if msg.Payload == nil {
+			continue
+		}
+		// Quoted history would repeat earlier messages' hits and disclaimers are noise
+		cleaned := cleanEmailBody(extractEmailBody(msg))
+		s.index.Add(&IndexedDoc{
+			ID:        "msg:" + msg.Id,
+			ThreadID:  thread.Id,
+			MessageID: msg.Id,
+			Kind:      "message",
+			Subject:   headerValue(msg.Payload.Headers, "Subject"),
+			From:      headerValue(msg.Payload.Headers, "From"),
+			Date:      headerValue(msg.Payload.Headers, "Date"),
+			Text:      strings.TrimSpace(cleaned.Body + "\n\n" + cleaned.Signature),
+		})
+	}
+
+	var files []string
+	for _, msg := range thread.Messages {
+		walkParts(msg.Payload, func(part *gmail.MessagePart) {
+			if indexableAttachment(part) {
+				files = append(files, cacheFileName(msg.Id, part.PartId))
+			}
+		})
+	}
+
+	s.mu.Lock()
+	for _, file := range s.cacheFiles[thread.Id] {
+		if !slices.Contains(files, file) {
+			s.prune = true
+		}
+	}
+	s.cacheFiles[thread.Id] = files
+	s.pending[thread.Id] = thread
+	s.mu.Unlock()
+	s.wakeUp()
+}
+
+// ThreadRemoved drops a thread from the index
+func (s *LocalSearch) ThreadRemoved(threadID string) {
+	s.mu.Lock()
+	delete(s.pending, threadID)
+	prune := len(s.cacheFiles[threadID]) > 0
+	if prune {
+		s.prune = true
+	}
+	delete(s.cacheFiles, threadID)
+	s.mu.Unlock()
+	s.index.RemoveThread(threadID)
+	if prune {
+		s.wakeUp()
+	}
+}
+
+// wakeUp tells Run there is work without blocking
+func (s *LocalSearch) wakeUp() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run extracts attachment text for queued threads until ctx is cancelled
+func (s *LocalSearch) Run(ctx context.Context) {
+	// Messages may have left the mirror while the server was not running
+	s.pruneCache()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		}
+
+		s.mu.Lock()
+		batch := s.pending
+		s.pending = make(map[string]*gmail.Thread)
+		prune := s.prune
+		s.prune = false
+		s.mu.Unlock()
+
+		if prune {
+			s.pruneCache()
+		}
+
+		for _, thread := range batch {
+			if ctx.Err() != nil {
+				return
+			}
+			s.indexAttachments(ctx, thread)
+		}
+	}
+}
+
+// indexAttachments adds the text of a thread's extractable attachments
+func (s *LocalSearch) indexAttachments(ctx context.Context, thread *gmail.Thread) {
+	for _, msg := range thread.Messages {
+		if msg.Payload == nil {
+			continue
+		}
+		walkParts(msg.Payload, func(part *gmail.MessagePart) {
+			if !indexableAttachment(part) {
+				return
+			}
+			docID := "att:" + msg.Id + ":" + part.PartId
+			if s.index.Has(docID) {
+				return
+			}
+
+			text, err := s.attachmentText(ctx, msg, part)
+			if err != nil {
+				log.Printf("Warning: Failed to index attachment %s of message %s: %v", part.Filename, msg.Id, err)
+				return
+			}
+			// The message may have been deleted while we were downloading
+			if !s.index.Has("msg:" + msg.Id) {
+				return
+			}
+			s.index.Add(&IndexedDoc{
+				ID:        docID,
+				ThreadID:  thread.Id,
+				MessageID: msg.Id,
+				Kind:      "attachment",
+				Subject:   headerValue(msg.Payload.Headers, "Subject"),
+				From:      headerValue(msg.Payload.Headers, "From"),
+				Date:      headerValue(msg.Payload.Headers, "Date"),
+				Filename:  part.Filename,
+				Text:      text,
+			})
+		})
+	}
+}
+
+// indexableAttachment reports whether a part's text should be extracted for the index
+func indexableAttachment(part *gmail.MessagePart) bool {
+	if part.Body == nil || part.Body.AttachmentId == "" || part.Body.Size > maxIndexedAttachmentBytes {
+		return false
+	}
+	return isExtractableDocument(part.MimeType, part.Filename)
+}
+
+// pruneCache deletes cached attachment text that no mirrored message uses any more
+func (s *LocalSearch) pruneCache() {
+	entries, err := os.ReadDir(s.cacheDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: Failed to read attachment text cache: %v", err)
+		}
+		return
+	}
+
+	s.mu.Lock()
+	keep := make(map[string]bool)
+	for _, files := range s.cacheFiles {
+		for _, file := range files {
+			keep[file] = true
+		}
+	}
+	s.mu.Unlock()
+
+	for _, entry := range entries {
+		if keep[entry.Name()] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.cacheDir, entry.Name())); err != nil {
+			log.Printf("Warning: Failed to remove cached attachment text %s: %v", entry.Name(), err)
+		}
+	}
+}
+
+// attachmentText returns an attachment's extracted text from the cache, or downloads and extracts it
+func (s *LocalSearch) attachmentText(ctx context.Context, msg *gmail.Message, part *gmail.MessagePart) (string, error) {
+	cachePath := filepath.Join(s.cacheDir, cacheFileName(msg.Id, part.PartId))
+	if cached, err := os.ReadFile(cachePath); err == nil {
+		return string(cached), nil
+	}
+
+	live := s.mirror.Mailbox
+	attachment, err := live.GetAttachment(ctx, msg.Id, part.Body.AttachmentId)
+	if err != nil {
+		// Attachment IDs of a mirrored copy can go stale; look the part up again
+		fresh, getErr := live.GetMessage(ctx, msg.Id)
+		if getErr != nil {
+			return "", err
+		}
+		var freshPart *gmail.MessagePart
+		walkParts(fresh.Payload, func(p *gmail.MessagePart) {
+			if p.PartId == part.PartId {
+				freshPart = p
+			}
+		})
+		if freshPart == nil || freshPart.Body == nil || freshPart.Body.AttachmentId == "" {
+			return "", err
+		}
+		attachment, err = live.GetAttachment(ctx, msg.Id, freshPart.Body.AttachmentId)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	data, err := base64.URLEncoding.DecodeString(attachment.Data)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode attachment data: %v", err)
+	}
+	text, err := extractTextFromBytes(data, part.MimeType, part.Filename)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(s.cacheDir, 0700); err == nil {
+		if err := writeFileAtomic(cachePath, []byte(text)); err != nil {
+			log.Printf("Warning: Failed to cache attachment text: %v", err)
+		}
+	}
+	return text, nil
+}
+
+// cacheFileName builds a file name from a message ID and MIME part ID (e.g. "1.2")
+func cacheFileName(messageID, partID string) string {
+	name := messageID + "-" + strings.ReplaceAll(partID, ".", "_")
+	if !mirrorIDPattern.MatchString(name) {
+		name = base64.RawURLEncoding.EncodeToString([]byte(messageID + "/" + partID))
+	}
+	return name + ".txt"
+}
+
+// walkParts calls fn for part and every nested part
+func walkParts(part *gmail.MessagePart, fn func(*gmail.MessagePart)) {
+	if part == nil {
+		return
+	}
+	fn(part)
+	for _, child := range part.Parts {
+		walkParts(child, fn)
+	}
+}
+
+// pendingCount returns how many threads are waiting for attachment extraction
+func (s *LocalSearch) pendingCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.pending)
+}
+
+// SearchLocal runs a full-text query against the local index
+func (g *GmailServer) SearchLocal(ctx context.Context, query string, maxResults int) (*mcp.CallToolResult, error) {
+	if g.search == nil {
+		return mcp.NewToolResultError("search_local needs the local mailbox mirror. Start the server with GMAIL_MIRROR=1"), nil
+	}
+	if maxResults <= 0 {
+		maxResults = 10
+	}
+	if parsed := parseSearchQuery(query); len(parsed.terms) == 0 && len(parsed.prefixes) == 0 {
+		return mcp.NewToolResultError("query has no searchable words"), nil
+	}
+
+	var results []map[string]interface{}
+	for _, hit := range g.search.index.Search(query, maxResults) {
+		result := map[string]interface{}{
+			"threadId":     hit.Doc.ThreadID,
+			"messageId":    hit.Doc.MessageID,
+			"kind":         hit.Doc.Kind,
+			"subject":      hit.Doc.Subject,
+			"from":         hit.Doc.From,
+			"date":         hit.Doc.Date,
+			"score":        math.Round(hit.Score*100) / 100,
+			"snippet":      hit.Snippet,
+			"matchedTerms": hit.Highlights,
+		}
+		if hit.Doc.Filename != "" {
+			result["filename"] = hit.Doc.Filename
+		}
+		results = append(results, result)
+	}
+	if results == nil {
+		results = []map[string]interface{}{}
+	}
+
+	messages, attachments := g.search.index.Counts()
+	response := map[string]interface{}{
+		"query":   query,
+		"results": results,
+		"index": map[string]interface{}{
+			"messages":                      messages,
+			"attachments":                   attachments,
+			"threadsAwaitingAttachmentText": g.search.pendingCount(),
+			"mirror":                        g.search.mirror.Status(),
+		},
+	}
+
+	resultJSON, _ := json.MarshalIndent(response, "", "  ")
+	return mcp.NewToolResultText(string(resultJSON)), nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// cachedTexts lists the files in the attachment text cache
+func cachedTexts(t *testing.T, s *LocalSearch) []string {
+	t.Helper()
+	entries, err := os.ReadDir(s.cacheDir)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+// waitFor polls cond until it holds or the test times out
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLocalSearchPrunesAttachmentTextOfDeletedMessages(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fake, err := NewFakeMailbox("me@example.com",
+		FixtureMessage{ID: "m1", ThreadID: "t1", From: "alice@example.com", Subject: "Minutes", Body: "Attached.",
+			Attachments: []FixtureAttachment{{Filename: "minutes.txt", MimeType: "text/plain", Data: []byte("quarterly budget approved")}}},
+		FixtureMessage{ID: "m2", ThreadID: "t2", From: "bob@example.com", Subject: "Agenda", Body: "See file.",
+			Attachments: []FixtureAttachment{{Filename: "agenda.txt", MimeType: "text/plain", Data: []byte("hiring plan")}}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mirror, err := NewMailboxMirror(fake, t.TempDir(), MirrorConfig{Query: "label:INBOX", MaxThreads: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mirror.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Left behind by a message deleted while the server was not running
+	search := NewLocalSearch(mirror)
+	if err := os.MkdirAll(search.cacheDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	orphan := cacheFileName("gone", "1")
+	if err := os.WriteFile(filepath.Join(search.cacheDir, orphan), []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	mirror.Subscribe(search)
+	go search.Run(ctx)
+	waitFor(t, "attachment text to be cached", func() bool {
+		_, attachments := search.index.Counts()
+		return attachments == 2
+	})
+	cached := cachedTexts(t, search)
+	if len(cached) != 2 || slices.Contains(cached, orphan) {
+		t.Fatalf("cache holds %v, want the two mirrored attachments only", cached)
+	}
+
+	if err := fake.DeleteMessage("m1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mirror.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the deleted message's text to be pruned", func() bool {
+		return len(cachedTexts(t, search)) == 1
+	})
+	if got := searchIDs(search.index, "budget"); len(got) != 0 {
+		t.Fatalf("deleted attachment still searchable: %v", got)
+	}
+	if got := searchIDs(search.index, "hiring"); len(got) != 1 {
+		t.Fatalf("kept attachment not searchable: %v", got)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// Mailbox is the narrow slice of the Gmail API that GmailServer depends on.
+// The live implementation wraps *gmail.Service; the tests' FakeMailbox keeps everything
+// in memory so tools can be exercised without a Google account.
+type Mailbox interface {
+	// Threads
+	ListThreads(ctx context.Context, query string, maxResults int64, pageToken string) (*gmail.ListThreadsResponse, error)
+	GetThread(ctx context.Context, threadID string) (*gmail.Thread, error)
+	// GetThreadMetadata returns the thread's messages with only the named headers and no bodies
+	GetThreadMetadata(ctx context.Context, threadID string, headers []string) (*gmail.Thread, error)
+
+	// Messages
+	ListMessages(ctx context.Context, query string, maxResults int64) (*gmail.ListMessagesResponse, error)
+	GetMessage(ctx context.Context, messageID string) (*gmail.Message, error)
+	GetAttachment(ctx context.Context, messageID, attachmentID string) (*gmail.MessagePartBody, error)
+	// SendMessage sends msg.Raw, a base64url RFC 822 message, in msg.ThreadId if set
+	SendMessage(ctx context.Context, msg *gmail.Message) (*gmail.Message, error)
+
+	// Drafts
+	ListDrafts(ctx context.Context, maxResults int64, pageToken string) (*gmail.ListDraftsResponse, error)
+	GetDraft(ctx context.Context, draftID string) (*gmail.Draft, error)
+	GetDraftRaw(ctx context.Context, draftID string) (*gmail.Draft, error) // message as base64url RFC 822 in Raw
+	CreateDraft(ctx context.Context, draft *gmail.Draft) (*gmail.Draft, error)
+	UpdateDraft(ctx context.Context, draftID string, draft *gmail.Draft) (*gmail.Draft, error)
+	DeleteDraft(ctx context.Context, draftID string) error
+
+	// Profile
+	GetProfile(ctx context.Context) (*gmail.Profile, error)
+	// ListSendAs returns the addresses the account can send as, including its primary address
+	ListSendAs(ctx context.Context) (*gmail.ListSendAsResponse, error)
+
+	// History returns mailbox changes after startHistoryID. Gmail answers 404 once
+	// startHistoryID is too old to replay.
+	ListHistory(ctx context.Context, startHistoryID uint64, pageToken string) (*gmail.ListHistoryResponse, error)
+}
+
+// gmailMailbox implements Mailbox against the live Gmail API
+type gmailMailbox struct {
+	service *gmail.Service
+	userID  string
+}
+
+func newGmailMailbox(service *gmail.Service, userID string) *gmailMailbox {
+	return &gmailMailbox{
+		service: service,
+		userID:  userID,
+	}
+}
+
+func (m *gmailMailbox) ListThreads(ctx context.Context, query string, maxResults int64, pageToken string) (*gmail.ListThreadsResponse, error) {
+	call := m.service.Users.Threads.List(m.userID).Q(query).Context(ctx)
+	if maxResults > 0 {
+		call = call.MaxResults(maxResults)
+	}
+	if pageToken != "" {
+		call = call.PageToken(pageToken)
+	}
+	return call.Do()
+}
+
+func (m *gmailMailbox) GetThread(ctx context.Context, threadID string) (*gmail.Thread, error) {
+	return m.service.Users.Threads.Get(m.userID, threadID).Context(ctx).Do()
+}
+
+func (m *gmailMailbox) GetThreadMetadata(ctx context.Context, threadID string, headers []string) (*gmail.Thread, error) {
+	return m.service.Users.Threads.Get(m.userID, threadID).Format("metadata").MetadataHeaders(headers...).Context(ctx).Do()
+}
+
+func (m *gmailMailbox) ListMessages(ctx context.Context, query string, maxResults int64) (*gmail.ListMessagesResponse, error) {
+	call := m.service.Users.Messages.List(m.userID).Q(query).Context(ctx)
+	if maxResults > 0 {
+		call = call.MaxResults(maxResults)
+	}
+	return call.Do()
+}
+
+func (m *gmailMailbox) GetMessage(ctx context.Context, messageID string) (*gmail.Message, error) {
+	return m.service.Users.Messages.Get(m.userID, messageID).Context(ctx).Do()
+}
+
+func (m *gmailMailbox) GetAttachment(ctx context.Context, messageID, attachmentID string) (*gmail.MessagePartBody, error) {
+	return m.service.Users.Messages.Attachments.Get(m.userID, messageID, attachmentID).Context(ctx).Do()
+}
+
+func (m *gmailMailbox) SendMessage(ctx context.Context, msg *gmail.Message) (*gmail.Message, error) {
+	return m.service.Users.Messages.Send(m.userID, msg).Context(ctx).Do()
+}
+
+func (m *gmailMailbox) ListDrafts(ctx context.Context, maxResults int64, pageToken string) (*gmail.ListDraftsResponse, error) {
+	call := m.service.Users.Drafts.List(m.userID).Context(ctx)
+	if maxResults > 0 {
+		call = call.MaxResults(maxResults)
+	}
+	if pageToken != "" {
+		call = call.PageToken(pageToken)
+	}
+	return call.Do()
+}
+
+func (m *gmailMailbox) GetDraft(ctx context.Context, draftID string) (*gmail.Draft, error) {
+	return m.service.Users.Drafts.Get(m.userID, draftID).Context(ctx).Do()
+}
+
+func (m *gmailMailbox) GetDraftRaw(ctx context.Context, draftID string) (*gmail.Draft, error) {
+	return m.service.Users.Drafts.Get(m.userID, draftID).Format("raw").Context(ctx).Do()
+}
+
+func (m *gmailMailbox) CreateDraft(ctx context.Context, draft *gmail.Draft) (*gmail.Draft, error) {
+	return m.service.Users.Drafts.Create(m.userID, draft).Context(ctx).Do()
+}
+
+func (m *gmailMailbox) UpdateDraft(ctx context.Context, draftID string, draft *gmail.Draft) (*gmail.Draft, error) {
+	return m.service.Users.Drafts.Update(m.userID, draftID, draft).Context(ctx).Do()
+}
+
+func (m *gmailMailbox) DeleteDraft(ctx context.Context, draftID string) error {
+	return m.service.Users.Drafts.Delete(m.userID, draftID).Context(ctx).Do()
+}
+
+func (m *gmailMailbox) GetProfile(ctx context.Context) (*gmail.Profile, error) {
+	return m.service.Users.GetProfile(m.userID).Context(ctx).Do()
+}
+
+func (m *gmailMailbox) ListSendAs(ctx context.Context) (*gmail.ListSendAsResponse, error) {
+	return m.service.Users.Settings.SendAs.List(m.userID).Context(ctx).Do()
+}
+
+func (m *gmailMailbox) ListHistory(ctx context.Context, startHistoryID uint64, pageToken string) (*gmail.ListHistoryResponse, error) {
+	call := m.service.Users.History.List(m.userID).StartHistoryId(startHistoryID).Context(ctx)
+	if pageToken != "" {
+		call = call.PageToken(pageToken)
+	}
+	return call.Do()
+}
+
+// metadataThread trims a full thread to what format=metadata returns: the
+// top-level MIME type and the requested headers of each message
+func metadataThread(thread *gmail.Thread, headers []string) *gmail.Thread {
+	for _, msg := range thread.Messages {
+		if msg.Payload == nil {
+			continue
+		}
+		var kept []*gmail.MessagePartHeader
+		for _, header := range msg.Payload.Headers {
+			for _, name := range headers {
+				if strings.EqualFold(header.Name, name) {
+					kept = append(kept, header)
+					break
+				}
+			}
+		}
+		msg.Payload = &gmail.MessagePart{MimeType: msg.Payload.MimeType, Headers: kept}
+	}
+	return thread
+}
@@ -0,0 +1,1007 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/mail"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// FixtureMessage describes a message used to seed a FakeMailbox.
+// Either set Raw to a complete RFC 822 message, or fill in the
+// individual fields and let the fake assemble the MIME structure.
+type FixtureMessage struct {
+	ID          string              `json:"id,omitempty"`
+	ThreadID    string              `json:"threadId,omitempty"`
+	From        string              `json:"from,omitempty"`
+	To          string              `json:"to,omitempty"`
+	Cc          string              `json:"cc,omitempty"`
+	Subject     string              `json:"subject,omitempty"`
+	Date        time.Time           `json:"date,omitempty"`
+	MessageID   string              `json:"messageId,omitempty"` // RFC 822 Message-ID header
+	InReplyTo   string              `json:"inReplyTo,omitempty"`
+	References  string              `json:"references,omitempty"`
+	Body        string              `json:"body,omitempty"`     // text/plain body
+	HTMLBody    string              `json:"htmlBody,omitempty"` // optional text/html alternative
+	Labels      []string            `json:"labels,omitempty"`
+	Headers     map[string]string   `json:"headers,omitempty"` // additional headers
+	Attachments []FixtureAttachment `json:"attachments,omitempty"`
+	Raw         string              `json:"raw,omitempty"` // full RFC 822 source, overrides the fields above
+}
+
+// FixtureAttachment is a file attached to a FixtureMessage
+type FixtureAttachment struct {
+	Filename string `json:"filename"`
+	MimeType string `json:"mimeType"`
+	Data     []byte `json:"data"` // base64 in JSON fixtures
+}
+
+// LoadFixtureFile reads a JSON array of FixtureMessage from disk
+func LoadFixtureFile(path string) ([]FixtureMessage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixture file: %w", err)
+	}
+
+	var fixtures []FixtureMessage
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		return nil, fmt.Errorf("failed to parse fixture file: %w", err)
+	}
+	return fixtures, nil
+}
+
+// FakeMailbox is an in-memory Mailbox for exercising GmailServer without a Google account
+type FakeMailbox struct {
+	mu          sync.Mutex
+	email       string
+	aliases     []string // send-as addresses besides email
+	messages    map[string]*gmail.Message
+	threads     map[string][]string // thread ID -> message IDs in arrival order
+	attachments map[string][]byte   // attachment ID -> decoded data
+	drafts      map[string]*gmail.Draft
+	draftRaw    map[string][]byte // draft ID -> RFC 822 source it was saved with
+	draftOrder  []string
+	sent        []*gmail.Message
+	historyID   uint64
+	history     []*gmail.History
+	oldestStart uint64 // ListHistory rejects older start IDs, like an expired Gmail historyId
+	nextID      int
+}
+
+// NewFakeMailbox creates a fake mailbox owned by email and seeded with fixtures
+func NewFakeMailbox(email string, fixtures ...FixtureMessage) (*FakeMailbox, error) {
+	f := &FakeMailbox{
+		email:       email,
+		messages:    make(map[string]*gmail.Message),
+		threads:     make(map[string][]string),
+		attachments: make(map[string][]byte),
+		drafts:      make(map[string]*gmail.Draft),
+		draftRaw:    make(map[string][]byte),
+		historyID:   1000,
+		oldestStart: 1000,
+	}
+
+	if err := f.Seed(fixtures...); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Seed adds fixture messages to the mailbox
+func (f *FakeMailbox) Seed(fixtures ...FixtureMessage) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, fixture := range fixtures {
+		if _, err := f.addFixtureLocked(fixture); err != nil {
+			return fmt.Errorf("fixture %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// SentMessages returns every message sent through SendMessage, oldest first
+func (f *FakeMailbox) SentMessages() []*gmail.Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*gmail.Message(nil), f.sent...)
+}
+
+func (f *FakeMailbox) newIDLocked() string {
+	f.nextID++
+	return fmt.Sprintf("%016x", 0x18f0000000000000+f.nextID)
+}
+
+func (f *FakeMailbox) nextHistoryIDLocked() uint64 {
+	f.historyID++
+	return f.historyID
+}
+
+// recordHistoryLocked appends a change record stamped with a new history ID
+func (f *FakeMailbox) recordHistoryLocked(record *gmail.History) uint64 {
+	record.Id = f.nextHistoryIDLocked()
+	f.history = append(f.history, record)
+	return record.Id
+}
+
+// historyRef is the minimal message reference Gmail puts in history records
+func historyRef(msg *gmail.Message) *gmail.Message {
+	return &gmail.Message{Id: msg.Id, ThreadId: msg.ThreadId, LabelIds: append([]string(nil), msg.LabelIds...)}
+}
+
+// ExpireHistory forgets all history so far; ListHistory from any earlier ID
+// then fails with 404 the way Gmail does once a historyId is too old
+func (f *FakeMailbox) ExpireHistory() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.history = nil
+	f.oldestStart = f.historyID
+}
+
+// DeleteMessage permanently removes a message
+func (f *FakeMailbox) DeleteMessage(messageID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.messages[messageID]; !ok {
+		return fakeNotFound()
+	}
+	f.deleteMessageLocked(messageID)
+	return nil
+}
+
+// deleteMessageLocked removes a message from its thread and records it
+func (f *FakeMailbox) deleteMessageLocked(messageID string) {
+	msg := f.messages[messageID]
+	delete(f.messages, messageID)
+	ids := f.threads[msg.ThreadId]
+	for i, id := range ids {
+		if id == messageID {
+			ids = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(ids) == 0 {
+		delete(f.threads, msg.ThreadId)
+	} else {
+		f.threads[msg.ThreadId] = ids
+	}
+	f.recordHistoryLocked(&gmail.History{
+		MessagesDeleted: []*gmail.HistoryMessageDeleted{{Message: historyRef(msg)}},
+	})
+}
+
+// ModifyLabels adds and removes labels on a message
+func (f *FakeMailbox) ModifyLabels(messageID string, add, remove []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	msg, ok := f.messages[messageID]
+	if !ok {
+		return fakeNotFound()
+	}
+	msg.LabelIds = applyLabelChanges(msg.LabelIds, add, remove)
+
+	record := &gmail.History{}
+	if len(add) > 0 {
+		record.LabelsAdded = []*gmail.HistoryLabelAdded{{LabelIds: add, Message: historyRef(msg)}}
+	}
+	if len(remove) > 0 {
+		record.LabelsRemoved = []*gmail.HistoryLabelRemoved{{LabelIds: remove, Message: historyRef(msg)}}
+	}
+	msg.HistoryId = f.recordHistoryLocked(record)
+	return nil
+}
+
+func (f *FakeMailbox) storeAttachmentLocked(data []byte) string {
+	id := "ANGjdJ" + f.newIDLocked()
+	f.attachments[id] = data
+	return id
+}
+
+func (f *FakeMailbox) addFixtureLocked(fixture FixtureMessage) (*gmail.Message, error) {
+	var payload *gmail.MessagePart
+	var err error
+	if fixture.Raw != "" {
+		payload, err = parseRawMessage([]byte(fixture.Raw), f.storeAttachmentLocked)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		payload = f.buildFixturePayloadLocked(fixture)
+	}
+
+	id := fixture.ID
+	if id == "" {
+		id = f.newIDLocked()
+	}
+	if _, exists := f.messages[id]; exists {
+		return nil, fmt.Errorf("duplicate message id %s", id)
+	}
+
+	threadID := fixture.ThreadID
+	if threadID == "" {
+		threadID = id
+	}
+
+	labels := fixture.Labels
+	if len(labels) == 0 {
+		labels = []string{"INBOX"}
+	}
+
+	date := fixture.Date
+	if date.IsZero() {
+		if parsed, err := mail.ParseDate(headerValue(payload.Headers, "Date")); err == nil {
+			date = parsed
+		} else {
+			date = time.Now()
+		}
+	}
+
+	msg := &gmail.Message{
+		Id:           id,
+		ThreadId:     threadID,
+		LabelIds:     labels,
+		Payload:      payload,
+		Snippet:      fakeSnippet(extractEmailBody(&gmail.Message{Payload: payload})),
+		InternalDate: date.UnixMilli(),
+	}
+	msg.HistoryId = f.recordHistoryLocked(&gmail.History{
+		MessagesAdded: []*gmail.HistoryMessageAdded{{Message: historyRef(msg)}},
+	})
+
+	f.messages[id] = msg
+	f.threads[threadID] = append(f.threads[threadID], id)
+	return msg, nil
+}
+
+func (f *FakeMailbox) buildFixturePayloadLocked(fixture FixtureMessage) *gmail.MessagePart {
+	date := fixture.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	messageID := fixture.MessageID
+	if messageID == "" {
+		messageID = fmt.Sprintf("<%s@fake.mail.gmail.com>", f.newIDLocked())
+	}
+
+	headers := []*gmail.MessagePartHeader{
+		{Name: "From", Value: fixture.From},
+		{Name: "To", Value: fixture.To},
+	}
+	if fixture.Cc != "" {
+		headers = append(headers, &gmail.MessagePartHeader{Name: "Cc", Value: fixture.Cc})
+	}
+	headers = append(headers,
+		&gmail.MessagePartHeader{Name: "Subject", Value: fixture.Subject},
+		&gmail.MessagePartHeader{Name: "Date", Value: date.Format(time.RFC1123Z)},
+		&gmail.MessagePartHeader{Name: "Message-ID", Value: messageID},
+	)
+	if fixture.InReplyTo != "" {
+		headers = append(headers, &gmail.MessagePartHeader{Name: "In-Reply-To", Value: fixture.InReplyTo})
+	}
+	if fixture.References != "" {
+		headers = append(headers, &gmail.MessagePartHeader{Name: "References", Value: fixture.References})
+	}
+	extra := make([]string, 0, len(fixture.Headers))
+	for name := range fixture.Headers {
+		extra = append(extra, name)
+	}
+	sort.Strings(extra)
+	for _, name := range extra {
+		headers = append(headers, &gmail.MessagePartHeader{Name: name, Value: fixture.Headers[name]})
+	}
+
+	textPart := func(mimeType, content string) *gmail.MessagePart {
+		return &gmail.MessagePart{
+			MimeType: mimeType,
+			Headers: []*gmail.MessagePartHeader{
+				{Name: "Content-Type", Value: mimeType + "; charset=\"UTF-8\""},
+			},
+			Body: &gmail.MessagePartBody{
+				Data: base64.URLEncoding.EncodeToString([]byte(content)),
+				Size: int64(len(content)),
+			},
+		}
+	}
+
+	var body *gmail.MessagePart
+	if fixture.HTMLBody != "" {
+		body = &gmail.MessagePart{
+			MimeType: "multipart/alternative",
+			Body:     &gmail.MessagePartBody{},
+			Parts: []*gmail.MessagePart{
+				textPart("text/plain", fixture.Body),
+				textPart("text/html", fixture.HTMLBody),
+			},
+		}
+	} else {
+		body = textPart("text/plain", fixture.Body)
+	}
+
+	if len(fixture.Attachments) == 0 {
+		body.Headers = append(headers, body.Headers...)
+		numberParts(body, "")
+		return body
+	}
+
+	root := &gmail.MessagePart{
+		MimeType: "multipart/mixed",
+		Headers:  headers,
+		Body:     &gmail.MessagePartBody{},
+		Parts:    []*gmail.MessagePart{body},
+	}
+	for _, att := range fixture.Attachments {
+		mimeType := att.MimeType
+		if mimeType == "" {
+			mimeType = "application/octet-stream"
+		}
+		root.Parts = append(root.Parts, &gmail.MessagePart{
+			MimeType: mimeType,
+			Filename: att.Filename,
+			Headers: []*gmail.MessagePartHeader{
+				{Name: "Content-Type", Value: mime.FormatMediaType(mimeType, map[string]string{"name": att.Filename})},
+				{Name: "Content-Disposition", Value: mime.FormatMediaType("attachment", map[string]string{"filename": att.Filename})},
+			},
+			Body: &gmail.MessagePartBody{
+				AttachmentId: f.storeAttachmentLocked(att.Data),
+				Size:         int64(len(att.Data)),
+			},
+		})
+	}
+	numberParts(root, "")
+	return root
+}
+
+func fakeSnippet(body string) string {
+	snippet := strings.Join(strings.Fields(body), " ")
+	if len(snippet) > 150 {
+		snippet = snippet[:150]
+	}
+	return snippet
+}
+
+func fakeNotFound() error {
+	return &googleapi.Error{Code: 404, Message: "Requested entity was not found."}
+}
+
+// fakePageBounds turns an opaque page token (an offset) into slice bounds
+func fakePageBounds(total int, maxResults int64, pageToken string) (start, end int, next string, err error) {
+	if pageToken != "" {
+		start, err = strconv.Atoi(pageToken)
+		if err != nil || start < 0 || start > total {
+			return 0, 0, "", &googleapi.Error{Code: 400, Message: "Invalid pageToken"}
+		}
+	}
+	if maxResults <= 0 {
+		maxResults = 100
+	}
+	end = start + int(maxResults)
+	if end >= total {
+		end = total
+	} else {
+		next = strconv.Itoa(end)
+	}
+	return start, end, next, nil
+}
+
+// sortedThreadIDsLocked returns thread IDs, most recently active first (as
+// Gmail lists them). Saving a draft doesn't count as activity.
+func (f *FakeMailbox) sortedThreadIDsLocked() []string {
+	latest := make(map[string]int64, len(f.threads))
+	ids := make([]string, 0, len(f.threads))
+	for threadID, messageIDs := range f.threads {
+		for _, messageID := range messageIDs {
+			msg := f.messages[messageID]
+			if hasLabel(msg.LabelIds, "DRAFT") {
+				continue
+			}
+			if date := msg.InternalDate; date > latest[threadID] {
+				latest[threadID] = date
+			}
+		}
+		ids = append(ids, threadID)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if latest[ids[i]] != latest[ids[j]] {
+			return latest[ids[i]] > latest[ids[j]]
+		}
+		return ids[i] > ids[j]
+	})
+	return ids
+}
+
+func (f *FakeMailbox) ListThreads(ctx context.Context, query string, maxResults int64, pageToken string) (*gmail.ListThreadsResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	terms := parseFakeQuery(query)
+	var matched []*gmail.Thread
+	for _, threadID := range f.sortedThreadIDsLocked() {
+		for _, messageID := range f.threads[threadID] {
+			msg := f.messages[messageID]
+			if fakeMatches(msg, terms) {
+				matched = append(matched, &gmail.Thread{Id: threadID, Snippet: msg.Snippet, HistoryId: msg.HistoryId})
+				break
+			}
+		}
+	}
+
+	start, end, next, err := fakePageBounds(len(matched), maxResults, pageToken)
+	if err != nil {
+		return nil, err
+	}
+	return &gmail.ListThreadsResponse{
+		Threads:            matched[start:end],
+		NextPageToken:      next,
+		ResultSizeEstimate: int64(len(matched)),
+	}, nil
+}
+
+func (f *FakeMailbox) GetThread(ctx context.Context, threadID string) (*gmail.Thread, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	messageIDs, ok := f.threads[threadID]
+	if !ok {
+		return nil, fakeNotFound()
+	}
+
+	thread := &gmail.Thread{Id: threadID}
+	for _, messageID := range messageIDs {
+		msg := copyMessage(f.messages[messageID])
+		thread.Messages = append(thread.Messages, msg)
+		if msg.HistoryId > thread.HistoryId {
+			thread.HistoryId = msg.HistoryId
+		}
+	}
+	sort.SliceStable(thread.Messages, func(i, j int) bool {
+		return thread.Messages[i].InternalDate < thread.Messages[j].InternalDate
+	})
+	thread.Snippet = thread.Messages[len(thread.Messages)-1].Snippet
+	return thread, nil
+}
+
+func (f *FakeMailbox) GetThreadMetadata(ctx context.Context, threadID string, headers []string) (*gmail.Thread, error) {
+	thread, err := f.GetThread(ctx, threadID)
+	if err != nil {
+		return nil, err
+	}
+	return metadataThread(thread, headers), nil
+}
+
+func (f *FakeMailbox) ListMessages(ctx context.Context, query string, maxResults int64) (*gmail.ListMessagesResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	terms := parseFakeQuery(query)
+	var matched []*gmail.Message
+	for _, msg := range f.messages {
+		if fakeMatches(msg, terms) {
+			matched = append(matched, &gmail.Message{Id: msg.Id, ThreadId: msg.ThreadId, InternalDate: msg.InternalDate})
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].InternalDate > matched[j].InternalDate
+	})
+
+	_, end, next, _ := fakePageBounds(len(matched), maxResults, "")
+	for _, msg := range matched {
+		msg.InternalDate = 0
+	}
+	return &gmail.ListMessagesResponse{
+		Messages:           matched[:end],
+		NextPageToken:      next,
+		ResultSizeEstimate: int64(len(matched)),
+	}, nil
+}
+
+func (f *FakeMailbox) GetMessage(ctx context.Context, messageID string) (*gmail.Message, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	msg, ok := f.messages[messageID]
+	if !ok {
+		return nil, fakeNotFound()
+	}
+	return copyMessage(msg), nil
+}
+
+func (f *FakeMailbox) GetAttachment(ctx context.Context, messageID, attachmentID string) (*gmail.MessagePartBody, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.messages[messageID]; !ok {
+		if _, isDraft := f.draftByMessageIDLocked(messageID); !isDraft {
+			return nil, fakeNotFound()
+		}
+	}
+	data, ok := f.attachments[attachmentID]
+	if !ok {
+		return nil, fakeNotFound()
+	}
+	return &gmail.MessagePartBody{
+		AttachmentId: attachmentID,
+		Data:         base64.URLEncoding.EncodeToString(data),
+		Size:         int64(len(data)),
+	}, nil
+}
+
+func (f *FakeMailbox) SendMessage(ctx context.Context, msg *gmail.Message) (*gmail.Message, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if msg == nil || msg.Raw == "" {
+		return nil, &googleapi.Error{Code: 400, Message: "Missing raw message"}
+	}
+	raw, err := decodeRawMessage(msg.Raw)
+	if err != nil {
+		return nil, &googleapi.Error{Code: 400, Message: "Invalid raw message"}
+	}
+	payload, err := parseRawMessage(raw, f.storeAttachmentLocked)
+	if err != nil {
+		return nil, &googleapi.Error{Code: 400, Message: err.Error()}
+	}
+
+	sent := &gmail.Message{
+		Id:           f.newIDLocked(),
+		ThreadId:     msg.ThreadId,
+		LabelIds:     []string{"SENT"},
+		Payload:      payload,
+		Snippet:      fakeSnippet(extractEmailBody(&gmail.Message{Payload: payload})),
+		InternalDate: time.Now().UnixMilli(),
+	}
+	if sent.ThreadId == "" {
+		sent.ThreadId = sent.Id
+	} else if _, ok := f.threads[sent.ThreadId]; !ok {
+		return nil, fakeNotFound()
+	}
+	if headerValue(payload.Headers, "Message-ID") == "" {
+		payload.Headers = append(payload.Headers, &gmail.MessagePartHeader{
+			Name:  "Message-ID",
+			Value: fmt.Sprintf("<%s@fake.mail.gmail.com>", sent.Id),
+		})
+	}
+
+	sent.HistoryId = f.recordHistoryLocked(&gmail.History{
+		MessagesAdded: []*gmail.HistoryMessageAdded{{Message: historyRef(sent)}},
+	})
+	f.messages[sent.Id] = sent
+	f.threads[sent.ThreadId] = append(f.threads[sent.ThreadId], sent.Id)
+	f.sent = append(f.sent, sent)
+	return &gmail.Message{Id: sent.Id, ThreadId: sent.ThreadId, LabelIds: sent.LabelIds}, nil
+}
+
+func (f *FakeMailbox) draftByMessageIDLocked(messageID string) (*gmail.Draft, bool) {
+	for _, draft := range f.drafts {
+		if draft.Message != nil && draft.Message.Id == messageID {
+			return draft, true
+		}
+	}
+	return nil, false
+}
+
+func (f *FakeMailbox) ListDrafts(ctx context.Context, maxResults int64, pageToken string) (*gmail.ListDraftsResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Newest drafts first, like Gmail
+	all := make([]*gmail.Draft, 0, len(f.draftOrder))
+	for i := len(f.draftOrder) - 1; i >= 0; i-- {
+		draft := f.drafts[f.draftOrder[i]]
+		all = append(all, &gmail.Draft{
+			Id:      draft.Id,
+			Message: &gmail.Message{Id: draft.Message.Id, ThreadId: draft.Message.ThreadId},
+		})
+	}
+
+	start, end, next, err := fakePageBounds(len(all), maxResults, pageToken)
+	if err != nil {
+		return nil, err
+	}
+	return &gmail.ListDraftsResponse{
+		Drafts:             all[start:end],
+		NextPageToken:      next,
+		ResultSizeEstimate: int64(len(all)),
+	}, nil
+}
+
+func (f *FakeMailbox) GetDraft(ctx context.Context, draftID string) (*gmail.Draft, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	draft, ok := f.drafts[draftID]
+	if !ok {
+		return nil, fakeNotFound()
+	}
+	return &gmail.Draft{Id: draft.Id, Message: copyMessage(draft.Message)}, nil
+}
+
+func (f *FakeMailbox) GetDraftRaw(ctx context.Context, draftID string) (*gmail.Draft, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	draft, ok := f.drafts[draftID]
+	if !ok {
+		return nil, fakeNotFound()
+	}
+	return &gmail.Draft{Id: draft.Id, Message: &gmail.Message{
+		Id:       draft.Message.Id,
+		ThreadId: draft.Message.ThreadId,
+		LabelIds: draft.Message.LabelIds,
+		Raw:      base64.URLEncoding.EncodeToString(f.draftRaw[draftID]),
+	}}, nil
+}
+
+// draftMessageLocked parses the raw RFC 822 message of a draft into a message
+// for threadID, or a thread of its own without one
+func (f *FakeMailbox) draftMessageLocked(draft *gmail.Draft, threadID string) (*gmail.Message, error) {
+	if draft == nil || draft.Message == nil || draft.Message.Raw == "" {
+		return nil, &googleapi.Error{Code: 400, Message: "Missing draft message"}
+	}
+
+	raw, err := decodeRawMessage(draft.Message.Raw)
+	if err != nil {
+		return nil, &googleapi.Error{Code: 400, Message: "Invalid raw message"}
+	}
+	payload, err := parseRawMessage(raw, f.storeAttachmentLocked)
+	if err != nil {
+		return nil, &googleapi.Error{Code: 400, Message: err.Error()}
+	}
+
+	if threadID != "" {
+		if _, ok := f.threads[threadID]; !ok {
+			return nil, fakeNotFound()
+		}
+	}
+
+	msg := &gmail.Message{
+		Id:           f.newIDLocked(),
+		ThreadId:     threadID,
+		LabelIds:     []string{"DRAFT"},
+		Payload:      payload,
+		Snippet:      fakeSnippet(extractEmailBody(&gmail.Message{Payload: payload})),
+		InternalDate: time.Now().UnixMilli(),
+	}
+	if msg.ThreadId == "" {
+		msg.ThreadId = msg.Id
+	}
+	return msg, nil
+}
+
+// addDraftMessageLocked puts a draft's message in its thread, where Gmail
+// shows it alongside the thread's other messages, and records it
+func (f *FakeMailbox) addDraftMessageLocked(msg *gmail.Message) {
+	msg.HistoryId = f.recordHistoryLocked(&gmail.History{
+		MessagesAdded: []*gmail.HistoryMessageAdded{{Message: historyRef(msg)}},
+	})
+	f.messages[msg.Id] = msg
+	f.threads[msg.ThreadId] = append(f.threads[msg.ThreadId], msg.Id)
+}
+
+func (f *FakeMailbox) CreateDraft(ctx context.Context, draft *gmail.Draft) (*gmail.Draft, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	threadID := ""
+	if draft != nil && draft.Message != nil {
+		threadID = draft.Message.ThreadId
+	}
+	msg, err := f.draftMessageLocked(draft, threadID)
+	if err != nil {
+		return nil, err
+	}
+	f.addDraftMessageLocked(msg)
+
+	f.nextID++
+	created := &gmail.Draft{Id: fmt.Sprintf("r%d", 1000000+f.nextID), Message: msg}
+	f.drafts[created.Id] = created
+	f.draftRaw[created.Id], _ = decodeRawMessage(draft.Message.Raw)
+	f.draftOrder = append(f.draftOrder, created.Id)
+	return &gmail.Draft{Id: created.Id, Message: &gmail.Message{Id: msg.Id, ThreadId: msg.ThreadId, LabelIds: msg.LabelIds}}, nil
+}
+
+func (f *FakeMailbox) UpdateDraft(ctx context.Context, draftID string, draft *gmail.Draft) (*gmail.Draft, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	existing, ok := f.drafts[draftID]
+	if !ok {
+		return nil, fakeNotFound()
+	}
+	// Gmail replaces the draft's message with a new one in the same thread
+	threadID := existing.Message.ThreadId
+	if draft != nil && draft.Message != nil && draft.Message.ThreadId != "" {
+		threadID = draft.Message.ThreadId
+	}
+	msg, err := f.draftMessageLocked(draft, threadID)
+	if err != nil {
+		return nil, err
+	}
+	f.deleteMessageLocked(existing.Message.Id)
+	f.addDraftMessageLocked(msg)
+	existing.Message = msg
+	f.draftRaw[draftID], _ = decodeRawMessage(draft.Message.Raw)
+	return &gmail.Draft{Id: draftID, Message: &gmail.Message{Id: msg.Id, ThreadId: msg.ThreadId, LabelIds: msg.LabelIds}}, nil
+}
+
+func (f *FakeMailbox) DeleteDraft(ctx context.Context, draftID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.drafts[draftID]; !ok {
+		return fakeNotFound()
+	}
+	f.removeDraftLocked(draftID)
+	return nil
+}
+
+func (f *FakeMailbox) removeDraftLocked(draftID string) {
+	if _, ok := f.messages[f.drafts[draftID].Message.Id]; ok {
+		f.deleteMessageLocked(f.drafts[draftID].Message.Id)
+	}
+	delete(f.drafts, draftID)
+	delete(f.draftRaw, draftID)
+	for i, id := range f.draftOrder {
+		if id == draftID {
+			f.draftOrder = append(f.draftOrder[:i], f.draftOrder[i+1:]...)
+			break
+		}
+	}
+}
+
+func (f *FakeMailbox) GetProfile(ctx context.Context) (*gmail.Profile, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return &gmail.Profile{
+		EmailAddress:  f.email,
+		HistoryId:     f.historyID,
+		MessagesTotal: int64(len(f.messages)),
+		ThreadsTotal:  int64(len(f.threads)),
+	}, nil
+}
+
+// AddSendAs registers extra addresses the mailbox can send as
+func (f *FakeMailbox) AddSendAs(addresses ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.aliases = append(f.aliases, addresses...)
+}
+
+func (f *FakeMailbox) ListSendAs(ctx context.Context) (*gmail.ListSendAsResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	sendAs := []*gmail.SendAs{{SendAsEmail: f.email, IsPrimary: true, IsDefault: true}}
+	for _, alias := range f.aliases {
+		sendAs = append(sendAs, &gmail.SendAs{SendAsEmail: alias})
+	}
+	return &gmail.ListSendAsResponse{SendAs: sendAs}, nil
+}
+
+func (f *FakeMailbox) ListHistory(ctx context.Context, startHistoryID uint64, pageToken string) (*gmail.ListHistoryResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if startHistoryID < f.oldestStart {
+		return nil, &googleapi.Error{Code: 404, Message: "Requested entity was not found."}
+	}
+
+	var records []*gmail.History
+	for _, record := range f.history {
+		if record.Id > startHistoryID {
+			records = append(records, record)
+		}
+	}
+
+	start, end, next, err := fakePageBounds(len(records), 0, pageToken)
+	if err != nil {
+		return nil, err
+	}
+	return &gmail.ListHistoryResponse{
+		History:       records[start:end],
+		HistoryId:     f.historyID,
+		NextPageToken: next,
+	}, nil
+}
+
+// fakeQueryTerm is one whitespace-separated term of a Gmail search query
+type fakeQueryTerm struct {
+	operator string // "" for free text
+	value    string
+	negate   bool
+	anyOf    []fakeQueryTerm // a {...} group, which matches if any of its terms does
+}
+
+// parseFakeQuery splits a Gmail query into terms, honouring double quotes and
+// {...} groups. Parentheses are dropped: the fake treats every query outside
+// braces as a conjunction.
+func parseFakeQuery(query string) []fakeQueryTerm {
+	var tokens []string
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, current.String())
+			current.Reset()
+		}
+	}
+	inQuotes := false
+	for _, r := range query {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+		case (r == ' ' || r == '\t') && !inQuotes:
+			flush()
+		case strings.ContainsRune("(){}", r) && !inQuotes:
+			flush()
+			tokens = append(tokens, string(r))
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+
+	var terms []fakeQueryTerm
+	var group *fakeQueryTerm
+	for _, token := range tokens {
+		switch token {
+		case "OR", "AND", "(", ")":
+			continue
+		case "{":
+			group = &fakeQueryTerm{}
+			continue
+		case "}":
+			if group != nil {
+				terms = append(terms, *group)
+				group = nil
+			}
+			continue
+		}
+		term := fakeQueryTerm{}
+		if strings.HasPrefix(token, "-") && len(token) > 1 {
+			term.negate = true
+			token = token[1:]
+		}
+		if op, value, ok := strings.Cut(token, ":"); ok && value != "" && !strings.Contains(op, "@") {
+			term.operator = strings.ToLower(op)
+			term.value = strings.ToLower(value)
+		} else {
+			term.value = strings.ToLower(strings.TrimPrefix(token, "+"))
+		}
+		if group != nil {
+			group.anyOf = append(group.anyOf, term)
+		} else {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// fakeMatches reports whether msg satisfies every term of a parsed query
+func fakeMatches(msg *gmail.Message, terms []fakeQueryTerm) bool {
+	for _, term := range terms {
+		if !fakeTermMatches(msg, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// fakeTermMatches reports whether msg satisfies one term. Only the operators
+// agents commonly use are supported; unknown operators match everything.
+func fakeTermMatches(msg *gmail.Message, term fakeQueryTerm) bool {
+	hasLabel := func(label string) bool {
+		for _, l := range msg.LabelIds {
+			if strings.EqualFold(l, label) {
+				return true
+			}
+		}
+		return false
+	}
+	header := func(name string) string {
+		if msg.Payload == nil {
+			return ""
+		}
+		return strings.ToLower(headerValue(msg.Payload.Headers, name))
+	}
+
+	var ok bool
+	switch {
+	case term.anyOf != nil:
+		for _, alternative := range term.anyOf {
+			if fakeTermMatches(msg, alternative) {
+				ok = true
+				break
+			}
+		}
+	default:
+		switch term.operator {
+		case "from", "to", "cc", "subject":
+			ok = strings.Contains(header(term.operator), term.value)
+		case "rfc822msgid":
+			ok = strings.Contains(header("message-id"), term.value)
+		case "label":
+			ok = hasLabel(term.value)
+		case "in":
+			ok = term.value == "anywhere" || hasLabel(term.value)
+		case "is":
+			ok = hasLabel(term.value)
+		case "has":
+			if term.value != "attachment" {
+				return true // like unknown operators
+			}
+			ok = len(extractAttachmentInfo(msg)) > 0
+		case "filename":
+			for _, att := range extractAttachmentInfo(msg) {
+				if strings.Contains(strings.ToLower(att["filename"].(string)), term.value) {
+					ok = true
+					break
+				}
+			}
+		case "":
+			text := strings.ToLower(header("subject") + " " + header("from") + " " + header("to") + " " + msg.Snippet + " " + extractEmailBody(msg))
+			ok = strings.Contains(text, term.value)
+		default:
+			// Unsupported operators are ignored, negated or not
+			return true
+		}
+	}
+	return ok != term.negate
+}
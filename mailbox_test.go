@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"slices"
+	"testing"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestFakeMailboxQueries(t *testing.T) {
+	fake, err := NewFakeMailbox("me@example.com",
+		FixtureMessage{ID: "m1", ThreadID: "t1", From: "alice@example.com", Subject: "Plans", Body: "Lunch on Friday?", MessageID: "<plans@example.com>"},
+		FixtureMessage{ID: "m2", ThreadID: "t2", From: "bob@example.com", Subject: "Report", Body: "Numbers attached",
+			Attachments: []FixtureAttachment{{Filename: "q3.pdf", MimeType: "application/pdf", Data: []byte("%PDF-1.4")}}},
+		FixtureMessage{ID: "m3", ThreadID: "t3", From: "shop@example.com", Subject: "Sale", Body: "50% off", Labels: []string{"CATEGORY_PROMOTIONS"}, MessageID: "<sale@example.com>"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"m1", "m2", "m3"}},
+		{"from:alice", []string{"m1"}},
+		{"-from:alice", []string{"m2", "m3"}},
+		{"lunch", []string{"m1"}},
+		{`"50% off"`, []string{"m3"}},
+		{"has:attachment", []string{"m2"}},
+		{"filename:q3", []string{"m2"}},
+		{"label:CATEGORY_PROMOTIONS", []string{"m3"}},
+		// Operators the fake doesn't know are ignored, negated or not
+		{"newer_than:7d", []string{"m1", "m2", "m3"}},
+		{"-newer_than:7d", []string{"m1", "m2", "m3"}},
+		{"-has:drive", []string{"m1", "m2", "m3"}},
+	}
+	for _, tt := range tests {
+		resp, err := fake.ListMessages(context.Background(), tt.query, 0)
+		if err != nil {
+			t.Fatalf("ListMessages(%q): %v", tt.query, err)
+		}
+		var got []string
+		for _, msg := range resp.Messages {
+			got = append(got, msg.Id)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("ListMessages(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestFakeMailboxSendsIntoThread(t *testing.T) {
+	ctx := context.Background()
+	fake, err := NewFakeMailbox("me@example.com",
+		FixtureMessage{ID: "m1", ThreadID: "t1", From: "alice@example.com", To: "me@example.com", Subject: "Plans", Body: "Lunch on Friday?"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := base64.URLEncoding.EncodeToString([]byte("To: alice@example.com\r\nSubject: Re: Plans\r\n\r\nFriday works."))
+	draft, err := fake.CreateDraft(ctx, &gmail.Draft{Message: &gmail.Message{ThreadId: "t1", Raw: raw}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	drafts, err := fake.ListDrafts(ctx, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drafts.Drafts) != 1 || drafts.Drafts[0].Id != draft.Id {
+		t.Fatalf("drafts = %+v, want only %s", drafts.Drafts, draft.Id)
+	}
+
+	sent, err := fake.SendMessage(ctx, &gmail.Message{ThreadId: "t1", Raw: raw})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent.ThreadId != "t1" || len(fake.SentMessages()) != 1 {
+		t.Fatalf("sent %+v, %d sent messages", sent, len(fake.SentMessages()))
+	}
+	if err := fake.DeleteDraft(ctx, draft.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fake.GetDraft(ctx, draft.Id); err == nil {
+		t.Fatal("draft still exists after deleting it")
+	}
+	thread, err := fake.GetThread(ctx, "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(thread.Messages) != 2 || extractEmailBody(thread.Messages[1]) != "Friday works." {
+		t.Fatalf("thread holds %d messages, want the original and the reply", len(thread.Messages))
+	}
+	if _, err := fake.SendMessage(ctx, &gmail.Message{ThreadId: "missing", Raw: raw}); err == nil {
+		t.Fatal("sent into a thread that does not exist")
+	}
+}
@@ -37,8 +37,32 @@ import (
 )
 
 type GmailServer struct {
-	service *gmail.Service
-	userID  string
+	mailbox     Mailbox
+	account     string            // configured account name (see accounts.go)
+	email       string            // account's email address, filled in at startup
+	search      *LocalSearch      // full-text index over the local mirror, nil unless GMAIL_MIRROR is set
+	attachments *AttachmentPolicy // size limits for outgoing attachments; nil uses the defaults
+}
+
+// NewGmailServerWithMailbox creates a server for account backed by an arbitrary Mailbox (e.g. the tests' FakeMailbox)
+func NewGmailServerWithMailbox(account string, mailbox Mailbox) *GmailServer {
+	return &GmailServer{
+		mailbox: mailbox,
+		account: account,
+	}
+}
+
+// styleGuidePath returns the location of this account's personal style guide
+func (g *GmailServer) styleGuidePath() string {
+	return getAppFilePath(styleGuideFileName(g.account))
+}
+
+// displayName describes the account for logs and approval prompts, e.g. "work <me@example.com>"
+func (g *GmailServer) displayName() string {
+	if g.email == "" {
+		return g.account
+	}
+	return fmt.Sprintf("%s <%s>", g.account, g.email)
 }
 
 // ============================================================================
@@ -54,20 +78,24 @@ type ApprovalResult struct {
 
 // PendingEmail represents an email waiting for user approval
 type PendingEmail struct {
-	ID       string              // Unique ID for this pending request
-	DraftID  string              // Gmail draft ID
-	To       string              // Recipient
-	Subject  string              // Email subject
-	Body     string              // Full email body
-	QueuedAt time.Time           // When the request was queued
-	ResultCh chan ApprovalResult // Channel to send result back to blocked caller
+	ID          string              // Unique ID for this pending request
+	Account     string              // Configured account the draft belongs to
+	From        string              // Sending account's email address
+	DraftID     string              // Gmail draft ID
+	ContentHash string              // draftContentHash of the draft shown for approval
+	To          string              // Recipient
+	Subject     string              // Email subject
+	Body        string              // Full email body
+	Attachments []AttachmentSummary // Files attached to the draft
+	QueuedAt    time.Time           // When the request was queued
+	ResultCh    chan ApprovalResult // Channel to send result back to blocked caller
 }
 
 // ApprovalSession manages the OOB approval state
 type ApprovalSession struct {
 	ID         string // Crypto-random session ID for URL
 	CreatedAt  time.Time
-	Pending    *PendingEmail // Only ONE pending email at a time
+	Pending    *PendingEmail // Email awaiting a decision on the outbox page; phone approvals are held by the approval daemon, which takes many at once
 	History    []EmailHistoryEntry
 	mu         sync.Mutex
 	sseClients map[chan string]bool // SSE clients for real-time updates
@@ -76,6 +104,7 @@ type ApprovalSession struct {
 // EmailHistoryEntry records sent/rejected emails
 type EmailHistoryEntry struct {
 	DraftID   string
+	From      string
 	To        string
 	Subject   string
 	Action    string // "sent" or "rejected"
@@ -105,19 +134,35 @@ func NewApprovalSession() (*ApprovalSession, error) {
 	}, nil
 }
 
-// sendToDaemon sends a request to the approval daemon via Unix socket
-func sendToDaemon(req map[string]string) (map[string]interface{}, error) {
+// sendToDaemon sends a request to the approval daemon via Unix socket. Cancelling
+// ctx hangs up on the daemon, which withdraws the pending approval.
+func sendToDaemon(ctx context.Context, req map[string]interface{}) (map[string]interface{}, error) {
 	home, _ := os.UserHomeDir()
 	socketPath := filepath.Join(home, ".config", "gmail-mcp", "approval.sock")
 
-	conn, err := net.Dial("unix", socketPath)
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", socketPath)
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		return nil, fmt.Errorf("approval daemon not running. Start it with: gmail-approval-daemon")
 	}
 	defer conn.Close()
 
-	// Set deadline for the entire operation (5 min approval timeout + buffer)
-	conn.SetDeadline(time.Now().Add(6 * time.Minute))
+	// Set deadline for the entire operation (5 min approval timeout + buffer),
+	// or the caller's deadline if that comes first
+	deadline := time.Now().Add(6 * time.Minute)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline)
+
+	// Unblock the read below if the caller gives up
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
 
 	encoder := json.NewEncoder(conn)
 	decoder := json.NewDecoder(conn)
@@ -128,45 +173,15 @@ func sendToDaemon(req map[string]string) (map[string]interface{}, error) {
 
 	var resp map[string]interface{}
 	if err := decoder.Decode(&resp); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		return nil, fmt.Errorf("failed to read daemon response: %w", err)
 	}
 
 	return resp, nil
 }
 
-// QueueEmail queues an email for approval, returns error if one is already pending
-func (s *ApprovalSession) QueueEmail(draftID, to, subject, body string) (*PendingEmail, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if s.Pending != nil {
-		return nil, fmt.Errorf("another email is already pending approval - only one at a time allowed")
-	}
-
-	// Generate unique ID for this pending request
-	idBytes := make([]byte, 8)
-	rand.Read(idBytes)
-	pendingID := base64.URLEncoding.EncodeToString(idBytes)
-	pendingID = strings.TrimRight(pendingID, "=")
-
-	pending := &PendingEmail{
-		ID:       pendingID,
-		DraftID:  draftID,
-		To:       to,
-		Subject:  subject,
-		Body:     body,
-		QueuedAt: time.Now(),
-		ResultCh: make(chan ApprovalResult, 1),
-	}
-
-	s.Pending = pending
-
-	// Notify SSE clients
-	s.broadcastUpdate()
-
-	return pending, nil
-}
-
 // Approve approves the pending email
 func (s *ApprovalSession) Approve() (*PendingEmail, error) {
 	s.mu.Lock()
@@ -182,6 +197,7 @@ func (s *ApprovalSession) Approve() (*PendingEmail, error) {
 	// Record in history
 	s.History = append(s.History, EmailHistoryEntry{
 		DraftID:   pending.DraftID,
+		From:      pending.From,
 		To:        pending.To,
 		Subject:   pending.Subject,
 		Action:    "sent",
@@ -209,6 +225,7 @@ func (s *ApprovalSession) Reject() (*PendingEmail, error) {
 	// Record in history
 	s.History = append(s.History, EmailHistoryEntry{
 		DraftID:   pending.DraftID,
+		From:      pending.From,
 		To:        pending.To,
 		Subject:   pending.Subject,
 		Action:    "rejected",
@@ -261,7 +278,7 @@ func (s *ApprovalSession) broadcastUpdate() {
 const oobServerPort = 8787
 
 // StartOOBServer starts the out-of-band approval web server
-func StartOOBServer(gmailServer *GmailServer) {
+func StartOOBServer(accounts *AccountRegistry) {
 	mux := http.NewServeMux()
 
 	// Dashboard page
@@ -308,14 +325,18 @@ func StartOOBServer(gmailServer *GmailServer) {
 		}
 
 		json.NewEncoder(w).Encode(map[string]interface{}{
-			"pending":   true,
-			"id":        pending.ID,
-			"draftId":   pending.DraftID,
-			"to":        pending.To,
-			"subject":   pending.Subject,
-			"body":      pending.Body,
-			"queuedAt":  pending.QueuedAt.Format(time.RFC3339),
-			"expiresIn": int(5*time.Minute - time.Since(pending.QueuedAt).Round(time.Second)/time.Second),
+			"pending":     true,
+			"id":          pending.ID,
+			"draftId":     pending.DraftID,
+			"account":     pending.Account,
+			"from":        pending.From,
+			"to":          pending.To,
+			"subject":     pending.Subject,
+			"body":        pending.Body,
+			"attachments": pending.Attachments,
+			"contentHash": pending.ContentHash,
+			"queuedAt":    pending.QueuedAt.Format(time.RFC3339),
+			"expiresIn":   int(5*time.Minute - time.Since(pending.QueuedAt).Round(time.Second)/time.Second),
 		})
 	})
 
@@ -346,8 +367,11 @@ func StartOOBServer(gmailServer *GmailServer) {
 			return
 		}
 
-		// Send the email via Gmail API
-		err = gmailServer.SendDraft(pending.DraftID)
+		// Send the email via Gmail API from the account that owns the draft
+		gmailServer, err := accounts.Resolve(pending.Account)
+		if err == nil {
+			err = gmailServer.SendApprovedDraft(r.Context(), pending.DraftID, pending.ContentHash)
+		}
 		if err != nil {
 			// Put back in history as failed
 			log.Printf("Failed to send email: %v", err)
@@ -423,7 +447,6 @@ func StartOOBServer(gmailServer *GmailServer) {
 		w.Header().Set("Content-Type", "text/event-stream")
 		w.Header().Set("Cache-Control", "no-cache")
 		w.Header().Set("Connection", "keep-alive")
-		w.Header().Set("Access-Control-Allow-Origin", "*")
 
 		// Create channel for this client
 		clientCh := make(chan string, 10)
@@ -455,8 +478,9 @@ func StartOOBServer(gmailServer *GmailServer) {
 
 	// Start server in goroutine
 	go func() {
-		addr := fmt.Sprintf(":%d", oobServerPort)
-		log.Printf("🌐 OOB Approval server starting on http://localhost%s", addr)
+		// Approvals are for the person at this machine
+		addr := fmt.Sprintf("127.0.0.1:%d", oobServerPort)
+		log.Printf("🌐 OOB Approval server starting on http://localhost:%d", oobServerPort)
 		if err := http.ListenAndServe(addr, mux); err != nil {
 			log.Printf("OOB Server error: %v", err)
 		}
@@ -474,13 +498,126 @@ func serveDashboard(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// SendDraft sends a draft via Gmail API
-func (g *GmailServer) SendDraft(draftID string) error {
-	_, err := g.service.Users.Drafts.Send(g.userID, &gmail.Draft{Id: draftID}).Do()
+// SendWithApproval saves outgoing as a draft (in threadID, if set), asks the
+// approval daemon to confirm it on the user's phone and sends it once approved
+func (g *GmailServer) SendWithApproval(ctx context.Context, outgoing OutgoingMessage, threadID string) (*mcp.CallToolResult, error) {
+	raw, err := outgoing.Build()
 	if err != nil {
-		return fmt.Errorf("failed to send draft: %v", err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to build email: %v", err)), nil
 	}
-	return nil
+	// Check the message reads back before leaving a draft behind
+	if _, err := parseRecipients(raw); err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to build email: %v", err)), nil
+	}
+	message := &gmail.Message{
+		ThreadId: threadID,
+		Raw:      base64.URLEncoding.EncodeToString(raw),
+	}
+
+	draft := &gmail.Draft{Message: message}
+	createdDraft, err := g.mailbox.CreateDraft(ctx, draft)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to create draft: %v", err)), nil
+	}
+
+	draftID := createdDraft.Id
+	log.Printf("📝 Draft created internally: account=%s id=%s to=%s subject=%s", g.account, draftID, outgoing.To, outgoing.Subject)
+
+	return g.approveAndSendDraft(ctx, draftID)
+}
+
+// approveAndSendDraft asks the approval daemon to confirm a draft on the
+// user's phone, blocking until they answer, and sends it once approved. The
+// approval is built from the draft as Gmail stores it and bound to its content
+// hash, and only the bytes carrying that hash are sent.
+func (g *GmailServer) approveAndSendDraft(ctx context.Context, draftID string) (*mcp.CallToolResult, error) {
+	raw, _, err := g.fetchDraftRaw(ctx, draftID)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	contentHash := draftContentHash(raw)
+	payload, err := parseRawMessage(raw, nil)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to read draft %s: %v", draftID, err)), nil
+	}
+	body := approvalBody(payload)
+
+	// The approval shows the recipients read back from the message itself,
+	// not the agent's arguments, so nothing can reach Gmail unseen
+	recipients, err := parseRecipients(raw)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to read email: %v", err)), nil
+	}
+	if len(recipients.To)+len(recipients.Cc)+len(recipients.Bcc) == 0 {
+		return mcp.NewToolResultError(fmt.Sprintf("Draft %s has no recipients", draftID)), nil
+	}
+	to, subject := strings.Join(recipients.To, ", "), recipients.Subject
+	attachments, err := parseAttachmentSummaries(raw)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to read email: %v", err)), nil
+	}
+
+	// Send to approval daemon for mobile push approval (blocking)
+	log.Printf("📱 Sending to approval daemon for mobile push approval...")
+	resp, err := sendToDaemon(ctx, map[string]interface{}{
+		"action":       "queue_email",
+		"account":      g.account,
+		"from":         g.email,
+		"to":           to,
+		"cc":           strings.Join(recipients.Cc, ", "),
+		"bcc":          strings.Join(recipients.Bcc, ", "),
+		"subject":      subject,
+		"body":         body,
+		"attachments":  attachments,
+		"draft_id":     draftID,
+		"content_hash": contentHash,
+	})
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	success, _ := resp["success"].(bool)
+	if !success {
+		errMsg, _ := resp["error"].(string)
+		return mcp.NewToolResultError(errMsg), nil
+	}
+	// Approved - send the draft. The user's decision stands even if the
+	// agent's request is cancelled from here on.
+	log.Printf("✅ Email approved, sending draft...")
+	sendCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+	err = g.SendApprovedDraft(sendCtx, draftID, contentHash)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("%s: %v", approvedButNotSent, err)), nil
+	}
+
+	log.Printf("📧 Email sent successfully: from=%s to=%s subject=%s", g.displayName(), to, subject)
+
+	resultJSON, _ := json.MarshalIndent(map[string]interface{}{
+		"status":      "sent",
+		"message":     "Email approved and sent successfully",
+		"account":     g.account,
+		"from":        g.email,
+		"to":          recipients.To,
+		"cc":          recipients.Cc,
+		"bcc":         recipients.Bcc,
+		"subject":     subject,
+		"attachments": attachments,
+		"contentHash": contentHash,
+	}, "", "  ")
+	return mcp.NewToolResultText(string(resultJSON)), nil
+}
+
+// approvalBody is the text an approver reads for a message. Recipients' mail
+// clients may show either the plain-text or the HTML version, and the two can
+// say different things, so when both are present the approver sees both.
+func approvalBody(payload *gmail.MessagePart) string {
+	plainText, htmlText := extractFromParts([]*gmail.MessagePart{payload})
+	if plainText == "" || htmlText == "" {
+		return extractEmailBody(&gmail.Message{Payload: payload})
+	}
+	return "[Plain-text version]\n" + strings.TrimSpace(plainText) +
+		"\n\n[HTML version]\n" + extractTextAndLinksFromHTML(htmlText)
 }
 
 // Dashboard HTML template
@@ -539,6 +676,15 @@ const dashboardHTML = `<!DOCTYPE html>
             display: inline-block;
             width: 80px;
         }
+        .attachments {
+            margin: 4px 0 0 0;
+            padding-left: 20px;
+        }
+        .attachments code {
+            font-size: 11px;
+            color: #666;
+            word-break: break-all;
+        }
         .email-body {
             background: #fafafa;
             border: 1px solid #eee;
@@ -627,6 +773,10 @@ const dashboardHTML = `<!DOCTYPE html>
     <div id="email-container" style="display: none;">
         <div class="email-card">
             <div class="email-header">
+                <div class="email-field">
+                    <label>From:</label>
+                    <span id="email-from"></span>
+                </div>
                 <div class="email-field">
                     <label>To:</label>
                     <span id="email-to"></span>
@@ -635,6 +785,10 @@ const dashboardHTML = `<!DOCTYPE html>
                     <label>Subject:</label>
                     <span id="email-subject"></span>
                 </div>
+                <div class="email-field" id="email-attachments-field" style="display: none;">
+                    <label>Attached:</label>
+                    <ul class="attachments" id="email-attachments"></ul>
+                </div>
             </div>
             <div class="email-body" id="email-body"></div>
             <div class="buttons">
@@ -685,9 +839,12 @@ const dashboardHTML = `<!DOCTYPE html>
                     document.getElementById("status").className = "status pending";
                     document.getElementById("status").innerHTML =
                         "<strong>⚠️ Email pending approval</strong>";
+                    document.getElementById("email-from").textContent =
+                        data.from ? data.account + " <" + data.from + ">" : data.account;
                     document.getElementById("email-to").textContent = data.to;
                     document.getElementById("email-subject").textContent = data.subject;
                     document.getElementById("email-body").textContent = data.body;
+                    renderAttachments(data.attachments || []);
                     document.getElementById("email-container").style.display = "block";
                     document.getElementById("btn-approve").disabled = false;
                     document.getElementById("btn-reject").disabled = false;
@@ -703,6 +860,28 @@ const dashboardHTML = `<!DOCTYPE html>
             }
         }
 
+        // Each attachment shows its name, size and SHA-256 so you know exactly what is leaving
+        function renderAttachments(attachments) {
+            const list = document.getElementById("email-attachments");
+            list.replaceChildren();
+            for (const a of attachments) {
+                const item = document.createElement("li");
+                item.textContent = a.filename + " (" + formatSize(a.size) + ", " + a.mimeType + ") ";
+                const hash = document.createElement("code");
+                hash.textContent = "sha256:" + a.sha256;
+                item.appendChild(hash);
+                list.appendChild(item);
+            }
+            document.getElementById("email-attachments-field").style.display =
+                attachments.length ? "block" : "none";
+        }
+
+        function formatSize(size) {
+            if (size >= 1048576) return (size / 1048576).toFixed(1) + " MB";
+            if (size >= 1024) return (size / 1024).toFixed(1) + " KB";
+            return size + " bytes";
+        }
+
         async function approve() {
             if (!currentPendingId) return;
 
@@ -787,7 +966,8 @@ const dashboardHTML = `<!DOCTYPE html>
 </html>
 `
 
-func NewGmailServer() (*GmailServer, error) {
+// NewGmailServer authenticates the named account and creates its Gmail client
+func NewGmailServer(account string) (*GmailServer, error) {
 	ctx := context.Background()
 
 	// Get credentials from separate environment variables
@@ -811,7 +991,7 @@ func NewGmailServer() (*GmailServer, error) {
 	}
 
 	// Get token from file or perform OAuth flow
-	token, err := getToken(config)
+	token, err := getToken(config, account)
 	if err != nil {
 		return nil, fmt.Errorf("unable to get token: %v", err)
 	}
@@ -823,31 +1003,54 @@ func NewGmailServer() (*GmailServer, error) {
 		return nil, fmt.Errorf("unable to create Gmail service: %v", err)
 	}
 
-	return &GmailServer{
-		service: service,
-		userID:  "me",
-	}, nil
+	// Every live call is charged against the account's Gmail quota and retried on rate limits
+	var mailbox Mailbox = newThrottledMailbox(newGmailMailbox(service, "me"), quotaUnitsPerSecond())
+
+	// Optionally serve reads from a local mirror kept current via the History API
+	mirrorConfig, err := mirrorConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	var search *LocalSearch
+	if mirrorConfig != nil {
+		mirror, err := NewMailboxMirror(mailbox, getAppFilePath(filepath.Join("mirror", account)), *mirrorConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		// Index mirrored bodies and attachment text for search_local
+		search = NewLocalSearch(mirror)
+		mirror.Subscribe(search)
+		go search.Run(context.Background())
+
+		go mirror.Run(context.Background())
+		mailbox = mirror
+	}
+
+	server := NewGmailServerWithMailbox(account, mailbox)
+	server.search = search
+	return server, nil
 }
 
 // getToken retrieves a token from a local file or initiates OAuth flow
-func getToken(config *oauth2.Config) (*oauth2.Token, error) {
-	tokenFile := getAppFilePath("token.json")
+func getToken(config *oauth2.Config, account string) (*oauth2.Token, error) {
+	tokenFile := getAppFilePath(tokenFileName(account))
 
 	// Try to load existing token
 	token, err := tokenFromFile(tokenFile)
 	if err != nil {
-		log.Printf("No valid token file found (%v), starting OAuth flow...", err)
-		return performOAuthFlow(config, tokenFile)
+		log.Printf("No valid token file found for account %s (%v), starting OAuth flow...", account, err)
+		return performOAuthFlow(config, account, tokenFile)
 	}
 
 	// Validate the token by testing it with a simple Gmail API call
-	log.Println("Validating existing token...")
+	log.Printf("Validating existing token for account %s...", account)
 	if !isTokenValid(token) {
-		log.Println("Existing token is invalid or expired, starting OAuth flow...")
-		return performOAuthFlow(config, tokenFile)
+		log.Printf("Existing token for account %s is invalid or expired, starting OAuth flow...", account)
+		return performOAuthFlow(config, account, tokenFile)
 	}
 
-	log.Println("✅ Using existing valid token")
+	log.Printf("✅ Using existing valid token for account %s", account)
 	return token, nil
 }
 
@@ -873,8 +1076,8 @@ func isTokenValid(token *oauth2.Token) bool {
 }
 
 // performOAuthFlow handles the OAuth flow and saves the token
-func performOAuthFlow(config *oauth2.Config, tokenFile string) (*oauth2.Token, error) {
-	token, err := getTokenFromWeb(config)
+func performOAuthFlow(config *oauth2.Config, account, tokenFile string) (*oauth2.Token, error) {
+	token, err := getTokenFromWeb(config, account)
 	if err != nil {
 		return nil, err
 	}
@@ -885,15 +1088,17 @@ func performOAuthFlow(config *oauth2.Config, tokenFile string) (*oauth2.Token, e
 }
 
 // getTokenFromWeb requests a token from the web, then returns the retrieved token
-func getTokenFromWeb(config *oauth2.Config) (*oauth2.Token, error) {
+func getTokenFromWeb(config *oauth2.Config, account string) (*oauth2.Token, error) {
 	// Create a channel to receive the authorization code
 	codeChan := make(chan string)
 	errChan := make(chan error)
 
-	// Start a temporary HTTP server to catch the OAuth callback
-	server := &http.Server{Addr: ":9876"}
+	// Start a temporary HTTP server to catch the OAuth callback. Each flow gets
+	// its own mux so several accounts can be authorized in one run.
+	mux := http.NewServeMux()
+	server := &http.Server{Addr: ":9876", Handler: mux}
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		code := r.URL.Query().Get("code")
 		if code == "" {
 			errChan <- fmt.Errorf("no code in callback")
@@ -936,7 +1141,13 @@ func getTokenFromWeb(config *oauth2.Config) (*oauth2.Token, error) {
 	config.RedirectURL = "http://localhost:9876"
 
 	// Generate the authorization URL
-	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
+	authOptions := []oauth2.AuthCodeOption{oauth2.AccessTypeOffline}
+	if account != defaultAccountName {
+		// Let the user pick the right Google account for each configured mailbox
+		authOptions = append(authOptions, oauth2.SetAuthURLParam("prompt", "select_account consent"))
+		fmt.Printf("Authorizing Gmail account %q - sign in with the matching Google account.\n", account)
+	}
+	authURL := config.AuthCodeURL("state-token", authOptions...)
 
 	fmt.Println("Opening browser for authorization...")
 	fmt.Printf("If browser doesn't open automatically, go to: %v\n", authURL)
@@ -1013,249 +1224,244 @@ func saveToken(path string, token *oauth2.Token) {
 	json.NewEncoder(f).Encode(token)
 }
 
-// SearchThreads searches Gmail threads based on a query
-func (g *GmailServer) SearchThreads(ctx context.Context, query string, maxResults int64) (*mcp.CallToolResult, error) {
-	if maxResults <= 0 {
-		maxResults = 10
-	}
+// searchCursor is the state behind the opaque cursor returned by search_threads.
+// It binds Gmail's page token to the query and account that produced it so a
+// cursor can't silently be replayed against a different search.
+type searchCursor struct {
+	Query     string `json:"q"`
+	Account   string `json:"a"`
+	PageToken string `json:"t"`
+	Page      int    `json:"n"` // 1-based page number the cursor points at
+}
 
-	threads, err := g.service.Users.Threads.List(g.userID).Q(query).MaxResults(maxResults).Do()
+// encodeSearchCursor serializes a cursor for handing back to the agent
+func encodeSearchCursor(cursor searchCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeSearchCursor parses a cursor and checks it belongs to this query and account
+func decodeSearchCursor(encoded, query, account string) (searchCursor, error) {
+	var cursor searchCursor
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(encoded))
 	if err != nil {
-		return mcp.NewToolResultError(fmt.Sprintf("Failed to search threads: %v", err)), nil
+		return cursor, fmt.Errorf("invalid cursor")
 	}
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.PageToken == "" {
+		return cursor, fmt.Errorf("invalid cursor")
+	}
+	if cursor.Query != query {
+		return cursor, fmt.Errorf("cursor was issued for a different query (%q); repeat that query or start again without a cursor", cursor.Query)
+	}
+	if cursor.Account != account {
+		return cursor, fmt.Errorf("cursor was issued for account %q, not %q", cursor.Account, account)
+	}
+	return cursor, nil
+}
 
-	var results []map[string]interface{}
-	for _, thread := range threads.Threads {
-		// Get thread details
-		threadDetail, err := g.service.Users.Threads.Get(g.userID, thread.Id).Do()
-		if err != nil {
-			continue
-		}
-
-		if len(threadDetail.Messages) == 0 {
-			continue
-		}
-
-		firstMessage := threadDetail.Messages[0]
-		var subject, from, snippet string
-
-		// Extract headers
-		for _, header := range firstMessage.Payload.Headers {
-			switch header.Name {
-			case "Subject":
-				subject = header.Value
-			case "From":
-				from = header.Value
-			}
-		}
-
-		// Use Gmail's built-in snippet for fast browsing (typically ~150 characters)
-		snippet = firstMessage.Snippet
-
-		// Collect attachment information from all messages in the thread
-		var allAttachments []map[string]interface{}
-		for _, message := range threadDetail.Messages {
-			attachments := extractAttachmentInfo(message)
-			for _, attachment := range attachments {
-				// Add message ID to each attachment for reference
-				attachment["messageId"] = message.Id
-				allAttachments = append(allAttachments, attachment)
-			}
-		}
+// SearchThreads searches Gmail threads based on a query. Pass the nextCursor from
+// a previous result to fetch the following page.
+func (g *GmailServer) SearchThreads(ctx context.Context, query string, maxResults int64, cursor string) (*mcp.CallToolResult, error) {
+	if maxResults <= 0 {
+		maxResults = 10
+	}
 
-		// Get existing drafts for this thread
-		existingDrafts, err := g.getThreadDrafts(thread.Id)
+	// Resume from a previous page if a cursor was given
+	page := 1
+	pageToken := ""
+	if cursor != "" {
+		decoded, err := decodeSearchCursor(cursor, query, g.account)
 		if err != nil {
-			log.Printf("Warning: Failed to get drafts for thread %s: %v", thread.Id, err)
-			existingDrafts = []map[string]interface{}{}
+			return mcp.NewToolResultError(err.Error()), nil
 		}
+		page = decoded.Page
+		pageToken = decoded.PageToken
+	}
 
-		threadResult := map[string]interface{}{
-			"threadId":     thread.Id,
-			"subject":      subject,
-			"from":         from,
-			"snippet":      snippet,
-			"messageCount": len(threadDetail.Messages),
-		}
+	threads, err := g.mailbox.ListThreads(ctx, query, maxResults, pageToken)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to search threads: %v", err)), nil
+	}
 
-		// Only include attachments if there are any
-		if len(allAttachments) > 0 {
-			threadResult["attachments"] = allAttachments
-		}
+	// One drafts listing serves every thread on this page
+	var warnings []string
+	drafts, err := g.loadDraftIndex(ctx)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		warnings = append(warnings, fmt.Sprintf("existing drafts could not be checked: %v", err))
+	}
 
-		// Only include drafts if there are any
-		if len(existingDrafts) > 0 {
-			threadResult["drafts"] = existingDrafts
-		}
+	threadIDs := make([]string, 0, len(threads.Threads))
+	for _, thread := range threads.Threads {
+		threadIDs = append(threadIDs, thread.Id)
+	}
+	results, failed := g.summarizeThreads(ctx, threadIDs, drafts)
 
-		results = append(results, threadResult)
+	response := map[string]interface{}{
+		"threads":            results,
+		"page":               page,
+		"resultSizeEstimate": threads.ResultSizeEstimate,
+		"hasMore":            threads.NextPageToken != "",
+	}
+	if results == nil {
+		response["threads"] = []map[string]interface{}{}
+	}
+	// Threads that could not be loaded stay in the list with an "error" field
+	if failed > 0 {
+		response["failedCount"] = failed
+	}
+	if len(warnings) > 0 {
+		response["warnings"] = warnings
+	}
+	if threads.NextPageToken != "" {
+		response["nextCursor"] = encodeSearchCursor(searchCursor{
+			Query:     query,
+			Account:   g.account,
+			PageToken: threads.NextPageToken,
+			Page:      page + 1,
+		})
 	}
 
-	resultJSON, _ := json.MarshalIndent(results, "", "  ")
+	resultJSON, _ := json.MarshalIndent(response, "", "  ")
 	return mcp.NewToolResultText(string(resultJSON)), nil
 }
 
 // getThreadDrafts retrieves existing drafts for a specific thread
-func (g *GmailServer) getThreadDrafts(threadID string) ([]map[string]interface{}, error) {
-	var drafts []map[string]interface{}
-
-	// List all drafts for the user
-	draftsList, err := g.service.Users.Drafts.List(g.userID).Do()
+func (g *GmailServer) getThreadDrafts(ctx context.Context, threadID string) ([]map[string]interface{}, error) {
+	drafts, err := g.loadDraftIndex(ctx)
 	if err != nil {
-		return drafts, fmt.Errorf("failed to list drafts: %v", err)
+		return nil, err
 	}
+	return drafts.forThread(ctx, threadID), nil
+}
 
-	// Check each draft to see if it belongs to this thread
-	for _, draft := range draftsList.Drafts {
-		// Get the full draft details
-		fullDraft, err := g.service.Users.Drafts.Get(g.userID, draft.Id).Do()
-		if err != nil {
-			continue // Skip drafts we can't access
-		}
-
-		// Check if this draft belongs to the specified thread
-		if fullDraft.Message != nil && fullDraft.Message.ThreadId == threadID {
-			draftInfo := map[string]interface{}{
-				"draftId":  fullDraft.Id,
-				"threadId": fullDraft.Message.ThreadId,
-			}
-
-			// Extract subject and snippet if available
-			if fullDraft.Message.Payload != nil {
-				for _, header := range fullDraft.Message.Payload.Headers {
-					if header.Name == "Subject" {
-						draftInfo["subject"] = header.Value
-						break
-					}
-				}
-
-				// Extract draft body/snippet
-				if body := extractEmailBody(fullDraft.Message); body != "" {
-					// Truncate to snippet length
-					snippet := body
-					if len(snippet) > 200 {
-						snippet = snippet[:200] + "..."
-					}
-					draftInfo["snippet"] = snippet
-				}
-			}
-
-			drafts = append(drafts, draftInfo)
+// CreateDraft creates a Gmail draft. With a threadID the draft is a reply to the
+// thread's latest message (see prepareReply), and mode decides what happens when
+// the thread already has a draft: "new", "replace" or "append". With no mode the
+// call is refused instead of touching the existing draft.
+func (g *GmailServer) CreateDraft(ctx context.Context, outgoing OutgoingMessage, threadID string, replyAll bool, mode string) (*mcp.CallToolResult, error) {
+	switch mode {
+	case "", draftModeNew:
+	case draftModeReplace, draftModeAppend:
+		if threadID == "" {
+			return mcp.NewToolResultError(fmt.Sprintf("mode %q needs thread_id; use update_draft to change a draft by its ID", mode)), nil
 		}
+	default:
+		return mcp.NewToolResultError(fmt.Sprintf("invalid mode %q: use new, replace or append", mode)), nil
 	}
 
-	return drafts, nil
-}
-
-// CreateDraft creates a Gmail draft or updates existing draft if one exists for the thread
-func (g *GmailServer) CreateDraft(ctx context.Context, to, subject, body string, threadID string) (*mcp.CallToolResult, error) {
 	var message gmail.Message
-
-	// Build the email message
-	headers := fmt.Sprintf("To: %s\r\n", to)
+	var existingDraftID string
 
 	if threadID != "" {
 		// Set the thread ID on the message for proper threading
 		message.ThreadId = threadID
 
-		// Ensure subject has "Re:" prefix for replies
-		if !strings.HasPrefix(strings.ToLower(subject), "re:") {
-			subject = "Re: " + subject
-		}
-
-		// For replies, we need to set the In-Reply-To and References headers
-		thread, err := g.service.Users.Threads.Get(g.userID, threadID).Do()
-		if err == nil && len(thread.Messages) > 0 {
-			lastMessage := thread.Messages[len(thread.Messages)-1]
-			var messageID string
-			var references string
-
-			// Extract Message-ID and References from the last message
-			for _, header := range lastMessage.Payload.Headers {
-				switch header.Name {
-				case "Message-ID":
-					messageID = header.Value
-				case "References":
-					references = header.Value
-				}
-			}
+		if err := g.prepareReply(ctx, &outgoing, threadID, replyAll); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
 
-			if messageID != "" {
-				headers += fmt.Sprintf("In-Reply-To: %s\r\n", messageID)
+		if mode != draftModeNew {
+			// Without knowing the thread's drafts, a draft could be silently
+			// duplicated or overwritten, so this fails whatever the mode
+			existingDrafts, err := g.getThreadDrafts(ctx, threadID)
+			if err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("Failed to check the thread's existing drafts: %v", err)), nil
+			}
+			draftIDs := make([]string, len(existingDrafts))
+			for i, draft := range existingDrafts {
+				draftIDs[i], _ = draft["draftId"].(string)
+			}
 
-				// Build References header (previous references + last message ID)
-				if references != "" {
-					headers += fmt.Sprintf("References: %s %s\r\n", references, messageID)
-				} else {
-					headers += fmt.Sprintf("References: %s\r\n", messageID)
-				}
+			switch {
+			case len(draftIDs) == 0:
+				// Nothing to replace or append to; create the draft
+			case mode == "":
+				return mcp.NewToolResultError(fmt.Sprintf("thread %s already has a draft (%s). Pass mode \"new\" to add another draft, \"replace\" to overwrite it or \"append\" to add to it", threadID, strings.Join(draftIDs, ", "))), nil
+			case len(draftIDs) > 1:
+				return mcp.NewToolResultError(fmt.Sprintf("thread %s has %d drafts (%s); use update_draft with the draft_id to change one of them", threadID, len(draftIDs), strings.Join(draftIDs, ", "))), nil
+			default:
+				existingDraftID = draftIDs[0]
 			}
 		}
 
-		// Check for existing drafts in this thread and update if found
-		existingDrafts, err := g.getThreadDrafts(threadID)
-		if err == nil && len(existingDrafts) > 0 {
-			// Assume only one draft per thread (as requested)
-			existingDraftID := existingDrafts[0]["draftId"].(string)
-
-			headers += fmt.Sprintf("Subject: %s\r\n", subject)
-			rawMessage := headers + "\r\n" + body
-			message.Raw = base64.URLEncoding.EncodeToString([]byte(rawMessage))
-
-			draft := &gmail.Draft{
-				Id:      existingDraftID,
-				Message: &message,
+		if existingDraftID != "" && mode == draftModeAppend {
+			existing, err := g.mailbox.GetDraft(ctx, existingDraftID)
+			if err != nil || existing.Message == nil {
+				return mcp.NewToolResultError(fmt.Sprintf("Failed to get existing draft %s: %v", existingDraftID, err)), nil
 			}
-
-			updatedDraft, err := g.service.Users.Drafts.Update(g.userID, existingDraftID, draft).Do()
+			previous := strings.ReplaceAll(extractEmailBody(existing.Message), "\r\n", "\n")
+			previous = strings.TrimRight(previous, " \t\n")
+			outgoing.Body = previous + "\n\n" + outgoing.Body
+			var adding int64
+			for _, attachment := range outgoing.Attachments {
+				adding += int64(len(attachment.Data))
+			}
+			kept, err := g.originalAttachments(ctx, existing.Message, adding)
 			if err != nil {
-				return mcp.NewToolResultError(fmt.Sprintf("Failed to update existing draft: %v", err)), nil
+				return mcp.NewToolResultError(err.Error()), nil
 			}
+			outgoing.Attachments = append(kept, outgoing.Attachments...)
+		}
+	}
 
-			result := map[string]interface{}{
-				"draftId": updatedDraft.Id,
-				"message": "Draft updated successfully (existing draft was overwritten)",
-				"action":  "updated",
-				"to":      to,
-				"subject": subject,
-			}
+	raw, err := outgoing.Build()
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to build email: %v", err)), nil
+	}
+	// Gmail API requires base64url-encoded raw message
+	message.Raw = base64.URLEncoding.EncodeToString(raw)
 
-			resultJSON, _ := json.MarshalIndent(result, "", "  ")
-			return mcp.NewToolResultText(string(resultJSON)), nil
-		}
+	result, err := draftResult(outgoing, raw)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to build email: %v", err)), nil
 	}
 
-	// No existing draft found or no thread ID, create new draft
-	headers += fmt.Sprintf("Subject: %s\r\n", subject)
-	rawMessage := headers + "\r\n" + body
+	if existingDraftID != "" {
+		draft := &gmail.Draft{
+			Id:      existingDraftID,
+			Message: &message,
+		}
 
-	// Gmail API requires base64url-encoded raw message
-	message.Raw = base64.URLEncoding.EncodeToString([]byte(rawMessage))
+		updatedDraft, err := g.mailbox.UpdateDraft(ctx, existingDraftID, draft)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to update existing draft: %v", err)), nil
+		}
+
+		result["draftId"] = updatedDraft.Id
+		if mode == draftModeAppend {
+			result["message"] = "Text appended to the thread's existing draft"
+			result["action"] = "appended"
+		} else {
+			result["message"] = "Draft replaced (the thread's existing draft was overwritten)"
+			result["action"] = "replaced"
+		}
 
+		resultJSON, _ := json.MarshalIndent(result, "", "  ")
+		return mcp.NewToolResultText(string(resultJSON)), nil
+	}
+
+	// No existing draft to change, create a new one
 	draft := &gmail.Draft{
 		Message: &message,
 	}
 
-	createdDraft, err := g.service.Users.Drafts.Create(g.userID, draft).Do()
+	createdDraft, err := g.mailbox.CreateDraft(ctx, draft)
 	if err != nil {
 		return mcp.NewToolResultError(fmt.Sprintf("Failed to create draft: %v", err)), nil
 	}
 
-	result := map[string]interface{}{
-		"draftId": createdDraft.Id,
-		"message": "Draft created successfully",
-		"action":  "created",
-		"to":      to,
-		"subject": subject,
-	}
+	result["draftId"] = createdDraft.Id
+	result["message"] = "Draft created successfully"
+	result["action"] = "created"
 
 	resultJSON, _ := json.MarshalIndent(result, "", "  ")
 	return mcp.NewToolResultText(string(resultJSON)), nil
 }
 
 // GetUserProfile gets the user's Gmail profile information
-func (g *GmailServer) GetUserProfile() (*gmail.Profile, error) {
-	profile, err := g.service.Users.GetProfile(g.userID).Do()
+func (g *GmailServer) GetUserProfile(ctx context.Context) (*gmail.Profile, error) {
+	profile, err := g.mailbox.GetProfile(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user profile: %v", err)
 	}
@@ -1263,8 +1469,8 @@ func (g *GmailServer) GetUserProfile() (*gmail.Profile, error) {
 }
 
 // GeneratePersonalEmailStyleGuide analyzes sent emails and generates a tone personalization file
-func GeneratePersonalEmailStyleGuide(gmailServer *GmailServer) error {
-	log.Println("Generating personal email style guide from sent emails...")
+func GeneratePersonalEmailStyleGuide(ctx context.Context, gmailServer *GmailServer) error {
+	log.Printf("Generating personal email style guide for account %s from sent emails...", gmailServer.account)
 
 	// Get OpenAI API key
 	apiKey := os.Getenv("OPENAI_API_KEY")
@@ -1277,7 +1483,7 @@ func GeneratePersonalEmailStyleGuide(gmailServer *GmailServer) error {
 
 	// Get user profile information
 	log.Println("Fetching user profile...")
-	profile, err := gmailServer.GetUserProfile()
+	profile, err := gmailServer.GetUserProfile(ctx)
 	if err != nil {
 		log.Printf("Warning: Could not fetch user profile: %v", err)
 		profile = &gmail.Profile{EmailAddress: "unknown@example.com"}
@@ -1285,7 +1491,7 @@ func GeneratePersonalEmailStyleGuide(gmailServer *GmailServer) error {
 
 	// Get sent emails
 	log.Println("Fetching sent emails...")
-	messages, err := gmailServer.service.Users.Messages.List(gmailServer.userID).Q("in:sent").MaxResults(50).Do()
+	messages, err := gmailServer.mailbox.ListMessages(ctx, "in:sent", 50)
 	if err != nil {
 		return fmt.Errorf("failed to fetch sent messages: %v", err)
 	}
@@ -1294,7 +1500,7 @@ func GeneratePersonalEmailStyleGuide(gmailServer *GmailServer) error {
 	var emailHeaders []map[string]string
 	for _, msg := range messages.Messages {
 		// Get full message
-		fullMsg, err := gmailServer.service.Users.Messages.Get(gmailServer.userID, msg.Id).Do()
+		fullMsg, err := gmailServer.mailbox.GetMessage(ctx, msg.Id)
 		if err != nil {
 			continue
 		}
@@ -1366,7 +1572,7 @@ Start with "# Personal Email Style Guide for %s"`, len(emailBodies), profile.Ema
 
 	// Call OpenAI API
 	log.Println("Generating personal email style guide with OpenAI...")
-	completion, err := client.Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
+	completion, err := client.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
 		Messages: []openai.ChatCompletionMessageParamUnion{
 			{
 				OfUser: &openai.ChatCompletionUserMessageParam{
@@ -1391,13 +1597,13 @@ Start with "# Personal Email Style Guide for %s"`, len(emailBodies), profile.Ema
 	styleGuide := completion.Choices[0].Message.Content
 
 	// Save to file
-	styleFilePath := getAppFilePath("personal-email-style-guide.md")
+	styleFilePath := gmailServer.styleGuidePath()
 	err = os.WriteFile(styleFilePath, []byte(styleGuide), 0644)
 	if err != nil {
 		return fmt.Errorf("failed to write personal email style guide file: %v", err)
 	}
 
-	log.Printf("Successfully generated personal-email-style-guide.md at: %s", styleFilePath)
+	log.Printf("Successfully generated %s at: %s", filepath.Base(styleFilePath), styleFilePath)
 	return nil
 }
 
@@ -1412,7 +1618,7 @@ func extractEmailBody(msg *gmail.Message) string {
 
 	// Check if there's direct body content
 	if msg.Payload.Body != nil && msg.Payload.Body.Data != "" {
-		decoded, err := decodeEmailContent(msg.Payload.Body.Data)
+		decoded, err := decodePartText(msg.Payload)
 		if err == nil {
 			if msg.Payload.MimeType == "text/html" {
 				htmlContent = decoded
@@ -1443,51 +1649,50 @@ func extractEmailBody(msg *gmail.Message) string {
 
 // extractFromParts recursively extracts both plain text and HTML content from message parts
 func extractFromParts(parts []*gmail.MessagePart) (plainText, htmlText string) {
+	plainPart, htmlPart := bodyParts(parts)
+	if plainPart != nil {
+		plainText, _ = decodePartText(plainPart)
+	}
+	if htmlPart != nil {
+		htmlText, _ = decodePartText(htmlPart)
+	}
+	return plainText, htmlText
+}
+
+// bodyParts finds the parts a message's text is read from: the first
+// text/plain and the first text/html part with readable content, depth first
+func bodyParts(parts []*gmail.MessagePart) (plainPart, htmlPart *gmail.MessagePart) {
 	for _, part := range parts {
 		if part.Body != nil && part.Body.Data != "" {
-			decoded, err := decodeEmailContent(part.Body.Data)
-			if err != nil {
+			decoded, err := decodePartText(part)
+			if err != nil || decoded == "" {
 				continue
 			}
 
 			switch part.MimeType {
 			case "text/plain":
-				if plainText == "" { // Take the first plain text part
-					plainText = decoded
+				if plainPart == nil { // Take the first plain text part
+					plainPart = part
 				}
 			case "text/html":
-				if htmlText == "" { // Take the first HTML part
-					htmlText = decoded
+				if htmlPart == nil { // Take the first HTML part
+					htmlPart = part
 				}
 			}
 		}
 
 		// Recursively check nested parts
 		if len(part.Parts) > 0 {
-			nestedPlain, nestedHTML := extractFromParts(part.Parts)
-			if plainText == "" && nestedPlain != "" {
-				plainText = nestedPlain
+			nestedPlain, nestedHTML := bodyParts(part.Parts)
+			if plainPart == nil {
+				plainPart = nestedPlain
 			}
-			if htmlText == "" && nestedHTML != "" {
-				htmlText = nestedHTML
+			if htmlPart == nil {
+				htmlPart = nestedHTML
 			}
 		}
 	}
-	return plainText, htmlText
-}
-
-// decodeEmailContent decodes base64url or base64 encoded email content
-func decodeEmailContent(data string) (string, error) {
-	// Try base64url decoding first (Gmail's preferred encoding)
-	decoded, err := base64.URLEncoding.DecodeString(data)
-	if err != nil {
-		// Try standard base64 if URL encoding fails
-		decoded, err = base64.StdEncoding.DecodeString(data)
-		if err != nil {
-			return "", err
-		}
-	}
-	return string(decoded), nil
+	return plainPart, htmlPart
 }
 
 // extractTextAndLinksFromHTML uses html-to-markdown library to convert HTML to proper markdown with preserved links
@@ -1510,8 +1715,8 @@ func extractAttachmentInfo(message *gmail.Message) []map[string]interface{} {
 		return attachments
 	}
 
-	// Check payload parts for attachments
-	extractAttachmentsFromParts(message.Payload.Parts, &attachments)
+	// A single-part message can itself be the attachment, e.g. a bare PDF
+	extractAttachmentsFromParts([]*gmail.MessagePart{message.Payload}, &attachments)
 
 	return attachments
 }
@@ -1570,7 +1775,7 @@ func isExtractableDocument(mimeType, filename string) bool {
 // ExtractAttachmentText safely extracts text content from an email attachment
 func (g *GmailServer) ExtractAttachmentText(ctx context.Context, messageID, attachmentID string) (*mcp.CallToolResult, error) {
 	// Get the message to extract attachment metadata
-	message, err := g.service.Users.Messages.Get(g.userID, messageID).Do()
+	message, err := g.mailbox.GetMessage(ctx, messageID)
 	if err != nil {
 		return mcp.NewToolResultError(fmt.Sprintf("Failed to get message: %v", err)), nil
 	}
@@ -1585,14 +1790,14 @@ func (g *GmailServer) ExtractAttachmentText(ctx context.Context, messageID, atta
 
 	// Find the attachment part to get metadata
 	var attachmentPart *gmail.MessagePart
-	findAttachmentPart(message.Payload.Parts, attachmentID, &attachmentPart)
+	findAttachmentPart([]*gmail.MessagePart{message.Payload}, attachmentID, &attachmentPart)
 
 	if attachmentPart == nil {
 		return mcp.NewToolResultError(fmt.Sprintf("Attachment not found in message. Available attachments: %v", allAttachments)), nil
 	}
 
 	// Get the attachment data
-	attachment, err := g.service.Users.Messages.Attachments.Get(g.userID, messageID, attachmentID).Do()
+	attachment, err := g.mailbox.GetAttachment(ctx, messageID, attachmentID)
 	if err != nil {
 		return mcp.NewToolResultError(fmt.Sprintf("Failed to get attachment: %v", err)), nil
 	}
@@ -1827,8 +2032,8 @@ func getAppFilePath(filename string) string {
 }
 
 // ensureStyleGuideExists checks if the style guide exists and auto-generates it if needed
-func ensureStyleGuideExists(gmailServer *GmailServer) error {
-	toneFilePath := getAppFilePath("personal-email-style-guide.md")
+func ensureStyleGuideExists(ctx context.Context, gmailServer *GmailServer) error {
+	toneFilePath := gmailServer.styleGuidePath()
 
 	// Check if file already exists
 	if _, err := os.Stat(toneFilePath); err == nil {
@@ -1841,8 +2046,8 @@ func ensureStyleGuideExists(gmailServer *GmailServer) error {
 		return fmt.Errorf("personal email style guide not found at %s and OPENAI_API_KEY not set. Please either set OPENAI_API_KEY for auto-generation or create the file manually", toneFilePath)
 	}
 
-	log.Println("📝 Style guide not found, auto-generating from your sent emails...")
-	if err := GeneratePersonalEmailStyleGuide(gmailServer); err != nil {
+	log.Printf("📝 Style guide for account %s not found, auto-generating from your sent emails...", gmailServer.account)
+	if err := GeneratePersonalEmailStyleGuide(ctx, gmailServer); err != nil {
 		return fmt.Errorf("personal email style guide not found at %s and auto-generation failed: %v. Please create the file manually or set OPENAI_API_KEY", toneFilePath, err)
 	}
 
@@ -1850,6 +2055,27 @@ func ensureStyleGuideExists(gmailServer *GmailServer) error {
 	return nil
 }
 
+// readStyleGuide returns the account's style guide, generating it first if it doesn't exist yet
+func readStyleGuide(ctx context.Context, gmailServer *GmailServer) (string, error) {
+	styleFilePath := gmailServer.styleGuidePath()
+	content, err := os.ReadFile(styleFilePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return "", fmt.Errorf("failed to read style guide at %s: %v", styleFilePath, err)
+		}
+		// Try to auto-generate if file doesn't exist
+		if genErr := ensureStyleGuideExists(ctx, gmailServer); genErr != nil {
+			return "", genErr
+		}
+		// Try reading again after generation
+		content, err = os.ReadFile(styleFilePath)
+		if err != nil {
+			return "", fmt.Errorf("failed to read generated style guide: %v", err)
+		}
+	}
+	return string(content), nil
+}
+
 func main() {
 	// Parse command line arguments for transport mode
 	var useHTTP = false
@@ -1871,19 +2097,47 @@ func main() {
 	}
 
 	// Show file locations early
+	accountNames, primaryAccount, err := configuredAccountNames()
+	if err != nil {
+		log.Fatalf("Invalid account configuration: %v", err)
+	}
 	log.Printf("📁 App data directory: %s", getAppDataDir())
-	log.Printf("🔑 Token file: %s", getAppFilePath("token.json"))
-	log.Printf("📝 Style guide file: %s", getAppFilePath("personal-email-style-guide.md"))
+	for _, name := range accountNames {
+		log.Printf("👤 Account %s:", name)
+		log.Printf("   🔑 Token file: %s", getAppFilePath(tokenFileName(name)))
+		log.Printf("   📝 Style guide file: %s", getAppFilePath(styleGuideFileName(name)))
+	}
 
-	// Create Gmail server instance
-	gmailServer, err := NewGmailServer()
+	// Create one Gmail server instance per configured account
+	accounts, err := NewAccountRegistry()
 	if err != nil {
 		log.Fatalf("Failed to create Gmail server: %v", err)
 	}
+	if err := accounts.LoadEmailAddresses(context.Background()); err != nil {
+		log.Fatalf("Failed to load account profiles: %v", err)
+	}
+
+	// Auto-generate tone personalization files if they don't exist
+	for _, gmailServer := range accounts.All() {
+		if err := ensureStyleGuideExists(context.Background(), gmailServer); err != nil {
+			log.Printf("⚠️  %v", err)
+		}
+	}
+
+	toolTimeouts, err := loadToolTimeouts()
+	if err != nil {
+		log.Fatalf("Failed to configure tool timeouts: %v", err)
+	}
 
-	// Auto-generate tone personalization file if it doesn't exist
-	if err := ensureStyleGuideExists(gmailServer); err != nil {
-		log.Printf("⚠️  %v", err)
+	attachmentPolicy, err := attachmentPolicyFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure attachments: %v", err)
+	}
+	if attachmentPolicy != nil {
+		log.Printf("📎 Attachments allowed from %s", attachmentPolicy.Dir)
+	}
+	for _, gmailServer := range accounts.All() {
+		gmailServer.attachments = attachmentPolicy
 	}
 
 	// Initialize OOB approval session (Agent Cut-Out Pattern)
@@ -1893,7 +2147,7 @@ func main() {
 	}
 
 	// Start the OOB approval web server
-	StartOOBServer(gmailServer)
+	StartOOBServer(accounts)
 
 	// Print the dashboard URL prominently
 	log.Println("")
@@ -1917,49 +2171,52 @@ func main() {
 		server.WithToolCapabilities(true),
 		server.WithResourceCapabilities(true, true),
 		server.WithPromptCapabilities(true),
+		server.WithToolHandlerMiddleware(toolTimeouts.Middleware()),
 	)
 
-	// Add email tone resource
-	toneResource := mcp.NewResource(
-		"file://personal-email-style-guide",
-		"Personal Email Style Guide",
-		mcp.WithResourceDescription("Instructions on how to write emails in the user's personal style and tone"),
-		mcp.WithMIMEType("text/markdown"),
-	)
-
-	mcpServer.AddResource(toneResource, func(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
-		// Try to read from personal-email-style-guide.md file in app data directory
-		toneFilePath := getAppFilePath("personal-email-style-guide.md")
-		content, err := os.ReadFile(toneFilePath)
-		if err != nil {
-			// If file doesn't exist, try to generate it automatically
-			if os.IsNotExist(err) {
-				if genErr := ensureStyleGuideExists(gmailServer); genErr != nil {
-					return nil, genErr
-				}
-				// Try reading again after generation
-				content, err = os.ReadFile(toneFilePath)
-				if err != nil {
-					return nil, fmt.Errorf("failed to read generated style guide: %v", err)
-				}
-			} else {
-				return nil, fmt.Errorf("failed to read style guide at %s: %v", toneFilePath, err)
+	// Add email tone resources, one per account. The unqualified URI always
+	// points at the primary account's guide.
+	addStyleGuideResource := func(uri string, gmailServer *GmailServer) {
+		description := "Instructions on how to write emails in the user's personal style and tone"
+		if len(accountNames) > 1 {
+			description += fmt.Sprintf(" (account: %s)", gmailServer.displayName())
+		}
+		toneResource := mcp.NewResource(
+			uri,
+			"Personal Email Style Guide",
+			mcp.WithResourceDescription(description),
+			mcp.WithMIMEType("text/markdown"),
+		)
+
+		mcpServer.AddResource(toneResource, func(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+			content, err := readStyleGuide(ctx, gmailServer)
+			if err != nil {
+				return nil, err
 			}
-		}
 
-		return []mcp.ResourceContents{
-			mcp.TextResourceContents{
-				URI:      "file://personal-email-style-guide",
-				MIMEType: "text/markdown",
-				Text:     string(content),
-			},
-		}, nil
-	})
+			return []mcp.ResourceContents{
+				mcp.TextResourceContents{
+					URI:      uri,
+					MIMEType: "text/markdown",
+					Text:     content,
+				},
+			}, nil
+		})
+	}
+	for _, gmailServer := range accounts.All() {
+		addStyleGuideResource(styleGuideURI(gmailServer.account), gmailServer)
+	}
+	if primaryAccount != defaultAccountName {
+		addStyleGuideResource(styleGuideURI(defaultAccountName), accounts.Primary())
+	}
 
 	// Add administrative prompts
 	generateTonePrompt := mcp.NewPrompt(
 		"generate-email-tone",
 		mcp.WithPromptDescription("Generate email tone personalization by analyzing your sent emails"),
+		mcp.WithArgument("account",
+			mcp.ArgumentDescription("Account to analyze (defaults to the primary account)"),
+		),
 	)
 
 	mcpServer.AddPrompt(generateTonePrompt, func(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
@@ -1976,8 +2233,20 @@ func main() {
 			}, nil
 		}
 
+		gmailServer, err := accounts.Resolve(request.Params.Arguments["account"])
+		if err != nil {
+			return &mcp.GetPromptResult{
+				Messages: []mcp.PromptMessage{
+					mcp.NewPromptMessage(
+						mcp.RoleUser,
+						mcp.NewTextContent(fmt.Sprintf("❌ %v", err)),
+					),
+				},
+			}, nil
+		}
+
 		// Generate tone personalization
-		err := GeneratePersonalEmailStyleGuide(gmailServer)
+		err = GeneratePersonalEmailStyleGuide(ctx, gmailServer)
 		if err != nil {
 			return &mcp.GetPromptResult{
 				Messages: []mcp.PromptMessage{
@@ -1989,12 +2258,12 @@ func main() {
 			}, nil
 		}
 
-		toneFilePath := getAppFilePath("personal-email-style-guide.md")
+		toneFilePath := gmailServer.styleGuidePath()
 		return &mcp.GetPromptResult{
 			Messages: []mcp.PromptMessage{
 				mcp.NewPromptMessage(
 					mcp.RoleUser,
-					mcp.NewTextContent(fmt.Sprintf("✅ Successfully generated personal email style guide at: %s\n\nYou can now use the file://personal-email-style-guide resource for personalized email writing.", toneFilePath)),
+					mcp.NewTextContent(fmt.Sprintf("✅ Successfully generated personal email style guide at: %s\n\nYou can now use the %s resource for personalized email writing.", toneFilePath, styleGuideURI(gmailServer.account))),
 				),
 			},
 		}, nil
@@ -2006,22 +2275,34 @@ func main() {
 	)
 
 	mcpServer.AddPrompt(statusPrompt, func(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
-		// Check file statuses
-		tokenPath := getAppFilePath("token.json")
-		tonePath := getAppFilePath("personal-email-style-guide.md")
-
-		tokenExists := "❌ Not found"
-		if _, err := os.Stat(tokenPath); err == nil {
-			tokenExists = "✅ Found"
+		fileStatus := func(path string) string {
+			if _, err := os.Stat(path); err == nil {
+				return "✅ Found"
+			}
+			return "❌ Not found"
 		}
 
-		toneExists := "❌ Not found"
-		if _, err := os.Stat(tonePath); err == nil {
-			toneExists = "✅ Found"
+		// Check file statuses for every account
+		var accountStatus strings.Builder
+		for _, gmailServer := range accounts.All() {
+			tokenPath := getAppFilePath(tokenFileName(gmailServer.account))
+			tonePath := gmailServer.styleGuidePath()
+
+			primaryMarker := ""
+			if gmailServer == accounts.Primary() {
+				primaryMarker = " (primary)"
+			}
+			fmt.Fprintf(&accountStatus, "👤 **Account %s**%s\n", gmailServer.displayName(), primaryMarker)
+			fmt.Fprintf(&accountStatus, "   🔑 Token File: %s - %s\n", tokenPath, fileStatus(tokenPath))
+			fmt.Fprintf(&accountStatus, "   📝 Style Guide File: %s - %s\n   Resource: %s\n", tonePath, fileStatus(tonePath), styleGuideURI(gmailServer.account))
+			if mirror, ok := gmailServer.mailbox.(*MailboxMirror); ok {
+				fmt.Fprintf(&accountStatus, "   🪞 Local Mirror: %s\n", mirror.Status())
+			}
+			accountStatus.WriteString("\n")
 		}
 
-		statusMessage := fmt.Sprintf("📊 **Gmail MCP Server Status**\n\n📁 **App Data Directory:** %s\n\n🔑 **Token File:** %s\n   Status: %s\n\n📝 **Style Guide File:** %s\n   Status: %s\n\n🛠️ **Available Commands:**\n- Use /generate-email-tone to create email tone personalization\n- Use tools: search_threads (includes drafts), create_draft (create/update), extract_attachment_by_filename\n- Use resource: file://personal-email-style-guide",
-			getAppDataDir(), tokenPath, tokenExists, tonePath, toneExists)
+		statusMessage := fmt.Sprintf("📊 **Gmail MCP Server Status**\n\n📁 **App Data Directory:** %s\n\n%s🛠️ **Available Commands:**\n- Use /generate-email-tone to create email tone personalization\n- Use tools: search_threads (includes drafts), search_local (needs GMAIL_MIRROR), create_draft, list_drafts/get_draft/update_draft/delete_draft, extract_attachment_by_filename, list_accounts\n- Pass account=<name> to any tool to pick a mailbox\n- Use resource: file://personal-email-style-guide",
+			getAppDataDir(), accountStatus.String())
 
 		return &mcp.GetPromptResult{
 			Messages: []mcp.PromptMessage{
@@ -2033,6 +2314,25 @@ func main() {
 		}, nil
 	})
 
+	// Add List Accounts tool
+	listAccountsTool := mcp.NewTool("list_accounts",
+		mcp.WithDescription("List the Gmail accounts this server can act on. Pass the account name (or email address) as the 'account' argument of other tools; omit it to use the primary account."),
+	)
+
+	mcpServer.AddTool(listAccountsTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		var results []map[string]interface{}
+		for _, gmailServer := range accounts.All() {
+			results = append(results, map[string]interface{}{
+				"account": gmailServer.account,
+				"email":   gmailServer.email,
+				"primary": gmailServer == accounts.Primary(),
+			})
+		}
+
+		resultJSON, _ := json.MarshalIndent(results, "", "  ")
+		return mcp.NewToolResultText(string(resultJSON)), nil
+	})
+
 	// Add Search Threads tool
 	searchThreadsTool := mcp.NewTool("search_threads",
 		mcp.WithDescription(`Search Gmail threads using Gmail's powerful query syntax.
@@ -2089,17 +2389,37 @@ EXAMPLE QUERIES:
   "subject:invoice older_than:30d" - Old invoices
   "has:attachment filename:pdf"  - PDF attachments
   "from:boss@company.com is:unread" - Unread emails from boss
-  "(urgent OR important) newer_than:1d" - Recent urgent/important emails`),
+  "(urgent OR important) newer_than:1d" - Recent urgent/important emails
+
+PAGINATION:
+Results are returned as {threads, page, resultSizeEstimate, hasMore, nextCursor}.
+resultSizeEstimate is Gmail's estimate of the total number of matching threads.
+To get the next page, call again with the SAME query and pass nextCursor as cursor.
+When hasMore is false there are no further pages.
+A thread that could not be loaded appears as {threadId, error} and is counted in failedCount;
+retry it with fetch_email_bodies or repeat the search.`),
 		mcp.WithString("query",
 			mcp.Required(),
 			mcp.Description("Gmail search query using the operators above (e.g., 'from:example@gmail.com', 'subject:meeting', 'is:unread')"),
 		),
 		mcp.WithNumber("max_results",
-			mcp.Description("Maximum number of threads to return (default: 10)"),
+			mcp.Description("Maximum number of threads to return per page (default: 10)"),
 		),
+		mcp.WithString("cursor",
+			mcp.Description("Opaque nextCursor from a previous search_threads result, to fetch the next page of the same query (optional)"),
+		),
+		mcp.WithString("page_token",
+			mcp.Description("Alias for cursor"),
+		),
+		withAccountParam(),
 	)
 
 	mcpServer.AddTool(searchThreadsTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		gmailServer, err := accounts.FromRequest(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
 		query, err := req.RequireString("query")
 		if err != nil {
 			return mcp.NewToolResultError("query parameter is required and must be a string"), nil
@@ -2111,15 +2431,63 @@ EXAMPLE QUERIES:
 			maxResults = int64(mr)
 		}
 
-		return gmailServer.SearchThreads(ctx, query, maxResults)
+		cursor, _ := args["cursor"].(string)
+		if cursor == "" {
+			cursor, _ = args["page_token"].(string)
+		}
+
+		return gmailServer.SearchThreads(ctx, query, maxResults, cursor)
+	})
+
+	// Add Search Local tool - full-text search over the local mirror
+	searchLocalTool := mcp.NewTool("search_local",
+		mcp.WithDescription(`Full-text search over the local mailbox mirror, ranked by relevance (BM25).
+
+Unlike search_threads this runs offline and matches words inside message bodies and
+inside the text of PDF, DOCX and TXT attachments, not just Gmail's own index.
+Requires the server to be started with GMAIL_MIRROR=1; only mirrored threads are searched.
+
+Query syntax:
+- words: every word must appear (e.g., 'invoice overdue')
+- "quoted phrases": words must appear together in that order
+- prefix*: matches any word starting with the prefix (e.g., 'contract*')
+
+Each result has a snippet with matched words wrapped in **bold**. Results with kind
+"attachment" also name the file; use extract_attachment_by_filename for its full text.`),
+		mcp.WithString("query",
+			mcp.Required(),
+			mcp.Description("Words, \"quoted phrases\" and prefix* terms to search for"),
+		),
+		mcp.WithNumber("max_results",
+			mcp.Description("Maximum number of results to return (default: 10, max: 50)"),
+		),
+		withAccountParam(),
+	)
+
+	mcpServer.AddTool(searchLocalTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		gmailServer, err := accounts.FromRequest(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		query, err := req.RequireString("query")
+		if err != nil {
+			return mcp.NewToolResultError("query parameter is required and must be a string"), nil
+		}
+
+		maxResults := 10
+		if mr, ok := req.GetArguments()["max_results"].(float64); ok {
+			maxResults = min(int(mr), 50)
+		}
+
+		return gmailServer.SearchLocal(ctx, query, maxResults)
 	})
 
 	// Add Create Draft tool
 	createDraftTool := mcp.NewTool("create_draft",
-		mcp.WithDescription("Create a Gmail draft email or update an existing draft if one exists for the thread. When a thread_id is provided, this tool will check for existing drafts in that thread and overwrite them, allowing LLMs to iteratively modify draft content. Important: Before writing any email, always request the file://personal-email-style-guide resource to understand the user's writing style and preferences."),
+		mcp.WithDescription("Create a Gmail draft email. When a thread_id is provided the draft is a reply in that thread; if the thread already has a draft, the call is refused unless mode says what to do with it, so a draft the user wrote is never overwritten by accident. To change a specific draft use update_draft; list_drafts and get_draft show what exists. Important: Before writing any email, always request the file://personal-email-style-guide resource to understand the user's writing style and preferences."),
 		mcp.WithString("to",
-			mcp.Required(),
-			mcp.Description("Recipient email address"),
+			mcp.Description("Recipient email address (required unless reply_all is set)"),
 		),
 		mcp.WithString("subject",
 			mcp.Required(),
@@ -2130,24 +2498,30 @@ EXAMPLE QUERIES:
 			mcp.Description("Email body content"),
 		),
 		mcp.WithString("thread_id",
-			mcp.Description("Thread ID if this is a reply (optional). If provided and a draft exists for this thread, the existing draft will be updated instead of creating a new one."),
+			mcp.Description("Thread ID if this is a reply (optional)"),
+		),
+		mcp.WithString("mode",
+			mcp.Description("What to do if the thread already has a draft: 'new' adds another draft, 'replace' overwrites the existing one, 'append' adds this body (and any attachments) to the end of it. Required when the thread has a draft; ignored otherwise"),
+			mcp.Enum(draftModeNew, draftModeReplace, draftModeAppend),
 		),
+		withReplyAllParam(),
+		withCompositionParams(),
+		withAttachmentsParam(),
+		withAccountParam(),
 	)
 
 	mcpServer.AddTool(createDraftTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		to, err := req.RequireString("to")
+		gmailServer, err := accounts.FromRequest(req)
 		if err != nil {
-			return mcp.NewToolResultError("to parameter is required and must be a string"), nil
+			return mcp.NewToolResultError(err.Error()), nil
 		}
 
-		subject, err := req.RequireString("subject")
+		outgoing, err := outgoingFromRequest(req)
 		if err != nil {
-			return mcp.NewToolResultError("subject parameter is required and must be a string"), nil
+			return mcp.NewToolResultError(err.Error()), nil
 		}
-
-		body, err := req.RequireString("body")
-		if err != nil {
-			return mcp.NewToolResultError("body parameter is required and must be a string"), nil
+		if outgoing.Attachments, err = attachmentPolicy.attachmentsFromRequest(req); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
 		}
 
 		threadID := ""
@@ -2155,38 +2529,152 @@ EXAMPLE QUERIES:
 		if tid, ok := args["thread_id"].(string); ok {
 			threadID = tid
 		}
+		replyAll, _ := args["reply_all"].(bool)
+		if replyAll && threadID == "" {
+			return mcp.NewToolResultError("reply_all requires thread_id"), nil
+		}
+
+		mode, _ := args["mode"].(string)
 
-		return gmailServer.CreateDraft(ctx, to, subject, body, threadID)
+		return gmailServer.CreateDraft(ctx, outgoing, threadID, replyAll, mode)
+	})
+
+	listDraftsTool := mcp.NewTool("list_drafts",
+		mcp.WithDescription("List drafts, newest first, with their recipients, subject, thread and a snippet. Results are paginated: pass the returned nextCursor back as cursor to get the next page."),
+		mcp.WithNumber("max_results",
+			mcp.Description("Maximum number of drafts per page (default: 10, max: 50)"),
+		),
+		mcp.WithString("cursor",
+			mcp.Description("nextCursor from a previous list_drafts call, to get the next page"),
+		),
+		withAccountParam(),
+	)
+
+	mcpServer.AddTool(listDraftsTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		gmailServer, err := accounts.FromRequest(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		args := req.GetArguments()
+		maxResults := int64(10)
+		if mr, ok := args["max_results"].(float64); ok && mr > 0 {
+			maxResults = min(int64(mr), 50)
+		}
+		cursor, _ := args["cursor"].(string)
+
+		return gmailServer.ListDrafts(ctx, maxResults, cursor)
+	})
+
+	getDraftTool := mcp.NewTool("get_draft",
+		mcp.WithDescription("Get a draft by ID: its headers (From, To, Cc, Bcc, Subject, threading headers), full body and attachments."),
+		mcp.WithString("draft_id",
+			mcp.Required(),
+			mcp.Description("ID of the draft, as returned by list_drafts or create_draft"),
+		),
+		withAccountParam(),
+	)
+
+	mcpServer.AddTool(getDraftTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		gmailServer, err := accounts.FromRequest(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		draftID, err := req.RequireString("draft_id")
+		if err != nil {
+			return mcp.NewToolResultError("draft_id parameter is required and must be a string"), nil
+		}
+		return gmailServer.GetDraft(ctx, draftID)
+	})
+
+	updateDraftTool := mcp.NewTool("update_draft",
+		mcp.WithDescription("Replace the content of a draft by ID. The draft stays in its thread and keeps replying to the same message; recipients, subject and body are replaced by what you pass. The draft's attachments are kept, and any you pass are added, unless remove_attachments is set. Use get_draft first if you only want to change part of it."),
+		mcp.WithString("draft_id",
+			mcp.Required(),
+			mcp.Description("ID of the draft to update"),
+		),
+		mcp.WithString("to",
+			mcp.Required(),
+			mcp.Description("Recipient email address"),
+		),
+		mcp.WithString("subject",
+			mcp.Required(),
+			mcp.Description("Email subject line"),
+		),
+		mcp.WithString("body",
+			mcp.Required(),
+			mcp.Description("Email body content"),
+		),
+		withCompositionParams(),
+		withAttachmentsParam(),
+		mcp.WithBoolean("remove_attachments",
+			mcp.Description("Drop the draft's existing attachments instead of keeping them (default: false)"),
+		),
+		withAccountParam(),
+	)
+
+	mcpServer.AddTool(updateDraftTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		gmailServer, err := accounts.FromRequest(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		draftID, err := req.RequireString("draft_id")
+		if err != nil {
+			return mcp.NewToolResultError("draft_id parameter is required and must be a string"), nil
+		}
+
+		outgoing, err := outgoingFromRequest(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		if outgoing.Attachments, err = attachmentPolicy.attachmentsFromRequest(req); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		removeAttachments, _ := req.GetArguments()["remove_attachments"].(bool)
+		return gmailServer.UpdateDraft(ctx, draftID, outgoing, removeAttachments)
+	})
+
+	deleteDraftTool := mcp.NewTool("delete_draft",
+		mcp.WithDescription("Permanently delete a draft by ID. Deleted drafts cannot be recovered, so only delete drafts the user asked you to remove or that you created yourself."),
+		mcp.WithString("draft_id",
+			mcp.Required(),
+			mcp.Description("ID of the draft to delete"),
+		),
+		withAccountParam(),
+	)
+
+	mcpServer.AddTool(deleteDraftTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		gmailServer, err := accounts.FromRequest(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		draftID, err := req.RequireString("draft_id")
+		if err != nil {
+			return mcp.NewToolResultError("draft_id parameter is required and must be a string"), nil
+		}
+		return gmailServer.DeleteDraft(ctx, draftID)
 	})
 
 	// TEMPORARY HACK: Add personal email style guide as a tool
 	// This is only needed until more MCP clients support resource-fetching properly
 	// TODO: Remove this tool once resource support is more widespread
 	getStyleGuideTool := mcp.NewTool("get_personal_email_style_guide",
-		mcp.WithDescription("Get the user's personal email writing style guide. IMPORTANT: Always call this tool BEFORE drafting any emails to understand the user's writing style and tone. This is a temporary tool that will be removed once more agents support resource-fetching."),
+		mcp.WithDescription("Get the user's personal email writing style guide. IMPORTANT: Always call this tool BEFORE drafting any emails to understand the user's writing style and tone. Pass the same account you will send from, since each account has its own guide. This is a temporary tool that will be removed once more agents support resource-fetching."),
+		withAccountParam(),
 	)
 
 	mcpServer.AddTool(getStyleGuideTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		// Read the personal email style guide file
-		styleFilePath := getAppFilePath("personal-email-style-guide.md")
-		content, err := os.ReadFile(styleFilePath)
+		gmailServer, err := accounts.FromRequest(req)
 		if err != nil {
-			if os.IsNotExist(err) {
-				// Try to auto-generate if file doesn't exist
-				if genErr := ensureStyleGuideExists(gmailServer); genErr != nil {
-					return mcp.NewToolResultError(genErr.Error()), nil
-				}
-				// Try reading again after generation
-				content, err = os.ReadFile(styleFilePath)
-				if err != nil {
-					return mcp.NewToolResultError(fmt.Sprintf("Failed to read generated style guide: %v", err)), nil
-				}
-			} else {
-				return mcp.NewToolResultError(fmt.Sprintf("Failed to read style guide at %s: %v", styleFilePath, err)), nil
-			}
+			return mcp.NewToolResultError(err.Error()), nil
 		}
 
-		return mcp.NewToolResultText(string(content)), nil
+		content, err := readStyleGuide(ctx, gmailServer)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		return mcp.NewToolResultText(content), nil
 	})
 
 	// Add Extract Attachment By Filename tool - more reliable than attachment ID
@@ -2200,9 +2688,15 @@ EXAMPLE QUERIES:
 			mcp.Required(),
 			mcp.Description("The filename of the attachment to extract (e.g., 'document.pdf', 'CV.docx')"),
 		),
+		withAccountParam(),
 	)
 
 	mcpServer.AddTool(extractByFilenameTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		gmailServer, err := accounts.FromRequest(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
 		messageID, err := req.RequireString("message_id")
 		if err != nil {
 			return mcp.NewToolResultError("message_id parameter is required and must be a string"), nil
@@ -2218,14 +2712,35 @@ EXAMPLE QUERIES:
 
 	// Add Fetch Email Bodies tool for selective full content retrieval
 	fetchEmailBodiesTool := mcp.NewTool("fetch_email_bodies",
-		mcp.WithDescription("Fetch full email bodies for specific threads after browsing with snippets. Can fetch multiple emails at once for efficient selective content retrieval."),
+		mcp.WithDescription("Fetch the full conversation for specific threads after browsing with snippets. Every message is returned in order with its sender, date and body; quoted copies of earlier messages, signatures and legal disclaimers are split out into separate fields, and about 8000 characters per thread are shared between the messages, favouring the most recent. Use latest_only, from_index/to_index or message_ids to read part of a long thread in full. A thread that could not be loaded is returned as {threadId, error}."),
 		mcp.WithString("thread_ids",
 			mcp.Required(),
 			mcp.Description("A comma-separated list of thread IDs to fetch full email content for (e.g., 'id1,id2,id3')"),
 		),
+		mcp.WithBoolean("latest_only",
+			mcp.Description("Return only the most recent message of each thread (default: false)"),
+		),
+		mcp.WithNumber("from_index",
+			mcp.Description("First message to return, counting from 1 in thread order (optional)"),
+		),
+		mcp.WithNumber("to_index",
+			mcp.Description("Last message to return, inclusive (optional)"),
+		),
+		mcp.WithString("message_ids",
+			mcp.Description("A comma-separated list of message IDs; only these messages are returned (optional)"),
+		),
+		mcp.WithBoolean("include_quoted",
+			mcp.Description("Also return each message's quoted history in full instead of just its length (default: false)"),
+		),
+		withAccountParam(),
 	)
 
 	mcpServer.AddTool(fetchEmailBodiesTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		gmailServer, err := accounts.FromRequest(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
 		threadIDsStr, err := req.RequireString("thread_ids")
 		if err != nil {
 			return mcp.NewToolResultError("thread_ids parameter is required and must be a string"), nil
@@ -2246,7 +2761,25 @@ EXAMPLE QUERIES:
 			return mcp.NewToolResultError("Maximum 20 thread_ids allowed per request"), nil
 		}
 
-		return gmailServer.FetchEmailBodies(ctx, threadIDs)
+		var opts ConversationOptions
+		args := req.GetArguments()
+		opts.LatestOnly, _ = args["latest_only"].(bool)
+		opts.IncludeQuoted, _ = args["include_quoted"].(bool)
+		if from, ok := args["from_index"].(float64); ok {
+			opts.FromIndex = int(from)
+		}
+		if to, ok := args["to_index"].(float64); ok {
+			opts.ToIndex = int(to)
+		}
+		if ids, ok := args["message_ids"].(string); ok {
+			for _, id := range strings.Split(ids, ",") {
+				if id = strings.TrimSpace(id); id != "" {
+					opts.MessageIDs = append(opts.MessageIDs, id)
+				}
+			}
+		}
+
+		return gmailServer.FetchEmailBodies(ctx, threadIDs, opts)
 	})
 
 	// Add Get OOB Dashboard URL tool
@@ -2273,23 +2806,24 @@ This tool implements zero-trust email sending:
 - Server executes the send only after user approves
 
 How it works:
-1. You call this tool with to, subject, body
-2. Server creates a draft and sends approval request to user's phone
-3. User receives push notification with email preview
+1. You call this tool with to, subject, body (and optionally account)
+2. Server creates a draft in that account and sends approval request to user's phone
+3. User receives push notification with email preview, including the sending account
 4. User taps Approve or Reject on their phone
 5. This tool waits for the response (up to 5 minutes)
 6. If approved, the email is sent and tool returns success
 
 Returns on success:
-- {status: "sent", message: "...", to: "...", subject: "..."}
+- {status: "sent", message: "...", account: "...", from: "...", to: [...], cc: [...], bcc: [...], subject: "...", attachments: [{filename, mimeType, size, sha256}]}
+
+The recipients, subject and attachments in the approval request and the result are read back from the built message, so they are exactly what Gmail receives. Header values containing line breaks or control characters, and addresses that do not parse, are rejected before any draft is created.
 
 Returns on rejection or timeout:
 - Error message explaining what happened
 
 NOTE: This tool blocks until the user responds on their phone. Tell the user to check their ntfy app.`),
 		mcp.WithString("to",
-			mcp.Required(),
-			mcp.Description("Recipient email address"),
+			mcp.Description("Recipient email address (required unless reply_all is set)"),
 		),
 		mcp.WithString("subject",
 			mcp.Required(),
@@ -2300,104 +2834,144 @@ NOTE: This tool blocks until the user responds on their phone. Tell the user to
 			mcp.Description("Email body content"),
 		),
 		mcp.WithString("thread_id",
-			mcp.Description("Thread ID if this is a reply (optional). If provided and a draft exists for this thread, the existing draft will be updated instead of creating a new one."),
+			mcp.Description("Thread ID if this is a reply (optional)"),
 		),
+		withReplyAllParam(),
+		withCompositionParams(),
+		withAttachmentsParam(),
+		withAccountParam(),
 	)
 
 	mcpServer.AddTool(sendEmailATOTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		to, err := req.RequireString("to")
+		gmailServer, err := accounts.FromRequest(req)
 		if err != nil {
-			return mcp.NewToolResultError("to parameter is required"), nil
+			return mcp.NewToolResultError(err.Error()), nil
 		}
-		subject, err := req.RequireString("subject")
+
+		outgoing, err := outgoingFromRequest(req)
 		if err != nil {
-			return mcp.NewToolResultError("subject parameter is required"), nil
+			return mcp.NewToolResultError(err.Error()), nil
 		}
-		body, err := req.RequireString("body")
-		if err != nil {
-			return mcp.NewToolResultError("body parameter is required"), nil
+		if outgoing.Attachments, err = attachmentPolicy.attachmentsFromRequest(req); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
 		}
 		threadID, _ := req.RequireString("thread_id") // optional
-
-		// Create draft internally
-		var message gmail.Message
-		headers := fmt.Sprintf("To: %s\r\nSubject: %s\r\n", to, subject)
+		replyAll, _ := req.GetArguments()["reply_all"].(bool)
+		if replyAll && threadID == "" {
+			return mcp.NewToolResultError("reply_all requires thread_id"), nil
+		}
 
 		if threadID != "" {
-			message.ThreadId = threadID
-			if !strings.HasPrefix(strings.ToLower(subject), "re:") {
-				subject = "Re: " + subject
-				headers = fmt.Sprintf("To: %s\r\nSubject: %s\r\n", to, subject)
+			if err := gmailServer.prepareReply(ctx, &outgoing, threadID, replyAll); err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
 			}
 		}
+		return gmailServer.SendWithApproval(ctx, outgoing, threadID)
+	})
 
-		rawMessage := headers + "\r\n" + body
-		message.Raw = base64.URLEncoding.EncodeToString([]byte(rawMessage))
+	sendDraftTool := mcp.NewTool("send_draft",
+		mcp.WithDescription(`Send an existing draft, exactly as it is saved, after the user approves it on their phone.
 
-		draft := &gmail.Draft{Message: &message}
-		createdDraft, err := gmailServer.service.Users.Drafts.Create(gmailServer.userID, draft).Do()
-		if err != nil {
-			return mcp.NewToolResultError(fmt.Sprintf("Failed to create draft: %v", err)), nil
-		}
+Use this after iterating on a draft with create_draft or update_draft. The approval request shows the draft's real recipients, subject, body and attachments (with size and SHA-256), read from the saved draft rather than from anything you pass. Approval works exactly like send_email_ato: this tool blocks until the user approves or rejects the push notification (up to 5 minutes), and nothing is sent otherwise.
 
-		draftID := createdDraft.Id
-		log.Printf("📝 Draft created internally: id=%s to=%s subject=%s", draftID, to, subject)
+Returns on success the same result as send_email_ato.`),
+		mcp.WithString("draft_id",
+			mcp.Required(),
+			mcp.Description("ID of the draft to send, as returned by create_draft or list_drafts"),
+		),
+		withAccountParam(),
+	)
 
-		// Send to approval daemon for mobile push approval (blocking)
-		log.Printf("📱 Sending to approval daemon for mobile push approval...")
-		resp, err := sendToDaemon(map[string]string{
-			"action":   "queue_email",
-			"to":       to,
-			"subject":  subject,
-			"body":     body,
-			"draft_id": draftID,
-		})
+	mcpServer.AddTool(sendDraftTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		gmailServer, err := accounts.FromRequest(req)
 		if err != nil {
 			return mcp.NewToolResultError(err.Error()), nil
 		}
 
-		success, _ := resp["success"].(bool)
-		if !success {
-			errMsg, _ := resp["error"].(string)
-			return mcp.NewToolResultError(errMsg), nil
+		draftID, err := req.RequireString("draft_id")
+		if err != nil {
+			return mcp.NewToolResultError("draft_id parameter is required and must be a string"), nil
 		}
+		return gmailServer.SendExistingDraft(ctx, draftID)
+	})
+
+	forwardMessageTool := mcp.NewTool("forward_message",
+		mcp.WithDescription(`Forward an existing email, with its attachments, after the user approves it on their phone.
+
+The forward quotes the original sender, date, subject and recipients above the original text, re-attaches every original attachment and stays in the original conversation. It is refused, naming the files at fault, if the attachments exceed the per-file or per-email size limit. Approval works exactly like send_email_ato: this tool blocks until the user approves or rejects the push notification (up to 5 minutes), and the approval lists the recipients and every attachment with its size and SHA-256.
+
+Returns on success the same result as send_email_ato.`),
+		mcp.WithString("message_id",
+			mcp.Required(),
+			mcp.Description("ID of the message to forward"),
+		),
+		mcp.WithString("to",
+			mcp.Required(),
+			mcp.Description("Comma-separated recipients of the forward"),
+		),
+		mcp.WithString("cc",
+			mcp.Description("Comma-separated Cc recipients (optional)"),
+		),
+		mcp.WithString("bcc",
+			mcp.Description("Comma-separated Bcc recipients (optional)"),
+		),
+		mcp.WithString("note",
+			mcp.Description("Text to put above the forwarded message, e.g. 'Please pay this by Friday' (optional)"),
+		),
+		withAccountParam(),
+	)
 
-		// Approved - send the draft
-		log.Printf("✅ Email approved, sending draft...")
-		err = gmailServer.SendDraft(draftID)
+	mcpServer.AddTool(forwardMessageTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		gmailServer, err := accounts.FromRequest(req)
 		if err != nil {
-			return mcp.NewToolResultError(fmt.Sprintf("approved but failed to send: %v", err)), nil
+			return mcp.NewToolResultError(err.Error()), nil
 		}
 
-		log.Printf("📧 Email sent successfully: to=%s subject=%s", to, subject)
+		messageID, err := req.RequireString("message_id")
+		if err != nil {
+			return mcp.NewToolResultError("message_id parameter is required and must be a string"), nil
+		}
+		var outgoing OutgoingMessage
+		if outgoing.To, err = req.RequireString("to"); err != nil {
+			return mcp.NewToolResultError("to parameter is required and must be a string"), nil
+		}
+		args := req.GetArguments()
+		outgoing.Cc, _ = args["cc"].(string)
+		outgoing.Bcc, _ = args["bcc"].(string)
+		note, _ := args["note"].(string)
 
-		resultJSON, _ := json.MarshalIndent(map[string]interface{}{
-			"status":  "sent",
-			"message": "Email approved and sent successfully",
-			"to":      to,
-			"subject": subject,
-		}, "", "  ")
-		return mcp.NewToolResultText(string(resultJSON)), nil
+		threadID, err := gmailServer.PrepareForward(ctx, &outgoing, messageID, note)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return gmailServer.SendWithApproval(ctx, outgoing, threadID)
 	})
 
 	// Start the server
 	if useHTTP {
-		log.Printf("Starting Gmail MCP Server in HTTP mode on port %s...", port)
-		log.Printf("✅ Server will run persistently at http://localhost:%s", port)
+		access, err := httpAccessFromEnv()
+		if err != nil {
+			log.Fatalf("Failed to configure HTTP mode: %v", err)
+		}
+		baseURL := access.BaseURL(port)
+
+		log.Printf("Starting Gmail MCP Server in HTTP mode on %s...", access.Addr(port))
+		log.Printf("✅ Server will run persistently at %s", baseURL)
 		log.Printf("   OAuth will only be required once at startup!")
 		log.Printf("   (Use Ctrl+C to stop the server)")
 
 		// Run Gmail server authentication once at startup
 		log.Println("🔐 Authenticating with Gmail (one-time only)...")
 
-		// Test Gmail connection to ensure OAuth is working
-		_, err := gmailServer.service.Users.GetProfile(gmailServer.userID).Do()
-		if err != nil {
-			log.Fatalf("Gmail authentication failed: %v", err)
+		// Test Gmail connection to ensure OAuth is working for every account
+		for _, gmailServer := range accounts.All() {
+			if _, err := gmailServer.GetUserProfile(context.Background()); err != nil {
+				log.Fatalf("Gmail authentication failed for account %s: %v", gmailServer.account, err)
+			}
 		}
 		log.Println("✅ Gmail authentication successful!")
 
-		// Create HTTP server with CORS support for browser clients
+		// Create HTTP server; the MCP endpoints only admit allowed origins and hosts
 		mux := http.NewServeMux()
 
 		// Add basic info endpoint
@@ -2408,34 +2982,43 @@ NOTE: This tool blocks until the user responds on their phone. Tell the user to
 <head><title>Gmail MCP Server</title></head>
 <body>
 <h1>📧 Gmail MCP Server</h1>
-<p><strong>Status:</strong> Running in HTTP mode on port %s</p>
+<p><strong>Status:</strong> Running in HTTP mode on %s</p>
 <p><strong>Cursor Configuration:</strong></p>
 <pre>
 {
   "mcpServers": {
     "gmail-http": {
-      "url": "http://localhost:%s"
+      "url": "%s/mcp"
     }
   }
 }
 </pre>
 <p><em>Copy the above configuration to your Cursor MCP settings.</em></p>
+<p><strong>Endpoints:</strong></p>
+<ul>
+<li><code>/mcp</code> - Streamable HTTP transport (recommended)</li>
+<li><code>/sse</code> + <code>/message</code> - Legacy SSE transport</li>
+</ul>
 <h2>Available Tools:</h2>
 <ul>
 <li>search_threads - Search Gmail with powerful query syntax</li>
-<li>create_draft - Create/update email drafts</li>
+<li>search_local - Full-text search of the local mirror, including attachments</li>
+<li>create_draft - Create email drafts</li>
+<li>list_drafts, get_draft, update_draft, delete_draft - Manage drafts by ID</li>
 <li>extract_attachment_by_filename - Extract text from attachments</li>
 <li>fetch_email_bodies - Get full email content</li>
 <li>get_personal_email_style_guide - Get writing style guide</li>
+<li>send_email_ato - Send email with out-of-band approval</li>
+<li>send_draft - Send an existing draft with out-of-band approval</li>
+<li>forward_message - Forward an email and its attachments with out-of-band approval</li>
 </ul>
 </body>
-</html>`, port, port)
+</html>`, template.HTMLEscapeString(access.Addr(port)), template.HTMLEscapeString(baseURL))
 		})
 
 		// Add health check endpoint
 		mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "application/json")
-			w.Header().Set("Access-Control-Allow-Origin", "*")
 
 			status := map[string]interface{}{
 				"status":              "healthy",
@@ -2448,46 +3031,38 @@ NOTE: This tool blocks until the user responds on their phone. Tell the user to
 			json.NewEncoder(w).Encode(status)
 		})
 
-		// Add MCP endpoint (simplified HTTP-based MCP)
-		mux.HandleFunc("/mcp", func(w http.ResponseWriter, r *http.Request) {
-			// Enable CORS
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-			if r.Method == "OPTIONS" {
-				w.WriteHeader(http.StatusOK)
-				return
-			}
-
-			w.Header().Set("Content-Type", "application/json")
-
-			// Simple implementation - for full MCP support, you'd need
-			// to implement the complete JSON-RPC protocol here
-			response := map[string]interface{}{
-				"jsonrpc": "2.0",
-				"result": map[string]interface{}{
-					"message":       "Gmail MCP Server HTTP endpoint",
-					"note":          "For full MCP support, use stdio mode. HTTP mode is experimental.",
-					"stdio_command": os.Args[0], // Path to this binary
-				},
-			}
-
-			json.NewEncoder(w).Encode(response)
-		})
-
-		log.Printf("🌐 HTTP server starting on http://localhost:%s", port)
-		log.Printf("📖 View server info: http://localhost:%s", port)
-		log.Printf("🔍 Health check: http://localhost:%s/health", port)
+		// Streamable HTTP transport (MCP spec 2025-03-26): POST for requests,
+		// GET for the server-to-client stream, DELETE to end a session
+		streamableServer := server.NewStreamableHTTPServer(mcpServer,
+			server.WithEndpointPath("/mcp"),
+			server.WithHeartbeatInterval(30*time.Second),
+		)
+		mux.Handle("/mcp", access.Wrap(streamableServer))
+
+		// Legacy HTTP+SSE transport for clients that predate Streamable HTTP
+		sseServer := server.NewSSEServer(mcpServer,
+			server.WithBaseURL(baseURL),
+			server.WithSSEEndpoint("/sse"),
+			server.WithMessageEndpoint("/message"),
+			server.WithKeepAlive(true),
+		)
+		mux.Handle("/sse", access.Wrap(sseServer))
+		mux.Handle("/message", access.Wrap(sseServer))
+
+		log.Printf("🌐 HTTP server starting on %s", baseURL)
+		log.Printf("📖 View server info: %s", baseURL)
+		log.Printf("🔍 Health check: %s/health", baseURL)
 		log.Println()
 		log.Println("🎯 TO CONNECT CURSOR:")
-		log.Printf("   1. For now, use stdio mode (recommended)")
-		log.Printf("   2. In Cursor MCP settings, use command: %s", os.Args[0])
-		log.Printf("   3. Or wait for full HTTP MCP transport support")
+		log.Printf("   Streamable HTTP: %s/mcp", baseURL)
+		log.Printf("   Legacy SSE:      %s/sse", baseURL)
+		if access.Token != "" {
+			log.Println("🔑 Clients must send Authorization: Bearer <GMAIL_HTTP_TOKEN>")
+		}
 
 		// Start HTTP server
 		httpServer := &http.Server{
-			Addr:    ":" + port,
+			Addr:    access.Addr(port),
 			Handler: mux,
 		}
 
@@ -2509,7 +3084,7 @@ NOTE: This tool blocks until the user responds on their phone. Tell the user to
 // This is more reliable than using attachment IDs which are unstable in Gmail API
 func (g *GmailServer) ExtractAttachmentByFilename(ctx context.Context, messageID, filename string) (*mcp.CallToolResult, error) {
 	// Get the message to find attachments
-	message, err := g.service.Users.Messages.Get(g.userID, messageID).Do()
+	message, err := g.mailbox.GetMessage(ctx, messageID)
 	if err != nil {
 		return mcp.NewToolResultError(fmt.Sprintf("Failed to get message: %v", err)), nil
 	}
@@ -2525,7 +3100,7 @@ func (g *GmailServer) ExtractAttachmentByFilename(ctx context.Context, messageID
 		if attachment["filename"] == filename {
 			targetAttachment = attachment
 			attachmentID := attachment["attachmentId"].(string)
-			findAttachmentPart(message.Payload.Parts, attachmentID, &attachmentPart)
+			findAttachmentPart([]*gmail.MessagePart{message.Payload}, attachmentID, &attachmentPart)
 			break
 		}
 	}
@@ -2544,7 +3119,7 @@ func (g *GmailServer) ExtractAttachmentByFilename(ctx context.Context, messageID
 
 	// Get the attachment data using the current attachment ID
 	attachmentID := targetAttachment["attachmentId"].(string)
-	attachment, err := g.service.Users.Messages.Attachments.Get(g.userID, messageID, attachmentID).Do()
+	attachment, err := g.mailbox.GetAttachment(ctx, messageID, attachmentID)
 	if err != nil {
 		return mcp.NewToolResultError(fmt.Sprintf("Failed to get attachment data: %v", err)), nil
 	}
@@ -2575,46 +3150,40 @@ func (g *GmailServer) ExtractAttachmentByFilename(ctx context.Context, messageID
 }
 
 // FetchEmailBodies fetches full email content for multiple threads
-func (g *GmailServer) FetchEmailBodies(ctx context.Context, threadIDs []string) (*mcp.CallToolResult, error) {
+func (g *GmailServer) FetchEmailBodies(ctx context.Context, threadIDs []string, opts ConversationOptions) (*mcp.CallToolResult, error) {
 	var results []map[string]interface{}
 
+	// One drafts listing serves every requested thread
+	drafts, err := g.loadDraftIndex(ctx)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+	}
+
 	for _, threadID := range threadIDs {
 		// Get thread details directly from Gmail API
-		threadDetail, err := g.service.Users.Threads.Get(g.userID, threadID).Do()
+		threadDetail, err := g.mailbox.GetThread(ctx, threadID)
 		if err != nil {
+			// Report the failure in place rather than silently dropping the thread
 			log.Printf("Warning: Failed to get thread %s: %v", threadID, err)
+			results = append(results, threadFailure(threadID, err))
 			continue
 		}
 
-		if len(threadDetail.Messages) == 0 {
+		// Drafts are neither counted nor numbered
+		conversation := withoutDrafts(threadDetail.Messages)
+		messages, positions := selectMessages(conversation, opts)
+		if len(messages) == 0 {
+			results = append(results, map[string]interface{}{
+				"threadId":     threadID,
+				"messageCount": len(conversation),
+				"messages":     []map[string]interface{}{},
+			})
 			continue
 		}
 
-		// Extract details from the first message
-		firstMessage := threadDetail.Messages[0]
-		var subject, from string
-
-		// Extract headers
-		for _, header := range firstMessage.Payload.Headers {
-			switch header.Name {
-			case "Subject":
-				subject = header.Value
-			case "From":
-				from = header.Value
-			}
-		}
-
-		// Extract full email body content with markdown formatting
-		fullBody := extractEmailBody(firstMessage)
-
-		// Limit full body to prevent overwhelming the context (8000 chars = ~2000 tokens)
-		if len(fullBody) > 8000 {
-			fullBody = fullBody[:8000] + "\n\n[Content truncated - email is longer than 8000 characters]"
-		}
-
-		// Collect attachment information from all messages in the thread
+		// Collect attachment information from the returned messages
 		var allAttachments []map[string]interface{}
-		for _, message := range threadDetail.Messages {
+		for _, message := range messages {
 			attachments := extractAttachmentInfo(message)
 			for _, attachment := range attachments {
 				// Add message ID to each attachment for reference
@@ -2624,18 +3193,18 @@ func (g *GmailServer) FetchEmailBodies(ctx context.Context, threadIDs []string)
 		}
 
 		// Get existing drafts for this thread
-		existingDrafts, err := g.getThreadDrafts(threadID)
-		if err != nil {
-			log.Printf("Warning: Failed to get drafts for thread %s: %v", threadID, err)
-			existingDrafts = []map[string]interface{}{}
+		existingDrafts := drafts.forThread(ctx, threadID)
+
+		var subject string
+		if first := threadDetail.Messages[0]; first.Payload != nil {
+			subject = headerValue(first.Payload.Headers, "Subject")
 		}
 
 		threadResult := map[string]interface{}{
 			"threadId":     threadID,
 			"subject":      subject,
-			"from":         from,
-			"fullBody":     fullBody,
-			"messageCount": len(threadDetail.Messages),
+			"messageCount": len(conversation),
+			"messages":     renderConversation(messages, positions, opts),
 		}
 
 		// Only include attachments if there are any
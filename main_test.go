@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// fakeApprovalDaemon answers the approval socket in place of
+// gmail-approval-daemon, recording every request it is sent
+type fakeApprovalDaemon struct {
+	mu       sync.Mutex
+	requests []map[string]interface{}
+}
+
+// startFakeApprovalDaemon points HOME at a temporary directory and serves the
+// approval socket there, answering each request with decide
+func startFakeApprovalDaemon(t *testing.T, decide func(req map[string]interface{}) map[string]interface{}) *fakeApprovalDaemon {
+	t.Helper()
+	// Unix socket paths are short; t.TempDir() names can exceed the limit
+	home, err := os.MkdirTemp("", "gmail-mcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(home) })
+	t.Setenv("HOME", home)
+
+	socketDir := filepath.Join(home, ".config", "gmail-mcp")
+	if err := os.MkdirAll(socketDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("unix", filepath.Join(socketDir, "approval.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	daemon := &fakeApprovalDaemon{}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var req map[string]interface{}
+				if err := json.NewDecoder(conn).Decode(&req); err != nil {
+					return
+				}
+				daemon.mu.Lock()
+				daemon.requests = append(daemon.requests, req)
+				daemon.mu.Unlock()
+				json.NewEncoder(conn).Encode(decide(req))
+			}()
+		}
+	}()
+	return daemon
+}
+
+// approveAll approves every request for the content it was shown
+func approveAll(req map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"success": true, "status": "approved", "content_hash": req["content_hash"]}
+}
+
+func (d *fakeApprovalDaemon) received() []map[string]interface{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]map[string]interface{}(nil), d.requests...)
+}
+
+// toolRequest builds a tool call with the given arguments
+func toolRequest(name string, args map[string]interface{}) mcp.CallToolRequest {
+	var req mcp.CallToolRequest
+	req.Params.Name = name
+	req.Params.Arguments = args
+	return req
+}
+
+// resultText returns the text of a tool result
+func resultText(t *testing.T, result *mcp.CallToolResult) string {
+	t.Helper()
+	if result == nil || len(result.Content) == 0 {
+		t.Fatal("empty tool result")
+	}
+	text, ok := result.Content[0].(mcp.TextContent)
+	if !ok {
+		t.Fatalf("tool result is %T, not text", result.Content[0])
+	}
+	return text.Text
+}
+
+// decodeResult parses a successful tool result's JSON into v
+func decodeResult(t *testing.T, result *mcp.CallToolResult, v interface{}) {
+	t.Helper()
+	text := resultText(t, result)
+	if result.IsError {
+		t.Fatalf("tool error: %s", text)
+	}
+	if err := json.Unmarshal([]byte(text), v); err != nil {
+		t.Fatalf("result is not JSON: %v\n%s", err, text)
+	}
+}
+
+// newFakeServer returns a GmailServer for me@example.com backed by a FakeMailbox
+func newFakeServer(t *testing.T, fixtures ...FixtureMessage) (*GmailServer, *FakeMailbox) {
+	t.Helper()
+	mailbox, err := NewFakeMailbox("me@example.com", fixtures...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewGmailServerWithMailbox("default", mailbox)
+	server.email = "me@example.com"
+	return server, mailbox
+}
+
+// reportThreads is five threads from the boss, newest last, plus one from
+// someone else; the second report has an attachment
+func reportThreads() []FixtureMessage {
+	start := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	var fixtures []FixtureMessage
+	for i := 1; i <= 5; i++ {
+		fixture := FixtureMessage{
+			ID:       fmt.Sprintf("report%d", i),
+			ThreadID: fmt.Sprintf("thread%d", i),
+			From:     "Boss <boss@example.com>",
+			To:       "me@example.com",
+			Subject:  fmt.Sprintf("Report %d", i),
+			Date:     start.Add(time.Duration(i) * time.Hour),
+			Body:     fmt.Sprintf("Please review report %d.", i),
+		}
+		if i == 2 {
+			fixture.Attachments = []FixtureAttachment{{Filename: "numbers.csv", MimeType: "text/csv", Data: []byte("a,b\n1,2\n")}}
+		}
+		fixtures = append(fixtures, fixture)
+	}
+	return append(fixtures, FixtureMessage{
+		ID:       "lunch",
+		ThreadID: "thread-lunch",
+		From:     "friend@example.com",
+		To:       "me@example.com",
+		Subject:  "Lunch?",
+		Date:     start.Add(10 * time.Hour),
+		Body:     "Noodles at noon?",
+	})
+}
+
+// plansThread is a one-message thread from Alice
+var plansThread = FixtureMessage{
+	ID:        "m1",
+	ThreadID:  "t1",
+	From:      "Alice <alice@example.com>",
+	To:        "me@example.com",
+	Subject:   "Plans",
+	MessageID: "<m1@example.com>",
+	Body:      "Dinner on Friday?",
+}
+
+type searchPage struct {
+	Threads []struct {
+		ThreadID     string                   `json:"threadId"`
+		Subject      string                   `json:"subject"`
+		From         string                   `json:"from"`
+		MessageCount int                      `json:"messageCount"`
+		Attachments  []map[string]interface{} `json:"attachments"`
+		Drafts       []map[string]interface{} `json:"drafts"`
+	} `json:"threads"`
+	Page       int    `json:"page"`
+	HasMore    bool   `json:"hasMore"`
+	NextCursor string `json:"nextCursor"`
+}
+
+func TestSearchThreadsPagesWithCursor(t *testing.T) {
+	server, mailbox := newFakeServer(t, reportThreads()...)
+	ctx := context.Background()
+	// A draft reply in one thread shows up in its result
+	if _, err := server.CreateDraft(ctx, OutgoingMessage{To: "boss@example.com", Subject: "Report 4", Body: "On it"}, "thread4", false, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var subjects []string
+	cursor := ""
+	for page := 1; ; page++ {
+		result, err := server.SearchThreads(ctx, "from:boss", 2, cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got searchPage
+		decodeResult(t, result, &got)
+		if got.Page != page {
+			t.Fatalf("page = %d, want %d", got.Page, page)
+		}
+		for _, thread := range got.Threads {
+			subjects = append(subjects, thread.Subject)
+			if thread.Subject == "Report 2" && (len(thread.Attachments) != 1 || thread.Attachments[0]["filename"] != "numbers.csv") {
+				t.Errorf("Report 2 attachments = %v, want numbers.csv", thread.Attachments)
+			}
+			if (thread.Subject == "Report 4") != (len(thread.Drafts) == 1) {
+				t.Errorf("%s drafts = %v, want one draft only on Report 4", thread.Subject, thread.Drafts)
+			}
+		}
+		if !got.HasMore {
+			if got.NextCursor != "" {
+				t.Fatal("last page has a cursor")
+			}
+			break
+		}
+		if len(got.Threads) != 2 {
+			t.Fatalf("page %d has %d threads, want 2", page, len(got.Threads))
+		}
+		cursor = got.NextCursor
+	}
+	if got := strings.Join(subjects, ", "); got != "Report 5, Report 4, Report 3, Report 2, Report 1" {
+		t.Fatalf("threads across pages = %s, want each report once, newest first", got)
+	}
+
+	// A cursor only continues the query it came from
+	first, _ := server.SearchThreads(ctx, "from:boss", 2, "")
+	var got searchPage
+	decodeResult(t, first, &got)
+	result, err := server.SearchThreads(ctx, "lunch", 2, got.NextCursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.IsError || !strings.Contains(resultText(t, result), "different query") {
+		t.Fatalf("cursor reused for another query: %s", resultText(t, result))
+	}
+	if len(mailbox.SentMessages()) != 0 {
+		t.Fatal("searching sent mail")
+	}
+}
+
+func TestFetchEmailBodiesSelectsMessages(t *testing.T) {
+	start := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	var fixtures []FixtureMessage
+	for i := 1; i <= 3; i++ {
+		fixtures = append(fixtures, FixtureMessage{
+			ID:        fmt.Sprintf("m%d", i),
+			ThreadID:  "t1",
+			From:      "alice@example.com",
+			To:        "me@example.com",
+			Subject:   "Trip",
+			MessageID: fmt.Sprintf("<m%d@example.com>", i),
+			Date:      start.Add(time.Duration(i) * time.Hour),
+			Body:      fmt.Sprintf("Message number %d.", i),
+		})
+	}
+	server, _ := newFakeServer(t, fixtures...)
+	ctx := context.Background()
+
+	type fetched struct {
+		ThreadID     string `json:"threadId"`
+		Subject      string `json:"subject"`
+		MessageCount int    `json:"messageCount"`
+		Error        string `json:"error"`
+		Messages     []struct {
+			Index     int    `json:"index"`
+			MessageID string `json:"messageId"`
+			Body      string `json:"body"`
+		} `json:"messages"`
+	}
+	fetch := func(threadIDs []string, opts ConversationOptions) []fetched {
+		t.Helper()
+		result, err := server.FetchEmailBodies(ctx, threadIDs, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var threads []fetched
+		decodeResult(t, result, &threads)
+		return threads
+	}
+
+	all := fetch([]string{"t1"}, ConversationOptions{})
+	if len(all) != 1 || all[0].Subject != "Trip" || all[0].MessageCount != 3 || len(all[0].Messages) != 3 {
+		t.Fatalf("whole thread = %+v", all)
+	}
+	for i, msg := range all[0].Messages {
+		if msg.Index != i+1 || msg.Body != fmt.Sprintf("Message number %d.", i+1) {
+			t.Errorf("message %d = %+v", i+1, msg)
+		}
+	}
+
+	latest := fetch([]string{"t1"}, ConversationOptions{LatestOnly: true})
+	if len(latest[0].Messages) != 1 || latest[0].Messages[0].MessageID != "m3" || latest[0].Messages[0].Index != 3 {
+		t.Fatalf("latest only = %+v", latest[0].Messages)
+	}
+
+	ranged := fetch([]string{"t1"}, ConversationOptions{FromIndex: 2, ToIndex: 2})
+	if len(ranged[0].Messages) != 1 || ranged[0].Messages[0].MessageID != "m2" {
+		t.Fatalf("index range = %+v", ranged[0].Messages)
+	}
+
+	// A missing thread is reported in place, not dropped
+	mixed := fetch([]string{"missing", "t1"}, ConversationOptions{LatestOnly: true})
+	if len(mixed) != 2 || mixed[0].ThreadID != "missing" || mixed[0].Error == "" || mixed[1].ThreadID != "t1" {
+		t.Fatalf("missing thread = %+v", mixed)
+	}
+}
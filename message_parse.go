@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// numberParts assigns Gmail-style part IDs ("", "0", "0.1", ...)
+func numberParts(part *gmail.MessagePart, id string) {
+	part.PartId = id
+	for i, child := range part.Parts {
+		childID := strconv.Itoa(i)
+		if id != "" {
+			childID = id + "." + childID
+		}
+		numberParts(child, childID)
+	}
+}
+
+// parseRawMessage converts an RFC 822 message into the MessagePart tree the Gmail API returns.
+// Attachment bodies are handed to storeAttachment, which returns the attachment ID to record.
+func parseRawMessage(raw []byte, storeAttachment func([]byte) string) (*gmail.MessagePart, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse message: %w", err)
+	}
+
+	part, err := parseMIMEPart(textproto.MIMEHeader(msg.Header), msg.Body, storeAttachment)
+	if err != nil {
+		return nil, err
+	}
+	numberParts(part, "")
+	return part, nil
+}
+
+func parseMIMEPart(header textproto.MIMEHeader, body io.Reader, storeAttachment func([]byte) string) (*gmail.MessagePart, error) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Gmail returns header values with RFC 2047 encoded words decoded
+	var decoder mime.WordDecoder
+	part := &gmail.MessagePart{}
+	for _, name := range names {
+		for _, value := range header[name] {
+			if decoded, err := decoder.DecodeHeader(value); err == nil {
+				value = decoded
+			}
+			part.Headers = append(part.Headers, &gmail.MessagePartHeader{Name: name, Value: value})
+		}
+	}
+
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+	part.MimeType = mediaType
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		part.Body = &gmail.MessagePartBody{}
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			child, err := reader.NextRawPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read multipart body: %w", err)
+			}
+			parsed, err := parseMIMEPart(child.Header, child, storeAttachment)
+			if err != nil {
+				return nil, err
+			}
+			part.Parts = append(part.Parts, parsed)
+		}
+		return part, nil
+	}
+
+	var decoded io.Reader = body
+	switch strings.ToLower(strings.TrimSpace(header.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		decoded = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		decoded = quotedprintable.NewReader(body)
+	}
+	data, err := io.ReadAll(decoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s part: %w", mediaType, err)
+	}
+
+	if _, dispParams, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil {
+		part.Filename = dispParams["filename"]
+	}
+	if part.Filename == "" {
+		part.Filename = params["name"]
+	}
+
+	if part.Filename != "" && storeAttachment != nil {
+		part.Body = &gmail.MessagePartBody{
+			AttachmentId: storeAttachment(data),
+			Size:         int64(len(data)),
+		}
+	} else {
+		part.Body = &gmail.MessagePartBody{
+			Data: base64.URLEncoding.EncodeToString(data),
+			Size: int64(len(data)),
+		}
+	}
+	return part, nil
+}
+
+// headerValue returns the first header with the given name (case-insensitive)
+func headerValue(headers []*gmail.MessagePartHeader, name string) string {
+	for _, header := range headers {
+		if strings.EqualFold(header.Name, name) {
+			return header.Value
+		}
+	}
+	return ""
+}
+
+// copyMessage returns a deep-enough copy that callers can't mutate the original
+func copyMessage(msg *gmail.Message) *gmail.Message {
+	data, _ := json.Marshal(msg)
+	var out gmail.Message
+	json.Unmarshal(data, &out)
+	return &out
+}
+
+// decodeRawMessage decodes base64url data from Gmail, such as a message's
+// "raw" field or an attachment body, with or without padding
+func decodeRawMessage(raw string) ([]byte, error) {
+	decoded, err := base64.URLEncoding.DecodeString(raw)
+	if err != nil {
+		decoded, err = base64.RawURLEncoding.DecodeString(raw)
+	}
+	return decoded, err
+}
@@ -0,0 +1,879 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// Mirror defaults, overridable with GMAIL_MIRROR_QUERY and GMAIL_MIRROR_MAX_THREADS
+const (
+	defaultMirrorQuery      = "newer_than:30d"
+	defaultMirrorMaxThreads = 500
+	mirrorSyncInterval      = 30 * time.Second
+	mirrorMaxStaleness      = 2 * time.Minute
+	mirrorReadSyncTimeout   = 10 * time.Second
+)
+
+var mirrorIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// MirrorConfig controls what the local mirror keeps
+type MirrorConfig struct {
+	Query        string        // Gmail query selecting the threads fetched by a full sync
+	MaxThreads   int           // upper bound on mirrored threads; the least recently active are evicted
+	MaxStaleness time.Duration // reads fall back to Gmail if the last sync is older than this
+}
+
+// mirrorConfigFromEnv returns the mirror configuration, or nil if GMAIL_MIRROR is not enabled
+func mirrorConfigFromEnv() (*MirrorConfig, error) {
+	enabled, _ := strconv.ParseBool(os.Getenv("GMAIL_MIRROR"))
+	if !enabled {
+		return nil, nil
+	}
+
+	config := &MirrorConfig{
+		Query:        defaultMirrorQuery,
+		MaxThreads:   defaultMirrorMaxThreads,
+		MaxStaleness: mirrorMaxStaleness,
+	}
+	if query := strings.TrimSpace(os.Getenv("GMAIL_MIRROR_QUERY")); query != "" {
+		config.Query = query
+	}
+	if raw := os.Getenv("GMAIL_MIRROR_MAX_THREADS"); raw != "" {
+		maxThreads, err := strconv.Atoi(raw)
+		if err != nil || maxThreads <= 0 {
+			return nil, fmt.Errorf("invalid GMAIL_MIRROR_MAX_THREADS %q", raw)
+		}
+		config.MaxThreads = maxThreads
+	}
+	return config, nil
+}
+
+// MirrorListener is told about every thread the mirror stores, updates or drops.
+// Calls happen outside the mirror's locks and receive copies.
+type MirrorListener interface {
+	ThreadUpdated(thread *gmail.Thread)
+	ThreadRemoved(threadID string)
+}
+
+// mirrorState is persisted as state.json next to the mirrored threads
+type mirrorState struct {
+	HistoryID  uint64    `json:"historyId,string"` // changes up to here are reflected locally
+	Query      string    `json:"query"`            // query of the last full sync
+	FullSyncAt time.Time `json:"fullSyncAt"`
+}
+
+// MailboxMirror is a Mailbox that keeps complete threads on disk and serves
+// thread and message reads locally while it is fresh. It does a full sync
+// of the threads matching its query, then follows Users.History.List from the
+// last historyId; if Gmail has expired that historyId it syncs from scratch.
+// Searches and every write go straight to the wrapped live mailbox.
+type MailboxMirror struct {
+	Mailbox // live mailbox
+
+	dir    string
+	config MirrorConfig
+
+	syncMu sync.Mutex // serializes syncs
+
+	mu            sync.Mutex
+	state         mirrorState
+	threads       map[string]*gmail.Thread
+	messageThread map[string]string // message ID -> thread ID
+	checkedAt     time.Time         // last successful sync; zero until the first one this run
+	listeners     []MirrorListener
+}
+
+// NewMailboxMirror opens (or creates) a mirror of live stored in dir
+func NewMailboxMirror(live Mailbox, dir string, config MirrorConfig) (*MailboxMirror, error) {
+	if config.MaxStaleness <= 0 {
+		config.MaxStaleness = mirrorMaxStaleness
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "threads"), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create mirror directory: %v", err)
+	}
+
+	m := &MailboxMirror{
+		Mailbox:       live,
+		dir:           dir,
+		config:        config,
+		threads:       make(map[string]*gmail.Thread),
+		messageThread: make(map[string]string),
+	}
+	if err := m.load(); err != nil {
+		// A damaged mirror is only a cache: start over with a full sync
+		log.Printf("⚠️  Discarding local mirror in %s: %v", dir, err)
+		m.state = mirrorState{}
+		m.threads = make(map[string]*gmail.Thread)
+		m.messageThread = make(map[string]string)
+	}
+	return m, nil
+}
+
+// load reads state.json and the thread files from disk
+func (m *MailboxMirror) load() error {
+	data, err := os.ReadFile(filepath.Join(m.dir, "state.json"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &m.state); err != nil {
+		return fmt.Errorf("invalid state.json: %v", err)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(m.dir, "threads"))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(m.dir, "threads", entry.Name()))
+		if err != nil {
+			return err
+		}
+		var thread gmail.Thread
+		if err := json.Unmarshal(data, &thread); err != nil {
+			return fmt.Errorf("invalid thread file %s: %v", entry.Name(), err)
+		}
+		m.putThreadLocked(&thread)
+	}
+	return nil
+}
+
+// Subscribe registers a listener and replays the threads already mirrored to it
+func (m *MailboxMirror) Subscribe(listener MirrorListener) {
+	m.mu.Lock()
+	m.listeners = append(m.listeners, listener)
+	existing := make([]*gmail.Thread, 0, len(m.threads))
+	for _, thread := range m.threads {
+		existing = append(existing, copyThread(thread))
+	}
+	m.mu.Unlock()
+
+	for _, thread := range existing {
+		listener.ThreadUpdated(thread)
+	}
+}
+
+// notify passes thread changes on to listeners
+func (m *MailboxMirror) notify(updated []*gmail.Thread, removed []string) {
+	m.mu.Lock()
+	listeners := append([]MirrorListener(nil), m.listeners...)
+	m.mu.Unlock()
+
+	for _, listener := range listeners {
+		for _, threadID := range removed {
+			listener.ThreadRemoved(threadID)
+		}
+		for _, thread := range updated {
+			listener.ThreadUpdated(thread)
+		}
+	}
+}
+
+// Run keeps the mirror in sync until ctx is cancelled
+func (m *MailboxMirror) Run(ctx context.Context) {
+	ticker := time.NewTicker(mirrorSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.Sync(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("⚠️  Mirror sync failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync brings the mirror up to date, doing a full sync when there is no usable historyId
+func (m *MailboxMirror) Sync(ctx context.Context) error {
+	m.syncMu.Lock()
+	defer m.syncMu.Unlock()
+	return m.syncLocked(ctx, true)
+}
+
+// syncLocked runs an incremental sync, falling back to a full one if allowed
+func (m *MailboxMirror) syncLocked(ctx context.Context, allowFull bool) error {
+	m.mu.Lock()
+	state := m.state
+	m.mu.Unlock()
+
+	if state.HistoryID == 0 || state.Query != m.config.Query {
+		if !allowFull {
+			return errMirrorNeedsFullSync
+		}
+		return m.fullSync(ctx)
+	}
+
+	err := m.incrementalSync(ctx, state.HistoryID)
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == 404 {
+		if !allowFull {
+			return errMirrorNeedsFullSync
+		}
+		log.Printf("🔄 Mirror historyId %d has expired, re-syncing from scratch", state.HistoryID)
+		return m.fullSync(ctx)
+	}
+	return err
+}
+
+var errMirrorNeedsFullSync = errors.New("mirror needs a full sync")
+
+// fullSync replaces the mirror with the threads currently matching the query
+func (m *MailboxMirror) fullSync(ctx context.Context) error {
+	started := time.Now()
+
+	// Take the checkpoint first: anything that changes while we copy threads
+	// will be replayed by the next incremental sync
+	profile, err := m.Mailbox.GetProfile(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get mailbox history ID: %v", err)
+	}
+
+	threadIDs, err := m.listQueryThreads(ctx)
+	if err != nil {
+		return err
+	}
+
+	threads, err := m.fetchThreads(ctx, threadIDs)
+	if err != nil {
+		return err
+	}
+
+	// Swap in the new contents and rewrite the directory
+	m.mu.Lock()
+	previous := m.threads
+	m.threads = make(map[string]*gmail.Thread)
+	m.messageThread = make(map[string]string)
+	for _, thread := range threads {
+		m.putThreadLocked(thread)
+	}
+	m.state = mirrorState{
+		HistoryID:  profile.HistoryId,
+		Query:      m.config.Query,
+		FullSyncAt: time.Now(),
+	}
+	m.checkedAt = time.Now()
+	var removed []string
+	for threadID := range previous {
+		if _, kept := m.threads[threadID]; !kept {
+			removed = append(removed, threadID)
+		}
+	}
+	m.mu.Unlock()
+
+	updated := make([]*gmail.Thread, 0, len(threads))
+	for _, thread := range threads {
+		updated = append(updated, copyThread(thread))
+	}
+	m.notify(updated, removed)
+
+	if err := os.RemoveAll(filepath.Join(m.dir, "threads")); err != nil {
+		return fmt.Errorf("failed to clear mirror: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(m.dir, "threads"), 0700); err != nil {
+		return fmt.Errorf("failed to create mirror directory: %v", err)
+	}
+	for _, thread := range threads {
+		if err := m.writeThread(thread); err != nil {
+			return err
+		}
+	}
+	if err := m.writeState(); err != nil {
+		return err
+	}
+
+	log.Printf("📥 Mirror synced %d threads in %s", len(threads), time.Since(started).Round(time.Millisecond))
+	return nil
+}
+
+// listQueryThreads returns the IDs of the newest threads matching the query, up to MaxThreads
+func (m *MailboxMirror) listQueryThreads(ctx context.Context) ([]string, error) {
+	var threadIDs []string
+	pageToken := ""
+	for len(threadIDs) < m.config.MaxThreads {
+		list, err := m.Mailbox.ListThreads(ctx, m.config.Query, int64(min(m.config.MaxThreads-len(threadIDs), 500)), pageToken)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list threads: %v", err)
+		}
+		for _, thread := range list.Threads {
+			threadIDs = append(threadIDs, thread.Id)
+		}
+		if list.NextPageToken == "" {
+			break
+		}
+		pageToken = list.NextPageToken
+	}
+	return threadIDs, nil
+}
+
+// fetchThreads downloads full threads with a bounded number of concurrent requests.
+// Threads deleted in the meantime are skipped.
+func (m *MailboxMirror) fetchThreads(ctx context.Context, threadIDs []string) ([]*gmail.Thread, error) {
+	threads := make([]*gmail.Thread, len(threadIDs))
+	errs := make([]error, len(threadIDs))
+
+	sem := make(chan struct{}, threadFetchWorkers)
+	var wg sync.WaitGroup
+	for i, threadID := range threadIDs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			threads[i], errs[i] = m.Mailbox.GetThread(ctx, threadID)
+		}()
+	}
+	wg.Wait()
+
+	var fetched []*gmail.Thread
+	for i, thread := range threads {
+		if isNotFound(errs[i]) {
+			continue
+		}
+		if errs[i] != nil {
+			return nil, fmt.Errorf("failed to fetch thread %s: %v", threadIDs[i], errs[i])
+		}
+		fetched = append(fetched, thread)
+	}
+	return fetched, nil
+}
+
+// threadsInQuery reports which of threads match the mirror's query. Each
+// thread is looked up by its messages' Message-IDs, so only these threads are
+// searched for; a thread whose messages carry no Message-ID never matches.
+func (m *MailboxMirror) threadsInQuery(ctx context.Context, threads []*gmail.Thread) (map[string]bool, error) {
+	matches := make(map[string]bool, len(threads))
+	if m.config.Query == "" {
+		for _, thread := range threads {
+			matches[thread.Id] = true
+		}
+		return matches, nil
+	}
+
+	found := make([]bool, len(threads))
+	errs := make([]error, len(threads))
+	sem := make(chan struct{}, threadFetchWorkers)
+	var wg sync.WaitGroup
+	for i, thread := range threads {
+		var ids []string
+		for _, msg := range thread.Messages {
+			if msg.Payload == nil {
+				continue
+			}
+			if id := strings.Trim(headerValue(msg.Payload.Headers, "Message-ID"), "<> "); id != "" {
+				ids = append(ids, "rfc822msgid:"+id)
+			}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		query := fmt.Sprintf("(%s) {%s}", m.config.Query, strings.Join(ids, " "))
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			list, err := m.Mailbox.ListMessages(ctx, query, 1)
+			found[i], errs[i] = err == nil && len(list.Messages) > 0, err
+		}()
+	}
+	wg.Wait()
+
+	for i, thread := range threads {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("failed to check thread %s against the mirror query: %v", thread.Id, errs[i])
+		}
+		matches[thread.Id] = found[i]
+	}
+	return matches, nil
+}
+
+// incrementalSync applies every history record after startHistoryID
+func (m *MailboxMirror) incrementalSync(ctx context.Context, startHistoryID uint64) error {
+	var records []*gmail.History
+	latest := startHistoryID
+	pageToken := ""
+	for {
+		page, err := m.Mailbox.ListHistory(ctx, startHistoryID, pageToken)
+		if err != nil {
+			return err
+		}
+		records = append(records, page.History...)
+		if page.HistoryId > latest {
+			latest = page.HistoryId
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		pageToken = page.NextPageToken
+	}
+
+	// Label changes are applied in place; new messages (drafts included, since
+	// saving a draft adds a message to its thread) mean re-fetching the thread
+	refetch := make(map[string]bool)
+	deleted := make(map[string]bool)
+	changed := make(map[string]bool)
+
+	m.mu.Lock()
+	for _, record := range records {
+		for _, added := range record.MessagesAdded {
+			if added.Message == nil {
+				continue
+			}
+			refetch[added.Message.ThreadId] = true
+		}
+		for _, removed := range record.MessagesDeleted {
+			if removed.Message == nil {
+				continue
+			}
+			if threadID, ok := m.removeMessageLocked(removed.Message.Id); ok {
+				if _, stillThere := m.threads[threadID]; stillThere {
+					changed[threadID] = true
+				} else {
+					deleted[threadID] = true
+				}
+			}
+		}
+		for _, labeled := range record.LabelsAdded {
+			if labeled.Message != nil {
+				if threadID, ok := m.relabelLocked(labeled.Message.Id, labeled.LabelIds, nil); ok {
+					changed[threadID] = true
+				}
+			}
+		}
+		for _, unlabeled := range record.LabelsRemoved {
+			if unlabeled.Message != nil {
+				if threadID, ok := m.relabelLocked(unlabeled.Message.Id, nil, unlabeled.LabelIds); ok {
+					changed[threadID] = true
+				}
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	threadIDs := make([]string, 0, len(refetch))
+	for threadID := range refetch {
+		threadIDs = append(threadIDs, threadID)
+	}
+	threads, err := m.fetchThreads(ctx, threadIDs)
+	if err != nil {
+		return err
+	}
+
+	// Threads new to the mirror are only taken in if they match the query,
+	// the same as a full sync would
+	var unmirrored []*gmail.Thread
+	m.mu.Lock()
+	for _, thread := range threads {
+		if _, ok := m.threads[thread.Id]; !ok {
+			unmirrored = append(unmirrored, thread)
+		}
+	}
+	m.mu.Unlock()
+	inQuery, err := m.threadsInQuery(ctx, unmirrored)
+	if err != nil {
+		return err
+	}
+	outOfScope := make(map[string]bool)
+	for _, thread := range unmirrored {
+		if !inQuery[thread.Id] {
+			outOfScope[thread.Id] = true
+		}
+	}
+
+	m.mu.Lock()
+	fetched := make(map[string]bool)
+	for _, thread := range threads {
+		fetched[thread.Id] = true
+		if outOfScope[thread.Id] {
+			continue
+		}
+		m.putThreadLocked(thread)
+		changed[thread.Id] = true
+		delete(deleted, thread.Id)
+	}
+	// A thread listed in history but gone by the time we asked for it was deleted
+	for _, threadID := range threadIDs {
+		if !fetched[threadID] {
+			m.removeThreadLocked(threadID)
+			deleted[threadID] = true
+			delete(changed, threadID)
+		}
+	}
+	for _, threadID := range m.evictLocked() {
+		deleted[threadID] = true
+		delete(changed, threadID)
+	}
+
+	var toWrite []*gmail.Thread
+	for threadID := range changed {
+		if thread, ok := m.threads[threadID]; ok {
+			toWrite = append(toWrite, copyThread(thread))
+		}
+	}
+	m.state.HistoryID = latest
+	m.checkedAt = time.Now()
+	m.mu.Unlock()
+
+	for _, thread := range toWrite {
+		if err := m.writeThread(thread); err != nil {
+			return err
+		}
+	}
+	removed := make([]string, 0, len(deleted))
+	for threadID := range deleted {
+		os.Remove(m.threadPath(threadID))
+		removed = append(removed, threadID)
+	}
+	m.notify(toWrite, removed)
+	if len(records) > 0 {
+		log.Printf("🔄 Mirror applied %d history records (%d threads updated, %d removed)", len(records), len(toWrite), len(deleted))
+	}
+	return m.writeState()
+}
+
+// putThreadLocked stores a thread and indexes its messages
+func (m *MailboxMirror) putThreadLocked(thread *gmail.Thread) {
+	if old, ok := m.threads[thread.Id]; ok {
+		for _, msg := range old.Messages {
+			delete(m.messageThread, msg.Id)
+		}
+	}
+	m.threads[thread.Id] = thread
+	for _, msg := range thread.Messages {
+		m.messageThread[msg.Id] = thread.Id
+	}
+}
+
+func (m *MailboxMirror) removeThreadLocked(threadID string) {
+	if thread, ok := m.threads[threadID]; ok {
+		for _, msg := range thread.Messages {
+			delete(m.messageThread, msg.Id)
+		}
+		delete(m.threads, threadID)
+	}
+}
+
+// removeMessageLocked drops one message, and its thread if that was the last message
+func (m *MailboxMirror) removeMessageLocked(messageID string) (threadID string, ok bool) {
+	threadID, ok = m.messageThread[messageID]
+	if !ok {
+		return "", false
+	}
+	delete(m.messageThread, messageID)
+
+	thread := m.threads[threadID]
+	for i, msg := range thread.Messages {
+		if msg.Id == messageID {
+			thread.Messages = append(thread.Messages[:i], thread.Messages[i+1:]...)
+			break
+		}
+	}
+	if len(thread.Messages) == 0 {
+		delete(m.threads, threadID)
+	}
+	return threadID, true
+}
+
+// relabelLocked applies a label change to a mirrored message
+func (m *MailboxMirror) relabelLocked(messageID string, add, remove []string) (threadID string, ok bool) {
+	threadID, ok = m.messageThread[messageID]
+	if !ok {
+		return "", false
+	}
+	for _, msg := range m.threads[threadID].Messages {
+		if msg.Id == messageID {
+			msg.LabelIds = applyLabelChanges(msg.LabelIds, add, remove)
+		}
+	}
+	return threadID, true
+}
+
+// evictLocked drops the least recently active threads beyond MaxThreads
+func (m *MailboxMirror) evictLocked() []string {
+	if len(m.threads) <= m.config.MaxThreads {
+		return nil
+	}
+
+	ids := make([]string, 0, len(m.threads))
+	for threadID := range m.threads {
+		ids = append(ids, threadID)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return lastActivity(m.threads[ids[i]]) > lastActivity(m.threads[ids[j]])
+	})
+
+	evicted := ids[m.config.MaxThreads:]
+	for _, threadID := range evicted {
+		m.removeThreadLocked(threadID)
+	}
+	return evicted
+}
+
+// lastActivity is the internal date of a thread's newest message
+func lastActivity(thread *gmail.Thread) int64 {
+	var latest int64
+	for _, msg := range thread.Messages {
+		latest = max(latest, msg.InternalDate)
+	}
+	return latest
+}
+
+func (m *MailboxMirror) threadPath(threadID string) string {
+	return filepath.Join(m.dir, "threads", threadID+".json")
+}
+
+// writeThread persists one thread; IDs that aren't safe file names are kept in memory only
+func (m *MailboxMirror) writeThread(thread *gmail.Thread) error {
+	if !mirrorIDPattern.MatchString(thread.Id) {
+		return nil
+	}
+	data, err := json.Marshal(thread)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(m.threadPath(thread.Id), data); err != nil {
+		return fmt.Errorf("failed to write mirrored thread: %v", err)
+	}
+	return nil
+}
+
+func (m *MailboxMirror) writeState() error {
+	m.mu.Lock()
+	data, err := json.MarshalIndent(m.state, "", "  ")
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(m.dir, "state.json"), data); err != nil {
+		return fmt.Errorf("failed to write mirror state: %v", err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into place
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// fresh reports whether the mirror synced recently enough to answer reads.
+// If it is stale it tries a quick incremental sync first; full syncs are left
+// to Run so a read never waits for one.
+func (m *MailboxMirror) fresh(ctx context.Context) bool {
+	m.mu.Lock()
+	isFresh := !m.checkedAt.IsZero() && time.Since(m.checkedAt) < m.config.MaxStaleness
+	m.mu.Unlock()
+	if isFresh {
+		return true
+	}
+
+	// Don't queue behind a sync that is already running
+	if !m.syncMu.TryLock() {
+		return false
+	}
+	defer m.syncMu.Unlock()
+
+	syncCtx, cancel := context.WithTimeout(ctx, mirrorReadSyncTimeout)
+	defer cancel()
+	if err := m.syncLocked(syncCtx, false); err != nil {
+		if err != errMirrorNeedsFullSync && ctx.Err() == nil {
+			log.Printf("⚠️  Mirror sync failed, reading from Gmail: %v", err)
+		}
+		return false
+	}
+	return true
+}
+
+// lookupThread returns a copy of a mirrored thread
+func (m *MailboxMirror) lookupThread(threadID string) (*gmail.Thread, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	thread, ok := m.threads[threadID]
+	if !ok {
+		return nil, false
+	}
+	return copyThread(thread), true
+}
+
+// GetThread serves a fresh mirrored thread, otherwise asks Gmail and remembers the answer
+func (m *MailboxMirror) GetThread(ctx context.Context, threadID string) (*gmail.Thread, error) {
+	if m.fresh(ctx) {
+		if thread, ok := m.lookupThread(threadID); ok {
+			return thread, nil
+		}
+	}
+
+	thread, err := m.Mailbox.GetThread(ctx, threadID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Once there is a history checkpoint, later changes to this thread will be
+	// replayed by incremental syncs, so it is safe to keep, as long as it is
+	// one the mirror would hold anyway
+	m.mu.Lock()
+	checkpointed := m.state.HistoryID != 0
+	m.mu.Unlock()
+	if !checkpointed {
+		return thread, nil
+	}
+	inQuery, err := m.threadsInQuery(ctx, []*gmail.Thread{thread})
+	if err != nil {
+		log.Printf("⚠️  %v", err)
+	}
+	m.mu.Lock()
+	if inQuery[thread.Id] && m.state.HistoryID != 0 {
+		m.putThreadLocked(copyThread(thread))
+		m.mu.Unlock()
+		if err := m.writeThread(thread); err != nil {
+			log.Printf("⚠️  %v", err)
+		}
+		m.notify([]*gmail.Thread{copyThread(thread)}, nil)
+	} else {
+		m.mu.Unlock()
+	}
+	return thread, nil
+}
+
+func (m *MailboxMirror) GetThreadMetadata(ctx context.Context, threadID string, headers []string) (*gmail.Thread, error) {
+	if m.fresh(ctx) {
+		if thread, ok := m.lookupThread(threadID); ok {
+			return metadataThread(thread, headers), nil
+		}
+	}
+	return m.Mailbox.GetThreadMetadata(ctx, threadID, headers)
+}
+
+func (m *MailboxMirror) GetMessage(ctx context.Context, messageID string) (*gmail.Message, error) {
+	if m.fresh(ctx) {
+		m.mu.Lock()
+		threadID, ok := m.messageThread[messageID]
+		if ok {
+			for _, msg := range m.threads[threadID].Messages {
+				if msg.Id == messageID {
+					copied := copyMessage(msg)
+					m.mu.Unlock()
+					return copied, nil
+				}
+			}
+		}
+		m.mu.Unlock()
+	}
+	return m.Mailbox.GetMessage(ctx, messageID)
+}
+
+// markStale makes the next read sync before it trusts the mirror, after a
+// write that changed the mailbox
+func (m *MailboxMirror) markStale() {
+	m.mu.Lock()
+	m.checkedAt = time.Time{}
+	m.mu.Unlock()
+}
+
+// SendMessage marks the mirror stale so the sent message shows up on the next read
+func (m *MailboxMirror) SendMessage(ctx context.Context, msg *gmail.Message) (*gmail.Message, error) {
+	sent, err := m.Mailbox.SendMessage(ctx, msg)
+	m.markStale()
+	return sent, err
+}
+
+// CreateDraft marks the mirror stale: a draft is a message in its thread
+func (m *MailboxMirror) CreateDraft(ctx context.Context, draft *gmail.Draft) (*gmail.Draft, error) {
+	created, err := m.Mailbox.CreateDraft(ctx, draft)
+	m.markStale()
+	return created, err
+}
+
+// UpdateDraft marks the mirror stale so the thread shows the new draft content
+func (m *MailboxMirror) UpdateDraft(ctx context.Context, draftID string, draft *gmail.Draft) (*gmail.Draft, error) {
+	updated, err := m.Mailbox.UpdateDraft(ctx, draftID, draft)
+	m.markStale()
+	return updated, err
+}
+
+// DeleteDraft marks the mirror stale so the thread no longer shows the draft
+func (m *MailboxMirror) DeleteDraft(ctx context.Context, draftID string) error {
+	err := m.Mailbox.DeleteDraft(ctx, draftID)
+	m.markStale()
+	return err
+}
+
+// Status summarizes the mirror for server-status
+func (m *MailboxMirror) Status() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.state.HistoryID == 0 {
+		return fmt.Sprintf("initial sync pending (%s)", m.dir)
+	}
+	lastSync := "not yet synced this run"
+	if !m.checkedAt.IsZero() {
+		lastSync = fmt.Sprintf("last synced %s ago", time.Since(m.checkedAt).Round(time.Second))
+	}
+	return fmt.Sprintf("%d threads matching %q, %s (%s)", len(m.threads), m.state.Query, lastSync, m.dir)
+}
+
+// copyThread deep-copies a thread so callers can't modify the mirror
+func copyThread(thread *gmail.Thread) *gmail.Thread {
+	data, _ := json.Marshal(thread)
+	var out gmail.Thread
+	json.Unmarshal(data, &out)
+	return &out
+}
+
+// applyLabelChanges returns labels with add appended and remove taken out
+func applyLabelChanges(labels, add, remove []string) []string {
+	var result []string
+	for _, label := range labels {
+		if !hasLabel(remove, label) {
+			result = append(result, label)
+		}
+	}
+	for _, label := range add {
+		if !hasLabel(result, label) && !hasLabel(remove, label) {
+			result = append(result, label)
+		}
+	}
+	return result
+}
+
+func hasLabel(labels []string, label string) bool {
+	for _, l := range labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// isNotFound reports whether err is a Gmail 404
+func isNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == 404
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// newTestMirror mirrors the INBOX of a fake mailbox holding an inbox thread
+// t1 and a promotions thread t2
+func newTestMirror(t *testing.T) (*MailboxMirror, *FakeMailbox) {
+	t.Helper()
+	fake, err := NewFakeMailbox("me@example.com",
+		FixtureMessage{ID: "m1", ThreadID: "t1", From: "alice@example.com", Subject: "Plans", Body: "Lunch?"},
+		FixtureMessage{ID: "m2", ThreadID: "t2", From: "shop@example.com", Subject: "Sale", Body: "50% off", Labels: []string{"CATEGORY_PROMOTIONS"}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mirror, err := NewMailboxMirror(fake, t.TempDir(), MirrorConfig{Query: "label:INBOX", MaxThreads: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mirror, fake
+}
+
+// countingThreadLists counts how often the thread list is searched
+type countingThreadLists struct {
+	*FakeMailbox
+	lists *atomic.Int32
+}
+
+func (m countingThreadLists) ListThreads(ctx context.Context, query string, maxResults int64, pageToken string) (*gmail.ListThreadsResponse, error) {
+	m.lists.Add(1)
+	return m.FakeMailbox.ListThreads(ctx, query, maxResults, pageToken)
+}
+
+// mirroredThreads returns the sorted IDs of the threads held in memory
+func mirroredThreads(m *MailboxMirror) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ids []string
+	for threadID := range m.threads {
+		ids = append(ids, threadID)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func currentMirrorState(m *MailboxMirror) mirrorState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state
+}
+
+func TestMirrorFirstSync(t *testing.T) {
+	mirror, fake := newTestMirror(t)
+	if err := mirror.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := mirroredThreads(mirror); !slices.Equal(got, []string{"t1"}) {
+		t.Fatalf("mirrored %v, want [t1]", got)
+	}
+	profile, _ := fake.GetProfile(context.Background())
+	if state := currentMirrorState(mirror); state.HistoryID != profile.HistoryId || state.Query != "label:INBOX" {
+		t.Fatalf("state %+v, want history ID %d", state, profile.HistoryId)
+	}
+	if _, err := os.Stat(filepath.Join(mirror.dir, "threads", "t2.json")); !os.IsNotExist(err) {
+		t.Fatalf("thread outside the query written to disk: %v", err)
+	}
+
+	reopened, err := NewMailboxMirror(fake, mirror.dir, mirror.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := mirroredThreads(reopened); !slices.Equal(got, []string{"t1"}) {
+		t.Fatalf("reopened mirror holds %v, want [t1]", got)
+	}
+	if want := currentMirrorState(mirror); reopened.state.HistoryID != want.HistoryID || !reopened.state.FullSyncAt.Equal(want.FullSyncAt) {
+		t.Fatalf("reopened state %+v, want %+v", reopened.state, want)
+	}
+}
+
+func TestMirrorIncrementalSync(t *testing.T) {
+	ctx := context.Background()
+	mirror, fake := newTestMirror(t)
+	if err := mirror.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	fullSyncAt := currentMirrorState(mirror).FullSyncAt
+
+	if err := fake.Seed(
+		FixtureMessage{ID: "m1b", ThreadID: "t1", From: "me@example.com", Subject: "Re: Plans", Body: "Sure", Labels: []string{"SENT"}},
+		FixtureMessage{ID: "m3", ThreadID: "t3", From: "bob@example.com", Subject: "Report", Body: "Attached"},
+		FixtureMessage{ID: "m4", ThreadID: "t4", From: "news@example.com", Subject: "Weekly", Body: "News", Labels: []string{"CATEGORY_PROMOTIONS"}},
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.ModifyLabels("m1", []string{"STARRED"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	// New threads are checked one by one, not by listing the whole query again
+	var lists atomic.Int32
+	mirror.Mailbox = countingThreadLists{fake, &lists}
+	if err := mirror.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := lists.Load(); n != 0 {
+		t.Fatalf("incremental sync listed the query's threads %d times", n)
+	}
+
+	if state := currentMirrorState(mirror); !state.FullSyncAt.Equal(fullSyncAt) {
+		t.Fatal("expected an incremental sync, got a full one")
+	}
+	if got := mirroredThreads(mirror); !slices.Equal(got, []string{"t1", "t3"}) {
+		t.Fatalf("mirrored %v, want [t1 t3]: new threads outside the query must stay out", got)
+	}
+	if _, err := os.Stat(filepath.Join(mirror.dir, "threads", "t4.json")); !os.IsNotExist(err) {
+		t.Fatalf("thread outside the query written to disk: %v", err)
+	}
+
+	thread, ok := mirror.lookupThread("t1")
+	if !ok || len(thread.Messages) != 2 {
+		t.Fatalf("t1 not refetched with the reply: %+v", thread)
+	}
+	if !hasLabel(thread.Messages[0].LabelIds, "STARRED") {
+		t.Fatalf("label change not applied: %v", thread.Messages[0].LabelIds)
+	}
+	profile, _ := fake.GetProfile(ctx)
+	if state := currentMirrorState(mirror); state.HistoryID != profile.HistoryId {
+		t.Fatalf("history ID %d, want %d", state.HistoryID, profile.HistoryId)
+	}
+}
+
+func TestMirrorResyncsAfterHistoryExpires(t *testing.T) {
+	ctx := context.Background()
+	mirror, fake := newTestMirror(t)
+	if err := mirror.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	fullSyncAt := currentMirrorState(mirror).FullSyncAt
+
+	if err := fake.Seed(FixtureMessage{ID: "m3", ThreadID: "t3", From: "bob@example.com", Subject: "Report", Body: "Attached"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.DeleteMessage("m1"); err != nil {
+		t.Fatal(err)
+	}
+	fake.ExpireHistory()
+
+	// A read never waits for a full sync: with the history gone it goes to Gmail
+	mirror.mu.Lock()
+	mirror.checkedAt = time.Time{}
+	mirror.mu.Unlock()
+	if mirror.fresh(ctx) {
+		t.Fatal("mirror reported fresh although its history ID has expired")
+	}
+
+	time.Sleep(time.Millisecond)
+	if err := mirror.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if state := currentMirrorState(mirror); !state.FullSyncAt.After(fullSyncAt) {
+		t.Fatal("expired history did not trigger a full sync")
+	}
+	if got := mirroredThreads(mirror); !slices.Equal(got, []string{"t3"}) {
+		t.Fatalf("mirrored %v after resync, want [t3]", got)
+	}
+	if _, err := os.Stat(filepath.Join(mirror.dir, "threads", "t1.json")); !os.IsNotExist(err) {
+		t.Fatalf("deleted thread left on disk: %v", err)
+	}
+}
+
+func TestMirrorGetThreadKeepsOnlyThreadsInQuery(t *testing.T) {
+	ctx := context.Background()
+	mirror, fake := newTestMirror(t)
+	if err := mirror.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.Seed(FixtureMessage{ID: "m3", ThreadID: "t3", From: "bob@example.com", Subject: "Report", Body: "Attached"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// t2 is outside the query: served from Gmail, but not kept
+	if thread, err := mirror.GetThread(ctx, "t2"); err != nil || thread.Id != "t2" {
+		t.Fatalf("GetThread(t2) = %v, %v", thread, err)
+	}
+	// t3 arrived since the last sync and matches: kept until the next sync catches up
+	if thread, err := mirror.GetThread(ctx, "t3"); err != nil || thread.Id != "t3" {
+		t.Fatalf("GetThread(t3) = %v, %v", thread, err)
+	}
+
+	if got := mirroredThreads(mirror); !slices.Equal(got, []string{"t1", "t3"}) {
+		t.Fatalf("mirrored %v, want [t1 t3]", got)
+	}
+	if _, err := os.Stat(filepath.Join(mirror.dir, "threads", "t2.json")); !os.IsNotExist(err) {
+		t.Fatalf("thread outside the query written to disk: %v", err)
+	}
+}
+
+func TestMirrorDraftWritesMarkItStale(t *testing.T) {
+	ctx := context.Background()
+	mirror, _ := newTestMirror(t)
+	stale := func() bool {
+		mirror.mu.Lock()
+		defer mirror.mu.Unlock()
+		return mirror.checkedAt.IsZero()
+	}
+	reply := func(body string) string {
+		return base64.URLEncoding.EncodeToString([]byte("To: alice@example.com\r\nSubject: Re: Plans\r\n\r\n" + body))
+	}
+
+	var draftID string
+	writes := []struct {
+		name  string
+		write func() error
+		want  []string // snippets of the mirrored thread after the next sync
+	}{
+		{"create", func() error {
+			draft, err := mirror.CreateDraft(ctx, &gmail.Draft{Message: &gmail.Message{ThreadId: "t1", Raw: reply("Yes")}})
+			if err == nil {
+				draftID = draft.Id
+			}
+			return err
+		}, []string{"Lunch?", "Yes"}},
+		{"update", func() error {
+			_, err := mirror.UpdateDraft(ctx, draftID, &gmail.Draft{Message: &gmail.Message{ThreadId: "t1", Raw: reply("Yes, at noon")}})
+			return err
+		}, []string{"Lunch?", "Yes, at noon"}},
+		{"delete", func() error { return mirror.DeleteDraft(ctx, draftID) }, []string{"Lunch?"}},
+		{"send", func() error {
+			_, err := mirror.SendMessage(ctx, &gmail.Message{ThreadId: "t1", Raw: reply("See you there")})
+			return err
+		}, []string{"Lunch?", "See you there"}},
+	}
+	for _, w := range writes {
+		if err := mirror.Sync(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if stale() {
+			t.Fatalf("%s: mirror stale right after a sync", w.name)
+		}
+		if err := w.write(); err != nil {
+			t.Fatalf("%s: %v", w.name, err)
+		}
+		// Otherwise reads would serve the thread as it was before the write
+		if !stale() {
+			t.Fatalf("%s: mirror still fresh after a write", w.name)
+		}
+
+		// The sync that follows brings the write into the mirrored thread
+		if err := mirror.Sync(ctx); err != nil {
+			t.Fatal(err)
+		}
+		thread, ok := mirror.lookupThread("t1")
+		if !ok {
+			t.Fatalf("%s: t1 missing from the mirror", w.name)
+		}
+		var snippets []string
+		for _, msg := range thread.Messages {
+			snippets = append(snippets, msg.Snippet)
+		}
+		if !slices.Equal(snippets, w.want) {
+			t.Fatalf("%s: mirrored t1 = %q, want %q", w.name, snippets, w.want)
+		}
+	}
+}
+
+func TestFakeMailboxMatchesMirrorQueries(t *testing.T) {
+	fake, err := NewFakeMailbox("me@example.com",
+		FixtureMessage{ID: "m1", ThreadID: "t1", From: "alice@example.com", Subject: "Plans", Body: "Lunch?", MessageID: "<plans@example.com>"},
+		FixtureMessage{ID: "m2", ThreadID: "t2", From: "bob@example.com", Subject: "Report", Body: "Numbers", MessageID: "<report@example.com>"},
+		FixtureMessage{ID: "m3", ThreadID: "t3", From: "shop@example.com", Subject: "Sale", Body: "50% off", Labels: []string{"CATEGORY_PROMOTIONS"}, MessageID: "<sale@example.com>"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// threadsInQuery asks for "(<mirror query>) {rfc822msgid:... ...}"
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"{from:alice from:shop}", []string{"m1", "m3"}},
+		{"(label:INBOX) {rfc822msgid:plans@example.com}", []string{"m1"}},
+		{"(-from:alice) {rfc822msgid:plans@example.com rfc822msgid:sale@example.com}", []string{"m3"}},
+		{"(label:CATEGORY_PROMOTIONS) {rfc822msgid:plans@example.com rfc822msgid:report@example.com}", nil},
+	}
+	for _, tt := range tests {
+		resp, err := fake.ListMessages(context.Background(), tt.query, 0)
+		if err != nil {
+			t.Fatalf("ListMessages(%q): %v", tt.query, err)
+		}
+		var got []string
+		for _, msg := range resp.Messages {
+			got = append(got, msg.Id)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("ListMessages(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"google.golang.org/api/gmail/v1"
+)
+
+// Attachment size limits, overridable with GMAIL_ATTACHMENT_MAX_BYTES and
+// GMAIL_ATTACHMENT_MAX_TOTAL_BYTES. Base64 grows the total by a third, which
+// keeps the default just under Gmail's 25 MB message limit.
+const (
+	defaultAttachmentMaxBytes      = 10 << 20
+	defaultAttachmentMaxTotalBytes = 18 << 20
+)
+
+// AttachmentPolicy decides which local files outgoing mail may carry
+type AttachmentPolicy struct {
+	Dir           string   // only files inside this directory can be attached
+	MaxFileBytes  int64    // largest single attachment
+	MaxTotalBytes int64    // largest combined size of one message's attachments
+	root          *os.Root // Dir opened so that ".." and symlinks cannot lead outside it
+}
+
+// OutgoingAttachment is a file to attach to an OutgoingMessage
+type OutgoingAttachment struct {
+	Filename string
+	MimeType string
+	Data     []byte
+}
+
+// AttachmentSummary describes an attachment for the person approving the email
+type AttachmentSummary struct {
+	Filename string `json:"filename"`
+	MimeType string `json:"mimeType"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
+}
+
+// attachmentPolicyFromEnv returns the attachment policy, or nil if GMAIL_ATTACHMENT_DIR is not set
+func attachmentPolicyFromEnv() (*AttachmentPolicy, error) {
+	dir := strings.TrimSpace(os.Getenv("GMAIL_ATTACHMENT_DIR"))
+	if dir == "" {
+		return nil, nil
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GMAIL_ATTACHMENT_DIR %q: %v", dir, err)
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open GMAIL_ATTACHMENT_DIR: %v", err)
+	}
+
+	policy := &AttachmentPolicy{
+		Dir:           dir,
+		MaxFileBytes:  defaultAttachmentMaxBytes,
+		MaxTotalBytes: defaultAttachmentMaxTotalBytes,
+		root:          root,
+	}
+	for _, limit := range []struct {
+		env   string
+		value *int64
+	}{
+		{"GMAIL_ATTACHMENT_MAX_BYTES", &policy.MaxFileBytes},
+		{"GMAIL_ATTACHMENT_MAX_TOTAL_BYTES", &policy.MaxTotalBytes},
+	} {
+		raw := os.Getenv(limit.env)
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || value <= 0 {
+			return nil, fmt.Errorf("invalid %s %q: use a positive number of bytes", limit.env, raw)
+		}
+		*limit.value = value
+	}
+	return policy, nil
+}
+
+// sizeLimits returns the per-file and per-email limits, or the defaults when
+// attaching local files is disabled
+func (p *AttachmentPolicy) sizeLimits() (maxFile, maxTotal int64) {
+	if p == nil {
+		return defaultAttachmentMaxBytes, defaultAttachmentMaxTotalBytes
+	}
+	return p.MaxFileBytes, p.MaxTotalBytes
+}
+
+// withAttachmentsParam adds the attachments parameter shared by the email-writing tools
+func withAttachmentsParam() mcp.ToolOption {
+	return mcp.WithArray("attachments",
+		mcp.Description("Files to attach, as paths inside the attachment directory configured with GMAIL_ATTACHMENT_DIR (relative to it, or absolute). Files outside it cannot be attached (optional)"),
+		mcp.Items(map[string]any{"type": "string"}),
+	)
+}
+
+// attachmentsFromRequest loads the files named by a tool call's attachments parameter
+func (p *AttachmentPolicy) attachmentsFromRequest(req mcp.CallToolRequest) ([]OutgoingAttachment, error) {
+	paths := req.GetStringSlice("attachments", nil)
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	if p == nil {
+		return nil, fmt.Errorf("attachments are disabled: set GMAIL_ATTACHMENT_DIR to the directory files may be attached from")
+	}
+
+	var attachments []OutgoingAttachment
+	var total int64
+	for _, path := range paths {
+		attachment, err := p.load(path)
+		if err != nil {
+			return nil, err
+		}
+		total += int64(len(attachment.Data))
+		if total > p.MaxTotalBytes {
+			return nil, fmt.Errorf("attachments exceed the %s limit per email", formatByteSize(p.MaxTotalBytes))
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, nil
+}
+
+// load reads one file from the attachment directory and detects its MIME type
+func (p *AttachmentPolicy) load(path string) (OutgoingAttachment, error) {
+	name := path
+	if filepath.IsAbs(path) {
+		rel, err := filepath.Rel(p.Dir, path)
+		if err != nil || !filepath.IsLocal(rel) {
+			return OutgoingAttachment{}, fmt.Errorf("attachment %q is outside the attachment directory %s", path, p.Dir)
+		}
+		name = rel
+	}
+
+	file, err := p.root.Open(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return OutgoingAttachment{}, fmt.Errorf("attachment %q not found in %s", path, p.Dir)
+		}
+		return OutgoingAttachment{}, fmt.Errorf("cannot attach %q: %v", path, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return OutgoingAttachment{}, fmt.Errorf("cannot attach %q: %v", path, err)
+	}
+	if !info.Mode().IsRegular() {
+		return OutgoingAttachment{}, fmt.Errorf("cannot attach %q: not a regular file", path)
+	}
+
+	// Read one byte past the limit rather than trusting Stat, in case the file is growing
+	data, err := io.ReadAll(io.LimitReader(file, p.MaxFileBytes+1))
+	if err != nil {
+		return OutgoingAttachment{}, fmt.Errorf("failed to read attachment %q: %v", path, err)
+	}
+	if int64(len(data)) > p.MaxFileBytes {
+		return OutgoingAttachment{}, fmt.Errorf("attachment %q is larger than the %s limit", path, formatByteSize(p.MaxFileBytes))
+	}
+
+	return OutgoingAttachment{
+		Filename: filepath.Base(name),
+		MimeType: detectMimeType(name, data),
+		Data:     data,
+	}, nil
+}
+
+// detectMimeType uses the file extension when it is known and sniffs the content otherwise
+func detectMimeType(name string, data []byte) string {
+	if byExtension := mime.TypeByExtension(filepath.Ext(name)); byExtension != "" {
+		if mediaType, _, err := mime.ParseMediaType(byExtension); err == nil {
+			return mediaType
+		}
+	}
+	mediaType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	return mediaType
+}
+
+// parseAttachmentSummaries lists every part of raw RFC 822 besides the body
+// text the approver reads, with the size and SHA-256 of its decoded content as
+// Gmail will store it. Unnamed parts and extra text parts are included, so
+// nothing in the message goes out unseen.
+func parseAttachmentSummaries(raw []byte) ([]AttachmentSummary, error) {
+	payload, err := parseRawMessage(raw, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse built message: %v", err)
+	}
+
+	plainPart, htmlPart := bodyParts([]*gmail.MessagePart{payload})
+	var summaries []AttachmentSummary
+	var decodeErr error
+	walkParts(payload, func(part *gmail.MessagePart) {
+		if len(part.Parts) > 0 || part == plainPart || part == htmlPart || part.Body == nil {
+			return
+		}
+		// An empty unnamed part, such as a blank body, carries nothing to review
+		if part.Filename == "" && part.Body.Size == 0 {
+			return
+		}
+		data, err := decodeRawMessage(part.Body.Data)
+		if err != nil {
+			decodeErr = fmt.Errorf("failed to decode %s part %s: %v", part.MimeType, part.PartId, err)
+			return
+		}
+		sum := sha256.Sum256(data)
+		summaries = append(summaries, AttachmentSummary{
+			Filename: part.Filename,
+			MimeType: part.MimeType,
+			Size:     int64(len(data)),
+			SHA256:   hex.EncodeToString(sum[:]),
+		})
+	})
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+	return summaries, nil
+}
+
+// formatByteSize renders a byte count for people, e.g. "1.5 MB"
+func formatByteSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	default:
+		return fmt.Sprintf("%d bytes", size)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// newAttachmentPolicy configures GMAIL_ATTACHMENT_DIR over a fresh directory
+// holding notes.txt, a subdirectory, and a symlink to a file outside it
+func newAttachmentPolicy(t *testing.T, maxFileBytes, maxTotalBytes string) (*AttachmentPolicy, string) {
+	t.Helper()
+	base := t.TempDir()
+	dir := filepath.Join(base, "attach")
+	outside := filepath.Join(base, "secret.txt")
+	for path, data := range map[string]string{
+		filepath.Join(dir, "notes.txt"):         "meeting notes",
+		filepath.Join(dir, "reports", "q3.csv"): "a,b\n1,2\n",
+		outside:                                 "do not send",
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("GMAIL_ATTACHMENT_DIR", dir)
+	t.Setenv("GMAIL_ATTACHMENT_MAX_BYTES", maxFileBytes)
+	t.Setenv("GMAIL_ATTACHMENT_MAX_TOTAL_BYTES", maxTotalBytes)
+	policy, err := attachmentPolicyFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return policy, dir
+}
+
+func TestAttachmentPolicyConfinesFiles(t *testing.T) {
+	policy, dir := newAttachmentPolicy(t, "", "")
+
+	tests := []struct {
+		path    string
+		wantErr string
+	}{
+		{path: "notes.txt"},
+		{path: "reports/q3.csv"},
+		{path: filepath.Join(dir, "notes.txt")},
+		{path: "../secret.txt", wantErr: "cannot attach"},
+		{path: filepath.Join(filepath.Dir(dir), "secret.txt"), wantErr: "outside the attachment directory"},
+		{path: "link.txt", wantErr: "cannot attach"},
+		{path: "reports", wantErr: "not a regular file"},
+		{path: "missing.txt", wantErr: "not found"},
+	}
+	for _, tt := range tests {
+		attachment, err := policy.load(tt.path)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("load(%q): %v", tt.path, err)
+			} else if attachment.Filename != filepath.Base(tt.path) {
+				t.Errorf("load(%q) filename = %q", tt.path, attachment.Filename)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("load(%q) = %v, want an error containing %q", tt.path, err, tt.wantErr)
+		}
+	}
+}
+
+func TestAttachmentsFromRequestLimits(t *testing.T) {
+	policy, _ := newAttachmentPolicy(t, "10", "15")
+
+	if _, err := policy.attachmentsFromRequest(toolRequest("create_draft", map[string]interface{}{
+		"attachments": []interface{}{"notes.txt"},
+	})); err == nil || !strings.Contains(err.Error(), "larger than the 10 bytes limit") {
+		t.Fatalf("oversized file: %v", err)
+	}
+
+	// Each file fits, together they don't
+	if _, err := policy.attachmentsFromRequest(toolRequest("create_draft", map[string]interface{}{
+		"attachments": []interface{}{"reports/q3.csv", "reports/q3.csv"},
+	})); err == nil || !strings.Contains(err.Error(), "per email") {
+		t.Fatalf("oversized total: %v", err)
+	}
+
+	var disabled *AttachmentPolicy
+	if _, err := disabled.attachmentsFromRequest(toolRequest("create_draft", map[string]interface{}{
+		"attachments": []interface{}{"notes.txt"},
+	})); err == nil || !strings.Contains(err.Error(), "GMAIL_ATTACHMENT_DIR") {
+		t.Fatalf("attachments without a directory: %v", err)
+	}
+}
+
+func TestBuildSummarizesAttachments(t *testing.T) {
+	policy, _ := newAttachmentPolicy(t, "", "")
+	attachment, err := policy.load("reports/q3.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := OutgoingMessage{To: "bob@example.com", Subject: "Q3", Body: "Numbers attached.", Attachments: []OutgoingAttachment{attachment}}
+	raw, err := m.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	summaries, err := parseAttachmentSummaries(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("a,b\n1,2\n"))
+	want := AttachmentSummary{Filename: "q3.csv", MimeType: "text/csv", Size: 8, SHA256: hex.EncodeToString(sum[:])}
+	if len(summaries) != 1 || summaries[0] != want {
+		t.Fatalf("summaries = %+v, want %+v", summaries, want)
+	}
+}
+
+func TestAttachmentSummariesListEveryPartButTheBody(t *testing.T) {
+	// A draft written elsewhere can carry parts no file picker would: a second
+	// text part and binary data without a filename
+	raw := strings.ReplaceAll(`To: bob@example.com
+Subject: Draft
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary=b1
+
+--b1
+Content-Type: text/plain; charset=utf-8
+
+Looks fine to me.
+--b1
+Content-Type: text/plain; charset=utf-8
+
+Hidden second text.
+--b1
+Content-Type: application/octet-stream
+Content-Transfer-Encoding: base64
+
+AAEC
+--b1
+Content-Type: application/pdf
+Content-Disposition: attachment; filename="q3.pdf"
+
+%PDF-1.4
+--b1--
+`, "\n", "\r\n")
+
+	summaries, err := parseAttachmentSummaries([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := func(data string) string {
+		sum := sha256.Sum256([]byte(data))
+		return hex.EncodeToString(sum[:])
+	}
+	want := []AttachmentSummary{
+		{MimeType: "text/plain", Size: 19, SHA256: hash("Hidden second text.")},
+		{MimeType: "application/octet-stream", Size: 3, SHA256: hash("\x00\x01\x02")},
+		{Filename: "q3.pdf", MimeType: "application/pdf", Size: 8, SHA256: hash("%PDF-1.4")},
+	}
+	if !slices.Equal(summaries, want) {
+		t.Fatalf("summaries = %+v, want %+v", summaries, want)
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// Gmail allows 15,000 quota units per user per minute. Each API method costs a
+// fixed number of units: https://developers.google.com/gmail/api/reference/quota
+const defaultQuotaUnitsPerSecond = 250
+
+const (
+	quotaThreadsList    = 10
+	quotaThreadsGet     = 10
+	quotaMessagesList   = 5
+	quotaMessagesGet    = 5
+	quotaAttachmentsGet = 5
+	quotaMessagesSend   = 100
+	quotaDraftsList     = 5
+	quotaDraftsGet      = 5
+	quotaDraftsCreate   = 10
+	quotaDraftsUpdate   = 15
+	quotaDraftsDelete   = 10
+	quotaGetProfile     = 1
+	quotaSendAsList     = 1
+	quotaHistoryList    = 2
+)
+
+// Retry policy for rate-limited and transient failures
+const (
+	retryMaxAttempts      = 5
+	retryBaseDelay        = 500 * time.Millisecond
+	retryMaxDelay         = 30 * time.Second
+	retryAfterHeaderLimit = time.Minute
+)
+
+// quotaLimiter is a token bucket measured in Gmail quota units
+type quotaLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // units refilled per second
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	now   func() time.Time                                 // time.Now, replaced in tests
+	sleep func(ctx context.Context, d time.Duration) error // sleepContext, replaced in tests
+}
+
+func newQuotaLimiter(unitsPerSecond float64) *quotaLimiter {
+	return &quotaLimiter{
+		rate:   unitsPerSecond,
+		burst:  unitsPerSecond,
+		tokens: unitsPerSecond,
+		last:   time.Now(),
+		now:    time.Now,
+		sleep:  sleepContext,
+	}
+}
+
+// sleepContext waits for d, or returns early with ctx's error once it is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// wait charges cost units, sleeping until the bucket can cover them
+func (l *quotaLimiter) wait(ctx context.Context, cost float64) error {
+	l.mu.Lock()
+	now := l.now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	// Reserve the units up front so concurrent callers queue fairly behind each other
+	l.tokens -= cost
+	deficit := -l.tokens
+	l.mu.Unlock()
+
+	if deficit <= 0 {
+		return nil
+	}
+
+	if err := l.sleep(ctx, time.Duration(deficit/l.rate*float64(time.Second))); err != nil {
+		l.mu.Lock()
+		l.tokens += cost
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// drain empties the bucket after Gmail reports we are over quota, so every
+// caller slows down rather than just the one that was rejected
+func (l *quotaLimiter) drain() {
+	l.mu.Lock()
+	l.tokens = min(l.tokens, 0)
+	l.mu.Unlock()
+}
+
+// quotaUnitsPerSecond reads GMAIL_QUOTA_UNITS_PER_SECOND for projects with a lower per-user limit
+func quotaUnitsPerSecond() float64 {
+	raw := os.Getenv("GMAIL_QUOTA_UNITS_PER_SECOND")
+	if raw == "" {
+		return defaultQuotaUnitsPerSecond
+	}
+	units, err := strconv.ParseFloat(raw, 64)
+	if err != nil || units <= 0 {
+		log.Printf("⚠️  Ignoring invalid GMAIL_QUOTA_UNITS_PER_SECOND %q", raw)
+		return defaultQuotaUnitsPerSecond
+	}
+	return units
+}
+
+// isRateLimitError reports whether Gmail rejected a call for exceeding quota
+func isRateLimitError(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if apiErr.Code == 429 {
+		return true
+	}
+	if apiErr.Code == 403 {
+		for _, item := range apiErr.Errors {
+			switch item.Reason {
+			case "rateLimitExceeded", "userRateLimitExceeded":
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isTransientError reports whether a failed call may succeed if repeated
+func isTransientError(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.Code {
+	case 500, 502, 503, 504:
+		return true
+	}
+	return false
+}
+
+// retryDelay returns the jittered exponential backoff for a retry attempt,
+// honoring a Retry-After header when Gmail sends one
+func retryDelay(err error, attempt int) time.Duration {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Header != nil {
+		if seconds, convErr := strconv.Atoi(apiErr.Header.Get("Retry-After")); convErr == nil && seconds > 0 {
+			return min(time.Duration(seconds)*time.Second, retryAfterHeaderLimit)
+		}
+	}
+
+	backoff := min(retryBaseDelay<<attempt, retryMaxDelay)
+	// Jitter keeps concurrent workers from retrying in lockstep
+	return backoff/2 + rand.N(backoff/2+1)
+}
+
+// throttledMailbox wraps a Mailbox with quota accounting and retries
+type throttledMailbox struct {
+	next    Mailbox
+	limiter *quotaLimiter
+}
+
+func newThrottledMailbox(next Mailbox, unitsPerSecond float64) *throttledMailbox {
+	return &throttledMailbox{
+		next:    next,
+		limiter: newQuotaLimiter(unitsPerSecond),
+	}
+}
+
+// throttled runs one API call under the quota limiter, retrying rate-limit errors
+// and, for idempotent calls, transient server errors
+func throttled[T any](ctx context.Context, m *throttledMailbox, method string, cost float64, idempotent bool, call func() (T, error)) (T, error) {
+	var zero T
+	for attempt := 0; ; attempt++ {
+		if err := m.limiter.wait(ctx, cost); err != nil {
+			return zero, err
+		}
+
+		result, err := call()
+		if err == nil {
+			return result, nil
+		}
+
+		rateLimited := isRateLimitError(err)
+		if rateLimited {
+			m.limiter.drain()
+		}
+		retryable := rateLimited || (idempotent && isTransientError(err))
+		if !retryable || attempt+1 >= retryMaxAttempts || ctx.Err() != nil {
+			if retryable {
+				return zero, fmt.Errorf("%w (gave up after %d attempts)", err, attempt+1)
+			}
+			return zero, err
+		}
+
+		delay := retryDelay(err, attempt)
+		log.Printf("⏳ Gmail %s failed (%s), retrying in %s (attempt %d/%d)", method, summarizeAPIError(err), delay.Round(time.Millisecond), attempt+2, retryMaxAttempts)
+		if err := m.limiter.sleep(ctx, delay); err != nil {
+			return zero, err
+		}
+	}
+}
+
+// summarizeAPIError shortens a Gmail error to its status code and reason for logging
+func summarizeAPIError(err error) string {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return err.Error()
+	}
+	var reasons []string
+	for _, item := range apiErr.Errors {
+		if item.Reason != "" {
+			reasons = append(reasons, item.Reason)
+		}
+	}
+	if len(reasons) == 0 {
+		return strconv.Itoa(apiErr.Code)
+	}
+	return fmt.Sprintf("%d %s", apiErr.Code, strings.Join(reasons, ","))
+}
+
+func (m *throttledMailbox) ListThreads(ctx context.Context, query string, maxResults int64, pageToken string) (*gmail.ListThreadsResponse, error) {
+	return throttled(ctx, m, "threads.list", quotaThreadsList, true, func() (*gmail.ListThreadsResponse, error) {
+		return m.next.ListThreads(ctx, query, maxResults, pageToken)
+	})
+}
+
+func (m *throttledMailbox) GetThread(ctx context.Context, threadID string) (*gmail.Thread, error) {
+	return throttled(ctx, m, "threads.get", quotaThreadsGet, true, func() (*gmail.Thread, error) {
+		return m.next.GetThread(ctx, threadID)
+	})
+}
+
+func (m *throttledMailbox) GetThreadMetadata(ctx context.Context, threadID string, headers []string) (*gmail.Thread, error) {
+	return throttled(ctx, m, "threads.get", quotaThreadsGet, true, func() (*gmail.Thread, error) {
+		return m.next.GetThreadMetadata(ctx, threadID, headers)
+	})
+}
+
+func (m *throttledMailbox) ListMessages(ctx context.Context, query string, maxResults int64) (*gmail.ListMessagesResponse, error) {
+	return throttled(ctx, m, "messages.list", quotaMessagesList, true, func() (*gmail.ListMessagesResponse, error) {
+		return m.next.ListMessages(ctx, query, maxResults)
+	})
+}
+
+func (m *throttledMailbox) GetMessage(ctx context.Context, messageID string) (*gmail.Message, error) {
+	return throttled(ctx, m, "messages.get", quotaMessagesGet, true, func() (*gmail.Message, error) {
+		return m.next.GetMessage(ctx, messageID)
+	})
+}
+
+func (m *throttledMailbox) GetAttachment(ctx context.Context, messageID, attachmentID string) (*gmail.MessagePartBody, error) {
+	return throttled(ctx, m, "messages.attachments.get", quotaAttachmentsGet, true, func() (*gmail.MessagePartBody, error) {
+		return m.next.GetAttachment(ctx, messageID, attachmentID)
+	})
+}
+
+// SendMessage is never retried after a server error, which could otherwise send twice
+func (m *throttledMailbox) SendMessage(ctx context.Context, msg *gmail.Message) (*gmail.Message, error) {
+	return throttled(ctx, m, "messages.send", quotaMessagesSend, false, func() (*gmail.Message, error) {
+		return m.next.SendMessage(ctx, msg)
+	})
+}
+
+func (m *throttledMailbox) ListDrafts(ctx context.Context, maxResults int64, pageToken string) (*gmail.ListDraftsResponse, error) {
+	return throttled(ctx, m, "drafts.list", quotaDraftsList, true, func() (*gmail.ListDraftsResponse, error) {
+		return m.next.ListDrafts(ctx, maxResults, pageToken)
+	})
+}
+
+func (m *throttledMailbox) GetDraft(ctx context.Context, draftID string) (*gmail.Draft, error) {
+	return throttled(ctx, m, "drafts.get", quotaDraftsGet, true, func() (*gmail.Draft, error) {
+		return m.next.GetDraft(ctx, draftID)
+	})
+}
+
+func (m *throttledMailbox) GetDraftRaw(ctx context.Context, draftID string) (*gmail.Draft, error) {
+	return throttled(ctx, m, "drafts.get", quotaDraftsGet, true, func() (*gmail.Draft, error) {
+		return m.next.GetDraftRaw(ctx, draftID)
+	})
+}
+
+// CreateDraft is not idempotent: a 5xx may still have created the draft, so
+// only quota rejections are retried
+func (m *throttledMailbox) CreateDraft(ctx context.Context, draft *gmail.Draft) (*gmail.Draft, error) {
+	return throttled(ctx, m, "drafts.create", quotaDraftsCreate, false, func() (*gmail.Draft, error) {
+		return m.next.CreateDraft(ctx, draft)
+	})
+}
+
+func (m *throttledMailbox) UpdateDraft(ctx context.Context, draftID string, draft *gmail.Draft) (*gmail.Draft, error) {
+	return throttled(ctx, m, "drafts.update", quotaDraftsUpdate, true, func() (*gmail.Draft, error) {
+		return m.next.UpdateDraft(ctx, draftID, draft)
+	})
+}
+
+// DeleteDraft is not retried after a server error: a repeat would fail with 404 if the first attempt went through
+func (m *throttledMailbox) DeleteDraft(ctx context.Context, draftID string) error {
+	_, err := throttled(ctx, m, "drafts.delete", quotaDraftsDelete, false, func() (struct{}, error) {
+		return struct{}{}, m.next.DeleteDraft(ctx, draftID)
+	})
+	return err
+}
+
+func (m *throttledMailbox) ListHistory(ctx context.Context, startHistoryID uint64, pageToken string) (*gmail.ListHistoryResponse, error) {
+	return throttled(ctx, m, "history.list", quotaHistoryList, true, func() (*gmail.ListHistoryResponse, error) {
+		return m.next.ListHistory(ctx, startHistoryID, pageToken)
+	})
+}
+
+func (m *throttledMailbox) GetProfile(ctx context.Context) (*gmail.Profile, error) {
+	return throttled(ctx, m, "getProfile", quotaGetProfile, true, func() (*gmail.Profile, error) {
+		return m.next.GetProfile(ctx)
+	})
+}
+
+func (m *throttledMailbox) ListSendAs(ctx context.Context) (*gmail.ListSendAsResponse, error) {
+	return throttled(ctx, m, "settings.sendAs.list", quotaSendAsList, true, func() (*gmail.ListSendAsResponse, error) {
+		return m.next.ListSendAs(ctx)
+	})
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// fakeClock stands in for time in a quotaLimiter: sleeping advances it
+// instantly and records how long each sleep was
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sleeps = append(c.sleeps, d)
+	c.now = c.now.Add(d)
+	return nil
+}
+
+func (c *fakeClock) Sleeps() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Duration(nil), c.sleeps...)
+}
+
+// newFakeClockThrottle wraps next in a throttledMailbox whose limiter runs on a fake clock
+func newFakeClockThrottle(next Mailbox, unitsPerSecond float64) (*throttledMailbox, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	m := newThrottledMailbox(next, unitsPerSecond)
+	m.limiter.now, m.limiter.sleep = clock.Now, clock.Sleep
+	m.limiter.last = clock.now
+	return m, clock
+}
+
+// failingMailbox fails the first calls to GetThread and SendMessage with
+// queued errors, then passes through to the fake
+type failingMailbox struct {
+	*FakeMailbox
+	mu       sync.Mutex
+	failures []error
+	calls    int
+}
+
+func (m *failingMailbox) nextFailure() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls++
+	if len(m.failures) == 0 {
+		return nil
+	}
+	err := m.failures[0]
+	m.failures = m.failures[1:]
+	return err
+}
+
+func (m *failingMailbox) GetThread(ctx context.Context, threadID string) (*gmail.Thread, error) {
+	if err := m.nextFailure(); err != nil {
+		return nil, err
+	}
+	return m.FakeMailbox.GetThread(ctx, threadID)
+}
+
+func (m *failingMailbox) SendMessage(ctx context.Context, msg *gmail.Message) (*gmail.Message, error) {
+	if err := m.nextFailure(); err != nil {
+		return nil, err
+	}
+	return m.FakeMailbox.SendMessage(ctx, msg)
+}
+
+func newFailingMailbox(t *testing.T, failures ...error) *failingMailbox {
+	t.Helper()
+	fake, err := NewFakeMailbox("me@example.com",
+		FixtureMessage{ID: "m1", ThreadID: "t1", From: "alice@example.com", Subject: "Plans", Body: "Lunch?"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &failingMailbox{FakeMailbox: fake, failures: failures}
+}
+
+func rateLimited() error {
+	return &googleapi.Error{Code: 429, Message: "Too many requests"}
+}
+
+func TestThrottledChargesQuotaPerMethod(t *testing.T) {
+	ctx := context.Background()
+	raw := base64.URLEncoding.EncodeToString([]byte("To: bob@example.com\r\nSubject: Hi\r\n\r\nHello\r\n"))
+
+	tests := []struct {
+		method string
+		call   func(m *throttledMailbox) error
+		cost   float64
+	}{
+		{"threads.list", func(m *throttledMailbox) error { _, err := m.ListThreads(ctx, "", 10, ""); return err }, quotaThreadsList},
+		{"threads.get", func(m *throttledMailbox) error { _, err := m.GetThread(ctx, "t1"); return err }, quotaThreadsGet},
+		{"messages.list", func(m *throttledMailbox) error { _, err := m.ListMessages(ctx, "", 10); return err }, quotaMessagesList},
+		{"messages.get", func(m *throttledMailbox) error { _, err := m.GetMessage(ctx, "m1"); return err }, quotaMessagesGet},
+		{"messages.send", func(m *throttledMailbox) error {
+			_, err := m.SendMessage(ctx, &gmail.Message{Raw: raw})
+			return err
+		}, quotaMessagesSend},
+		{"drafts.create", func(m *throttledMailbox) error {
+			_, err := m.CreateDraft(ctx, &gmail.Draft{Message: &gmail.Message{Raw: raw}})
+			return err
+		}, quotaDraftsCreate},
+		{"history.list", func(m *throttledMailbox) error { _, err := m.ListHistory(ctx, 1000, ""); return err }, quotaHistoryList},
+		{"getProfile", func(m *throttledMailbox) error { _, err := m.GetProfile(ctx); return err }, quotaGetProfile},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			m, _ := newFakeClockThrottle(newFailingMailbox(t), 1000)
+			if err := tt.call(m); err != nil {
+				t.Fatal(err)
+			}
+			if used := 1000 - m.limiter.tokens; used != tt.cost {
+				t.Fatalf("%s used %v quota units, want %v", tt.method, used, tt.cost)
+			}
+		})
+	}
+}
+
+func TestQuotaLimiterWaitsForRefill(t *testing.T) {
+	m, clock := newFakeClockThrottle(newFailingMailbox(t), 20)
+	ctx := context.Background()
+
+	// The bucket holds one second of quota: two threads.get fit, the third
+	// must wait half a second for 10 more units
+	for range 3 {
+		if _, err := m.GetThread(ctx, "t1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sleeps := clock.Sleeps(); len(sleeps) != 1 || sleeps[0] != 500*time.Millisecond {
+		t.Fatalf("sleeps = %v, want one of 500ms", sleeps)
+	}
+
+	// The half second slept refilled the bucket to empty. A cancelled wait
+	// gives its units back rather than leaving them charged.
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := m.limiter.wait(cancelled, 100); !errors.Is(err, context.Canceled) {
+		t.Fatalf("wait on a cancelled context = %v", err)
+	}
+	if m.limiter.tokens != 0 {
+		t.Fatalf("tokens = %v after a cancelled wait, want 0", m.limiter.tokens)
+	}
+}
+
+func TestThrottledRetriesRateLimitThenSucceeds(t *testing.T) {
+	next := newFailingMailbox(t, rateLimited(), &googleapi.Error{Code: 503, Message: "Backend Error"})
+	m, clock := newFakeClockThrottle(next, 1000)
+
+	thread, err := m.GetThread(context.Background(), "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thread.Id != "t1" || next.calls != 3 {
+		t.Fatalf("got thread %q after %d calls, want t1 after 3", thread.Id, next.calls)
+	}
+	// One jittered backoff before each retry; the 429 drained the bucket, but
+	// the backoff is long enough to refill it
+	sleeps := clock.Sleeps()
+	if len(sleeps) != 2 {
+		t.Fatalf("sleeps = %v, want a backoff before each retry", sleeps)
+	}
+	if sleeps[0] < retryBaseDelay/2 || sleeps[0] > retryBaseDelay {
+		t.Fatalf("first backoff %s, want between %s and %s", sleeps[0], retryBaseDelay/2, retryBaseDelay)
+	}
+}
+
+func TestThrottledGivesUpAfterMaxAttempts(t *testing.T) {
+	var failures []error
+	for range retryMaxAttempts + 1 {
+		failures = append(failures, rateLimited())
+	}
+	next := newFailingMailbox(t, failures...)
+	m, _ := newFakeClockThrottle(next, 1000)
+
+	_, err := m.GetThread(context.Background(), "t1")
+	if !isRateLimitError(err) || !strings.Contains(err.Error(), fmt.Sprintf("gave up after %d attempts", retryMaxAttempts)) {
+		t.Fatalf("GetThread = %v, want the rate limit error after %d attempts", err, retryMaxAttempts)
+	}
+	if next.calls != retryMaxAttempts {
+		t.Fatalf("%d calls, want %d", next.calls, retryMaxAttempts)
+	}
+}
+
+func TestThrottledSendsOnceAfterServerError(t *testing.T) {
+	next := newFailingMailbox(t, &googleapi.Error{Code: 500, Message: "Backend Error"})
+	m, clock := newFakeClockThrottle(next, 1000)
+	raw := base64.URLEncoding.EncodeToString([]byte("To: bob@example.com\r\nSubject: Hi\r\n\r\nHello\r\n"))
+
+	// The send may have gone through before the 500, so repeating it could send twice
+	if _, err := m.SendMessage(context.Background(), &gmail.Message{Raw: raw}); err == nil {
+		t.Fatal("SendMessage succeeded, want the server error")
+	}
+	if next.calls != 1 || len(next.SentMessages()) != 0 || len(clock.Sleeps()) != 0 {
+		t.Fatalf("%d attempts, %d sent, %d sleeps; want exactly one attempt", next.calls, len(next.SentMessages()), len(clock.Sleeps()))
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	withRetryAfter := func(value string) error {
+		header := http.Header{}
+		header.Set("Retry-After", value)
+		return &googleapi.Error{Code: 429, Header: header}
+	}
+
+	if got := retryDelay(withRetryAfter("7"), 0); got != 7*time.Second {
+		t.Errorf("Retry-After 7 = %s, want 7s", got)
+	}
+	if got := retryDelay(withRetryAfter("3600"), 0); got != retryAfterHeaderLimit {
+		t.Errorf("Retry-After 3600 = %s, want the %s cap", got, retryAfterHeaderLimit)
+	}
+	for attempt := range 8 {
+		backoff := min(retryBaseDelay<<attempt, retryMaxDelay)
+		for _, err := range []error{withRetryAfter("soon"), rateLimited()} {
+			if got := retryDelay(err, attempt); got < backoff/2 || got > backoff {
+				t.Errorf("attempt %d: delay %s outside [%s, %s]", attempt, got, backoff/2, backoff)
+			}
+		}
+	}
+}
+
+func TestClassifyAPIErrors(t *testing.T) {
+	tests := []struct {
+		name                   string
+		err                    error
+		rateLimited, transient bool
+	}{
+		{"429", rateLimited(), true, false},
+		{"403 user rate limit", &googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "userRateLimitExceeded"}}}, true, false},
+		{"403 rate limit", &googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}}, true, false},
+		{"403 forbidden", &googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "insufficientPermissions"}}}, false, false},
+		{"500", &googleapi.Error{Code: 500}, false, true},
+		{"503 wrapped", fmt.Errorf("threads.get: %w", &googleapi.Error{Code: 503}), false, true},
+		{"404", &googleapi.Error{Code: 404}, false, false},
+		{"not an API error", errors.New("connection reset"), false, false},
+	}
+	for _, tt := range tests {
+		if got := isRateLimitError(tt.err); got != tt.rateLimited {
+			t.Errorf("%s: isRateLimitError = %v, want %v", tt.name, got, tt.rateLimited)
+		}
+		if got := isTransientError(tt.err); got != tt.transient {
+			t.Errorf("%s: isTransientError = %v, want %v", tt.name, got, tt.transient)
+		}
+	}
+}
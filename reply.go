@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/mail"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"google.golang.org/api/gmail/v1"
+)
+
+// replyHeaders are the headers of the message being answered that a reply is built from
+var replyHeaders = []string{"Message-ID", "References", "Subject", "From", "Reply-To", "To", "Cc"}
+
+// withReplyAllParam adds the reply_all switch shared by the email-writing tools
+func withReplyAllParam() mcp.ToolOption {
+	return mcp.WithBoolean("reply_all",
+		mcp.Description("Reply to everyone on the thread's latest message (requires thread_id). To and Cc are filled in from that message, leaving out your own address and send-as aliases; any to or cc you pass is added to them (default: false)"),
+	)
+}
+
+// prepareReply turns outgoing into a reply to the latest message in threadID:
+// it prefixes the subject with "Re:", sets In-Reply-To and References so
+// recipients' clients thread it, and with replyAll addresses everyone on that
+// message except the account's own addresses
+func (g *GmailServer) prepareReply(ctx context.Context, outgoing *OutgoingMessage, threadID string, replyAll bool) error {
+	if !strings.HasPrefix(strings.ToLower(outgoing.Subject), "re:") {
+		outgoing.Subject = "Re: " + outgoing.Subject
+	}
+
+	thread, err := g.mailbox.GetThreadMetadata(ctx, threadID, replyHeaders)
+	if err != nil {
+		return fmt.Errorf("failed to load thread %s: %v", threadID, err)
+	}
+	latest, _ := selectMessages(withoutDrafts(thread.Messages), ConversationOptions{LatestOnly: true})
+	if len(latest) == 0 {
+		return fmt.Errorf("thread %s has no messages to reply to", threadID)
+	}
+	headers := latest[0].Payload.Headers
+
+	outgoing.InReplyTo, outgoing.References = threadingHeaders(headers)
+
+	if !replyAll {
+		return nil
+	}
+
+	own, err := g.ownAddresses(ctx)
+	if err != nil {
+		return err
+	}
+	to, cc := replyAllRecipients(headers, own)
+	// Anything the caller passed explicitly is kept alongside the derived recipients
+	for _, extra := range []struct {
+		name, value string
+		list        *[]*mail.Address
+	}{
+		{"to", outgoing.To, &to},
+		{"cc", outgoing.Cc, &cc},
+	} {
+		if strings.TrimSpace(extra.value) == "" {
+			continue
+		}
+		addresses, err := mail.ParseAddressList(extra.value)
+		if err != nil {
+			return fmt.Errorf("invalid %s address list %q: %v", extra.name, extra.value, err)
+		}
+		*extra.list = append(*extra.list, addresses...)
+	}
+
+	seen := make(map[string]bool)
+	to, cc = dedupeAddresses(to, own, seen), dedupeAddresses(cc, own, seen)
+	if len(to) == 0 {
+		to, cc = cc, nil
+	}
+	if len(to) == 0 {
+		return fmt.Errorf("nobody to reply to: every participant in the latest message is one of your own addresses")
+	}
+	outgoing.To, outgoing.Cc = joinAddresses(to), joinAddresses(cc)
+	return nil
+}
+
+// threadingHeaders returns the In-Reply-To and References values for a message
+// answering or forwarding the one with these headers
+func threadingHeaders(headers []*gmail.MessagePartHeader) (inReplyTo, references string) {
+	messageID := headerValue(headers, "Message-ID")
+	if messageID == "" {
+		return "", ""
+	}
+	if previous := headerValue(headers, "References"); previous != "" {
+		return messageID, previous + " " + messageID
+	}
+	return messageID, messageID
+}
+
+// replyAllRecipients mirrors Gmail's reply-all: answer the sender (or their
+// Reply-To) and copy the other recipients, or, when the latest message is the
+// user's own, write to its original recipients again
+func replyAllRecipients(headers []*gmail.MessagePartHeader, own map[string]bool) (to, cc []*mail.Address) {
+	from := parseHeaderAddresses(headerValue(headers, "From"))
+	if len(from) > 0 && own[strings.ToLower(from[0].Address)] {
+		return parseHeaderAddresses(headerValue(headers, "To")), parseHeaderAddresses(headerValue(headers, "Cc"))
+	}
+
+	to = parseHeaderAddresses(headerValue(headers, "Reply-To"))
+	if len(to) == 0 {
+		to = from
+	}
+	cc = append(parseHeaderAddresses(headerValue(headers, "To")), parseHeaderAddresses(headerValue(headers, "Cc"))...)
+	return to, cc
+}
+
+// ownAddresses returns the lower-cased addresses the account sends as: its
+// primary address plus any send-as aliases
+func (g *GmailServer) ownAddresses(ctx context.Context) (map[string]bool, error) {
+	own := make(map[string]bool)
+	if g.email != "" {
+		own[strings.ToLower(g.email)] = true
+	}
+
+	sendAs, err := g.mailbox.ListSendAs(ctx)
+	if err != nil {
+		if len(own) == 0 {
+			return nil, fmt.Errorf("failed to look up the account's own addresses: %v", err)
+		}
+		log.Printf("⚠️  Could not list send-as aliases for account %s, excluding only %s from reply-all: %v", g.account, g.email, err)
+		return own, nil
+	}
+	for _, alias := range sendAs.SendAs {
+		own[strings.ToLower(alias.SendAsEmail)] = true
+	}
+	return own, nil
+}
+
+// parseHeaderAddresses parses an address header leniently, keeping whichever
+// comma-separated entries are valid when the list as a whole is not
+func parseHeaderAddresses(value string) []*mail.Address {
+	if strings.TrimSpace(value) == "" {
+		return nil
+	}
+	if addresses, err := mail.ParseAddressList(value); err == nil {
+		return addresses
+	}
+	var addresses []*mail.Address
+	for _, entry := range strings.Split(value, ",") {
+		if address, err := mail.ParseAddress(entry); err == nil {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+// dedupeAddresses drops own addresses and any already in seen, recording the rest in seen
+func dedupeAddresses(addresses []*mail.Address, own, seen map[string]bool) []*mail.Address {
+	var kept []*mail.Address
+	for _, address := range addresses {
+		key := strings.ToLower(address.Address)
+		if own[key] || seen[key] {
+			continue
+		}
+		seen[key] = true
+		kept = append(kept, address)
+	}
+	return kept
+}
+
+func joinAddresses(addresses []*mail.Address) string {
+	formatted := make([]string, len(addresses))
+	for i, address := range addresses {
+		formatted[i] = displayAddress(address)
+	}
+	return strings.Join(formatted, ", ")
+}
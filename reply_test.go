@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPrepareReplyAll(t *testing.T) {
+	tests := []struct {
+		name           string
+		latest         FixtureMessage
+		to, cc         string
+		wantTo, wantCc string
+	}{
+		{
+			name:   "reply to others",
+			latest: FixtureMessage{From: "Alice <alice@example.com>", To: "me@example.com, bob@example.com", Cc: "carol@example.com, Me <ME@example.com>"},
+			wantTo: "Alice <alice@example.com>", wantCc: "bob@example.com, carol@example.com",
+		},
+		{
+			name:   "reply to own message",
+			latest: FixtureMessage{From: "me@example.com", To: "alice@example.com", Cc: "bob@example.com, alias@example.com"},
+			wantTo: "alice@example.com", wantCc: "bob@example.com",
+		},
+		{
+			name:   "sender's Reply-To",
+			latest: FixtureMessage{From: "alice@example.com", To: "me@example.com", Headers: map[string]string{"Reply-To": "list@example.com"}},
+			wantTo: "list@example.com",
+		},
+		{
+			name:   "explicit extra recipients",
+			latest: FixtureMessage{From: "alice@example.com", To: "me@example.com"},
+			to:     "dave@example.com, alice@example.com", cc: "erin@example.com",
+			wantTo: "alice@example.com, dave@example.com", wantCc: "erin@example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			latest := tt.latest
+			latest.ID, latest.ThreadID, latest.Subject, latest.MessageID = "m2", "t1", "Re: Plans", "<m2@example.com>"
+			latest.References = "<m1@example.com>"
+			server, mailbox := newFakeServer(t,
+				FixtureMessage{ID: "m1", ThreadID: "t1", From: "alice@example.com", To: "me@example.com", Subject: "Plans", MessageID: "<m1@example.com>"},
+				latest,
+			)
+			mailbox.AddSendAs("alias@example.com")
+
+			outgoing := OutgoingMessage{To: tt.to, Cc: tt.cc, Subject: "Plans", Body: "Sounds good"}
+			if err := server.prepareReply(context.Background(), &outgoing, "t1", true); err != nil {
+				t.Fatal(err)
+			}
+			if outgoing.To != tt.wantTo || outgoing.Cc != tt.wantCc {
+				t.Errorf("to %q cc %q, want to %q cc %q", outgoing.To, outgoing.Cc, tt.wantTo, tt.wantCc)
+			}
+			if outgoing.Subject != "Re: Plans" || outgoing.InReplyTo != "<m2@example.com>" || outgoing.References != "<m1@example.com> <m2@example.com>" {
+				t.Errorf("subject %q, In-Reply-To %q, References %q", outgoing.Subject, outgoing.InReplyTo, outgoing.References)
+			}
+		})
+	}
+}
+
+func TestPrepareReplyAllToOnlyOwnAddresses(t *testing.T) {
+	server, _ := newFakeServer(t,
+		FixtureMessage{ID: "m1", ThreadID: "t1", From: "me@example.com", To: "me@example.com", Subject: "Note to self"},
+	)
+	outgoing := OutgoingMessage{Subject: "Note to self", Body: "Reminder"}
+	if err := server.prepareReply(context.Background(), &outgoing, "t1", true); err == nil {
+		t.Fatalf("replied to nobody: to %q", outgoing.To)
+	}
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// BM25 parameters (the usual Robertson/Sparck Jones defaults)
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// Query limits
+const (
+	maxSearchQueryTokens = 32
+	minPrefixTermRunes   = 2
+)
+
+// snippetChars is the approximate length of a hit's excerpt; matches in it are
+// wrapped in highlightMarker (markdown bold)
+const (
+	snippetChars    = 240
+	highlightMarker = "**"
+)
+
+// IndexedDoc is one searchable unit: a message body or an attachment's text
+type IndexedDoc struct {
+	ID        string
+	ThreadID  string
+	MessageID string
+	Kind      string // "message" or "attachment"
+	Subject   string
+	From      string
+	Date      string
+	Filename  string // attachments only
+	Text      string
+
+	length int // number of tokens
+}
+
+// SearchHit is a ranked match with a highlighted excerpt
+type SearchHit struct {
+	Doc        *IndexedDoc
+	Score      float64
+	Snippet    string
+	Highlights []string // query terms found in the document
+}
+
+// SearchIndex is an in-memory inverted index ranked with BM25
+type SearchIndex struct {
+	mu          sync.RWMutex
+	docs        map[string]*IndexedDoc
+	postings    map[string]map[string]int // term -> doc ID -> term frequency
+	threadDocs  map[string]map[string]bool
+	totalLength int
+}
+
+func NewSearchIndex() *SearchIndex {
+	return &SearchIndex{
+		docs:       make(map[string]*IndexedDoc),
+		postings:   make(map[string]map[string]int),
+		threadDocs: make(map[string]map[string]bool),
+	}
+}
+
+// tokenize lowercases text and splits it into runs of letters and digits.
+// Chinese and Japanese are written without spaces, so each Han, Hiragana and
+// Katakana character is a token of its own; a word of several is matched as
+// a phrase (see parseSearchQuery).
+func tokenize(text string) []string {
+	spans := tokenSpans(text)
+	tokens := make([]string, len(spans))
+	for i, span := range spans {
+		tokens[i] = span.term
+	}
+	return tokens
+}
+
+// isIdeographic reports whether r is written without spaces between words
+func isIdeographic(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana) || r == 'ー'
+}
+
+// Add indexes a document, replacing any previous version with the same ID
+func (idx *SearchIndex) Add(doc *IndexedDoc) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.removeLocked(doc.ID)
+
+	// Subject, sender and filename are searchable alongside the text
+	tokens := tokenize(strings.Join([]string{doc.Subject, doc.From, doc.Filename, doc.Text}, "\n"))
+	doc.length = len(tokens)
+	idx.docs[doc.ID] = doc
+	idx.totalLength += doc.length
+
+	if idx.threadDocs[doc.ThreadID] == nil {
+		idx.threadDocs[doc.ThreadID] = make(map[string]bool)
+	}
+	idx.threadDocs[doc.ThreadID][doc.ID] = true
+
+	for _, token := range tokens {
+		if idx.postings[token] == nil {
+			idx.postings[token] = make(map[string]int)
+		}
+		idx.postings[token][doc.ID]++
+	}
+}
+
+// Has reports whether a document is indexed
+func (idx *SearchIndex) Has(docID string) bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	_, ok := idx.docs[docID]
+	return ok
+}
+
+// RemoveThread drops every document belonging to a thread
+func (idx *SearchIndex) RemoveThread(threadID string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for docID := range idx.threadDocs[threadID] {
+		idx.removeLocked(docID)
+	}
+	delete(idx.threadDocs, threadID)
+}
+
+// RemoveWhere drops the thread's documents for which drop returns true
+func (idx *SearchIndex) RemoveWhere(threadID string, drop func(doc *IndexedDoc) bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for docID := range idx.threadDocs[threadID] {
+		if drop(idx.docs[docID]) {
+			idx.removeLocked(docID)
+		}
+	}
+}
+
+func (idx *SearchIndex) removeLocked(docID string) {
+	doc, ok := idx.docs[docID]
+	if !ok {
+		return
+	}
+	for _, token := range tokenize(strings.Join([]string{doc.Subject, doc.From, doc.Filename, doc.Text}, "\n")) {
+		if postings, ok := idx.postings[token]; ok {
+			delete(postings, docID)
+			if len(postings) == 0 {
+				delete(idx.postings, token)
+			}
+		}
+	}
+	idx.totalLength -= doc.length
+	delete(idx.docs, docID)
+	if docs := idx.threadDocs[doc.ThreadID]; docs != nil {
+		delete(docs, docID)
+		if len(docs) == 0 {
+			delete(idx.threadDocs, doc.ThreadID)
+		}
+	}
+}
+
+// Counts returns how many message and attachment documents are indexed
+func (idx *SearchIndex) Counts() (messages, attachments int) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	for _, doc := range idx.docs {
+		if doc.Kind == "attachment" {
+			attachments++
+		} else {
+			messages++
+		}
+	}
+	return messages, attachments
+}
+
+// parsedQuery is a search query split into scored terms and required phrases
+type parsedQuery struct {
+	terms    []string // exact terms
+	prefixes []string // terms written with a trailing *
+	phrases  []string // quoted phrases, lowercased and normalized
+}
+
+// parseSearchQuery understands bare words, "quoted phrases" and prefix* terms
+func parseSearchQuery(query string) parsedQuery {
+	var parsed parsedQuery
+	seen := make(map[string]bool)
+	addTerm := func(term string) {
+		if !seen[term] && len(parsed.terms)+len(parsed.prefixes) < maxSearchQueryTokens {
+			seen[term] = true
+			parsed.terms = append(parsed.terms, term)
+		}
+	}
+
+	parts := strings.Split(query, `"`)
+	for i, part := range parts {
+		if i%2 == 1 {
+			// Inside quotes: a phrase that must appear verbatim
+			tokens := tokenize(part)
+			if len(tokens) == 0 {
+				continue
+			}
+			parsed.phrases = append(parsed.phrases, strings.Join(tokens, " "))
+			for _, token := range tokens {
+				addTerm(token)
+			}
+			continue
+		}
+		for _, word := range strings.Fields(part) {
+			if strings.HasSuffix(word, "*") {
+				tokens := tokenize(strings.TrimSuffix(word, "*"))
+				if len(tokens) == 1 && utf8.RuneCountInString(tokens[0]) >= minPrefixTermRunes && !seen["*"+tokens[0]] {
+					seen["*"+tokens[0]] = true
+					parsed.prefixes = append(parsed.prefixes, tokens[0])
+					continue
+				}
+			}
+			tokens := tokenize(word)
+			for _, token := range tokens {
+				addTerm(token)
+			}
+			// Characters of an unspaced word must still appear together
+			if len(tokens) > 1 && strings.ContainsFunc(word, isIdeographic) {
+				parsed.phrases = append(parsed.phrases, strings.Join(tokens, " "))
+			}
+		}
+	}
+	return parsed
+}
+
+// Search returns up to limit documents ranked by BM25. A document must
+// contain every word of the query, at least one completion of each prefix*
+// term, and every quoted phrase.
+func (idx *SearchIndex) Search(query string, limit int) []SearchHit {
+	parsed := parseSearchQuery(query)
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if len(idx.docs) == 0 {
+		return nil
+	}
+
+	// Expand prefix terms against the vocabulary
+	terms := append([]string(nil), parsed.terms...)
+	scored := make(map[string]bool)
+	for _, term := range terms {
+		scored[term] = true
+	}
+	for _, prefix := range parsed.prefixes {
+		var expansions []string
+		for term := range idx.postings {
+			if strings.HasPrefix(term, prefix) && !scored[term] {
+				expansions = append(expansions, term)
+			}
+		}
+		// Every completion is scored: dropping rare ones would hide the
+		// documents that only match through them
+		for _, term := range expansions {
+			scored[term] = true
+		}
+		terms = append(terms, expansions...)
+	}
+
+	n := float64(len(idx.docs))
+	avgLength := float64(idx.totalLength) / n
+	scores := make(map[string]float64)
+	for _, term := range terms {
+		postings := idx.postings[term]
+		if len(postings) == 0 {
+			continue
+		}
+		df := float64(len(postings))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for docID, tf := range postings {
+			length := float64(idx.docs[docID].length)
+			freq := float64(tf)
+			scores[docID] += idf * freq * (bm25K1 + 1) / (freq + bm25K1*(1-bm25B+bm25B*length/avgLength))
+		}
+	}
+
+	var hits []SearchHit
+	for docID, score := range scores {
+		doc := idx.docs[docID]
+		if !idx.hasAllTermsLocked(docID, parsed, terms) || !containsPhrases(doc, parsed.phrases) {
+			continue
+		}
+		hits = append(hits, SearchHit{Doc: doc, Score: score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Doc.ID < hits[j].Doc.ID
+	})
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+
+	for i := range hits {
+		hits[i].Snippet, hits[i].Highlights = buildSnippet(hits[i].Doc, scored)
+	}
+	return hits
+}
+
+// hasAllTermsLocked checks that a document contains every bare word and, for
+// each prefix* term, at least one of the terms it was expanded to
+func (idx *SearchIndex) hasAllTermsLocked(docID string, parsed parsedQuery, expanded []string) bool {
+	for _, term := range parsed.terms {
+		if idx.postings[term][docID] == 0 {
+			return false
+		}
+	}
+	for _, prefix := range parsed.prefixes {
+		found := false
+		for _, term := range expanded {
+			if strings.HasPrefix(term, prefix) && idx.postings[term][docID] > 0 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// containsPhrases checks that every quoted phrase occurs in the document
+func containsPhrases(doc *IndexedDoc, phrases []string) bool {
+	if len(phrases) == 0 {
+		return true
+	}
+	normalized := " " + strings.Join(tokenize(strings.Join([]string{doc.Subject, doc.From, doc.Filename, doc.Text}, "\n")), " ") + " "
+	for _, phrase := range phrases {
+		if !strings.Contains(normalized, " "+phrase+" ") {
+			return false
+		}
+	}
+	return true
+}
+
+// tokenSpan is the byte range of one token in the original text
+type tokenSpan struct {
+	start, end int
+	term       string
+}
+
+// tokenSpans tokenizes text for tokenize, keeping byte offsets
+func tokenSpans(text string) []tokenSpan {
+	var spans []tokenSpan
+	start := -1
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if start >= 0 && (!isWord || isIdeographic(r)) {
+			spans = append(spans, tokenSpan{start: start, end: i, term: strings.ToLower(text[start:i])})
+			start = -1
+		}
+		if isIdeographic(r) {
+			spans = append(spans, tokenSpan{start: i, end: i + utf8.RuneLen(r), term: text[i : i+utf8.RuneLen(r)]})
+		} else if isWord && start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, tokenSpan{start: start, end: len(text), term: strings.ToLower(text[start:])})
+	}
+	return spans
+}
+
+// buildSnippet picks the window of the document text with the most matching
+// terms and marks them with **bold**
+func buildSnippet(doc *IndexedDoc, matchTerms map[string]bool) (string, []string) {
+	text := strings.Join(strings.Fields(doc.Text), " ")
+	spans := tokenSpans(text)
+
+	var matches []tokenSpan
+	for _, span := range spans {
+		if matchTerms[span.term] {
+			matches = append(matches, span)
+		}
+	}
+
+	var highlights []string
+	seen := make(map[string]bool)
+	for _, match := range matches {
+		if !seen[match.term] {
+			seen[match.term] = true
+			highlights = append(highlights, match.term)
+		}
+	}
+
+	if len(matches) == 0 {
+		// Matched on subject, sender or filename only
+		return truncateAtWord(text, snippetChars), highlights
+	}
+
+	// Slide a window over the matches and keep the one covering the most
+	bestStart, bestCount := 0, 0
+	for i := range matches {
+		count := 0
+		for j := i; j < len(matches) && matches[j].end-matches[i].start <= snippetChars; j++ {
+			count++
+		}
+		if count > bestCount {
+			bestStart, bestCount = i, count
+		}
+	}
+
+	// Center the window on its matches, then widen to word boundaries
+	first := matches[bestStart]
+	last := matches[bestStart+bestCount-1]
+	slack := max(0, snippetChars-(last.end-first.start)) / 2
+	windowStart := max(0, first.start-slack)
+	windowEnd := min(len(text), last.end+slack)
+	for windowStart > 0 && text[windowStart-1] != ' ' {
+		windowStart--
+	}
+	for windowEnd < len(text) && text[windowEnd] != ' ' {
+		windowEnd++
+	}
+
+	var b strings.Builder
+	if windowStart > 0 {
+		b.WriteString("…")
+	}
+	pos := windowStart
+	for _, match := range matches {
+		if match.start < windowStart || match.end > windowEnd {
+			continue
+		}
+		b.WriteString(text[pos:match.start])
+		b.WriteString(highlightMarker)
+		b.WriteString(text[match.start:match.end])
+		b.WriteString(highlightMarker)
+		pos = match.end
+	}
+	b.WriteString(text[pos:windowEnd])
+	if windowEnd < len(text) {
+		b.WriteString("…")
+	}
+	return b.String(), highlights
+}
+
+// truncateAtWord shortens text to about limit bytes without splitting a word
+func truncateAtWord(text string, limit int) string {
+	if len(text) <= limit {
+		return text
+	}
+	cut := strings.LastIndex(text[:limit], " ")
+	if cut <= 0 {
+		cut = limit
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+	}
+	return text[:cut] + "…"
+}
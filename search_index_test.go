@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"testing"
+)
+
+func searchIDs(idx *SearchIndex, query string) []string {
+	var ids []string
+	for _, hit := range idx.Search(query, 0) {
+		ids = append(ids, hit.Doc.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestSearchRequiresEveryWord(t *testing.T) {
+	idx := NewSearchIndex()
+	idx.Add(&IndexedDoc{ID: "both", ThreadID: "t1", Kind: "message", Subject: "Invoice", Text: "The invoice is overdue."})
+	idx.Add(&IndexedDoc{ID: "invoice", ThreadID: "t2", Kind: "message", Subject: "Invoice", Text: "Your invoice is attached."})
+	idx.Add(&IndexedDoc{ID: "overdue", ThreadID: "t3", Kind: "message", Subject: "Library", Text: "Your book is overdue, overdue, overdue."})
+	idx.Add(&IndexedDoc{ID: "contract", ThreadID: "t4", Kind: "attachment", Filename: "terms.pdf", Text: "Contractual terms, overdue payments."})
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"invoice", []string{"both", "invoice"}},
+		{"invoice overdue", []string{"both"}},
+		{"OVERDUE Invoice", []string{"both"}},
+		{"invoice missing", nil},
+		{"contract* overdue", []string{"contract"}},
+		{"contract* invoice", nil},
+		{"zzz* overdue", nil},
+		{`"is overdue"`, []string{"both", "overdue"}},
+		{`"overdue is"`, nil},
+		{`"is overdue" book`, []string{"overdue"}},
+	}
+	for _, tt := range tests {
+		got := searchIDs(idx, tt.query)
+		if len(got) != len(tt.want) {
+			t.Errorf("Search(%q) = %v, want %v", tt.query, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("Search(%q) = %v, want %v", tt.query, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestSearchMatchesChineseAndJapanese(t *testing.T) {
+	idx := NewSearchIndex()
+	idx.Add(&IndexedDoc{ID: "meeting", ThreadID: "t1", Kind: "message", Subject: "会議の議事録", Text: "来週の会議は東京で行います。"})
+	idx.Add(&IndexedDoc{ID: "budget", ThreadID: "t2", Kind: "message", Text: "我们明天开会讨论预算。"})
+	idx.Add(&IndexedDoc{ID: "apart", ThreadID: "t3", Kind: "message", Text: "会社での議論について"})
+	idx.Add(&IndexedDoc{ID: "katakana", ThreadID: "t4", Kind: "message", Text: "メールのテストです"})
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"東京", []string{"meeting"}},
+		{"预算", []string{"budget"}},
+		{"議", []string{"apart", "meeting"}},
+		// The characters of a word must be adjacent, not just present
+		{"会議", []string{"meeting"}},
+		{`"会議"`, []string{"meeting"}},
+		{"東京 会議", []string{"meeting"}},
+		{"テスト", []string{"katakana"}},
+		{"京東", nil},
+	}
+	for _, tt := range tests {
+		if got := searchIDs(idx, tt.query); !slices.Equal(got, tt.want) {
+			t.Errorf("Search(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	hits := idx.Search("東京", 0)
+	if len(hits) != 1 || !slices.Contains(hits[0].Highlights, "東") || !slices.Contains(hits[0].Highlights, "京") {
+		t.Fatalf("hits %+v, want 東 and 京 highlighted", hits)
+	}
+}
+
+func TestSearchRanksByBM25AmongFullMatches(t *testing.T) {
+	idx := NewSearchIndex()
+	idx.Add(&IndexedDoc{ID: "once", ThreadID: "t1", Kind: "message", Text: "budget review notes and many other unrelated words here"})
+	idx.Add(&IndexedDoc{ID: "often", ThreadID: "t2", Kind: "message", Text: "budget review: budget budget"})
+
+	hits := idx.Search("budget review", 0)
+	if len(hits) != 2 || hits[0].Doc.ID != "often" {
+		t.Fatalf("Search ranked %v, want the denser match first", hits)
+	}
+	if hits[0].Snippet == "" || len(hits[0].Highlights) == 0 {
+		t.Fatalf("hit has no snippet or highlights: %+v", hits[0])
+	}
+}
+
+func TestSearchPrefixMatchesEveryCompletion(t *testing.T) {
+	idx := NewSearchIndex()
+	// 60 common completions of "report", each in two documents, and a rare one
+	for i := range 60 {
+		for j := range 2 {
+			id := fmt.Sprintf("common-%d-%d", i, j)
+			idx.Add(&IndexedDoc{ID: id, ThreadID: id, Kind: "message", Text: fmt.Sprintf("report%d quarterly", i)})
+		}
+	}
+	idx.Add(&IndexedDoc{ID: "rare", ThreadID: "rare", Kind: "message", Text: "reportage quarterly"})
+
+	if got := searchIDs(idx, "report*"); len(got) != 121 {
+		t.Fatalf("report* matched %d documents, want 121", len(got))
+	}
+	if got := searchIDs(idx, "reportage* quarterly"); !slices.Equal(got, []string{"rare"}) {
+		t.Fatalf("reportage* quarterly = %v, want [rare]", got)
+	}
+	hits := idx.Search("report* quarterly", 0)
+	found := false
+	for _, hit := range hits {
+		if hit.Doc.ID == "rare" {
+			found = true
+			if !slices.Contains(hit.Highlights, "reportage") {
+				t.Fatalf("rare completion not highlighted: %v", hit.Highlights)
+			}
+		}
+	}
+	if len(hits) != 121 || !found {
+		t.Fatalf("report* quarterly matched %d documents (rare found: %v), want 121", len(hits), found)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mime"
+	"strings"
+	"sync"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// threadFetchWorkers bounds how many Gmail thread fetches run at once per request
+const threadFetchWorkers = 8
+
+// searchMetadataHeaders are the only headers requested when summarizing threads.
+// Content-Type and Content-Disposition tell us which messages may carry attachments.
+var searchMetadataHeaders = []string{"Subject", "From", "Date", "Content-Type", "Content-Disposition"}
+
+// draftIndex maps thread IDs to the drafts in them. It is built once per tool
+// call from the drafts list, so each draft is fetched at most once and only if
+// its thread is actually part of the result.
+type draftIndex struct {
+	mailbox  Mailbox
+	byThread map[string][]string // thread ID -> draft IDs
+}
+
+// loadDraftIndex lists every draft in the mailbox and groups them by thread
+func (g *GmailServer) loadDraftIndex(ctx context.Context) (*draftIndex, error) {
+	index := &draftIndex{
+		mailbox:  g.mailbox,
+		byThread: make(map[string][]string),
+	}
+
+	pageToken := ""
+	for {
+		list, err := g.mailbox.ListDrafts(ctx, 0, pageToken)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list drafts: %v", err)
+		}
+		for _, draft := range list.Drafts {
+			if draft.Message == nil || draft.Message.ThreadId == "" {
+				continue
+			}
+			index.byThread[draft.Message.ThreadId] = append(index.byThread[draft.Message.ThreadId], draft.Id)
+		}
+		if list.NextPageToken == "" {
+			break
+		}
+		pageToken = list.NextPageToken
+	}
+	return index, nil
+}
+
+// forThread fetches and summarizes the drafts belonging to one thread.
+// A nil index (drafts could not be listed) yields no drafts.
+func (d *draftIndex) forThread(ctx context.Context, threadID string) []map[string]interface{} {
+	if d == nil {
+		return nil
+	}
+
+	var drafts []map[string]interface{}
+	for _, draftID := range d.byThread[threadID] {
+		fullDraft, err := d.mailbox.GetDraft(ctx, draftID)
+		if err != nil || fullDraft.Message == nil {
+			continue // Skip drafts we can't access
+		}
+
+		draftInfo := map[string]interface{}{
+			"draftId":  fullDraft.Id,
+			"threadId": fullDraft.Message.ThreadId,
+		}
+
+		// Extract subject and snippet if available
+		if fullDraft.Message.Payload != nil {
+			if subject := headerValue(fullDraft.Message.Payload.Headers, "Subject"); subject != "" {
+				draftInfo["subject"] = subject
+			}
+
+			if body := extractEmailBody(fullDraft.Message); body != "" {
+				snippet := body
+				if len(snippet) > 200 {
+					snippet = snippet[:200] + "..."
+				}
+				draftInfo["snippet"] = snippet
+			}
+		}
+
+		drafts = append(drafts, draftInfo)
+	}
+	return drafts
+}
+
+// mayHaveAttachments reports whether a metadata-only message could contain
+// attachments, judging by its top-level Content-Type and Content-Disposition
+func mayHaveAttachments(message *gmail.Message) bool {
+	if message.Payload == nil {
+		return false
+	}
+	contentType := headerValue(message.Payload.Headers, "Content-Type")
+	if contentType == "" {
+		contentType = message.Payload.MimeType
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	switch {
+	case mediaType == "multipart/alternative":
+		// Only the text and HTML renderings of one body
+		return false
+	case strings.HasPrefix(mediaType, "multipart/"):
+		return true
+	case mediaType == "text/plain" || mediaType == "text/html":
+		// A bare text body is a file only if it is marked as one
+		disposition := strings.ToLower(headerValue(message.Payload.Headers, "Content-Disposition"))
+		return strings.HasPrefix(disposition, "attachment") || strings.Contains(disposition, "filename")
+	default:
+		// A single-part message of any other type, e.g. a bare application/pdf, is itself the file
+		return true
+	}
+}
+
+// summarizeThread fetches one thread's metadata and builds its search result.
+// Only messages that may carry attachments are fetched in full.
+func (g *GmailServer) summarizeThread(ctx context.Context, threadID string, drafts *draftIndex) (map[string]interface{}, error) {
+	threadDetail, err := g.mailbox.GetThreadMetadata(ctx, threadID, searchMetadataHeaders)
+	if err != nil {
+		return nil, err
+	}
+	if len(threadDetail.Messages) == 0 {
+		return nil, nil
+	}
+
+	firstMessage := threadDetail.Messages[0]
+	var subject, from string
+	if firstMessage.Payload != nil {
+		subject = headerValue(firstMessage.Payload.Headers, "Subject")
+		from = headerValue(firstMessage.Payload.Headers, "From")
+	}
+
+	// Collect attachment information from all messages in the thread
+	var allAttachments []map[string]interface{}
+	for _, message := range threadDetail.Messages {
+		if !mayHaveAttachments(message) {
+			continue
+		}
+		fullMessage, err := g.mailbox.GetMessage(ctx, message.Id)
+		if err != nil {
+			log.Printf("Warning: Failed to get message %s for attachments: %v", message.Id, err)
+			continue
+		}
+		for _, attachment := range extractAttachmentInfo(fullMessage) {
+			// Add message ID to each attachment for reference
+			attachment["messageId"] = message.Id
+			allAttachments = append(allAttachments, attachment)
+		}
+	}
+
+	threadResult := map[string]interface{}{
+		"threadId": threadID,
+		"subject":  subject,
+		"from":     from,
+		// Use Gmail's built-in snippet for fast browsing (typically ~150 characters)
+		"snippet":      firstMessage.Snippet,
+		"messageCount": len(threadDetail.Messages),
+	}
+
+	// Only include attachments if there are any
+	if len(allAttachments) > 0 {
+		threadResult["attachments"] = allAttachments
+	}
+
+	// Only include drafts if there are any
+	if existingDrafts := drafts.forThread(ctx, threadID); len(existingDrafts) > 0 {
+		threadResult["drafts"] = existingDrafts
+	}
+
+	return threadResult, nil
+}
+
+// summarizeThreads summarizes threads concurrently with a bounded worker pool,
+// keeping the order of threadIDs. A thread that fails to load is reported in
+// place as {threadId, error}; failed counts those entries.
+func (g *GmailServer) summarizeThreads(ctx context.Context, threadIDs []string, drafts *draftIndex) (results []map[string]interface{}, failed int) {
+	summaries := make([]map[string]interface{}, len(threadIDs))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	workers := threadFetchWorkers
+	if len(threadIDs) < workers {
+		workers = len(threadIDs)
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				summary, err := g.summarizeThread(ctx, threadIDs[i], drafts)
+				if err != nil {
+					log.Printf("Warning: Failed to get thread %s: %v", threadIDs[i], err)
+					summary = threadFailure(threadIDs[i], err)
+				}
+				summaries[i] = summary
+			}
+		}()
+	}
+
+	dispatched := 0
+	for dispatched < len(threadIDs) && ctx.Err() == nil {
+		jobs <- dispatched
+		dispatched++
+	}
+	close(jobs)
+	wg.Wait()
+
+	for i, summary := range summaries {
+		if i >= dispatched {
+			// Never fetched because the request was cancelled
+			summary = threadFailure(threadIDs[i], ctx.Err())
+		}
+		if summary == nil {
+			continue // empty thread
+		}
+		if _, isFailure := summary["error"]; isFailure {
+			failed++
+		}
+		results = append(results, summary)
+	}
+	return results, failed
+}
+
+// threadFailure is the result entry for a thread that could not be loaded
+func threadFailure(threadID string, err error) map[string]interface{} {
+	return map[string]interface{}{
+		"threadId": threadID,
+		"error":    err.Error(),
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// slowMailbox adds a fixed round-trip latency to every call search results
+// are built from, like a real Gmail backend, and counts the calls
+type slowMailbox struct {
+	*FakeMailbox
+	latency time.Duration
+	calls   *atomic.Int64
+}
+
+func (m slowMailbox) wait() {
+	m.calls.Add(1)
+	time.Sleep(m.latency)
+}
+
+func (m slowMailbox) GetThread(ctx context.Context, threadID string) (*gmail.Thread, error) {
+	m.wait()
+	return m.FakeMailbox.GetThread(ctx, threadID)
+}
+
+func (m slowMailbox) GetThreadMetadata(ctx context.Context, threadID string, headers []string) (*gmail.Thread, error) {
+	m.wait()
+	return m.FakeMailbox.GetThreadMetadata(ctx, threadID, headers)
+}
+
+func (m slowMailbox) GetMessage(ctx context.Context, messageID string) (*gmail.Message, error) {
+	m.wait()
+	return m.FakeMailbox.GetMessage(ctx, messageID)
+}
+
+func (m slowMailbox) ListDrafts(ctx context.Context, maxResults int64, pageToken string) (*gmail.ListDraftsResponse, error) {
+	m.wait()
+	return m.FakeMailbox.ListDrafts(ctx, maxResults, pageToken)
+}
+
+func (m slowMailbox) GetDraft(ctx context.Context, draftID string) (*gmail.Draft, error) {
+	m.wait()
+	return m.FakeMailbox.GetDraft(ctx, draftID)
+}
+
+// newSlowServer returns a server over n single-message threads behind
+// latency. Every third message has an attachment and every thread but the
+// last few has a draft reply.
+func newSlowServer(tb testing.TB, n int, latency time.Duration) (*GmailServer, []string, *atomic.Int64) {
+	tb.Helper()
+	var fixtures []FixtureMessage
+	var threadIDs []string
+	for i := range n {
+		fixture := FixtureMessage{
+			ID:       fmt.Sprintf("m%02d", i),
+			ThreadID: fmt.Sprintf("t%02d", i),
+			From:     "sender@example.com",
+			To:       "me@example.com",
+			Subject:  fmt.Sprintf("Thread %d", i),
+			Body:     "Hello",
+		}
+		if i%3 == 0 {
+			fixture.Attachments = []FixtureAttachment{{Filename: "notes.txt", MimeType: "text/plain", Data: []byte("notes")}}
+		}
+		fixtures = append(fixtures, fixture)
+		threadIDs = append(threadIDs, fixture.ThreadID)
+	}
+	fake, err := NewFakeMailbox("me@example.com", fixtures...)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for i := range max(n-5, 0) {
+		raw, _ := (&OutgoingMessage{To: "sender@example.com", Subject: fmt.Sprintf("Re: Thread %d", i), Body: "Thanks"}).Build()
+		draft := &gmail.Draft{Message: &gmail.Message{Raw: base64.URLEncoding.EncodeToString(raw), ThreadId: threadIDs[i]}}
+		if _, err := fake.CreateDraft(context.Background(), draft); err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	calls := &atomic.Int64{}
+	return NewGmailServerWithMailbox("default", slowMailbox{fake, latency, calls}), threadIDs, calls
+}
+
+// summarizeThreadsOneByOne builds search results the way SearchThreads did
+// before the worker pool: each thread fetched in full, one after another,
+// with the whole drafts list walked again for every thread
+func summarizeThreadsOneByOne(ctx context.Context, g *GmailServer, threadIDs []string) ([]map[string]interface{}, error) {
+	var results []map[string]interface{}
+	for _, threadID := range threadIDs {
+		thread, err := g.mailbox.GetThread(ctx, threadID)
+		if err != nil {
+			return nil, err
+		}
+		first := thread.Messages[0]
+
+		var attachments []map[string]interface{}
+		for _, message := range thread.Messages {
+			attachments = append(attachments, extractAttachmentInfo(message)...)
+		}
+
+		var drafts []map[string]interface{}
+		list, err := g.mailbox.ListDrafts(ctx, 0, "")
+		if err != nil {
+			return nil, err
+		}
+		for _, draft := range list.Drafts {
+			full, err := g.mailbox.GetDraft(ctx, draft.Id)
+			if err != nil {
+				return nil, err
+			}
+			if full.Message != nil && full.Message.ThreadId == threadID {
+				drafts = append(drafts, map[string]interface{}{"draftId": full.Id, "subject": headerValue(full.Message.Payload.Headers, "Subject")})
+			}
+		}
+
+		results = append(results, map[string]interface{}{
+			"threadId":     threadID,
+			"subject":      headerValue(first.Payload.Headers, "Subject"),
+			"from":         headerValue(first.Payload.Headers, "From"),
+			"snippet":      first.Snippet,
+			"messageCount": len(thread.Messages),
+			"attachments":  attachments,
+			"drafts":       drafts,
+		})
+	}
+	return results, nil
+}
+
+func TestSummarizeThreadsKeepsOrder(t *testing.T) {
+	server, threadIDs, _ := newSlowServer(t, 20, time.Millisecond)
+	threadIDs = append(threadIDs[:5], append([]string{"missing"}, threadIDs[5:]...)...)
+	drafts, err := server.loadDraftIndex(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, failed := server.summarizeThreads(context.Background(), threadIDs, drafts)
+	if failed != 1 || len(results) != len(threadIDs) {
+		t.Fatalf("%d results, %d failed; want %d results with 1 failure", len(results), failed, len(threadIDs))
+	}
+	for i, result := range results {
+		if result["threadId"] != threadIDs[i] {
+			t.Fatalf("result %d is %v, want %s", i, result["threadId"], threadIDs[i])
+		}
+	}
+	if _, ok := results[5]["error"]; !ok {
+		t.Fatalf("missing thread not reported in place: %v", results[5])
+	}
+	if attachments, _ := results[0]["attachments"].([]map[string]interface{}); len(attachments) != 1 {
+		t.Fatalf("thread with an attachment summarized as %v", results[0])
+	}
+	if drafts, _ := results[0]["drafts"].([]map[string]interface{}); len(drafts) != 1 || drafts[0]["subject"] != "Re: Thread 0" {
+		t.Fatalf("thread with a draft summarized as %v", results[0])
+	}
+	if _, ok := results[len(results)-1]["drafts"]; ok {
+		t.Fatalf("thread without drafts summarized as %v", results[len(results)-1])
+	}
+}
+
+func TestSummarizeThreadFindsSinglePartAttachments(t *testing.T) {
+	const header = "From: scanner@example.com\r\nTo: me@example.com\r\nSubject: Scan\r\nContent-Transfer-Encoding: base64\r\n"
+	tests := []struct {
+		name        string
+		fixture     FixtureMessage
+		attachments int
+		fullFetches int64 // messages fetched in full to look for attachments
+	}{
+		{"bare pdf", FixtureMessage{Raw: header + "Content-Type: application/pdf; name=\"scan.pdf\"\r\n\r\nJVBERi0xLjQK\r\n"}, 1, 1},
+		{"text file", FixtureMessage{Raw: header + "Content-Type: text/plain\r\nContent-Disposition: attachment; filename=\"notes.txt\"\r\n\r\naGVsbG8K\r\n"}, 1, 1},
+		{"multipart/mixed", FixtureMessage{Body: "See attached", Attachments: []FixtureAttachment{{Filename: "a.csv", MimeType: "text/csv", Data: []byte("a,b")}}}, 1, 1},
+		{"plain text", FixtureMessage{Body: "Just text"}, 0, 0},
+		{"text and html", FixtureMessage{Body: "Just text", HTMLBody: "<p>Just text</p>"}, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fixture := tt.fixture
+			fixture.ID, fixture.ThreadID = "m1", "t1"
+			fake, err := NewFakeMailbox("me@example.com", fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+			calls := &atomic.Int64{}
+			server := NewGmailServerWithMailbox("default", slowMailbox{fake, 0, calls})
+
+			summary, err := server.summarizeThread(context.Background(), "t1", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			attachments, _ := summary["attachments"].([]map[string]interface{})
+			if len(attachments) != tt.attachments {
+				t.Fatalf("%d attachments, want %d: %v", len(attachments), tt.attachments, summary)
+			}
+			if fetches := calls.Load() - 1; fetches != tt.fullFetches {
+				t.Fatalf("%d messages fetched in full, want %d", fetches, tt.fullFetches)
+			}
+		})
+	}
+}
+
+// BenchmarkSummarizeThreads compares building a page of 25 search results the
+// old way (full threads one at a time, drafts listed per thread) with the
+// metadata-only worker pool and shared draft index, at 2ms per Gmail call
+func BenchmarkSummarizeThreads(b *testing.B) {
+	ctx := context.Background()
+
+	b.Run("one-by-one", func(b *testing.B) {
+		server, threadIDs, calls := newSlowServer(b, 25, 2*time.Millisecond)
+		calls.Store(0)
+		for b.Loop() {
+			if _, err := summarizeThreadsOneByOne(ctx, server, threadIDs); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(calls.Load())/float64(b.N), "calls/op")
+	})
+	b.Run("pool", func(b *testing.B) {
+		server, threadIDs, calls := newSlowServer(b, 25, 2*time.Millisecond)
+		calls.Store(0)
+		for b.Loop() {
+			drafts, err := server.loadDraftIndex(ctx)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, failed := server.summarizeThreads(ctx, threadIDs, drafts); failed > 0 {
+				b.Fatalf("%d threads failed", failed)
+			}
+		}
+		b.ReportMetric(float64(calls.Load())/float64(b.N), "calls/op")
+	})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// defaultToolTimeout applies to any tool without its own entry in defaultToolTimeouts
+const defaultToolTimeout = 60 * time.Second
+
+// defaultToolTimeouts are the built-in per-tool deadlines. send_email_ato has to
+// outlast the 5 minute approval window on the user's phone.
+var defaultToolTimeouts = map[string]time.Duration{
+	"fetch_email_bodies":             2 * time.Minute,
+	"extract_attachment_by_filename": 2 * time.Minute,
+	"get_personal_email_style_guide": 3 * time.Minute, // may generate the guide via OpenAI
+	"send_email_ato":                 6 * time.Minute,
+	"send_draft":                     6 * time.Minute,
+	"forward_message":                6 * time.Minute,
+}
+
+// ToolTimeouts holds the deadline applied to each tool call
+type ToolTimeouts struct {
+	fallback  time.Duration
+	overrides map[string]time.Duration
+}
+
+// loadToolTimeouts reads GMAIL_TOOL_TIMEOUT (tools without a built-in default) and
+// GMAIL_TOOL_TIMEOUT_<TOOL_NAME> (one tool, e.g. GMAIL_TOOL_TIMEOUT_SEARCH_THREADS=30s)
+func loadToolTimeouts() (*ToolTimeouts, error) {
+	timeouts := &ToolTimeouts{
+		fallback:  defaultToolTimeout,
+		overrides: make(map[string]time.Duration),
+	}
+	for name, timeout := range defaultToolTimeouts {
+		timeouts.overrides[name] = timeout
+	}
+
+	if raw := os.Getenv("GMAIL_TOOL_TIMEOUT"); raw != "" {
+		timeout, err := parseToolTimeout("GMAIL_TOOL_TIMEOUT", raw)
+		if err != nil {
+			return nil, err
+		}
+		timeouts.fallback = timeout
+	}
+
+	for _, env := range os.Environ() {
+		key, raw, _ := strings.Cut(env, "=")
+		tool, ok := strings.CutPrefix(key, "GMAIL_TOOL_TIMEOUT_")
+		if !ok || tool == "" {
+			continue
+		}
+		timeout, err := parseToolTimeout(key, raw)
+		if err != nil {
+			return nil, err
+		}
+		timeouts.overrides[strings.ToLower(tool)] = timeout
+	}
+	return timeouts, nil
+}
+
+func parseToolTimeout(key, raw string) (time.Duration, error) {
+	timeout, err := time.ParseDuration(strings.TrimSpace(raw))
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid %s %q: use a positive duration such as 90s or 5m", key, raw)
+	}
+	return timeout, nil
+}
+
+// For returns the timeout for a tool
+func (t *ToolTimeouts) For(tool string) time.Duration {
+	if timeout, ok := t.overrides[tool]; ok {
+		return timeout
+	}
+	return t.fallback
+}
+
+// Middleware bounds every tool call by its timeout. The deadline is applied to
+// the request context, so in-flight Gmail calls and approval waits stop as soon
+// as it passes or the client cancels the request.
+func (t *ToolTimeouts) Middleware() server.ToolHandlerMiddleware {
+	return func(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+		return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			timeout := t.For(req.Params.Name)
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			result, err := next(ctx, req)
+
+			// Replace whatever error the handler surfaced with a clear explanation,
+			// unless it failed after the user approved: that send ran on past the
+			// deadline, and its own error says what happened to the email
+			if err == nil && result != nil && result.IsError && !failedAfterApproval(result) {
+				switch {
+				case errors.Is(ctx.Err(), context.DeadlineExceeded):
+					return mcp.NewToolResultError(fmt.Sprintf("%s timed out after %s", req.Params.Name, timeout)), nil
+				case errors.Is(ctx.Err(), context.Canceled):
+					return mcp.NewToolResultError(fmt.Sprintf("%s was cancelled", req.Params.Name)), nil
+				}
+			}
+			return result, err
+		}
+	}
+}
+
+// approvedButNotSent starts the error of a send that failed after the user
+// approved it
+const approvedButNotSent = "approved but not sent"
+
+// failedAfterApproval reports whether result is the error of an approved send
+func failedAfterApproval(result *mcp.CallToolResult) bool {
+	if len(result.Content) == 0 {
+		return false
+	}
+	text, ok := result.Content[0].(mcp.TextContent)
+	return ok && strings.HasPrefix(text.Text, approvedButNotSent)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestTimeoutMiddlewareKeepsPostApprovalErrors(t *testing.T) {
+	timeouts := &ToolTimeouts{fallback: time.Millisecond, overrides: map[string]time.Duration{}}
+
+	tests := []struct {
+		name, handlerError, want string
+	}{
+		// The handler gave up because of the deadline: say so
+		{"waiting for approval", "failed to contact daemon: context deadline exceeded", "send_draft timed out after 1ms"},
+		// The user approved and the send ran on past the deadline; what became
+		// of the email is the handler's to say
+		{"sending after approval", "approved but not sent: draft changed after approval", "approved but not sent: draft changed after approval"},
+	}
+	for _, tt := range tests {
+		handler := timeouts.Middleware()(func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			<-ctx.Done()
+			return mcp.NewToolResultError(tt.handlerError), nil
+		})
+		var req mcp.CallToolRequest
+		req.Params.Name = "send_draft"
+		result, err := handler(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := result.Content[0].(mcp.TextContent).Text; !result.IsError || got != tt.want {
+			t.Errorf("%s: result %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}